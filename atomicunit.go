@@ -0,0 +1,148 @@
+package blink_tree
+
+import (
+	"sort"
+	"sync/atomic"
+)
+
+// BeginAtomic starts an atomic unit over keys. leaf pages of keys are locked
+// in LockAtomic mode in key order, so atomic units of other BLTree handles
+// which have keys on the same leaf pages wait until EndAtomic. keys moved to
+// new pages by splits stay locked, and locked pages are not deleted nor merged.
+// keys are changed in the unit by usual methods of this tree.
+// like the atomic lock of the original C implementation, LockAtomic serializes
+// atomic units only and doesn't exclude readers and writers outside units.
+// BLTErrAtomic is returned when an atomic unit of the tree is in progress, and
+// BLTErrCapacity when keys belong to more leaf pages than a quarter of buffer pool pages
+func (tree *BLTree) BeginAtomic(keys [][]byte) BLTErr {
+	if tree.atomics != nil {
+		tree.err = BLTErrAtomic
+		return tree.err
+	}
+	encoded := make([][]byte, len(keys))
+	for i, key := range keys {
+		enc, err := encodeKey(key)
+		if err != BLTErrOk {
+			tree.err = err
+			return err
+		}
+		encoded[i] = enc
+	}
+	sort.Slice(encoded, func(a, b int) bool {
+		return tree.mgr.compareKeys(encoded[a], encoded[b]) < 0
+	})
+
+	tree.startOp()
+	tree.atomics = []*Latchs{}
+	for _, key := range encoded {
+		if err := tree.lockAtomicKey(key); err != BLTErrOk {
+			tree.EndAtomic()
+			tree.err = err
+			return err
+		}
+	}
+	return BLTErrOk
+}
+
+// EndAtomic finishes the atomic unit and releases locks of its leaf pages
+func (tree *BLTree) EndAtomic() {
+	for _, latch := range tree.atomics {
+		tree.releaseAtomic(latch)
+	}
+	tree.atomics = nil
+}
+
+// lockAtomicKey locks leaf page of key in LockAtomic mode unless the unit holds it.
+// the page is locked without page lock and then checked that it still has key
+func (tree *BLTree) lockAtomicKey(key []byte) BLTErr {
+	for {
+		var set PageSet
+		slot, err := tree.mgr.pageFetch(&set, key, 0, LockRead, &tree.reads, &tree.writes, tree.deadline)
+		if slot == 0 {
+			if err == BLTErrOk {
+				err = BLTErrStruct
+			}
+			return err
+		}
+		latch := set.latch
+		tree.mgr.PageUnlock(LockRead, latch)
+		if tree.holdsAtomic(latch) {
+			tree.mgr.UnpinLatch(latch)
+			return BLTErrOk
+		}
+
+		// locked pages must leave most of buffer pool to other operations
+		if uint(len(tree.atomics)) >= tree.mgr.latchTotal/4 {
+			tree.mgr.UnpinLatch(latch)
+			return BLTErrCapacity
+		}
+		if !tree.mgr.pageLockDeadline(LockAtomic, latch, tree.deadline) {
+			tree.mgr.UnpinLatch(latch)
+			return BLTErrTimeout
+		}
+
+		// the page may be split or deleted before the lock
+		tree.mgr.PageLock(LockRead, latch)
+		page := tree.mgr.GetRefOfPageAtPool(latch)
+		found := !page.Kill && !page.Free && page.Lvl == 0 && page.findSlot(key, tree.mgr.keyCompare) > 0
+		tree.mgr.PageUnlock(LockRead, latch)
+		if found {
+			tree.atomics = append(tree.atomics, latch)
+			return BLTErrOk
+		}
+		tree.releaseAtomic(latch)
+	}
+}
+
+// holdsAtomic reports whether the atomic unit holds LockAtomic of latch
+func (tree *BLTree) holdsAtomic(latch *Latchs) bool {
+	for _, held := range tree.atomics {
+		for l := held; ; {
+			if l == latch {
+				return true
+			}
+			tree.mgr.PageLock(LockRead, l)
+			split := l.split
+			tree.mgr.PageUnlock(LockRead, l)
+			if split == 0 {
+				break
+			}
+			l = &tree.mgr.latchs[split]
+		}
+	}
+	return false
+}
+
+// releaseAtomic releases LockAtomic of latch and right pages split off while
+// it was held, and unpins them. split is cleared under write lock of the page
+// before the release, so no split is chained after that
+func (tree *BLTree) releaseAtomic(latch *Latchs) {
+	for latch != nil {
+		tree.mgr.PageLock(LockWrite, latch)
+		split := latch.split
+		latch.split = 0
+		tree.mgr.PageUnlock(LockAtomic, latch)
+		tree.mgr.PageUnlock(LockWrite, latch)
+		tree.mgr.UnpinLatch(latch)
+
+		latch = nil
+		if split > 0 {
+			latch = &tree.mgr.latchs[split]
+		}
+	}
+}
+
+// atomicHeld reports whether LockAtomic of latch is held
+func (mgr *BufMgr) atomicHeld(latch *Latchs) bool {
+	return atomic.LoadUint32(&latch.atomic.rin)&Mask > 0
+}
+
+// chainAtomic locks right page split off from write locked page of latch
+// in LockAtomic mode for the holder of LockAtomic of latch. the right page is
+// pinned until the holder releases it with latch
+func (mgr *BufMgr) chainAtomic(latch *Latchs, right *Latchs) {
+	atomic.AddUint32(&right.pin, 1)
+	mgr.PageLock(LockAtomic, right)
+	right.split = latch.split
+	latch.split = right.entry
+}
@@ -0,0 +1,114 @@
+package blink_tree
+
+import (
+	"encoding/binary"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBLTree_BeginAtomic(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	bltree := NewBLTree(mgr)
+	keyOf := func(i uint64) []byte {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		return bs
+	}
+
+	// counters are on different pages
+	num := uint64(2000)
+	for i := uint64(0); i < num; i++ {
+		bltree.InsertKey(keyOf(i), 0, keyOf(0), true)
+	}
+	first, last := keyOf(0), keyOf(num-1)
+
+	if err := bltree.BeginAtomic([][]byte{first}); err != BLTErrOk {
+		t.Fatalf("BeginAtomic() = %v, want %v", err, BLTErrOk)
+	}
+	if err := bltree.BeginAtomic([][]byte{last}); err != BLTErrAtomic {
+		t.Errorf("BeginAtomic() in atomic unit = %v, want %v", err, BLTErrAtomic)
+	}
+	bltree.EndAtomic()
+
+	// both counters are incremented by read-modify-write in atomic units
+	workers, incs := 4, 100
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tree := NewBLTree(mgr)
+			for n := 0; n < incs; n++ {
+				if err := tree.BeginAtomic([][]byte{last, first}); err != BLTErrOk {
+					t.Errorf("BeginAtomic() = %v, want %v", err, BLTErrOk)
+					return
+				}
+				for _, key := range [][]byte{first, last} {
+					_, _, val := tree.FindKey(key, 8)
+					tree.InsertKey(key, 0, keyOf(binary.BigEndian.Uint64(val)+1), true)
+				}
+				tree.EndAtomic()
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, key := range [][]byte{first, last} {
+		if _, _, val := bltree.FindKey(key, 8); binary.BigEndian.Uint64(val) != uint64(workers*incs) {
+			t.Errorf("FindKey(%v) = %v, want %v", key, binary.BigEndian.Uint64(val), workers*incs)
+		}
+	}
+}
+
+func TestBLTree_BeginAtomic_split(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	bltree := NewBLTree(mgr)
+	keyOf := func(i uint64) []byte {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		return bs
+	}
+
+	key := keyOf(1000)
+	bltree.InsertKey(key, 0, keyOf(0), true)
+	if err := bltree.BeginAtomic([][]byte{key}); err != BLTErrOk {
+		t.Fatalf("BeginAtomic() = %v, want %v", err, BLTErrOk)
+	}
+	// the key is moved to a split page in the unit
+	for i := uint64(0); i < 2000; i++ {
+		bltree.InsertKey(keyOf(i), 0, keyOf(0), true)
+	}
+	if stats, _ := bltree.Stats(); stats.Pages[0] < 2 {
+		t.Fatalf("Stats() leaf pages = %v, want split pages", stats.Pages[0])
+	}
+
+	var entered int32
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		tree := NewBLTree(mgr)
+		if err := tree.BeginAtomic([][]byte{key}); err != BLTErrOk {
+			t.Errorf("BeginAtomic() = %v, want %v", err, BLTErrOk)
+			return
+		}
+		atomic.StoreInt32(&entered, 1)
+		tree.EndAtomic()
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if atomic.LoadInt32(&entered) != 0 {
+		t.Errorf("BeginAtomic() of other tree entered before EndAtomic()")
+	}
+	bltree.EndAtomic()
+	<-done
+	if atomic.LoadInt32(&entered) != 1 {
+		t.Errorf("BeginAtomic() of other tree didn't enter after EndAtomic()")
+	}
+
+	// all locks are released
+	if err := bltree.BeginAtomic([][]byte{keyOf(0), keyOf(1999)}); err != BLTErrOk {
+		t.Fatalf("BeginAtomic() = %v, want %v", err, BLTErrOk)
+	}
+	bltree.EndAtomic()
+}
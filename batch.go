@@ -0,0 +1,618 @@
+package blink_tree
+
+import (
+	"bytes"
+	"sort"
+)
+
+// batchChange is a key changed by a batch operation to be reported to change hook
+type batchChange struct {
+	idx int    // index of key in keys argument (DeleteBatch and InsertBatch)
+	key []byte // deleted key as stored in page (DeleteRange)
+	seq uint64 // change sequence number
+	val []byte // deleted or overwritten value as stored in page
+}
+
+// InsertBatch inserts keys with values like InsertKey with uniq true.
+// values[i] is the value of keys[i]. keys are sorted, and keys which belong to
+// the same leaf page are inserted under one write lock of the page with one
+// descent from the root page. when a key is passed twice, the last value is stored.
+// when a key or value is rejected, no key is inserted.
+// ATTENTION: the batch is not atomic. when an error is returned,
+// some of keys are already inserted
+func (tree *BLTree) InsertBatch(keys [][]byte, values [][]byte) BLTErr {
+	if len(keys) != len(values) {
+		tree.err = BLTErrStruct
+		return tree.err
+	}
+	encoded := make([][]byte, len(keys))
+	stored := make([][]byte, len(keys))
+	order := make([]int, len(keys))
+	for i, key := range keys {
+		var err BLTErr
+		if encoded[i], err = tree.insertingKey(key, true); err != BLTErrOk {
+			return err
+		}
+		if stored[i], err = tree.storedValue(key, values[i]); err != BLTErrOk {
+			return err
+		}
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return tree.mgr.compareKeys(encoded[order[a]], encoded[order[b]]) < 0
+	})
+
+	defer tree.mgr.recordLatency(LatencyInsert, tree.mgr.latencyStart())
+
+	tree.startOp()
+	defer tree.mgr.enforceDirtyQuota(&tree.reads, &tree.writes)
+
+	for next := 0; next < len(order); {
+		var changes []batchChange
+		var split bool
+		var err BLTErr
+		next, changes, split, err = tree.insertLeafBatch(encoded, stored, order, next)
+		if err == BLTErrOk && split {
+			// the page is full, so the key is inserted with a split
+			idx := order[next]
+			tree.changeSeq = 0
+			tree.changeVal = nil
+			posts := postStack{{kind: postInsert, key: encoded[idx], ins: encoded[idx], value: stored[idx], typ: Unique}}
+			err = tree.runPosts(&posts)
+			if err == BLTErrOk {
+				changes = append(changes, batchChange{idx: idx, seq: tree.changeSeq, val: tree.changeVal})
+				next++
+			}
+		}
+		for _, c := range changes {
+			if c.seq > 0 {
+				tree.mgr.notifyChange(c.seq, ChangeInsert, keys[c.idx], tree.mgr.changedValue(c.val), values[c.idx])
+			}
+		}
+		if err != BLTErrOk {
+			return err
+		}
+	}
+	return BLTErrOk
+}
+
+// insertLeafBatch inserts sorted keys from order[start] which belong
+// to the leaf page of the first one. returns index of the first key
+// which is not inserted and inserted keys. split is true when
+// the key of the index doesn't fit in the page without a split
+func (tree *BLTree) insertLeafBatch(encoded [][]byte, stored [][]byte, order []int, start int) (int, []batchChange, bool, BLTErr) {
+	var set PageSet
+
+	slot, err := tree.mgr.pageFetch(&set, encoded[order[start]], 0, LockWrite, &tree.reads, &tree.writes, tree.deadline)
+	if slot == 0 {
+		if err == BLTErrOk {
+			err = BLTErrStruct
+		}
+		tree.err = err
+		return start, nil, false, err
+	}
+
+	if !ValidatePage(set.page) {
+		panic("InsertBatch: page is broken.")
+	}
+
+	var changes []batchChange
+	split := false
+	next := start
+	for ; next < len(order); next++ {
+		key, value := encoded[order[next]], stored[order[next]]
+		if next > start {
+			// key is beyond fence key of this page
+			if slot = set.page.findSlot(key, tree.mgr.keyCompare); slot == 0 {
+				break
+			}
+		}
+		tree.mgr.recordAccess(key, set.latch.pageNo)
+
+		seq, ok := tree.putSlot(&set, slot, key, value)
+		if !ok {
+			split = true
+			break
+		}
+		changes = append(changes, batchChange{idx: order[next], seq: seq, val: tree.changeVal})
+	}
+
+	tree.mgr.PageUnlock(LockWrite, set.latch)
+	tree.mgr.UnpinLatch(set.latch)
+	return next, changes, split, BLTErrOk
+}
+
+// putSlot inserts key with value into write locked leaf page at slot found
+// by key, or updates value of key when it exists. returns change sequence
+// number of the change, and false when key doesn't fit in the page without a split.
+// overwritten value is left in tree.changeVal
+func (tree *BLTree) putSlot(set *PageSet, slot uint32, key []byte, value []byte) (uint64, bool) {
+	tree.changeVal = nil
+
+	// if librarian slot == found slot, advance to real slot
+	if set.page.Typ(slot) == Librarian && tree.mgr.compareKeys(set.page.Key(slot), key) == 0 {
+		slot++
+	}
+	ptr := set.page.Key(slot)
+	keyLen := len(ptr)
+	if set.page.Typ(slot) == Duplicate {
+		keyLen -= BtId
+	}
+
+	// if key already exists, update value in place when it fits
+	replace := false
+	if tree.keyEqual(ptr, keyLen, key) {
+		val := *set.page.Value(slot)
+		if !set.page.Dead(slot) && !tree.mgr.expired(set.page, slot) {
+			tree.changeVal = val
+		}
+		if set.page.valueFits(slot, value) {
+			if set.page.Dead(slot) {
+				set.page.Act++
+				set.page.Garbage -= set.page.entrySize(slot)
+			}
+			// tail of old value is left unused
+			set.page.Garbage += uint32(len(val) - len(value))
+			tree.markDirty(set.latch)
+			set.page.SetDead(slot, false)
+			set.page.SetValue(value, slot)
+			return tree.mgr.nextChangeSeq(), true
+		}
+		replace = !set.page.Dead(slot)
+	}
+
+	cleaned := tree.cleanPage(set, key, slot, uint8(len(value)))
+	if cleaned == 0 {
+		return 0, false
+	}
+	if replace {
+		tree.killReplaced(set, cleaned, key)
+	}
+	tree.changeSeq = 0
+	tree.insertSlot(set, cleaned, key, value, Unique, false)
+	return tree.changeSeq, true
+}
+
+// DeleteBatch deletes keys and reports whether each key was found.
+// found[i] is the result of keys[i]. keys are sorted, and keys which
+// belong to the same leaf page are deleted under one write lock of the page.
+// when a key is passed twice, only the first one is reported as found.
+// when a key is rejected by key validator, no key is deleted.
+// ATTENTION: the batch is not atomic. when an error is returned,
+// keys reported as found are already deleted
+func (tree *BLTree) DeleteBatch(keys [][]byte) ([]bool, BLTErr) {
+	found := make([]bool, len(keys))
+	encoded := make([][]byte, len(keys))
+	order := make([]int, len(keys))
+	for i, key := range keys {
+		if err := tree.mgr.validateKey(key); err != BLTErrOk {
+			tree.err = err
+			return nil, err
+		}
+		if err := tree.mgr.validateWrite(ChangeDelete, key, nil); err != BLTErrOk {
+			tree.err = err
+			return nil, err
+		}
+		enc, err := encodeKey(key)
+		if err != BLTErrOk {
+			tree.err = err
+			return nil, err
+		}
+		encoded[i] = enc
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return tree.mgr.compareKeys(encoded[order[a]], encoded[order[b]]) < 0
+	})
+
+	tree.startOp()
+	defer tree.mgr.enforceDirtyQuota(&tree.reads, &tree.writes)
+
+	for next := 0; next < len(order); {
+		var changes []batchChange
+		var err BLTErr
+		next, changes, err = tree.deleteLeafBatch(encoded, order, next)
+		for _, c := range changes {
+			found[c.idx] = true
+			if c.seq > 0 {
+				tree.mgr.notifyChange(c.seq, ChangeDelete, keys[c.idx], tree.mgr.changedValue(c.val), nil)
+			}
+		}
+		if err != BLTErrOk {
+			return found, err
+		}
+	}
+	return found, BLTErrOk
+}
+
+// deleteLeafBatch deletes sorted keys from order[start] which belong
+// to the leaf page of the first one. returns index of the first key
+// which is not processed and deleted keys
+func (tree *BLTree) deleteLeafBatch(encoded [][]byte, order []int, start int) (int, []batchChange, BLTErr) {
+	var set PageSet
+
+	slot, err := tree.mgr.pageFetch(&set, encoded[order[start]], 0, LockWrite, &tree.reads, &tree.writes, tree.deadline)
+	if slot == 0 {
+		if err == BLTErrOk {
+			err = BLTErrStruct
+		}
+		tree.err = err
+		return start, nil, err
+	}
+
+	if !ValidatePage(set.page) {
+		panic("DeleteBatch: page is broken.")
+	}
+
+	var changes []batchChange
+	next := start
+	for ; next < len(order); next++ {
+		key := encoded[order[next]]
+		if next > start {
+			// key is beyond fence key of this page
+			if slot = set.page.findSlot(key, tree.mgr.keyCompare); slot == 0 {
+				break
+			}
+		}
+		tree.mgr.recordAccess(key, set.latch.pageNo)
+
+		found, seq, val := tree.deleteSlot(&set, slot, key)
+		if found {
+			changes = append(changes, batchChange{idx: order[next], seq: seq, val: val})
+		}
+	}
+
+	if len(changes) == 0 {
+		tree.mgr.PageUnlock(LockWrite, set.latch)
+		tree.mgr.UnpinLatch(set.latch)
+		return next, nil, BLTErrOk
+	}
+
+	// space of deleted keys is reclaimed by one compaction of the page
+	// like Vacuum instead of cleanups by later inserts
+	if set.page.Garbage >= tree.mgr.pageDataSize/4 {
+		tree.compactPage(&set, set.page.commonKeyPrefix(1, set.page.Cnt), 0)
+	}
+
+	_, err = tree.finishLeafDelete(&set)
+	return next, changes, err
+}
+
+// deleteSlot deletes key from write locked leaf page at slot found by key.
+// returns false when key doesn't exist, and change sequence number with
+// copy of deleted value when the change is numbered
+func (tree *BLTree) deleteSlot(set *PageSet, slot uint32, key []byte) (bool, uint64, []byte) {
+	// if librarian slot, advance to real slot
+	if set.page.Typ(slot) == Librarian {
+		slot++
+	}
+	if tree.mgr.compareKeys(set.page.Key(slot), key) != 0 || set.page.Dead(slot) || tree.mgr.expired(set.page, slot) {
+		return false, 0, nil
+	}
+
+	var val []byte
+	seq := tree.mgr.nextChangeSeq()
+	if seq > 0 {
+		val = append([]byte{}, *set.page.Value(slot)...)
+	}
+	set.page.SetDead(slot, true)
+	set.page.Garbage += set.page.entrySize(slot)
+	set.page.Act--
+	return true, seq, val
+}
+
+// finishLeafDelete collapses dead slots of write locked leaf page whose keys
+// are deleted and deletes the page when it becomes empty, or merges it into
+// the right page when it underflows. the page is released.
+// returns true when contents of the right page are pulled into the page
+func (tree *BLTree) finishLeafDelete(set *PageSet) (bool, BLTErr) {
+	// collapse empty slots beneath the fence
+	idx := set.page.Cnt - 1
+	for idx > 0 && set.page.Dead(idx) {
+		copy(set.page.slotBytes(idx), set.page.slotBytes(idx+1))
+		set.page.ClearSlot(set.page.Cnt)
+		set.page.Cnt--
+		idx = set.page.Cnt - 1
+	}
+	tree.markDirty(set.latch)
+
+	if !ValidatePage(set.page) {
+		panic("finishLeafDelete: page is broken.")
+	}
+
+	// delete empty page or merge underfull page
+	var posts postStack
+	var pulled bool
+	var err BLTErr
+	if set.page.Act == 0 {
+		pulled, err = tree.pullRight(&posts, set, LockNone, false)
+	} else {
+		pulled, err = tree.mergeUnderflow(&posts, set)
+	}
+	if err != BLTErrOk {
+		return false, err
+	}
+	if pulled {
+		return true, tree.runPosts(&posts)
+	}
+
+	tree.mgr.PageUnlock(LockWrite, set.latch)
+	tree.mgr.UnpinLatch(set.latch)
+	return false, BLTErrOk
+}
+
+// DeleteRange deletes keys between lowerKey and upperKey (both inclusive) and returns
+// count of deleted keys. nil argument for lowerKey means no lower bound and
+// nil argument for upperKey means no upper bound. keys of each leaf page are
+// deleted under one write lock of the page, and emptied pages are reclaimed.
+// bounds are not checked by key validator.
+// ATTENTION: the deletion is not atomic. when an error is returned,
+// keys counted in the result are already deleted
+func (tree *BLTree) DeleteRange(lowerKey []byte, upperKey []byte) (int, BLTErr) {
+	var err BLTErr
+	start := []byte{}
+	if lowerKey != nil {
+		if start, err = encodeKey(lowerKey); err != BLTErrOk {
+			tree.err = err
+			return 0, err
+		}
+	}
+	if upperKey != nil {
+		if upperKey, err = encodeKey(upperKey); err != BLTErrOk {
+			tree.err = err
+			return 0, err
+		}
+	}
+
+	tree.startOp()
+	defer tree.mgr.enforceDirtyQuota(&tree.reads, &tree.writes)
+
+	deleted := 0
+	for start != nil {
+		var changes []batchChange
+		start, changes, err = tree.deleteLeafRange(start, upperKey)
+		for _, c := range changes {
+			if c.seq > 0 {
+				tree.mgr.notifyChange(c.seq, ChangeDelete, decodeKey(c.key), tree.mgr.changedValue(c.val), nil)
+			}
+		}
+		deleted += len(changes)
+		if err != BLTErrOk {
+			return deleted, err
+		}
+	}
+	return deleted, BLTErrOk
+}
+
+// DeletePrefix deletes keys beginning with prefix and returns count of deleted keys.
+// the deletion starts from prefix and stops at the first key without prefix,
+// so that keys of each leaf page are deleted under one write lock of the page
+// and pages emptied by the deletion are reclaimed like DeleteRange.
+// prefix is compared as bytes, so keys beginning with prefix may be left
+// when the tree has key comparator which doesn't keep them together.
+// ATTENTION: like DeleteRange, the deletion is not atomic
+func (tree *BLTree) DeletePrefix(prefix []byte) (int, BLTErr) {
+	encoded, err := encodeKey(prefix)
+	if err != BLTErrOk {
+		tree.err = err
+		return 0, err
+	}
+	tree.delPrefix = encoded
+	defer func() { tree.delPrefix = nil }()
+	return tree.DeleteRange(prefix, nil)
+}
+
+// deleteLeafRange deletes keys from start up to upperKey which belong to the leaf page
+// of start. returns the smallest key which can be in the right page, or nil
+// when the range is finished, and deleted keys
+func (tree *BLTree) deleteLeafRange(start []byte, upperKey []byte) ([]byte, []batchChange, BLTErr) {
+	var set PageSet
+
+	slot, err := tree.mgr.pageFetch(&set, start, 0, LockWrite, &tree.reads, &tree.writes, tree.deadline)
+	if slot == 0 {
+		if err == BLTErrOk {
+			err = BLTErrStruct
+		}
+		tree.err = err
+		return nil, nil, err
+	}
+
+	if !ValidatePage(set.page) {
+		panic("DeleteRange: page is broken.")
+	}
+
+	var changes []batchChange
+	var next []byte
+	removed := false
+	for ; slot <= set.page.Cnt; slot++ {
+		if set.page.Typ(slot) == Librarian || set.page.Dead(slot) {
+			continue
+		}
+		// infinite stopper of the rightmost page
+		if slot == set.page.Cnt && GetID(&set.page.Right) == 0 {
+			break
+		}
+		key := set.page.Key(slot)
+		if upperKey != nil && tree.mgr.compareKeys(key, upperKey) > 0 {
+			break
+		}
+		if tree.delPrefix != nil && !bytes.HasPrefix(key, tree.delPrefix) {
+			break
+		}
+
+		// expired keys are deleted without notice and counted only by PurgeExpired
+		if expired := tree.mgr.expired(set.page, slot); expired || tree.purging {
+			if !expired {
+				continue
+			}
+			if tree.purging {
+				changes = append(changes, batchChange{key: key})
+			}
+		} else {
+			c := batchChange{key: key, seq: tree.mgr.nextChangeSeq()}
+			if c.seq > 0 {
+				c.val = *set.page.Value(slot)
+			}
+			changes = append(changes, c)
+		}
+
+		set.page.SetDead(slot, true)
+		set.page.Garbage += set.page.entrySize(slot)
+		set.page.Act--
+		removed = true
+	}
+	// keys of the right page are greater than the fence key
+	var right Uid
+	if slot > set.page.Cnt {
+		next = append(set.page.Key(set.page.Cnt), 0)
+		right = GetID(&set.page.Right)
+	}
+	pageNo := set.latch.pageNo
+
+	if !removed {
+		tree.mgr.PageUnlock(LockWrite, set.latch)
+		tree.mgr.UnpinLatch(set.latch)
+	} else if pulled, err := tree.finishLeafDelete(&set); err != BLTErrOk {
+		return next, changes, err
+	} else if pulled && right > 0 {
+		// contents of the right page are pulled into the page
+		right = pageNo
+	}
+
+	// appended byte doesn't make a greater key in order of key comparator
+	if right > 0 && tree.mgr.keyCompare != nil {
+		next, err = tree.firstKeyOf(right)
+	}
+	return next, changes, err
+}
+
+// firstKeyOf returns the first key of page pageNo
+func (tree *BLTree) firstKeyOf(pageNo Uid) ([]byte, BLTErr) {
+	latch, err := tree.mgr.pinLatch(pageNo, true, &tree.reads, &tree.writes, tree.deadline)
+	if latch == nil {
+		tree.err = err
+		return nil, err
+	}
+	tree.mgr.PageLock(LockRead, latch)
+	key := tree.mgr.GetRefOfPageAtPool(latch).Key(1)
+	tree.mgr.PageUnlock(LockRead, latch)
+	tree.mgr.UnpinLatch(latch)
+	return key, BLTErrOk
+}
+
+// FindKeys finds unique keys or first duplicate keys and returns their values.
+// values[i] is the value of keys[i] and nil when the key is not found.
+// keys are sorted, and keys which belong to the same leaf page are found
+// under one read lock of the page with one descent from the root page.
+// ATTENTION: the lookups are not atomic with other tree operations
+func (tree *BLTree) FindKeys(keys [][]byte) ([][]byte, BLTErr) {
+	values := make([][]byte, len(keys))
+	encoded := make([][]byte, len(keys))
+	order := make([]int, len(keys))
+	for i, key := range keys {
+		enc, err := encodeKey(key)
+		if err != BLTErrOk {
+			tree.err = err
+			return nil, err
+		}
+		encoded[i] = enc
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return tree.mgr.compareKeys(encoded[order[a]], encoded[order[b]]) < 0
+	})
+
+	defer tree.mgr.recordLatency(LatencyFind, tree.mgr.latencyStart())
+
+	tree.startOp()
+
+	for next := 0; next < len(order); {
+		var pending []int
+		var err BLTErr
+		next, pending, err = tree.findLeafBatch(encoded, order, next, values)
+		if err != BLTErrOk {
+			return values, err
+		}
+
+		// duplicate keys which continue to the right page
+		for _, idx := range pending {
+			tree.findKey(keys[idx], false, func(page *Page, slot uint32) {
+				values[idx], err = tree.mgr.decodeValue(*page.Value(slot))
+			})
+			if err == BLTErrOk {
+				err = tree.err
+			}
+			if err != BLTErrOk {
+				tree.err = err
+				return values, err
+			}
+		}
+	}
+	return values, BLTErrOk
+}
+
+// findLeafBatch finds sorted keys from order[start] which belong to the leaf page
+// of the first one and stores their values. returns index of the first key
+// which is not processed and indexes of keys which can't be decided on the page
+func (tree *BLTree) findLeafBatch(encoded [][]byte, order []int, start int, values [][]byte) (int, []int, BLTErr) {
+	var set PageSet
+
+	slot, err := tree.mgr.pageFetch(&set, encoded[order[start]], 0, LockRead, &tree.reads, &tree.writes, tree.deadline)
+	if slot == 0 {
+		if err == BLTErrOk {
+			err = BLTErrStruct
+		}
+		tree.err = err
+		return start, nil, err
+	}
+	defer func() {
+		tree.mgr.PageUnlock(LockRead, set.latch)
+		tree.mgr.UnpinLatch(set.latch)
+	}()
+
+	var pending []int
+	next := start
+	for ; next < len(order); next++ {
+		key := encoded[order[next]]
+		if next > start {
+			// key is beyond fence key of this page
+			if slot = set.page.findSlot(key, tree.mgr.keyCompare); slot == 0 {
+				break
+			}
+		}
+		tree.mgr.recordAccess(key, set.latch.pageNo)
+
+		s := slot
+		for ; s <= set.page.Cnt; s++ {
+			// skip librarian slot place holder and deleted keys
+			if set.page.Typ(s) == Librarian {
+				continue
+			}
+			// not there if we reach the stopper key
+			if s == set.page.Cnt && GetID(&set.page.Right) == 0 {
+				break
+			}
+			if set.page.Dead(s) || tree.mgr.expired(set.page, s) {
+				continue
+			}
+
+			suffix := 0
+			if set.page.Typ(s) == Duplicate {
+				suffix = BtId
+			}
+			if set.page.hasKey(s, key, suffix, tree.mgr.keyCompare) {
+				val, err := tree.mgr.decodeValue(*set.page.Value(s))
+				if err != BLTErrOk {
+					tree.err = err
+					return next, nil, err
+				}
+				values[order[next]] = val
+			}
+			break
+		}
+		if s > set.page.Cnt {
+			pending = append(pending, order[next])
+		}
+	}
+	return next, pending, BLTErrOk
+}
@@ -0,0 +1,377 @@
+package blink_tree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestBLTree_DeleteBatch(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	bltree := NewBLTree(mgr)
+
+	num := uint64(2000)
+	key := func(i uint64) []byte {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		return bs
+	}
+	for i := uint64(0); i < num; i++ {
+		if err := bltree.InsertKey(key(i), 0, []byte{byte(i), 0, 0, 0, 0, 0}, true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+
+	deleted := 0
+	mgr.SetChangeHook(func(ev ChangeEvent) {
+		if ev.Op != ChangeDelete || ev.Value[0] != ev.Key[7] {
+			t.Errorf("hook event = %v, want delete of its value", ev)
+		}
+		deleted++
+	})
+
+	// unsorted keys over many leaf pages, missing keys and a key passed twice
+	keys := [][]byte{key(1500), key(num + 1), key(3), key(1500)}
+	for i := uint64(1000); i > 100; i-- {
+		keys = append(keys, key(i))
+	}
+	found, err := bltree.DeleteBatch(keys)
+	if err != BLTErrOk {
+		t.Fatalf("DeleteBatch() = %v, want %v", err, BLTErrOk)
+	}
+	want := []bool{true, false, true, false}
+	for range keys[len(want):] {
+		want = append(want, true)
+	}
+	for i := range want {
+		if found[i] != want[i] {
+			t.Errorf("DeleteBatch() found[%d] = %v, want %v", i, found[i], want[i])
+		}
+	}
+	if deleted != len(keys)-2 {
+		t.Errorf("hook called %v times, want %v", deleted, len(keys)-2)
+	}
+	mgr.SetChangeHook(nil)
+
+	for i := uint64(0); i < num; i++ {
+		ret, _, _ := bltree.FindKey(key(i), BtId)
+		gone := i == 3 || i == 1500 || (i > 100 && i <= 1000)
+		if gone && ret != -1 {
+			t.Errorf("FindKey(%v) after DeleteBatch = %v, want %v", i, ret, -1)
+		} else if !gone && ret != BtId {
+			t.Errorf("FindKey(%v) = %v, want %v", i, ret, BtId)
+		}
+	}
+
+	// emptied leaf pages are deleted and keys can be inserted again
+	if _, err := bltree.GarbageStats(); err != BLTErrOk {
+		t.Errorf("GarbageStats() = %v, want %v", err, BLTErrOk)
+	}
+	for i := uint64(101); i <= 1000; i++ {
+		if err := bltree.InsertKey(key(i), 0, []byte{byte(i), 0, 0, 0, 0, 0}, true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+	if ret, _, _ := bltree.FindKey(key(500), BtId); ret != BtId {
+		t.Errorf("FindKey() after reinsert = %v, want %v", ret, BtId)
+	}
+
+	if found, err := bltree.DeleteBatch(nil); err != BLTErrOk || len(found) != 0 {
+		t.Errorf("DeleteBatch(nil) = %v, %v, want empty, %v", found, err, BLTErrOk)
+	}
+}
+
+func TestBLTree_DeleteBatch_compact(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	bltree := NewBLTree(mgr)
+
+	num := uint64(2000)
+	key := func(i uint64) []byte {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		return bs
+	}
+	var keys [][]byte
+	for i := uint64(0); i < num; i++ {
+		if err := bltree.InsertKey(key(i), 0, make([]byte, 2*BtId), true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+		if i%4 > 0 {
+			keys = append(keys, key(i))
+		}
+	}
+
+	// most keys of every leaf page are deleted, so pages are compacted
+	if _, err := bltree.DeleteBatch(keys); err != BLTErrOk {
+		t.Fatalf("DeleteBatch() = %v, want %v", err, BLTErrOk)
+	}
+	stats, err := bltree.GarbageStats()
+	if err != BLTErrOk {
+		t.Fatalf("GarbageStats() = %v, want %v", err, BLTErrOk)
+	}
+	// deleted entries take 15 bytes each when they are left as garbage
+	if max := uint64(len(keys)) * 15 / 10; stats.LeafBytes > max {
+		t.Errorf("GarbageStats().LeafBytes after DeleteBatch = %v, want <= %v", stats.LeafBytes, max)
+	}
+	for i := uint64(0); i < num; i++ {
+		want := 2 * BtId
+		if i%4 > 0 {
+			want = -1
+		}
+		if ret, _, _ := bltree.FindKey(key(i), 2*BtId); ret != want {
+			t.Errorf("FindKey(%v) = %v, want %v", i, ret, want)
+		}
+	}
+}
+
+func TestBLTree_DeleteRange(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	bltree := NewBLTree(mgr)
+
+	num := uint64(5000)
+	key := func(i uint64) []byte {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		return bs
+	}
+	for i := uint64(0); i < num; i++ {
+		if err := bltree.InsertKey(key(i), 0, []byte{byte(i), 0, 0, 0, 0, 0}, true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+	// a key whose prefix is a key in the range
+	longKey := append(key(3000), 1)
+	if err := bltree.InsertKey(longKey, 0, make([]byte, BtId), true); err != BLTErrOk {
+		t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+	}
+
+	hooked := 0
+	mgr.SetChangeHook(func(ev ChangeEvent) {
+		if ev.Op != ChangeDelete || ev.Value[0] != ev.Key[7] {
+			t.Errorf("hook event = %v, want delete of its value", ev)
+		}
+		hooked++
+	})
+	deleted, err := bltree.DeleteRange(key(100), key(3000))
+	if err != BLTErrOk {
+		t.Fatalf("DeleteRange() = %v, want %v", err, BLTErrOk)
+	}
+	if deleted != 2901 || hooked != 2901 {
+		t.Errorf("DeleteRange() = %v with %v hook calls, want %v", deleted, hooked, 2901)
+	}
+	mgr.SetChangeHook(nil)
+
+	for i := uint64(0); i < num; i++ {
+		ret, _, _ := bltree.FindKey(key(i), BtId)
+		if gone := i >= 100 && i <= 3000; gone && ret != -1 {
+			t.Errorf("FindKey(%v) after DeleteRange = %v, want %v", i, ret, -1)
+		} else if !gone && ret != BtId {
+			t.Errorf("FindKey(%v) after DeleteRange = %v, want %v", i, ret, BtId)
+		}
+	}
+	if ret, _, _ := bltree.FindKey(longKey, BtId); ret != BtId {
+		t.Errorf("FindKey() of key above upper bound = %v, want %v", ret, BtId)
+	}
+
+	// deleting again finds nothing
+	if deleted, _ := bltree.DeleteRange(key(100), key(3000)); deleted != 0 {
+		t.Errorf("DeleteRange() twice = %v, want %v", deleted, 0)
+	}
+
+	// open bounds delete all keys and the tree is still usable
+	if deleted, err := bltree.DeleteRange(nil, nil); err != BLTErrOk || deleted != int(num)-2901+1 {
+		t.Errorf("DeleteRange(nil, nil) = %v, %v, want %v, %v", deleted, err, int(num)-2901+1, BLTErrOk)
+	}
+	if num, _, _ := bltree.RangeScan(nil, nil); num != 0 {
+		t.Errorf("RangeScan() after DeleteRange(nil, nil) = %v, want %v", num, 0)
+	}
+	if err := bltree.InsertKey(key(1), 0, make([]byte, BtId), true); err != BLTErrOk {
+		t.Errorf("InsertKey() after DeleteRange() = %v, want %v", err, BLTErrOk)
+	}
+}
+
+func TestBLTree_DeletePrefix(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	bltree := NewBLTree(mgr)
+
+	// keys of 3 namespaces, and escaped keys beginning with 0xff
+	key := func(ns byte, i uint64) []byte {
+		bs := make([]byte, 9)
+		bs[0] = ns
+		binary.BigEndian.PutUint64(bs[1:], i)
+		return bs
+	}
+	num := uint64(3000)
+	for _, ns := range []byte{1, 2, 0xff} {
+		for i := uint64(0); i < num; i++ {
+			if err := bltree.InsertKey(key(ns, i), 0, make([]byte, BtId), true); err != BLTErrOk {
+				t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+			}
+		}
+	}
+	// prefix itself is deleted
+	bltree.InsertKey([]byte{2}, 0, make([]byte, BtId), true)
+	pages, _ := bltree.Stats()
+
+	deleted, err := bltree.DeletePrefix([]byte{2})
+	if deleted != int(num)+1 || err != BLTErrOk {
+		t.Fatalf("DeletePrefix() = %v, %v, want %v, %v", deleted, err, num+1, BLTErrOk)
+	}
+	if stats, _ := bltree.Stats(); stats.Pages[0] >= pages.Pages[0] {
+		t.Errorf("Stats() leaf pages after DeletePrefix() = %v, want less than %v", stats.Pages[0], pages.Pages[0])
+	}
+	// keys of 0xff namespace below 256
+	if deleted, _ = bltree.DeletePrefix(key(0xff, 0)[:8]); deleted != 256 {
+		t.Errorf("DeletePrefix() of escaped prefix = %v, want %v", deleted, 256)
+	}
+	if deleted, _ = bltree.DeletePrefix([]byte{3}); deleted != 0 {
+		t.Errorf("DeletePrefix() of missing prefix = %v, want 0", deleted)
+	}
+
+	for _, ns := range []byte{1, 2, 0xff} {
+		for i := uint64(0); i < num; i++ {
+			want := ns == 1 || (ns == 0xff && i >= 256)
+			if got := bltree.Exists(key(ns, i)); got != want {
+				t.Fatalf("Exists(%v, %v) = %v, want %v", ns, i, got, want)
+			}
+		}
+	}
+	if report, err := ValidateTree(bltree); err != BLTErrOk || !report.Valid() {
+		t.Fatalf("ValidateTree() = %v, %v, want no problem", report.Problems, err)
+	}
+}
+
+func TestBLTree_FindKeys(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	bltree := NewBLTree(mgr)
+
+	num := uint64(3000)
+	key := func(i uint64) []byte {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		return bs
+	}
+	for i := uint64(0); i < num; i += 2 {
+		if err := bltree.InsertKey(key(i), 0, []byte{byte(i), 0, 0, 0, 0, 0}, true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+	for i := uint64(0); i < num; i += 10 {
+		if err := bltree.DeleteKey(key(i), 0); err != BLTErrOk {
+			t.Fatalf("DeleteKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+
+	// unsorted keys over many leaf pages, missing keys and a key passed twice
+	keys := [][]byte{key(1500 + 2), key(num + 1), key(4), key(1502)}
+	for i := num; i > 0; i -= 3 {
+		keys = append(keys, key(i))
+	}
+	values, err := bltree.FindKeys(keys)
+	if err != BLTErrOk {
+		t.Fatalf("FindKeys() = %v, want %v", err, BLTErrOk)
+	}
+	for i, k := range keys {
+		ret, _, want := bltree.FindKey(k, BtId)
+		if ret < 0 {
+			want = nil
+		}
+		if (values[i] == nil) != (want == nil) || !bytes.Equal(values[i], want) {
+			t.Errorf("FindKeys() [%d] = %v, want %v", i, values[i], want)
+		}
+	}
+
+	dup := []byte("dup")
+	for i := 0; i < 3; i++ {
+		if err := bltree.InsertKey(dup, 0, []byte{byte(i + 1), 0, 0, 0, 0, 0}, false); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+	_, _, want := bltree.FindKey(dup, BtId)
+	if values, _ := bltree.FindKeys([][]byte{dup}); !bytes.Equal(values[0], want) {
+		t.Errorf("FindKeys() of duplicate key = %v, want %v", values[0], want)
+	}
+
+	if values, err := bltree.FindKeys(nil); err != BLTErrOk || len(values) != 0 {
+		t.Errorf("FindKeys(nil) = %v, %v, want empty", values, err)
+	}
+}
+
+func TestBLTree_InsertBatch(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	bltree := NewBLTree(mgr)
+
+	num := uint64(6000)
+	key := func(i uint64) []byte {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		return bs
+	}
+	for i := uint64(0); i < num; i += 3 {
+		if err := bltree.InsertKey(key(i), 0, []byte{1}, true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+
+	inserted := 0
+	mgr.SetChangeHook(func(ev ChangeEvent) {
+		if ev.Op != ChangeInsert || (len(ev.Value) > 0 && ev.Value[0] != ev.Key[7]) {
+			t.Errorf("hook event = %v, want insert of its value", ev)
+		}
+		inserted++
+	})
+
+	// unsorted new and existing keys over many leaf pages which are split,
+	// values shorter and longer than existing ones and a key passed twice
+	keys := [][]byte{key(7), key(num + 1), key(7)}
+	values := [][]byte{{7}, {byte(num + 1)}, {7, 7}}
+	for i := num; i > 0; i -= 2 {
+		keys = append(keys, key(i))
+		values = append(values, bytes.Repeat([]byte{byte(i)}, int(i%3)))
+	}
+	if err := bltree.InsertBatch(keys, values); err != BLTErrOk {
+		t.Fatalf("InsertBatch() = %v, want %v", err, BLTErrOk)
+	}
+	if inserted != len(keys) {
+		t.Errorf("hook called %v times, want %v", inserted, len(keys))
+	}
+	mgr.SetChangeHook(nil)
+
+	for i := uint64(0); i <= num+1; i++ {
+		var want []byte
+		switch {
+		case i == 7:
+			want = []byte{7, 7}
+		case i == num+1:
+			want = []byte{byte(i)}
+		case i > 0 && i%2 == 0 && i <= num:
+			want = bytes.Repeat([]byte{byte(i)}, int(i%3))
+		case i%3 == 0:
+			want = []byte{1}
+		default:
+			if ret, _, _ := bltree.FindKey(key(i), BtId); ret != -1 {
+				t.Errorf("FindKey(%v) = %v, want %v", i, ret, -1)
+			}
+			continue
+		}
+		if ret, _, val := bltree.FindKey(key(i), BtId); ret != len(want) || !bytes.Equal(val, want) {
+			t.Errorf("FindKey(%v) = %v, %v, want %v", i, ret, val, want)
+		}
+	}
+	if report, err := ValidateTree(bltree); err != BLTErrOk || !report.Valid() {
+		t.Errorf("ValidateTree() after InsertBatch = %v, %v, want no problem", report.Problems, err)
+	}
+
+	if err := bltree.InsertBatch(keys, values[1:]); err != BLTErrStruct {
+		t.Errorf("InsertBatch() of mismatched values = %v, want %v", err, BLTErrStruct)
+	}
+	if err := bltree.InsertBatch([][]byte{key(num + 2), key(num + 3)}, [][]byte{{1}, make([]byte, mgr.MaxValueSize()+1)}); err != BLTErrOverflow {
+		t.Errorf("InsertBatch() of too long value = %v, want %v", err, BLTErrOverflow)
+	}
+	if ret, _, _ := bltree.FindKey(key(num+2), BtId); ret != -1 {
+		t.Errorf("FindKey() of rejected batch = %v, want %v", ret, -1)
+	}
+	if err := bltree.InsertBatch(nil, nil); err != BLTErrOk {
+		t.Errorf("InsertBatch(nil) = %v, want %v", err, BLTErrOk)
+	}
+}
@@ -0,0 +1,224 @@
+// Package blbench is workload generator which drives blink_tree
+// through its public API and reports throughput and latency.
+//
+//	res, err := blbench.Run(blbench.Config{Keys: 1 << 20, Dist: blbench.Zipfian, ReadRatio: 0.9})
+//	fmt.Println(res)
+//
+// cmd/blbench runs the same workloads from command line.
+package blbench
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	blink_tree "github.com/ryogrid/bltree-go-for-embedding"
+)
+
+// Config is configuration of a workload. zero fields are set to defaults
+type Config struct {
+	Keys        int          // number of distinct keys (default 100000)
+	Ops         int          // number of operations of each worker after preload (default 100000)
+	Workers     int          // number of goroutines. each uses its own BLTree handle (default 1)
+	KeySize     int          // key length in bytes. at least 8 (default 8)
+	Dist        Distribution // distribution of accessed keys (default Uniform)
+	ZipfS       float64      // skew of Zipfian. must be greater than 1 (default 1.1)
+	ReadRatio   float64      // fraction of FindKey (default 0)
+	DeleteRatio float64      // fraction of DeleteKey. the rest are InsertKey (default 0)
+	Preload     bool         // insert all keys before operations are measured
+	Seed        int64        // seed of random generators
+
+	PageBits uint8 // page size bits of BufMgr created by Run (default 12)
+	PoolSize uint  // buffer pool size of BufMgr created by Run (default 4096)
+}
+
+// Result is measured result of a workload
+type Result struct {
+	Config  Config
+	Finds   int // FindKey calls
+	Found   int // FindKey calls which found the key
+	Inserts int // InsertKey calls
+	Deletes int // DeleteKey calls
+	Elapsed time.Duration
+	Latency blink_tree.LatencyStats
+}
+
+// Ops returns count of measured operations
+func (r *Result) Ops() int {
+	return r.Finds + r.Inserts + r.Deletes
+}
+
+// Throughput returns operations per second
+func (r *Result) Throughput() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Ops()) / r.Elapsed.Seconds()
+}
+
+func (r Result) String() string {
+	var sb strings.Builder
+	c := &r.Config
+	fmt.Fprintf(&sb, "workload: dist=%v keys=%d keysize=%d workers=%d read=%.2f delete=%.2f\n",
+		c.Dist, c.Keys, c.KeySize, c.Workers, c.ReadRatio, c.DeleteRatio)
+	fmt.Fprintf(&sb, "ops=%d (find=%d found=%d insert=%d delete=%d) elapsed=%v throughput=%.0f ops/s\n",
+		r.Ops(), r.Finds, r.Found, r.Inserts, r.Deletes, r.Elapsed, r.Throughput())
+	for _, l := range []struct {
+		name string
+		s    *blink_tree.LatencySnapshot
+	}{{"find", &r.Latency.Find}, {"insert", &r.Latency.Insert}, {"delete", &r.Latency.Delete}} {
+		if l.s.Count == 0 {
+			continue
+		}
+		fmt.Fprintf(&sb, "%-6s mean=%v p50=%v p99=%v max=%v\n",
+			l.name, l.s.Mean(), l.s.Quantile(0.5), l.s.Quantile(0.99), l.s.Max)
+	}
+	return sb.String()
+}
+
+func (cfg *Config) setDefaults() error {
+	if cfg.Keys == 0 {
+		cfg.Keys = 100000
+	}
+	if cfg.Ops == 0 {
+		cfg.Ops = 100000
+	}
+	if cfg.Workers == 0 {
+		cfg.Workers = 1
+	}
+	if cfg.KeySize == 0 {
+		cfg.KeySize = 8
+	}
+	if cfg.ZipfS == 0 {
+		cfg.ZipfS = 1.1
+	}
+	if cfg.PageBits == 0 {
+		cfg.PageBits = 12
+	}
+	if cfg.PoolSize == 0 {
+		cfg.PoolSize = 4096
+	}
+
+	switch {
+	case cfg.Keys < 2:
+		return fmt.Errorf("blbench: Keys must be at least 2: %d", cfg.Keys)
+	case cfg.KeySize < 8 || cfg.KeySize > blink_tree.MaxKey:
+		return fmt.Errorf("blbench: KeySize must be in [8, %d]: %d", blink_tree.MaxKey, cfg.KeySize)
+	case cfg.ZipfS <= 1:
+		return fmt.Errorf("blbench: ZipfS must be greater than 1: %v", cfg.ZipfS)
+	case cfg.ReadRatio < 0 || cfg.DeleteRatio < 0 || cfg.ReadRatio+cfg.DeleteRatio > 1:
+		return fmt.Errorf("blbench: invalid ReadRatio %v and DeleteRatio %v", cfg.ReadRatio, cfg.DeleteRatio)
+	}
+	return nil
+}
+
+// Run creates BufMgr on in-memory ParentBufMgrDummy and runs workload on it
+func Run(cfg Config) (Result, error) {
+	if err := cfg.setDefaults(); err != nil {
+		return Result{}, err
+	}
+	mgr := blink_tree.NewBufMgr(cfg.PageBits, cfg.PoolSize, blink_tree.NewParentBufMgrDummy(nil), nil)
+	return RunTree(mgr, cfg)
+}
+
+// RunTree runs workload on given BufMgr. PageBits and PoolSize are ignored.
+// latency histograms of mgr are cleared and left enabled
+func RunTree(mgr *blink_tree.BufMgr, cfg Config) (Result, error) {
+	if err := cfg.setDefaults(); err != nil {
+		return Result{}, err
+	}
+
+	if cfg.Preload {
+		if err := preload(mgr, &cfg); err != nil {
+			return Result{}, err
+		}
+	}
+
+	mgr.EnableLatencyHistograms()
+	results := make([]Result, cfg.Workers)
+	errs := make([]error, cfg.Workers)
+	wg := sync.WaitGroup{}
+	wg.Add(cfg.Workers)
+	start := time.Now()
+	for w := 0; w < cfg.Workers; w++ {
+		go func(w int) {
+			defer wg.Done()
+			errs[w] = runWorker(blink_tree.NewBLTree(mgr), &cfg, w, &results[w])
+		}(w)
+	}
+	wg.Wait()
+
+	res := Result{Config: cfg, Elapsed: time.Since(start), Latency: mgr.LatencyStats()}
+	for w := range results {
+		if errs[w] != nil {
+			return res, errs[w]
+		}
+		res.Finds += results[w].Finds
+		res.Found += results[w].Found
+		res.Inserts += results[w].Inserts
+		res.Deletes += results[w].Deletes
+	}
+	return res, nil
+}
+
+// preload inserts all keys in parallel
+func preload(mgr *blink_tree.BufMgr, cfg *Config) error {
+	errs := make([]error, cfg.Workers)
+	wg := sync.WaitGroup{}
+	wg.Add(cfg.Workers)
+	for w := 0; w < cfg.Workers; w++ {
+		go func(w int) {
+			defer wg.Done()
+			tree := blink_tree.NewBLTree(mgr)
+			for n := w; n < cfg.Keys; n += cfg.Workers {
+				if err := tree.InsertKey(makeKey(uint64(n), cfg.KeySize), 0, value(uint64(n)), true); err != blink_tree.BLTErrOk {
+					errs[w] = fmt.Errorf("blbench: preload InsertKey() = %v", err)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runWorker(tree *blink_tree.BLTree, cfg *Config, w int, res *Result) error {
+	gen := newGenerator(cfg, w)
+	for i := 0; i < cfg.Ops; i++ {
+		n := gen.key()
+		key := makeKey(n, cfg.KeySize)
+		switch op := gen.rnd.Float64(); {
+		case op < cfg.ReadRatio:
+			res.Finds++
+			if ret, _, _ := tree.FindKey(key, blink_tree.BtId); ret >= 0 {
+				res.Found++
+			}
+		case op < cfg.ReadRatio+cfg.DeleteRatio:
+			res.Deletes++
+			if err := tree.DeleteKey(key, 0); err != blink_tree.BLTErrOk {
+				return fmt.Errorf("blbench: DeleteKey() = %v", err)
+			}
+		default:
+			res.Inserts++
+			if err := tree.InsertKey(key, 0, value(n), true); err != blink_tree.BLTErrOk {
+				return fmt.Errorf("blbench: InsertKey() = %v", err)
+			}
+		}
+	}
+	return nil
+}
+
+// value returns value of key number. values have fixed size BtId
+func value(n uint64) []byte {
+	v := make([]byte, blink_tree.BtId)
+	for i := range v {
+		v[i] = byte(n >> (8 * i))
+	}
+	return v
+}
@@ -0,0 +1,79 @@
+package blbench
+
+import (
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	for _, dist := range []Distribution{Uniform, Zipfian, Sequential} {
+		cfg := Config{
+			Keys:        5000,
+			Ops:         2000,
+			Workers:     4,
+			KeySize:     16,
+			Dist:        dist,
+			ReadRatio:   0.5,
+			DeleteRatio: 0.1,
+			Preload:     true,
+			PoolSize:    256,
+		}
+		res, err := Run(cfg)
+		if err != nil {
+			t.Fatalf("Run(%v) = %v, want nil", dist, err)
+		}
+		if res.Ops() != cfg.Ops*cfg.Workers {
+			t.Errorf("Run(%v) ops = %v, want %v", dist, res.Ops(), cfg.Ops*cfg.Workers)
+		}
+		if res.Finds == 0 || res.Inserts == 0 || res.Deletes == 0 {
+			t.Errorf("Run(%v) = %+v, want all kinds of operations", dist, res)
+		}
+		if res.Found == 0 {
+			t.Errorf("Run(%v) found no preloaded key", dist)
+		}
+		if res.Latency.Find.Count != uint64(res.Finds) {
+			t.Errorf("Run(%v) find latency count = %v, want %v", dist, res.Latency.Find.Count, res.Finds)
+		}
+	}
+}
+
+func TestGenerator(t *testing.T) {
+	cfg := Config{Keys: 100, Workers: 2, Dist: Sequential}
+	if err := cfg.setDefaults(); err != nil {
+		t.Fatalf("setDefaults() = %v, want nil", err)
+	}
+	g := newGenerator(&cfg, 1)
+	for _, want := range []uint64{1, 3, 5} {
+		if got := g.key(); got != want {
+			t.Errorf("key() = %v, want %v", got, want)
+		}
+	}
+
+	cfg.Dist = Zipfian
+	g = newGenerator(&cfg, 0)
+	low := 0
+	for i := 0; i < 1000; i++ {
+		n := g.key()
+		if n >= 100 {
+			t.Fatalf("key() = %v, want less than %v", n, 100)
+		}
+		if n < 10 {
+			low++
+		}
+	}
+	if low < 500 {
+		t.Errorf("zipfian keys less than 10 = %v, want skewed to small keys", low)
+	}
+}
+
+func TestConfig_invalid(t *testing.T) {
+	for _, cfg := range []Config{
+		{KeySize: 4},
+		{ZipfS: 0.5},
+		{ReadRatio: 0.8, DeleteRatio: 0.5},
+		{Keys: 1},
+	} {
+		if _, err := Run(cfg); err == nil {
+			t.Errorf("Run(%+v) = nil, want error", cfg)
+		}
+	}
+}
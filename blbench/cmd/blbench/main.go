@@ -0,0 +1,43 @@
+// Command blbench runs a workload of package blbench and prints its result.
+//
+//	go run ./blbench/cmd/blbench -keys 1000000 -dist zipfian -read 0.9 -workers 8 -preload
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/ryogrid/bltree-go-for-embedding/blbench"
+)
+
+func main() {
+	var cfg blbench.Config
+	dist := flag.String("dist", "uniform", "distribution of keys: uniform, zipfian or sequential")
+	flag.IntVar(&cfg.Keys, "keys", 100000, "number of distinct keys")
+	flag.IntVar(&cfg.Ops, "ops", 100000, "number of operations of each worker")
+	flag.IntVar(&cfg.Workers, "workers", 1, "number of goroutines")
+	flag.IntVar(&cfg.KeySize, "keysize", 8, "key length in bytes")
+	flag.Float64Var(&cfg.ZipfS, "zipfs", 1.1, "skew of zipfian distribution")
+	flag.Float64Var(&cfg.ReadRatio, "read", 0, "fraction of finds")
+	flag.Float64Var(&cfg.DeleteRatio, "delete", 0, "fraction of deletes")
+	flag.BoolVar(&cfg.Preload, "preload", false, "insert all keys before measurement")
+	flag.Int64Var(&cfg.Seed, "seed", 1, "seed of random generators")
+	pageBits := flag.Uint("pagebits", 12, "page size bits")
+	flag.UintVar(&cfg.PoolSize, "pool", 4096, "buffer pool size in pages")
+	flag.Parse()
+
+	var ok bool
+	if cfg.Dist, ok = blbench.ParseDistribution(*dist); !ok {
+		fmt.Fprintf(os.Stderr, "unknown distribution: %s\n", *dist)
+		os.Exit(2)
+	}
+	cfg.PageBits = uint8(*pageBits)
+
+	res, err := blbench.Run(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Print(res)
+}
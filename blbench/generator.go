@@ -0,0 +1,84 @@
+package blbench
+
+import (
+	"encoding/binary"
+	"math/rand"
+)
+
+// Distribution is distribution of accessed keys
+type Distribution int
+
+const (
+	Uniform    Distribution = iota // every key is accessed with same probability
+	Zipfian                        // small key numbers are accessed much more often
+	Sequential                     // keys are accessed in ascending order by each worker
+)
+
+func (d Distribution) String() string {
+	switch d {
+	case Uniform:
+		return "uniform"
+	case Zipfian:
+		return "zipfian"
+	case Sequential:
+		return "sequential"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseDistribution returns Distribution of its name
+func ParseDistribution(name string) (Distribution, bool) {
+	for _, d := range []Distribution{Uniform, Zipfian, Sequential} {
+		if d.String() == name {
+			return d, true
+		}
+	}
+	return 0, false
+}
+
+// generator generates key numbers of a worker
+type generator struct {
+	dist Distribution
+	keys uint64
+	rnd  *rand.Rand
+	zipf *rand.Zipf
+	next uint64 // next key number of Sequential
+	step uint64 // stride of Sequential
+}
+
+func newGenerator(cfg *Config, worker int) *generator {
+	g := &generator{
+		dist: cfg.Dist,
+		keys: uint64(cfg.Keys),
+		rnd:  rand.New(rand.NewSource(cfg.Seed + int64(worker))),
+		next: uint64(worker),
+		step: uint64(cfg.Workers),
+	}
+	if g.dist == Zipfian {
+		g.zipf = rand.NewZipf(g.rnd, cfg.ZipfS, 1, g.keys-1)
+	}
+	return g
+}
+
+// key returns next key number
+func (g *generator) key() uint64 {
+	switch g.dist {
+	case Zipfian:
+		return g.zipf.Uint64()
+	case Sequential:
+		n := g.next % g.keys
+		g.next += g.step
+		return n
+	default:
+		return uint64(g.rnd.Int63n(int64(g.keys)))
+	}
+}
+
+// makeKey returns key of key number. keys are ordered by key number
+// and padded with zero bytes to size
+func makeKey(n uint64, size int) []byte {
+	key := make([]byte, size)
+	binary.BigEndian.PutUint64(key, n)
+	return key
+}
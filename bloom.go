@@ -0,0 +1,104 @@
+package blink_tree
+
+import (
+	"sync/atomic"
+)
+
+// leafBloom is a Bloom filter of keys of a leaf page in buffer pool
+type leafBloom struct {
+	pageNo Uid
+	bits   []uint64
+	hashes uint32
+}
+
+// SetLeafBloomFilter makes lookups of FindKey, FindKeyFunc and Exists check a Bloom filter
+// of the leaf page before the page is searched, so most lookups of absent keys don't
+// search the page. bitsPerKey bits are used for each key (10 gives about 1% false positives).
+// 0 disables the filters. filters are kept in memory with pages of buffer pool. they are
+// built at the first lookup of each page and dropped whenever the page is write locked.
+// when a lookup is answered by the filter, FindKey returns nil for foundKey.
+// filters are not used while key comparator is set
+func (mgr *BufMgr) SetLeafBloomFilter(bitsPerKey int) {
+	if bitsPerKey < 0 {
+		bitsPerKey = 0
+	}
+	atomic.StoreUint32(&mgr.bloomBits, uint32(bitsPerKey))
+}
+
+// leafMayContain reports whether leaf page of latch which is read locked may contain key.
+// it's true when Bloom filters are disabled
+func (mgr *BufMgr) leafMayContain(latch *Latchs, page *Page, key []byte) bool {
+	bitsPerKey := atomic.LoadUint32(&mgr.bloomBits)
+	if bitsPerKey == 0 || mgr.keyCompare != nil {
+		return true
+	}
+
+	bloom := mgr.blooms[latch.entry].Load()
+	if bloom == nil || bloom.pageNo != latch.pageNo {
+		bloom = newLeafBloom(latch.pageNo, page, bitsPerKey)
+		mgr.blooms[latch.entry].Store(bloom)
+	}
+	return bloom.mayContain(key)
+}
+
+// newLeafBloom builds a Bloom filter of live keys and the fence key of leaf page.
+// sequence suffixes of duplicate keys are excluded
+func newLeafBloom(pageNo Uid, page *Page, bitsPerKey uint32) *leafBloom {
+	words := (uint32(page.Act)*bitsPerKey + 63) / 64
+	if words == 0 {
+		words = 1
+	}
+	// optimal count of hash functions is bitsPerKey * ln 2
+	hashes := bitsPerKey * 69 / 100
+	if hashes < 1 {
+		hashes = 1
+	}
+	bloom := &leafBloom{pageNo: pageNo, bits: make([]uint64, words), hashes: hashes}
+
+	for slot := uint32(1); slot <= page.Cnt; slot++ {
+		if page.Typ(slot) == Librarian {
+			continue
+		}
+		// keys of dead fence key can continue to the right page
+		if page.Dead(slot) && slot < page.Cnt {
+			continue
+		}
+		key := page.Key(slot)
+		if page.Typ(slot) == Duplicate {
+			key = key[:len(key)-BtId]
+		}
+		bloom.add(key)
+	}
+	return bloom
+}
+
+func (b *leafBloom) add(key []byte) {
+	h1, h2 := bloomHash(key)
+	n := uint64(len(b.bits)) * 64
+	for i := uint32(0); i < b.hashes; i++ {
+		bit := (h1 + uint64(i)*h2) % n
+		b.bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+func (b *leafBloom) mayContain(key []byte) bool {
+	h1, h2 := bloomHash(key)
+	n := uint64(len(b.bits)) * 64
+	for i := uint32(0); i < b.hashes; i++ {
+		bit := (h1 + uint64(i)*h2) % n
+		if b.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// bloomHash returns two hashes of key for double hashing (FNV-1a)
+func bloomHash(key []byte) (uint64, uint64) {
+	h := uint64(14695981039346656037)
+	for _, c := range key {
+		h ^= uint64(c)
+		h *= 1099511628211
+	}
+	return h, h>>32 | h<<32 | 1
+}
@@ -0,0 +1,76 @@
+package blink_tree
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestBufMgr_SetLeafBloomFilter(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	mgr.SetLeafBloomFilter(10)
+	bltree := NewBLTree(mgr)
+
+	key := func(i uint64) []byte {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		return bs
+	}
+	num := uint64(3000)
+	for i := uint64(0); i < num; i += 2 {
+		if err := bltree.InsertKey(key(i), 0, []byte{byte(i), 0, 0, 0, 0, 0}, true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+	for i := uint64(1); i < 100; i += 2 {
+		if err := bltree.InsertKey([]byte{0xff, byte(i)}, 0, []byte{byte(i), 0, 0, 0, 0, 0}, false); err != BLTErrOk {
+			t.Fatalf("InsertKey() of duplicate key = %v, want %v", err, BLTErrOk)
+		}
+	}
+
+	check := func(when string, present func(i uint64) bool) {
+		for i := uint64(0); i < num; i++ {
+			ret, _, val := bltree.FindKey(key(i), BtId)
+			if want := present(i); (ret >= 0) != want || bltree.Exists(key(i)) != want {
+				t.Fatalf("FindKey(%v) %s = %v, want found %v", i, when, ret, want)
+			} else if want && val[0] != byte(i) {
+				t.Fatalf("FindKey(%v) %s = %v, want %v", i, when, val, byte(i))
+			}
+		}
+		if !bltree.Exists([]byte{0xff, 1}) || bltree.Exists([]byte{0xff, 2}) {
+			t.Fatalf("Exists() of duplicate key %s is wrong", when)
+		}
+	}
+	check("", func(i uint64) bool { return i%2 == 0 })
+
+	// most absent keys are answered by the filters
+	var reads, writes uint
+	filtered := 0
+	for i := uint64(1); i < num; i += 2 {
+		var set PageSet
+		if slot, err := mgr.probeLeaf(&set, key(i), &reads, &writes, bltree.deadline); slot == 0 && err == BLTErrOk {
+			filtered++
+			continue
+		}
+		mgr.PageUnlock(LockRead, set.latch)
+		mgr.UnpinLatch(set.latch)
+	}
+	if filtered < int(num/2)*9/10 {
+		t.Errorf("probeLeaf() filtered %v absent keys, want at least %v", filtered, int(num/2)*9/10)
+	}
+
+	// filters follow modifications of pages
+	for i := uint64(0); i < num; i += 4 {
+		if err := bltree.DeleteKey(key(i), 0); err != BLTErrOk {
+			t.Fatalf("DeleteKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+	for i := uint64(1); i < num; i += 4 {
+		if err := bltree.InsertKey(key(i), 0, []byte{byte(i), 0, 0, 0, 0, 0}, true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+	check("after modifications", func(i uint64) bool { return i%4 == 1 || i%4 == 2 })
+
+	mgr.SetLeafBloomFilter(0)
+	check("after disabled", func(i uint64) bool { return i%4 == 1 || i%4 == 2 })
+}
@@ -11,4 +11,20 @@ const (
 	BLTErrRead
 	BLTErrWrite
 	BLTErrAtomic
+	BLTErrCapacity // page number, parent page, serialization or buffer pool capacity is exhausted
+	BLTErrTimeout  // operation deadline is exceeded
+	BLTErrCodec    // value codec failed to decode stored value
+	BLTErrKey      // key is rejected by key validator
+	BLTErrCompare  // key comparator doesn't match the tree
+	BLTErrNotFound // key to be updated doesn't exist
+	BLTErrMismatch // value of key doesn't match expected value of CompareAndSwap or DeleteIf
+	BLTErrMerge    // merge operator isn't set
+	BLTErrNotEmpty // tree to be bulk loaded or set fixed widths has keys
+	BLTErrOrder    // keys to be bulk loaded aren't strictly ascending
+	BLTErrExpire   // expiration of keys isn't enabled
+	BLTErrClosed   // snapshot is closed
+	BLTErrRejected // write is rejected by write validator
+	BLTErrCorrupt  // page read from parent buffer manager doesn't match its checksum
+	BLTErrVersion  // page read from parent buffer manager is written in unsupported format version
+	BLTErrDecrypt  // page read from parent buffer manager can't be decrypted
 )
@@ -2,25 +2,206 @@ package blink_tree
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
 	"sync/atomic"
+	"time"
 )
 
+// BLTreeItr iterates keys and values of a range in ascending order.
+// leaf pages are read one by one as Next is called, so memory usage doesn't
+// depend on size of the range. no page is pinned or latched between calls of Next,
+// so an iterator abandoned in the middle of the range holds nothing but
+// its copy of the leaf page, which is released by Close or garbage collection.
+// when the page on the right of the copy was deleted or merged meanwhile,
+// the leaf page is searched again from the root with the last key, like Cursor.
+// like RangeScan, the iteration is not atomic with other tree operations
 type BLTreeItr struct {
-	keys   [][]byte
-	vals   [][]byte
-	curIdx uint32
-	elems  uint32
+	tree     *BLTree
+	lowerKey []byte // encoded lower bound (nil means no bound)
+	upperKey []byte // encoded upper bound (nil means no bound)
+	page     *Page  // copy of current leaf page
+	slot     uint32 // last read slot of page
+	last     []byte // last key of the range which Next went past (encoded)
+	after    []byte // keys not greater than after are skipped after searching again
+	skip     int    // count of keys still to be skipped
+	left     int    // count of keys still to be returned (-1 means no limit)
+	opts     ScanOptions
+	snap     *Snapshot // snapshot whose pages are read (nil means the live tree)
+	done     bool
+	err      BLTErr
 }
 
+// ScanOptions selects part of keys of a range for pagination
+type ScanOptions struct {
+	Offset   int  // count of leading keys to skip
+	Limit    int  // max count of keys to return (0 means no limit)
+	KeysOnly bool // return only keys. values are nil and not decoded
+
+	// bounds are inclusive unless they are excluded
+	ExcludeLower bool // lowerKey itself is not returned
+	ExcludeUpper bool // upperKey itself is not returned
+
+	// Filter is called with value of each key in the range and the key is
+	// returned only when it returns true. values which are rejected are not copied.
+	// the value is read-only and valid only until Filter returns.
+	// Offset and Limit count keys which are accepted
+	Filter func(value []byte) bool
+}
+
+// Next returns next key and value. ok is false when the iteration is
+// finished or failed. use Err to distinguish them
 func (itr *BLTreeItr) Next() (ok bool, key []byte, value []byte) {
-	if itr.curIdx >= itr.elems {
-		return false, nil, nil
+	if itr.left == 0 {
+		itr.done = true
+	}
+	for !itr.done {
+		if itr.slot >= itr.page.Cnt {
+			right := GetID(&itr.page.Right)
+			if right == 0 {
+				itr.done = true
+				break
+			}
+			itr.readPage(right)
+			continue
+		}
+
+		itr.slot++
+		slot := itr.slot
+		if itr.page.Dead(slot) || itr.page.Typ(slot) != Unique || itr.tree.mgr.expired(itr.page, slot) {
+			continue
+		}
+		// infinite stopper of the rightmost page
+		if slot == itr.page.Cnt && GetID(&itr.page.Right) == 0 {
+			itr.done = true
+			break
+		}
+
+		key := itr.page.Key(slot)
+		if itr.after != nil {
+			if itr.tree.mgr.compareKeys(key, itr.after) <= 0 {
+				continue
+			}
+			itr.after = nil
+		}
+		if itr.upperKey != nil {
+			if cmp := itr.tree.mgr.compareKeys(key, itr.upperKey); cmp > 0 || (cmp == 0 && itr.opts.ExcludeUpper) {
+				itr.done = true
+				break
+			}
+		}
+		if itr.lowerKey != nil {
+			if cmp := itr.tree.mgr.compareKeys(key, itr.lowerKey); cmp < 0 || (cmp == 0 && itr.opts.ExcludeLower) {
+				continue
+			}
+		}
+		itr.last = key
+		if itr.opts.Filter != nil {
+			val, err := itr.tree.mgr.decodeValue(itr.page.valueBytes(slot))
+			if err != BLTErrOk {
+				itr.fail(err)
+				break
+			}
+			if !itr.opts.Filter(val) {
+				continue
+			}
+		}
+		if itr.skip > 0 {
+			itr.skip--
+			continue
+		}
+		if itr.left > 0 {
+			itr.left--
+		}
+		if itr.opts.KeysOnly {
+			return true, decodeKey(key), nil
+		}
+
+		val, err := itr.tree.mgr.decodeValue(*itr.page.Value(slot))
+		if err != BLTErrOk {
+			itr.fail(err)
+			break
+		}
+		return true, decodeKey(key), val
 	}
-	key = itr.keys[itr.curIdx]
-	value = itr.vals[itr.curIdx]
-	itr.curIdx++
-	return true, key, value
+	return false, nil, nil
+}
+
+// Err returns error which stopped the iteration
+func (itr *BLTreeItr) Err() BLTErr {
+	return itr.err
+}
+
+// Close finishes the iteration and releases the copy of the leaf page.
+// Next returns false after Close. Close can be called more than once
+func (itr *BLTreeItr) Close() {
+	itr.done = true
+	itr.page = nil
+}
+
+func (itr *BLTreeItr) fail(err BLTErr) {
+	itr.tree.err = err
+	itr.err = err
+	itr.done = true
+}
+
+// readPage copies leaf page pageNo to page of iterator
+func (itr *BLTreeItr) readPage(pageNo Uid) {
+	tree := itr.tree
+	tree.startOp()
+
+	if itr.snap != nil {
+		if err := itr.snap.readPage(pageNo, itr.page); err != BLTErrOk {
+			itr.fail(err)
+			return
+		}
+		itr.slot = 0
+		return
+	}
+
+	start := tree.mgr.latencyStart()
+	latch, err := tree.mgr.pinLatch(pageNo, true, &tree.reads, &tree.writes, tree.deadline)
+	if latch == nil {
+		itr.fail(err)
+		return
+	}
+	if !tree.mgr.pageLockDeadline(LockRead, latch, tree.deadline) {
+		tree.mgr.UnpinLatch(latch)
+		itr.fail(BLTErrTimeout)
+		return
+	}
+	page := tree.mgr.GetRefOfPageAtPool(latch)
+	// right link of the copy is stale when the page was deleted or merged
+	// into its left page, and then freed or reused
+	stale := page.Kill || page.Free || page.Kind != PageKindTree || page.Lvl != 0
+	switch {
+	case stale:
+		// searched again after the latch is released
+	case itr.upperKey != nil && tree.mgr.keyBelowPage(page, itr.upperKey):
+		// page whose keys are all above upper bound isn't copied
+		itr.done = true
+	default:
+		MemCpyPage(itr.page, page)
+	}
+	tree.mgr.PageUnlock(LockRead, latch)
+	tree.mgr.UnpinLatch(latch)
+	tree.mgr.recordLatency(LatencyScanNext, start)
+	itr.slot = 0
+
+	if stale {
+		itr.reseek()
+	}
+}
+
+// reseek searches the leaf page again from the root with the last key
+// so that Next continues from the key after it
+func (itr *BLTreeItr) reseek() {
+	if itr.last == nil {
+		itr.seek(itr.lowerKey)
+		return
+	}
+	itr.after = itr.last
+	itr.seek(itr.last)
 }
 
 type BLTree struct {
@@ -34,6 +215,29 @@ type BLTree struct {
 	//key        [KeyArray]byte // last found complete key (Note: not used)
 	reads  uint // number of reads from the btree
 	writes uint // number of writes to the btree
+
+	opTimeout time.Duration // default deadline of each operation (0 means no deadline)
+	deadline  time.Time     // deadline of current operation
+
+	changeSeq uint64 // change sequence number of leaf modified by current operation (0 means no change)
+	changeVal []byte // value removed or overwritten by current operation
+	// condition of current insert which is checked under write lock of leaf page
+	// with stored value of the key (nil means no condition)
+	putCond func(exists bool, stored []byte) BLTErr
+	// computes value of current insert under write lock of leaf page
+	// from stored value of the key (nil means value is given)
+	putMerge func(exists bool, stored []byte) ([]byte, BLTErr)
+	// condition of current delete which is checked under write lock of leaf page
+	// with stored value of the key (nil means no condition)
+	delCond  func(stored []byte) bool
+	expireAt int64 // expiration time of value of current insert in unix nano (0 means never)
+	purging  bool  // current DeleteRange deletes only expired keys
+	// leaf pages locked by BeginAtomic (nil means no atomic unit is in progress)
+	atomics []*Latchs
+	// current DeleteRange stops at the first key without this prefix (nil means no prefix)
+	delPrefix []byte
+
+	lsn uint64 // LSN attached to pages modified by following operations
 }
 
 /*
@@ -92,21 +296,69 @@ func NewBLTree(bufMgr *BufMgr) *BLTree {
 	return &tree
 }
 
+// SetOpTimeout sets default deadline of each operation on the tree handle.
+// the deadline is enforced at page faults and latch waits while the key is
+// searched, and the operation returns BLTErrTimeout when it is exceeded.
+// structural modifications (page split, fence key posting) which are already
+// started are not interrupted. 0 disables the deadline
+func (tree *BLTree) SetOpTimeout(d time.Duration) {
+	tree.opTimeout = d
+}
+
+// SetLSN sets LSN which is attached to pages modified by following operations
+// on the tree handle. the largest LSN attached to a dirty page is passed to
+// ParentBufMgr which implements interfaces.ParentBufMgrWithLSN when the page
+// is written back, and it's kept in LSN of page header (see PageImageLSN).
+// typically the host sets LSN of its WAL record before
+// InsertKey or DeleteKey. LSN must not decrease on a handle.
+// ATTENTION: a handle shared by goroutines can't attach LSNs of each operation
+func (tree *BLTree) SetLSN(lsn uint64) {
+	tree.lsn = lsn
+}
+
+// startOp sets deadline of the operation which is starting
+func (tree *BLTree) startOp() {
+	tree.mgr.completeHalfSplits()
+	tree.err = BLTErrOk
+	if tree.opTimeout > 0 {
+		tree.deadline = time.Now().Add(tree.opTimeout)
+	} else {
+		tree.deadline = time.Time{}
+	}
+}
+
+// startStructureMod disables deadline of current operation
+// because modification of tree structure must not be interrupted
+func (tree *BLTree) startStructureMod() {
+	tree.deadline = time.Time{}
+}
+
 // fixFence
 // a fence key was deleted from a page,
 // push new fence value upwards
-func (tree *BLTree) fixFence(set *PageSet, lvl uint8) BLTErr {
+func (tree *BLTree) fixFence(posts *postStack, set *PageSet, lvl uint8) BLTErr {
 	// remove the old fence value
 	rightKey := set.page.Key(set.page.Cnt)
 	set.page.ClearSlot(set.page.Cnt)
 	set.page.Cnt--
-	set.latch.dirty = true
+	tree.markDirty(set.latch)
 
 	// cache new fence value
 	leftKey := set.page.Key(set.page.Cnt)
-
-	var value [BtId]byte
-	PutID(&value, set.latch.pageNo)
+	set.page.setHighFence()
+
+	// keys up to the old fence key are moved to the right page
+	// after the fence key is posted, so LowFence of the right page is lowered.
+	// the fence key of the rightmost page is the stopper key which is never deleted
+	if right := tree.mgr.PinLatch(GetID(&set.page.Right), true, &tree.reads, &tree.writes); right != nil {
+		tree.mgr.PageLock(LockWrite, right)
+		if page := tree.mgr.GetRefOfPageAtPool(right); !page.Kill {
+			page.LowFence = set.page.HighFence
+			tree.markDirty(right)
+		}
+		tree.mgr.PageUnlock(LockWrite, right)
+		tree.mgr.UnpinLatch(right)
+	}
 
 	if !ValidatePage(set.page) {
 		panic("fixFence: page is broken.")
@@ -116,26 +368,23 @@ func (tree *BLTree) fixFence(set *PageSet, lvl uint8) BLTErr {
 		panic("fixFence: page is broken.")
 	}
 
+	tree.startStructureMod()
 	tree.mgr.PageLock(LockParent, set.latch)
 	tree.mgr.PageUnlock(LockWrite, set.latch)
 
-	// insert new (now smaller) fence key
-
-	if err := tree.InsertKey(leftKey, lvl+1, value, true); err != BLTErrOk {
-		return err
-	}
-
-	// now delete old fence key
-	if err := tree.DeleteKey(rightKey, lvl+1); err != BLTErrOk {
-		return err
-	}
-
-	if !ValidatePage(set.page) {
-		panic("fixFence: page is broken.")
-	}
-
-	tree.mgr.PageUnlock(LockParent, set.latch)
-	tree.mgr.UnpinLatch(set.latch)
+	// insert new (now smaller) fence key, then delete old fence key.
+	// queued in reverse order of execution
+	latch := set.latch
+	page := set.page
+	posts.pushRelease(func(ok bool) {
+		if ok && !ValidatePage(page) {
+			panic("fixFence: page is broken.")
+		}
+		tree.mgr.PageUnlock(LockParent, latch)
+		tree.mgr.UnpinLatch(latch)
+	})
+	posts.pushDelete(rightKey, lvl+1)
+	posts.pushInsert(leftKey, lvl+1, latch.pageNo)
 
 	return BLTErrOk
 }
@@ -147,6 +396,9 @@ func (tree *BLTree) collapseRoot(root *PageSet) BLTErr {
 	var child PageSet
 	var pageNo Uid
 	var idx uint32
+
+	tree.startStructureMod()
+
 	// find the child entry and promote as new root contents
 	for {
 		idx = 1
@@ -172,7 +424,7 @@ func (tree *BLTree) collapseRoot(root *PageSet) BLTErr {
 			panic("collapseRoot: page is broken")
 		}
 		MemCpyPage(root.page, child.page)
-		root.latch.dirty = true
+		tree.markDirty(root.latch)
 		tree.mgr.PageFree(&child)
 
 		if !(root.page.Lvl > 1 && root.page.Act == 1) {
@@ -193,18 +445,29 @@ func (tree *BLTree) collapseRoot(root *PageSet) BLTErr {
 // delete a page and manage keys
 // call with page writelocked
 // returns with page unpinned
-func (tree *BLTree) deletePage(set *PageSet, mode BLTLockMode) BLTErr {
+func (tree *BLTree) deletePage(posts *postStack, set *PageSet, mode BLTLockMode) BLTErr {
+	_, err := tree.pullRight(posts, set, mode, false)
+	return err
+}
+
+// pullRight pulls contents of the right page into the page of set
+// and deletes the right page. when merge is true, live keys of the page
+// are kept before keys of the right page, and false is returned without
+// any change when they don't fit in a page
+func (tree *BLTree) pullRight(posts *postStack, set *PageSet, mode BLTLockMode, merge bool) (bool, BLTErr) {
 	var right PageSet
 	// cache copy of fence key to post in parent
 	lowerFence := set.page.Key(set.page.Cnt)
 
+	tree.startStructureMod()
+
 	// obtain lock on right page
 	pageNo := GetID(&set.page.Right)
 	right.latch = tree.mgr.PinLatch(pageNo, true, &tree.reads, &tree.writes)
 	if right.latch != nil {
 		right.page = tree.mgr.GetRefOfPageAtPool(right.latch)
 	} else {
-		return BLTErrOk
+		return false, BLTErrOk
 	}
 
 	tree.mgr.PageLock(LockWrite, right.latch)
@@ -215,12 +478,33 @@ func (tree *BLTree) deletePage(set *PageSet, mode BLTLockMode) BLTErr {
 
 	if right.page.Kill {
 		tree.err = BLTErrStruct
-		return tree.err
+		return false, tree.err
 	}
 
-	// pull contents of right peer into our empty page
-	MemCpyPage(set.page, right.page)
-	set.latch.dirty = true
+	// keys locked by an atomic unit are not moved out of the locked page
+	if right.page.Lvl == 0 && tree.mgr.atomicHeld(right.latch) {
+		tree.mgr.PageUnlock(mode, right.latch)
+		tree.mgr.PageUnlock(LockWrite, right.latch)
+		tree.mgr.UnpinLatch(right.latch)
+		return false, BLTErrOk
+	}
+
+	contents := right.page
+	if merge {
+		if contents = tree.mgr.mergedPage(set.page, right.page); contents == nil {
+			tree.mgr.PageUnlock(mode, right.latch)
+			tree.mgr.PageUnlock(LockWrite, right.latch)
+			tree.mgr.UnpinLatch(right.latch)
+			return false, BLTErrOk
+		}
+	}
+
+	// pull contents of right peer into our page.
+	// keys of our page stay above fence key of the left page
+	low := set.page.LowFence
+	MemCpyPage(set.page, contents)
+	set.page.LowFence = low
+	tree.markDirty(set.latch)
 
 	if !ValidatePage(set.page) {
 		panic("deletePage: page is broken.")
@@ -230,44 +514,49 @@ func (tree *BLTree) deletePage(set *PageSet, mode BLTLockMode) BLTErr {
 	// until we can post parent updates that remove access
 	// to the deleted page.
 	PutID(&right.page.Right, set.latch.pageNo)
-	right.latch.dirty = true
+	tree.markDirty(right.latch)
 	right.page.Kill = true
 
-	// redirect higher key directly to our new node contents
-	var value [BtId]byte
-	PutID(&value, set.latch.pageNo)
-
 	tree.mgr.PageLock(LockParent, right.latch)
 	tree.mgr.PageUnlock(LockWrite, right.latch)
 	tree.mgr.PageUnlock(mode, right.latch)
 	tree.mgr.PageLock(LockParent, set.latch)
 	tree.mgr.PageUnlock(LockWrite, set.latch)
 
-	if err := tree.InsertKey(higherFence, set.page.Lvl+1, value, true); err != BLTErrOk {
-		return err
-	}
+	// redirect higher key directly to our new node contents,
+	// then delete old lower key to our node.
+	// queued in reverse order of execution
+	left := *set
+	posts.pushRelease(func(ok bool) {
+		if !ok {
+			// right page stays killed and points to left page
+			tree.mgr.PageUnlock(LockParent, right.latch)
+			tree.mgr.UnpinLatch(right.latch)
+			tree.mgr.PageUnlock(LockParent, left.latch)
+			tree.mgr.UnpinLatch(left.latch)
+			return
+		}
 
-	// delete old lower key to our node
-	if err := tree.DeleteKey(lowerFence, set.page.Lvl+1); err != BLTErrOk {
-		return err
-	}
+		if !ValidatePage(right.page) {
+			panic("deletePage: page is broken.")
+		}
+		if !ValidatePage(left.page) {
+			panic("deletePage: page is broken.")
+		}
 
-	if !ValidatePage(right.page) {
-		panic("fixFence: page is broken.")
-	}
-	if !ValidatePage(set.page) {
-		panic("fixFence: page is broken.")
-	}
+		// obtain delete and write locks to right node
+		tree.mgr.PageUnlock(LockParent, right.latch)
+		tree.mgr.PageLock(LockDelete, right.latch)
+		tree.mgr.PageLock(LockWrite, right.latch)
+		tree.mgr.PageFree(&right)
+		tree.mgr.PageUnlock(LockParent, left.latch)
+		tree.mgr.UnpinLatch(left.latch)
+	})
+	posts.pushDelete(lowerFence, set.page.Lvl+1)
+	posts.pushInsert(higherFence, set.page.Lvl+1, set.latch.pageNo)
 
-	// obtain delete and write locks to right node
-	tree.mgr.PageUnlock(LockParent, right.latch)
-	tree.mgr.PageLock(LockDelete, right.latch)
-	tree.mgr.PageLock(LockWrite, right.latch)
-	tree.mgr.PageFree(&right)
-	tree.mgr.PageUnlock(LockParent, set.latch)
-	tree.mgr.UnpinLatch(set.latch)
 	//tree.found = true
-	return BLTErrOk
+	return true, BLTErrOk
 }
 
 // DeleteKey
@@ -275,12 +564,152 @@ func (tree *BLTree) deletePage(set *PageSet, mode BLTLockMode) BLTErr {
 // find and delete key on page by marking delete flag bit
 // if page becomes empty, delete it from the btree
 func (tree *BLTree) DeleteKey(key []byte, lvl uint8) BLTErr {
+	if lvl > 0 {
+		return tree.deleteKey(key, lvl)
+	}
+	defer tree.mgr.recordLatency(LatencyDelete, tree.mgr.latencyStart())
+
+	if err := tree.mgr.validateKey(key); err != BLTErrOk {
+		tree.err = err
+		return err
+	}
+	if err := tree.mgr.validateWrite(ChangeDelete, key, nil); err != BLTErrOk {
+		tree.err = err
+		return err
+	}
+	del, err := encodeKey(key)
+	if err != BLTErrOk {
+		tree.err = err
+		return err
+	}
+
+	tree.changeSeq = 0
+	tree.changeVal = nil
+	err = tree.deleteKey(del, lvl)
+	if err == BLTErrOk && tree.changeSeq > 0 {
+		tree.mgr.notifyChange(tree.changeSeq, ChangeDelete, key, tree.mgr.changedValue(tree.changeVal), nil)
+	}
+	tree.mgr.enforceDirtyQuota(&tree.reads, &tree.writes)
+	return err
+}
+
+// Remove deletes key like DeleteKey and reports whether key existed
+// and its deleted value. they are captured while the leaf page is write locked,
+// so FindKey before DeleteKey isn't needed for read-modify-delete
+func (tree *BLTree) Remove(key []byte) (found bool, value []byte, err BLTErr) {
+	if err = tree.DeleteKey(key, 0); err != BLTErrOk {
+		return false, nil, err
+	}
+	if tree.changeVal == nil {
+		return false, nil, BLTErrOk
+	}
+	if value, err = tree.mgr.decodeValue(tree.changeVal); err != BLTErrOk {
+		tree.err = err
+		return true, nil, err
+	}
+	return true, value, BLTErrOk
+}
+
+// DeleteIf deletes key only when its current value equals expected.
+// the comparison and the deletion are done under write lock of the leaf page,
+// so a value replaced by another writer isn't deleted. BLTErrMismatch is returned
+// when value of key isn't expected or key doesn't exist
+func (tree *BLTree) DeleteIf(key []byte, expected []byte) BLTErr {
+	tree.delCond = func(stored []byte) bool {
+		current, err := tree.mgr.decodeValue(stored)
+		return err == BLTErrOk && bytes.Equal(current, expected)
+	}
+	err := tree.DeleteKey(key, 0)
+	tree.delCond = nil
+	if err == BLTErrOk && tree.changeVal == nil {
+		return BLTErrMismatch
+	}
+	return err
+}
+
+// DeleteDuplicate deletes the first key which is equal to key and whose value
+// equals value. duplicate keys and unique key are searched in order of FindAll.
+// the value is compared while the leaf page is write locked. returns false
+// when such key doesn't exist
+func (tree *BLTree) DeleteDuplicate(key []byte, value []byte) (bool, BLTErr) {
+	defer tree.mgr.recordLatency(LatencyDelete, tree.mgr.latencyStart())
+
+	if err := tree.mgr.validateKey(key); err != BLTErrOk {
+		tree.err = err
+		return false, err
+	}
+	if err := tree.mgr.validateWrite(ChangeDelete, key, nil); err != BLTErrOk {
+		tree.err = err
+		return false, err
+	}
+	del, err := encodeKey(key)
+	if err != BLTErrOk {
+		tree.err = err
+		return false, err
+	}
+
+	matches := func(stored []byte) bool {
+		val, err := tree.mgr.decodeValue(stored)
+		return err == BLTErrOk && bytes.Equal(val, value)
+	}
+	for {
+		// stored key with sequence suffix identifies the entry
+		tree.startOp()
+		var entry []byte
+		if err = tree.findEntries(del, func(page *Page, slot uint32) bool {
+			if matches(page.valueBytes(slot)) {
+				entry = page.Key(slot)
+				return false
+			}
+			return true
+		}); err != BLTErrOk || entry == nil {
+			return false, err
+		}
+
+		tree.changeSeq = 0
+		tree.changeVal = nil
+		tree.delCond = matches
+		err = tree.deleteKey(entry, 0)
+		tree.delCond = nil
+		if err != BLTErrOk {
+			return false, err
+		}
+		if tree.changeSeq > 0 {
+			tree.mgr.notifyChange(tree.changeSeq, ChangeDelete, key, value, nil)
+		}
+		if tree.changeVal != nil {
+			tree.mgr.enforceDirtyQuota(&tree.reads, &tree.writes)
+			return true, BLTErrOk
+		}
+		// the entry was deleted or updated by another writer
+	}
+}
+
+func (tree *BLTree) deleteKey(key []byte, lvl uint8) BLTErr {
+	if lvl == 0 {
+		tree.startOp()
+	}
+
+	var posts postStack
+	posts.pushDelete(key, lvl)
+	return tree.runPosts(&posts)
+}
+
+// deleteOnce deletes key at the level.
+// updates of upper level are queued on posts
+func (tree *BLTree) deleteOnce(posts *postStack, key []byte, lvl uint8) BLTErr {
 	var set PageSet
 
-	slot := tree.mgr.PageFetch(&set, key, lvl, LockWrite, &tree.reads, &tree.writes)
+	slot, err := tree.mgr.pageFetch(&set, key, lvl, LockWrite, &tree.reads, &tree.writes, tree.deadline)
 	if slot == 0 {
+		if err == BLTErrTimeout {
+			tree.err = err
+		}
 		return tree.err
 	}
+	if lvl == 0 {
+		tree.mgr.recordAccess(key, set.latch.pageNo)
+	}
 	ptr := set.page.Key(slot)
 
 	if !ValidatePage(set.page) {
@@ -296,13 +725,20 @@ func (tree *BLTree) DeleteKey(key []byte, lvl uint8) BLTErr {
 	fence := slot == set.page.Cnt
 
 	// if key is found delete it, otherwise ignore request
-	found := KeyCmp(ptr, key) == 0
+	found := tree.mgr.compareKeys(ptr, key) == 0
 	if found {
-		found = !set.page.Dead(slot)
+		found = !set.page.Dead(slot) && !tree.mgr.expired(set.page, slot)
+		if found && lvl == 0 && tree.delCond != nil {
+			found = tree.delCond(set.page.valueBytes(slot))
+		}
 		if found {
 			val := *set.page.Value(slot)
 			set.page.SetDead(slot, true)
-			set.page.Garbage += uint32(1+len(ptr)) + uint32(1+len(val))
+			if lvl == 0 {
+				tree.changeSeq = tree.mgr.nextChangeSeq()
+				tree.changeVal = val
+			}
+			set.page.Garbage += set.page.entrySize(slot)
 			set.page.Act--
 
 			// collapse empty slots beneath the fence
@@ -326,7 +762,7 @@ func (tree *BLTree) DeleteKey(key []byte, lvl uint8) BLTErr {
 
 	// did we delete a fence key in an upper level?
 	if found && lvl > 0 && set.page.Act > 0 && fence {
-		if err := tree.fixFence(&set, lvl); err != BLTErrOk {
+		if err := tree.fixFence(posts, &set, lvl); err != BLTErrOk {
 			return err
 		} else {
 			return BLTErrOk
@@ -344,14 +780,21 @@ func (tree *BLTree) DeleteKey(key []byte, lvl uint8) BLTErr {
 
 	// delete empty page
 	if set.page.Act == 0 {
-		return tree.deletePage(&set, LockNone)
+		return tree.deletePage(posts, &set, LockNone)
+	}
+
+	// merge underfull leaf page into the right page
+	if found && lvl == 0 {
+		if merged, err := tree.mergeUnderflow(posts, &set); merged || err != BLTErrOk {
+			return err
+		}
 	}
 
 	if !ValidatePage(set.page) {
 		panic("DeleteKey: page is broken.")
 	}
 
-	set.latch.dirty = true
+	tree.markDirty(set.latch)
 	tree.mgr.PageUnlock(LockWrite, set.latch)
 	tree.mgr.UnpinLatch(set.latch)
 	return BLTErrOk
@@ -367,10 +810,12 @@ func (tree *BLTree) findNext(set *PageSet, slot uint32) uint32 {
 	prevLatch := set.latch
 	pageNo := GetID(&set.page.Right)
 	if pageNo > 0 {
-		set.latch = tree.mgr.PinLatch(pageNo, true, &tree.reads, &tree.writes)
-		if set.latch != nil {
+		latch, err := tree.mgr.pinLatch(pageNo, true, &tree.reads, &tree.writes, tree.deadline)
+		if latch != nil {
+			set.latch = latch
 			set.page = tree.mgr.GetRefOfPageAtPool(set.latch)
 		} else {
+			tree.err = err
 			return 0
 		}
 	} else {
@@ -387,33 +832,187 @@ func (tree *BLTree) findNext(set *PageSet, slot uint32) uint32 {
 	return 1
 }
 
+// IsKeyPathResident reports whether all pages from the root page to the leaf page
+// which contains key are in the buffer pool, so that FindKey of key doesn't fetch
+// pages from parent buffer manager. like BufMgr.IsResident, the result is a hint
+func (tree *BLTree) IsKeyPathResident(key []byte) bool {
+	key, err := encodeKey(key)
+	if err != BLTErrOk {
+		return false
+	}
+
+	pageNo := RootPage
+	for pageNo > 0 {
+		latch := tree.mgr.pinResident(pageNo)
+		if latch == nil {
+			return false
+		}
+		tree.mgr.PageLock(LockRead, latch)
+		page := tree.mgr.GetRefOfPageAtPool(latch)
+
+		// slide right unless key is found on the page
+		leaf := false
+		free := page.Free
+		next := GetID(&page.Right)
+		if !page.Free && !page.Kill {
+			if slot := page.findSlot(key, tree.mgr.keyCompare); slot > 0 {
+				if page.Lvl == 0 {
+					leaf = true
+				} else {
+					for slot < page.Cnt && page.Dead(slot) {
+						slot++
+					}
+					if !page.Dead(slot) {
+						next = GetIDFromValue(page.Value(slot))
+					}
+				}
+			}
+		}
+
+		tree.mgr.PageUnlock(LockRead, latch)
+		tree.mgr.UnpinLatch(latch)
+		if leaf {
+			return true
+		} else if free {
+			return false
+		}
+		pageNo = next
+	}
+	return false
+}
+
 // FindKey
 //
 // find unique key or first duplicate key in
 // leaf level and return number of value bytes
-// or (-1) if not found. Setup key for foundKey
+// or (-1) if not found. Setup key for foundKey.
+// (-1) is also returned when the search failed. use FindKeyErr
+// to tell it from a missing key
 func (tree *BLTree) FindKey(key []byte, valMax int) (ret int, foundKey []byte, foundValue []byte) {
-	var set PageSet
 	ret = -1
 
-	slot := tree.mgr.PageFetch(&set, key, 0, LockRead, &tree.reads, &tree.writes)
-	for ; slot > 0; slot = tree.findNext(&set, slot) {
-		ptr := set.page.Key(slot)
+	defer tree.mgr.recordLatency(LatencyFind, tree.mgr.latencyStart())
 
+	foundKey = tree.findKey(key, true, func(page *Page, slot uint32) {
+		val, err := tree.mgr.decodeValue(*page.Value(slot))
+		if err != BLTErrOk {
+			tree.err = err
+			return
+		}
+		if valMax > len(val) {
+			valMax = len(val)
+		}
+		foundValue = make([]byte, valMax)
+		copy(foundValue, val[:])
+		ret = valMax
+	})
+
+	return ret, foundKey, foundValue
+}
+
+// FindKeyErr is FindKey which distinguishes a missing key from a failed search.
+// found is false and err is BLTErrOk when key doesn't exist.
+// err is not BLTErrOk when the search failed, e.g. page fetch failed
+// or the operation timed out, and then found is false
+func (tree *BLTree) FindKeyErr(key []byte, valMax int) (found bool, foundKey []byte, foundValue []byte, err BLTErr) {
+	ret, foundKey, foundValue := tree.FindKey(key, valMax)
+	if tree.err != BLTErrOk {
+		return false, nil, nil, tree.err
+	}
+	if ret < 0 {
+		return false, nil, nil, BLTErrOk
+	}
+	return true, foundKey, foundValue, BLTErrOk
+}
+
+// Exists reports whether unique key or duplicate key exists.
+// unlike FindKey, found key and value are not copied
+func (tree *BLTree) Exists(key []byte) bool {
+	var set PageSet
+
+	defer tree.mgr.recordLatency(LatencyFind, tree.mgr.latencyStart())
+
+	tree.startOp()
+
+	key, tree.err = encodeKey(key)
+	if tree.err != BLTErrOk {
+		return false
+	}
+
+	slot, err := tree.mgr.probeLeaf(&set, key, &tree.reads, &tree.writes, tree.deadline)
+	if slot == 0 {
+		tree.err = err
+		return false
+	}
+	tree.mgr.recordAccess(key, set.latch.pageNo)
+
+	exists := false
+	for ; slot > 0; slot = tree.findNext(&set, slot) {
 		// skip librarian slot place holder
 		if set.page.Typ(slot) == Librarian {
 			slot++
-			ptr = set.page.Key(slot)
 		}
 
-		// return actual key found
-		foundKey = make([]byte, len(ptr))
-		copy(foundKey, ptr)
+		// not there if we reach the stopper key
+		if slot == set.page.Cnt && GetID(&set.page.Right) == 0 {
+			break
+		}
+		if set.page.Dead(slot) || tree.mgr.expired(set.page, slot) {
+			continue
+		}
+
+		suffix := 0
+		if set.page.Typ(slot) == Duplicate {
+			suffix = BtId
+		}
+		exists = set.page.hasKey(slot, key, suffix, tree.mgr.keyCompare)
+		break
+	}
+
+	tree.mgr.PageUnlock(LockRead, set.latch)
+	tree.mgr.UnpinLatch(set.latch)
+	return exists
+}
+
+// findKey finds unique key or first duplicate key in leaf level and calls found
+// with the slot of key while its page is pinned and read locked.
+// found isn't called when key doesn't exist. returns actual key found
+// when wantKey is true
+func (tree *BLTree) findKey(key []byte, wantKey bool, found func(page *Page, slot uint32)) (foundKey []byte) {
+	var set PageSet
 
-		keyLen := len(ptr)
+	tree.startOp()
+
+	key, tree.err = encodeKey(key)
+	if tree.err != BLTErrOk {
+		return nil
+	}
+
+	slot, err := tree.mgr.probeLeaf(&set, key, &tree.reads, &tree.writes, tree.deadline)
+	if slot == 0 {
+		tree.err = err
+		return nil
+	}
+	tree.mgr.recordAccess(key, set.latch.pageNo)
+	for ; slot > 0; slot = tree.findNext(&set, slot) {
+		// skip librarian slot place holder
+		if set.page.Typ(slot) == Librarian {
+			slot++
+		}
 
+		suffix := 0
 		if set.page.Typ(slot) == Duplicate {
-			keyLen -= BtId
+			suffix = BtId
+		}
+
+		// return actual key found
+		if wantKey {
+			ptr := set.page.Key(slot)
+			keyLen := len(ptr) - suffix
+			userKey := decodeKey(ptr[:keyLen])
+			foundKey = make([]byte, len(userKey)+suffix)
+			copy(foundKey, userKey)
+			copy(foundKey[len(userKey):], ptr[keyLen:])
 		}
 
 		// not there if we reach the stopper key
@@ -423,22 +1022,13 @@ func (tree *BLTree) FindKey(key []byte, valMax int) (ret int, foundKey []byte, f
 			}
 		}
 
-		// if key exists, return >= 0 value bytes copied
-		// otherwise return (-1)
-		if set.page.Dead(slot) {
+		// if key exists, call found
+		if set.page.Dead(slot) || tree.mgr.expired(set.page, slot) {
 			continue
 		}
 
-		if keyLen == len(key) {
-			if KeyCmp(ptr[:keyLen], key) == 0 {
-				val := *set.page.Value(slot)
-				if valMax > len(val) {
-					valMax = len(val)
-				}
-				foundValue = make([]byte, valMax)
-				copy(foundValue, val[:])
-				ret = valMax
-			}
+		if set.page.hasKey(slot, key, suffix, tree.mgr.keyCompare) {
+			found(set.page, slot)
 		}
 		break
 
@@ -447,7 +1037,7 @@ func (tree *BLTree) FindKey(key []byte, valMax int) (ret int, foundKey []byte, f
 	tree.mgr.PageUnlock(LockRead, set.latch)
 	tree.mgr.UnpinLatch(set.latch)
 
-	return ret, foundKey, foundValue
+	return foundKey
 }
 
 func (tree *BLTree) removeDeletedAndLibrarianSlots(page *Page, slot uint32) {
@@ -474,29 +1064,26 @@ func (tree *BLTree) removeDeletedAndLibrarianSlots(page *Page, slot uint32) {
 			continue
 		}
 
-		// copy the value across
+		// copy the key and value across
 		val := *frame.Value(cnt)
-		nxt -= uint32(len(val) + 1)
-		copy(page.Data[nxt:], append([]byte{byte(len(val))}, val[:]...))
-
-		// copy the key across
 		key := frame.Key(cnt)
-		nxt -= uint32(len(key) + 1)
-		copy(page.Data[nxt:], append([]byte{byte(len(key))}, key[:]...))
+		nxt = page.putEntry(nxt, key, 0, val)
 
 		// not make librarian slot
 
 		// set up the slot
 		idx++
-		page.SetKeyOffset(idx, nxt)
+		page.setEntry(idx, nxt, key, 0, val)
 		page.SetTyp(idx, frame.Typ(cnt))
 
 		page.SetDead(idx, false)
+		page.setSlotFlags(idx, frame.SlotFlags(cnt))
 		page.Act++
 	}
 
 	page.Min = nxt
 	page.Cnt = idx
+	page.setKeyPrefixFlag(false)
 
 	if !ValidatePage(page) {
 		panic("cleanPage: page is broken.")
@@ -510,24 +1097,68 @@ func (tree *BLTree) removeDeletedAndLibrarianSlots(page *Page, slot uint32) {
 //	clean if necessary and return
 //	0 - page needs splitting
 //	>0 new slot value
-func (tree *BLTree) cleanPage(set *PageSet, keyLen uint8, slot uint32, valLen uint8) uint32 {
-	nxt := tree.mgr.pageDataSize
+//
+// dead slots are purged at once without waiting for scans. Cursor and BLTreeItr
+// read slots of their own copy of the leaf page, which is taken under read latch,
+// and Snapshot preserves pages before they're write locked, so no scan holds
+// offsets of a page in buffer pool after its latch is released. a cursor moves
+// to another page by searching again with its key, and an iterator follows
+// the right link of its copy and searches again when the page is killed or freed
+func (tree *BLTree) cleanPage(set *PageSet, key []byte, slot uint32, valLen uint8) uint32 {
 	page := set.page
 	max := page.Cnt
+	keyLen := uint32(len(key))
 
 	if !ValidatePage(page) {
 		panic("cleanPage: page broken!")
 	}
 
+	// keys are stored without common prefix of keys of the page. when key doesn't
+	// share the whole key prefix of the page, the page is compacted with shorter
+	// prefix before key is inserted, so that every key of the page shares it
+	var pagePrefix []byte
+	if page.hasKeyPrefix() {
+		pagePrefix = page.keyPrefix()
+	}
+	shrink := sharedPrefixLen(key, pagePrefix) < uint32(len(pagePrefix))
+	prefix := pagePrefix
+	cleaned := page.Lvl > 0
+	if cleaned {
+		prefix = page.cleanedKeyPrefix(key)
+	}
+	storedLen := keyLen - uint32(len(prefix))
+
 	// skip cleanup and proceed to split
 	// if there's not enough garbage to bother with.
+	reserve := atomic.LoadUint32(&tree.mgr.cleanupBytes)
 
 	//dataSpaceAfterClean := (tree.mgr.pageDataSize - page.Min) + page.Garbage
-	dataSpaceAfterClean := uint32(1+keyLen+1+valLen) * (page.Act + 1)
+	dataSpaceAfterClean := (2+storedLen+uint32(valLen))*(page.Act+1) + keyPrefixSize(prefix)
+	live := page.Act
+	if page.Lvl == 0 {
+		// values of leaf page vary in length, so live entries are measured.
+		// fence key is kept even if it's dead
+		dataSpaceAfterClean = tree.mgr.pageDataSize - page.Min - page.Garbage + 2 + keyLen + uint32(valLen)
+		if page.Dead(max) {
+			dataSpaceAfterClean += page.entrySize(max)
+		}
+		// expired keys are dropped by the cleanup
+		size, keys := tree.mgr.expiredEntries(page)
+		dataSpaceAfterClean -= size
+		live -= keys
+		if shrink || dataSpaceAfterClean+(live*2+1)*SlotSize > tree.mgr.pageDataSize-reserve {
+			// kept keys are stored without their common prefix after the cleanup.
+			// it's shorter than key prefix of the page when key doesn't share it
+			prefix = page.cleanedKeyPrefix(key)
+			storedLen = keyLen - uint32(len(prefix))
+			dataSpaceAfterClean = tree.mgr.compactedSize(page, prefix) + 2 + storedLen + uint32(valLen)
+			cleaned = true
+		}
+	}
 
 	//afterCleanSize := (tree.mgr.pageDataSize - page.Min) - page.Garbage + (page.Act*2+1)*SlotSize
-	afterCleanSize := dataSpaceAfterClean + (page.Act*2+1)*SlotSize
-	if int(tree.mgr.pageDataSize)-int(afterCleanSize) < int(tree.mgr.pageDataSize/5) {
+	afterCleanSize := dataSpaceAfterClean + (live*2+1)*SlotSize
+	if int(tree.mgr.pageDataSize)-int(afterCleanSize) < int(reserve) {
 		//tree.removeDeletedAndLibrarianSlots(set.page, slot)
 		//set.latch.dirty = true
 		return 0
@@ -538,25 +1169,68 @@ func (tree *BLTree) cleanPage(set *PageSet, keyLen uint8, slot uint32, valLen ui
 	//	return slot
 	//}
 
-	if dataSpaceAfterClean+(page.Act*2+1)*SlotSize > tree.mgr.pageDataSize {
+	if dataSpaceAfterClean+(live*2+1)*SlotSize > tree.mgr.pageDataSize {
 		// in this case, after cleanup, header space and data space overlaps and it's an illegal state of page
 		//tree.removeDeletedAndLibrarianSlots(set.page, slot)
 		//set.latch.dirty = true
 		return 0
 	}
 
-	if page.Min >= (max+2)*SlotSize+uint32(keyLen)+1+uint32(valLen)+1 {
+	if !shrink && page.Min >= (max+2)*SlotSize+keyLen+1+uint32(valLen)+1 {
 		return slot
 	}
 
+	if !cleaned {
+		prefix = page.cleanedKeyPrefix(key)
+		storedLen = keyLen - uint32(len(prefix))
+	}
+	newSlot := tree.compactPage(set, prefix, slot)
+
+	// see if page has enough space now, or does it need splitting?
+	//if tree.mgr.pageDataSize-page.Min < tree.mgr.pageDataSize/5 {
+	if page.Min < reserve {
+		//tree.removeDeletedAndLibrarianSlots(set.page, slot)
+		//set.latch.dirty = true
+		return 0
+	} else if page.Min > (page.Cnt+2)*SlotSize+uint32(storedLen)+1+uint32(valLen)+1 {
+		return newSlot
+	} else {
+		panic("cleanPage: page is broken.")
+	}
+}
+
+// compactedSize returns size of data area which keys kept by compactPage
+// take when they are stored without prefix, including the prefix itself
+func (mgr *BufMgr) compactedSize(page *Page, prefix []byte) uint32 {
+	size := keyPrefixSize(prefix)
+	for slot := uint32(1); slot <= page.Cnt; slot++ {
+		// librarian slots are dead
+		if slot < page.Cnt && (page.Dead(slot) || mgr.expired(page, slot)) {
+			continue
+		}
+		size += page.rebuiltEntrySize(slot, prefix)
+	}
+	return size
+}
+
+// compactPage rebuilds page of set without dead keys, expired keys and keys dropped
+// by compaction filter, and puts librarian slots between remaining keys.
+// keys are stored without prefix. dead fence key is kept.
+// returns new slot of the key which was at slot
+func (tree *BLTree) compactPage(set *PageSet, prefix []byte, slot uint32) uint32 {
+	page := set.page
+	max := page.Cnt
+	nxt := tree.mgr.pageDataSize
+
 	frame := NewPage(tree.mgr.pageDataSize)
 	MemCpyPage(frame, page)
 
 	// skip page info and set rest of page to zero
 	page.Data = make([]byte, tree.mgr.pageDataSize)
-	set.latch.dirty = true
+	tree.markDirty(set.latch)
 	page.Garbage = 0
 	page.Act = 0
+	nxt = page.putKeyPrefix(prefix)
 
 	// clean up page first by removing deleted keys
 	newSlot := max
@@ -578,56 +1252,55 @@ func (tree *BLTree) cleanPage(set *PageSet, keyLen uint8, slot uint32, valLen ui
 
 		// copy the value across
 		val := *frame.Value(cnt)
-		nxt -= uint32(len(val) + 1)
-		copy(page.Data[nxt:], append([]byte{byte(len(val))}, val[:]...))
+		if cnt < max && frame.Lvl == 0 && frame.Typ(cnt) != Librarian {
+			var keep bool
+			if keep, val = tree.mgr.applyCompactionFilter(frame.Key(cnt), val); !keep {
+				continue
+			}
+		}
 
 		// copy the key across
 		key := frame.Key(cnt)
-		nxt -= uint32(len(key) + 1)
-		copy(page.Data[nxt:], append([]byte{byte(len(key))}, key[:]...))
+		pre := sharedPrefixLen(key, prefix)
+		nxt = page.putEntry(nxt, key, pre, val)
 
 		// make a librarian slot
 		if idx > 0 {
 			idx++
-			page.SetKeyOffset(idx, nxt)
+			page.setEntry(idx, nxt, key, pre, val)
 			page.SetTyp(idx, Librarian)
 			page.SetDead(idx, true)
 		}
 
 		// set up the slot
 		idx++
-		page.SetKeyOffset(idx, nxt)
+		page.setEntry(idx, nxt, key, pre, val)
 		page.SetTyp(idx, frame.Typ(cnt))
 
 		if nxt <= idx*SlotSize {
 			//log.Printf("cleanPage: nxt overlaps with the slot area!!! nxt: %d, idx: %d, keyLen: %d, valLen: %d, set.latch.pageNo: %d, slot: %d, frame.header: %v, frame.data: %v\n", nxt, idx, keyLen, valLen, set.latch.pageNo, slot, frame.PageHeader, frame.Data)
-			panic(fmt.Sprintf("cleanPage: nxt overlaps with the slot area!!! nxt: %d, idx: %d, cnt: %d, keyLen: %d, valLen: %d, set.latch.pageNo: %d, slot: %d, frame.header: %v, frame.data: %v\n", nxt, idx, set.page.Cnt, keyLen, valLen, set.latch.pageNo, slot, frame.PageHeader, frame.Data))
+			panic(fmt.Sprintf("compactPage: nxt overlaps with the slot area!!! nxt: %d, idx: %d, cnt: %d, set.latch.pageNo: %d, slot: %d, frame.header: %v, frame.data: %v\n", nxt, idx, set.page.Cnt, set.latch.pageNo, slot, frame.PageHeader, frame.Data))
 		}
 
 		page.SetDead(idx, frame.Dead(cnt))
+		page.setSlotFlags(idx, frame.SlotFlags(cnt))
 		if !page.Dead(idx) {
 			page.Act++
+		} else {
+			// dead fence key is kept
+			page.Garbage += page.entrySize(idx)
 		}
 	}
 
 	page.Min = nxt
-	page.Cnt = idx
-
-	if !ValidatePage(page) {
-		panic("cleanPage: page is broken.")
-	}
-
-	// see if page has enough space now, or does it need splitting?
-	//if tree.mgr.pageDataSize-page.Min < tree.mgr.pageDataSize/5 {
-	if page.Min < tree.mgr.pageDataSize/5 {
-		//tree.removeDeletedAndLibrarianSlots(set.page, slot)
-		//set.latch.dirty = true
-		return 0
-	} else if page.Min > (idx+2)*SlotSize+uint32(keyLen)+1+uint32(valLen)+1 {
-		return newSlot
-	} else {
-		panic("cleanPage: page is broken.")
+	page.Cnt = idx
+	page.setKeyPrefixFlag(len(prefix) > 0)
+
+	if !ValidatePage(page) {
+		panic("compactPage: page is broken.")
 	}
+
+	return newSlot
 }
 
 // splitRoot
@@ -643,10 +1316,15 @@ func (tree *BLTree) splitRoot(root *PageSet, right *Latchs) BLTErr {
 	// Obtain an empty page to use, and copy the current
 	// root contents into it, e.g. lower keys
 	if err := tree.mgr.NewPage(&left, root.page, &tree.reads, &tree.writes); err != BLTErrOk {
+		// keys of right half are still reachable through right link of root
+		tree.mgr.PageUnlock(LockWrite, root.latch)
+		tree.mgr.UnpinLatch(root.latch)
+		tree.mgr.UnpinLatch(right)
 		return err
 	}
 
 	leftPageNo := left.latch.pageNo
+	tree.markDirty(left.latch)
 	tree.mgr.UnpinLatch(left.latch)
 
 	// preserve the page info at the bottom
@@ -655,28 +1333,24 @@ func (tree *BLTree) splitRoot(root *PageSet, right *Latchs) BLTErr {
 
 	// insert stopper key at top of newroot page
 	// and increase the root height
-	nxt -= BtId + 1
+	stopper := []byte{0xff, 0xff}
 	PutID(&value, right.pageNo)
-	copy(root.page.Data[nxt:], append([]byte{byte(BtId)}, value[:]...))
-
-	nxt -= 2 + 1
-	root.page.SetKeyOffset(2, nxt)
-	copy(root.page.Data[nxt:], append([]byte{byte(2)}, 0xff, 0xff))
+	nxt = root.page.putEntry(nxt, stopper, 0, value[:])
+	root.page.setEntry(2, nxt, stopper, 0, value[:])
 
 	// insert lower keys page fence key on newroot page as first key
-	nxt -= BtId + 1
 	PutID(&value, leftPageNo)
-	copy(root.page.Data[nxt:], append([]byte{byte(BtId)}, value[:]...))
-
-	nxt -= uint32(len(leftKey)) + 1
-	root.page.SetKeyOffset(1, nxt)
-	copy(root.page.Data[nxt:], append([]byte{byte(len(leftKey))}, leftKey[:]...))
+	nxt = root.page.putEntry(nxt, leftKey, 0, value[:])
+	root.page.setEntry(1, nxt, leftKey, 0, value[:])
 
 	PutID(&root.page.Right, 0)
 	root.page.Min = nxt
 	root.page.Cnt = 2
+	root.page.setKeyPrefixFlag(false)
 	root.page.Act = 2
+	root.page.Garbage = 0
 	root.page.Lvl++
+	root.page.setHighFence()
 
 	//if root.page.Min < root.page.Cnt*SlotSize {
 	//	fmt.Println("splitRoot: need check!")
@@ -686,6 +1360,8 @@ func (tree *BLTree) splitRoot(root *PageSet, right *Latchs) BLTErr {
 		panic("splitRoot: page broken!")
 	}
 
+	atomic.AddUint64(&tree.mgr.rootSplits, 1)
+
 	// release and unpin root pages
 	tree.mgr.PageUnlock(LockWrite, root.latch)
 	tree.mgr.UnpinLatch(root.latch)
@@ -696,22 +1372,38 @@ func (tree *BLTree) splitRoot(root *PageSet, right *Latchs) BLTErr {
 // splitPage
 //
 // split already locked full node; leave it locked.
+// tail is true when the page is split for a key appended at tail of
+// the rightmost page of the level, then 90% of keys are left in the page
+// because following keys are likely to be appended too.
 // @return pool entry for new right page, unlocked
-func (tree *BLTree) splitPage(set *PageSet) uint {
+func (tree *BLTree) splitPage(set *PageSet, tail bool) uint {
 	nxt := tree.mgr.pageDataSize
 	lvl := set.page.Lvl
 	var right PageSet
 
-	// split higher half of keys to frame
+	// split higher half of keys to frame, whose entries have the same widths
 	frame := NewPage(tree.mgr.pageDataSize)
+	frame.KeyWidth, frame.ValWidth = set.page.KeyWidth, set.page.ValWidth
 	max := set.page.Cnt
 	if max <= 1 {
 		panic("splitPage: max <= 1")
 	}
 	cnt := max / 2
+	if tail && max >= 10 {
+		cnt = max - max/10
+		// librarian slots are added to the keys left in the page
+		for cnt > max/2 && set.page.rebuiltSize(cnt, nil) > tree.mgr.pageDataSize {
+			cnt--
+		}
+	}
+	half := cnt
 
 	idx := uint32(0)
 
+	// keys are stored without common prefix of the half
+	prefix := set.page.commonKeyPrefix(cnt+1, max)
+	nxt = frame.putKeyPrefix(prefix)
+
 	for cnt < max {
 		cnt++
 		if cnt < max || set.page.Lvl > 0 {
@@ -720,30 +1412,30 @@ func (tree *BLTree) splitPage(set *PageSet) uint {
 			}
 		}
 		value := *set.page.Value(cnt)
-		valLen := uint32(len(value))
-		nxt -= valLen + 1
-		copy(frame.Data[nxt:], append([]byte{byte(valLen)}, value...))
-
 		key := set.page.Key(cnt)
-		nxt -= uint32(len(key)) + 1
-		copy(frame.Data[nxt:], append([]byte{byte(len(key))}, key[:]...))
+		pre := sharedPrefixLen(key, prefix)
+		nxt = frame.putEntry(nxt, key, pre, value)
 
 		// add librarian slot
 		if idx > 0 {
 			idx++
-			frame.SetKeyOffset(idx, nxt)
+			frame.setEntry(idx, nxt, key, pre, value)
 			frame.SetTyp(idx, Librarian)
 			frame.SetDead(idx, true)
 		}
 
 		// add actual slot
 		idx++
-		frame.SetKeyOffset(idx, nxt)
+		frame.setEntry(idx, nxt, key, pre, value)
 		frame.SetTyp(idx, set.page.Typ(cnt))
 
 		frame.SetDead(idx, set.page.Dead(cnt))
+		frame.setSlotFlags(idx, set.page.SlotFlags(cnt))
 		if !frame.Dead(idx) {
 			frame.Act++
+		} else {
+			// dead fence key of leaf page is kept
+			frame.Garbage += frame.entrySize(idx)
 		}
 	}
 
@@ -751,6 +1443,8 @@ func (tree *BLTree) splitPage(set *PageSet) uint {
 	frame.Min = nxt
 	frame.Cnt = idx
 	frame.Lvl = lvl
+	frame.setKeyPrefixFlag(len(prefix) > 0)
+	frame.setHighFence()
 
 	//if (idx+1)*6+(frame.Act-1)*EntrySizeForDebug+3 > tree.mgr.pageDataSize {
 	//	//fmt.Println("splitPage: need check!")
@@ -767,18 +1461,20 @@ func (tree *BLTree) splitPage(set *PageSet) uint {
 
 	// get new free page and write higher keys to it.
 	if err := tree.mgr.NewPage(&right, frame, &tree.reads, &tree.writes); err != BLTErrOk {
+		tree.err = err
 		return 0
 	}
+	tree.markDirty(right.latch)
 
 	MemCpyPage(frame, set.page)
 	set.page.Data = make([]byte, tree.mgr.pageDataSize)
-	set.latch.dirty = true
+	tree.markDirty(set.latch)
 
 	nxt = tree.mgr.pageDataSize
 	set.page.Garbage = 0
 	set.page.Act = 0
 
-	max /= 2
+	max = half
 
 	cnt = 0
 	idx = 0
@@ -787,38 +1483,41 @@ func (tree *BLTree) splitPage(set *PageSet) uint {
 		max--
 	}
 
+	prefix = frame.commonKeyPrefix(1, max)
+	nxt = set.page.putKeyPrefix(prefix)
+
 	for cnt < max {
 		cnt++
 		if frame.Dead(cnt) {
 			continue
 		}
 		value := *frame.Value(cnt)
-		valLen := uint32(len(value))
-		nxt -= valLen + 1
-		copy(set.page.Data[nxt:], append([]byte{byte(valLen)}, value...))
-
 		key := frame.Key(cnt)
-		nxt -= uint32(len(key)) + 1
-		copy(set.page.Data[nxt:], append([]byte{byte(len(key))}, key[:]...))
+		pre := sharedPrefixLen(key, prefix)
+		nxt = set.page.putEntry(nxt, key, pre, value)
 
 		// add librarian slot
 		if idx > 0 {
 			idx++
-			set.page.SetKeyOffset(idx, nxt)
+			set.page.setEntry(idx, nxt, key, pre, value)
 			set.page.SetTyp(idx, Librarian)
 			set.page.SetDead(idx, true)
 		}
 
 		// add actual slot
 		idx++
-		set.page.SetKeyOffset(idx, nxt)
+		set.page.setEntry(idx, nxt, key, pre, value)
 		set.page.SetTyp(idx, frame.Typ(cnt))
+		set.page.setSlotFlags(idx, frame.SlotFlags(cnt))
 		set.page.Act++
 	}
 
 	PutID(&set.page.Right, right.latch.pageNo)
 	set.page.Min = nxt
 	set.page.Cnt = idx
+	set.page.setKeyPrefixFlag(len(prefix) > 0)
+	set.page.setHighFence()
+	right.page.LowFence = set.page.HighFence
 
 	//if (idx+1)*6+(set.page.Act-1)*EntrySizeForDebug+3 > tree.mgr.pageDataSize {
 	//	//fmt.Println("splitPage: need check!")
@@ -835,6 +1534,12 @@ func (tree *BLTree) splitPage(set *PageSet) uint {
 
 	//fmt.Println("splitPage: Min", set.page.Min, " Cnt:", set.page.Cnt, " Act:", set.page.Act, ", pageNo:", set.latch.pageNo)
 
+	// keys moved to the right page stay locked by the atomic unit
+	if lvl == 0 && tree.mgr.atomicHeld(set.latch) {
+		tree.mgr.chainAtomic(set.latch, right.latch)
+	}
+
+	atomic.AddUint64(&tree.mgr.splits, 1)
 	return right.latch.entry
 }
 
@@ -842,8 +1547,9 @@ func (tree *BLTree) splitPage(set *PageSet) uint {
 //
 // fix keys for newly split page
 // call with page locked
-// @return unlocked
-func (tree *BLTree) splitKeys(set *PageSet, right *Latchs) BLTErr {
+// @return unlocked. parent locks are released after
+// the queued fence keys are posted by runPosts
+func (tree *BLTree) splitKeys(posts *postStack, set *PageSet, right *Latchs) BLTErr {
 	lvl := set.page.Lvl
 
 	// if current page is the root page, split it
@@ -855,6 +1561,8 @@ func (tree *BLTree) splitKeys(set *PageSet, right *Latchs) BLTErr {
 
 	page := tree.mgr.GetRefOfPageAtPool(right)
 
+	tree.startStructureMod()
+
 	rightKey := page.Key(page.Cnt)
 
 	// insert new fences in their parent pages
@@ -862,26 +1570,26 @@ func (tree *BLTree) splitKeys(set *PageSet, right *Latchs) BLTErr {
 	tree.mgr.PageLock(LockParent, set.latch)
 	tree.mgr.PageUnlock(LockWrite, set.latch)
 
-	// insert new fence for reformulated left block of smaller keys
-	var value [BtId]byte
-	PutID(&value, set.latch.pageNo)
-
-	if err := tree.InsertKey(leftKey, lvl+1, value, true); err != BLTErrOk {
-		return err
-	}
-
-	// switch fence for right block of larger keys to new right page
-	PutID(&value, right.pageNo)
-
-	if err := tree.InsertKey(rightKey, lvl+1, value, true); err != BLTErrOk {
-		return err
-	}
+	// insert new fence for reformulated left block of smaller keys,
+	// then switch fence for right block of larger keys to new right page.
+	// queued in reverse order of execution
+	left := set.latch
+	posts.pushRelease(func(bool) {
+		tree.releaseSplitLatches(left, right)
+	})
+	posts.pushInsert(rightKey, lvl+1, right.pageNo)
+	posts.pushInsert(leftKey, lvl+1, left.pageNo)
+	return BLTErrOk
+}
 
-	tree.mgr.PageUnlock(LockParent, set.latch)
-	tree.mgr.UnpinLatch(set.latch)
+// releaseSplitLatches releases parent locks of split pages
+// after their fence keys are posted. when posting failed,
+// right page remains reachable through right link of left page.
+func (tree *BLTree) releaseSplitLatches(left *Latchs, right *Latchs) {
+	tree.mgr.PageUnlock(LockParent, left)
+	tree.mgr.UnpinLatch(left)
 	tree.mgr.PageUnlock(LockParent, right)
 	tree.mgr.UnpinLatch(right)
-	return BLTErrOk
 }
 
 // insertSlot install new key and value onto page.
@@ -890,7 +1598,7 @@ func (tree *BLTree) insertSlot(
 	set *PageSet,
 	slot uint32,
 	key []byte,
-	value [BtId]byte,
+	value []byte,
 	typ SlotType,
 	release bool,
 ) BLTErr {
@@ -914,13 +1622,12 @@ func (tree *BLTree) insertSlot(
 		}
 	}
 
-	// copy value onto page
-	set.page.Min -= uint32(len(value)) + 1
-	copy(set.page.Data[set.page.Min:], append([]byte{byte(len(value))}, value[:]...))
-
-	// copy key onto page
-	set.page.Min -= uint32(len(key) + 1)
-	copy(set.page.Data[set.page.Min:], append([]byte{byte(len(key))}, key[:]...))
+	// copy key and value onto page without key prefix of the page
+	var pre uint32
+	if set.page.hasKeyPrefix() {
+		pre = sharedPrefixLen(key, set.page.keyPrefix())
+	}
+	set.page.Min = set.page.putEntry(set.page.Min, key, pre, value)
 
 	// find first empty slot
 	idx := slot
@@ -939,30 +1646,32 @@ func (tree *BLTree) insertSlot(
 	} else {
 		librarian = 1
 	}
-	set.latch.dirty = true
+	tree.markDirty(set.latch)
 	set.page.Act++
 
 	// move slots up to make room for new key
 	for idx > slot+librarian-1 {
-		set.page.SetDead(idx, set.page.Dead(idx-librarian))
-		set.page.SetTyp(idx, set.page.Typ(idx-librarian))
-		set.page.SetKeyOffset(idx, set.page.KeyOffset(idx-librarian))
+		copy(set.page.slotBytes(idx), set.page.slotBytes(idx-librarian))
 		idx--
 	}
 
 	// add librarian slot
 	if librarian > 1 {
-		set.page.SetKeyOffset(slot, set.page.Min)
+		set.page.setEntry(slot, set.page.Min, key, pre, value)
 		set.page.SetTyp(slot, Librarian)
 		set.page.SetDead(slot, true)
 		slot++
 	}
 
 	// fill in new slot
-	set.page.SetKeyOffset(slot, set.page.Min)
+	set.page.setEntry(slot, set.page.Min, key, pre, value)
 	set.page.SetTyp(slot, typ)
 	set.page.SetDead(slot, false)
 
+	if set.page.Lvl == 0 {
+		tree.changeSeq = tree.mgr.nextChangeSeq()
+	}
+
 	//if set.latch.pageNo == 14233 && (slot == 101) {
 	//	fmt.Println("insertSlot: need check!")
 	//}
@@ -979,110 +1688,381 @@ func (tree *BLTree) insertSlot(
 	return BLTErrOk
 }
 
+// keyEqual reports whether key of slot ptr whose length without
+// sequence suffix is keyLen is equal to key ins to be inserted
+func (tree *BLTree) keyEqual(ptr []byte, keyLen int, ins []byte) bool {
+	if tree.mgr.keyCompare != nil {
+		return tree.mgr.keyCompare(ptr, ins) == 0
+	}
+	return keyLen == len(ins) && KeyCmp(ptr, ins) == 0
+}
+
 // newDup
 func (tree *BLTree) newDup() Uid {
 	return Uid(atomic.AddUint64(&(&tree.mgr.pageZero).dups, 1))
 }
 
-// Attention: length of key should be fixed size
-// Note: currently, uniq argument is always true
-// InsertKey insert new key into the btree at a given level. either add a new key or update/add an existing one
-func (tree *BLTree) InsertKey(key []byte, lvl uint8, value [BtId]byte, uniq bool) BLTErr {
-	var slot uint32
-	var keyLen uint8
-	var set PageSet
+// InsertKey insert new key into the btree at a given level. either add a new key or update/add an existing one.
+// when uniq is false, key is added as a duplicate key even if it exists (see InsertDuplicate).
+// key can be up to mgr.MaxKeySize() bytes and value of leaf level (lvl 0) can be up to mgr.MaxValueSize() bytes.
+// BLTErrOverflow is returned for longer key or value. value of upper levels is a child page number of BtId bytes
+func (tree *BLTree) InsertKey(key []byte, lvl uint8, value []byte, uniq bool) BLTErr {
+	if lvl > 0 {
+		if len(value) != BtId {
+			tree.err = BLTErrStruct
+			return tree.err
+		}
+		if len(key) > tree.mgr.MaxKeySize() {
+			tree.err = BLTErrOverflow
+			return tree.err
+		}
+		return tree.insertKey(key, lvl, value, uniq)
+	}
+	return tree.insertValue(key, value, uniq)
+}
+
+// InsertDuplicate adds key with value even if key exists. keys equal to each other
+// are kept in order of insertion and found by FindAll. FindKey finds the first one
+// unless a unique key of InsertKey exists. duplicate keys can't be inserted
+// while key comparator is set (BLTErrCompare)
+func (tree *BLTree) InsertDuplicate(key []byte, value []byte) BLTErr {
+	return tree.insertValue(key, value, false)
+}
+
+// PutReport inserts key with value like InsertKey with uniq true and reports
+// whether value of existing key was overwritten. oldValue is the overwritten value.
+// they are captured while the leaf page is write locked, so FindKey before
+// InsertKey isn't needed to tell insert from update
+func (tree *BLTree) PutReport(key []byte, value []byte) (replaced bool, oldValue []byte, err BLTErr) {
+	if err = tree.insertValue(key, value, true); err != BLTErrOk {
+		return false, nil, err
+	}
+	if tree.changeVal == nil {
+		return false, nil, BLTErrOk
+	}
+	if oldValue, err = tree.mgr.decodeValue(tree.changeVal); err != BLTErrOk {
+		tree.err = err
+		return true, nil, err
+	}
+	return true, oldValue, BLTErrOk
+}
+
+// Update sets value of existing key. BLTErrNotFound is returned
+// without inserting key when key doesn't exist
+func (tree *BLTree) Update(key []byte, value []byte) BLTErr {
+	tree.putCond = func(exists bool, _ []byte) BLTErr {
+		if !exists {
+			return BLTErrNotFound
+		}
+		return BLTErrOk
+	}
+	err := tree.insertValue(key, value, true)
+	tree.putCond = nil
+	return err
+}
+
+// CompareAndSwap sets value of key to newValue only when its current value
+// equals expected. nil expected means key must not exist, and the key is inserted.
+// the comparison and the write are done under write lock of the leaf page,
+// so it's atomic with other writers. BLTErrMismatch is returned when value
+// of key isn't expected
+func (tree *BLTree) CompareAndSwap(key []byte, expected []byte, newValue []byte) BLTErr {
+	tree.putCond = func(exists bool, stored []byte) BLTErr {
+		if expected == nil || !exists {
+			if exists || expected != nil {
+				return BLTErrMismatch
+			}
+			return BLTErrOk
+		}
+		current, err := tree.mgr.decodeValue(stored)
+		if err != BLTErrOk {
+			return err
+		}
+		if !bytes.Equal(current, expected) {
+			return BLTErrMismatch
+		}
+		return BLTErrOk
+	}
+	err := tree.insertValue(key, newValue, true)
+	tree.putCond = nil
+	return err
+}
+
+// Merge sets value of key to result of fn. fn receives current value of key
+// and whether key exists. it's called while the leaf page is write locked,
+// so read-modify-write like counters needs no external locking.
+// fn may be called more than once when the page is split,
+// so it shouldn't have side effects nor call methods of the tree
+func (tree *BLTree) Merge(key []byte, fn func(old []byte, exists bool) []byte) BLTErr {
+	defer tree.mgr.recordLatency(LatencyInsert, tree.mgr.latencyStart())
+
+	ins, err := tree.insertingKey(key, true)
+	if err != BLTErrOk {
+		return err
+	}
+
+	var merged []byte
+	tree.putMerge = func(exists bool, stored []byte) ([]byte, BLTErr) {
+		var old []byte
+		if exists {
+			decoded, err := tree.mgr.decodeValue(stored)
+			if err != BLTErrOk {
+				return nil, err
+			}
+			// value on the page must not be modified by fn
+			old = append([]byte{}, decoded...)
+		}
+		merged = fn(old, exists)
+		return tree.storedValue(key, merged)
+	}
+	tree.changeSeq = 0
+	tree.changeVal = nil
+	err = tree.insertKey(ins, 0, nil, true)
+	tree.putMerge = nil
+	if err == BLTErrOk && tree.changeSeq > 0 {
+		tree.mgr.notifyChange(tree.changeSeq, ChangeInsert, key, tree.mgr.changedValue(tree.changeVal), merged)
+	}
+	tree.mgr.enforceDirtyQuota(&tree.reads, &tree.writes)
+	return err
+}
+
+// insertValue inserts user key with value into leaf level
+func (tree *BLTree) insertValue(key []byte, value []byte, uniq bool) BLTErr {
+	defer tree.mgr.recordLatency(LatencyInsert, tree.mgr.latencyStart())
+
+	ins, err := tree.insertingKey(key, uniq)
+	if err != BLTErrOk {
+		return err
+	}
+	stored, err := tree.storedValue(key, value)
+	if err != BLTErrOk {
+		return err
+	}
+
+	tree.changeSeq = 0
+	tree.changeVal = nil
+	err = tree.insertKey(ins, 0, stored, uniq)
+	if err == BLTErrOk && tree.changeSeq > 0 {
+		tree.mgr.notifyChange(tree.changeSeq, ChangeInsert, key, tree.mgr.changedValue(tree.changeVal), value)
+	}
+	tree.mgr.enforceDirtyQuota(&tree.reads, &tree.writes)
+	return err
+}
+
+// insertingKey validates and encodes user key to be inserted into leaf level
+func (tree *BLTree) insertingKey(key []byte, uniq bool) ([]byte, BLTErr) {
+	if err := tree.mgr.validateKey(key); err != BLTErrOk {
+		tree.err = err
+		return nil, err
+	}
+	// sequence suffix of duplicate key can't be compared by key comparator
+	if !uniq && tree.mgr.keyCompare != nil {
+		tree.err = BLTErrCompare
+		return nil, tree.err
+	}
+	ins, err := encodeKey(key)
+	if err != BLTErrOk {
+		tree.err = err
+		return nil, err
+	}
+	maxKey := tree.mgr.MaxKeySize()
+	if !uniq {
+		// room for sequence suffix
+		maxKey -= BtId
+	}
+	if len(ins) > maxKey {
+		tree.err = BLTErrOverflow
+		return nil, tree.err
+	}
+	return ins, BLTErrOk
+}
+
+// storedValue validates write of key with user value and encodes
+// the value to be stored in leaf page
+func (tree *BLTree) storedValue(key []byte, value []byte) ([]byte, BLTErr) {
+	if err := tree.mgr.validateWrite(ChangeInsert, key, value); err != BLTErrOk {
+		tree.err = err
+		return nil, err
+	}
+	stored, err := tree.mgr.encodeValue(value)
+	if err != BLTErrOk {
+		tree.err = err
+		return nil, err
+	}
+	if tree.expireAt > 0 {
+		binary.BigEndian.PutUint64(stored, uint64(tree.expireAt))
+	}
+	return stored, BLTErrOk
+}
+
+func (tree *BLTree) insertKey(key []byte, lvl uint8, value []byte, uniq bool) BLTErr {
 	ins := key
-	var ptr []byte
-	var sequence Uid
-	var typ SlotType
+	typ := Unique
 
 	// is this a non-unique index value?
-	if uniq {
-		typ = Unique
-	} else {
+	if !uniq {
 		typ = Duplicate
-		sequence = tree.newDup()
 		var seqBytes [BtId]byte
-		PutID(&seqBytes, sequence)
-		ins = append(ins, seqBytes[:]...)
+		PutID(&seqBytes, tree.newDup())
+		ins = append(ins[:len(ins):len(ins)], seqBytes[:]...)
 	}
 
-	for {
-		slot = tree.mgr.PageFetch(&set, key, lvl, LockWrite, &tree.reads, &tree.writes)
-		if slot > 0 {
-			ptr = set.page.Key(slot)
-		} else {
-			if tree.err != BLTErrOk {
-				tree.err = BLTErrOverflow
-			}
-			return tree.err
-		}
+	if lvl == 0 {
+		tree.startOp()
+	}
+
+	posts := postStack{{kind: postInsert, key: key, ins: ins, lvl: lvl, value: value, typ: typ}}
+	return tree.runPosts(&posts)
+}
+
+// insertOnce tries to insert ins at the level.
+// it returns false when it split the page instead. fence keys of
+// the split are queued on posts and insert must be retried after them.
+func (tree *BLTree) insertOnce(posts *postStack, key []byte, ins []byte, lvl uint8, value []byte, typ SlotType) (bool, BLTErr) {
+	var slot uint32
+	var keyLen int
+	var set PageSet
+	var ptr []byte
+	uniq := typ == Unique
 
-		if !ValidatePage(set.page) {
-			panic("InsertKey: page is broken.")
+	// duplicate key is placed in order of its sequence suffix
+	if !uniq {
+		key = ins
+	}
+
+	slot, err := tree.mgr.pageFetch(&set, key, lvl, LockWrite, &tree.reads, &tree.writes, tree.deadline)
+	if slot > 0 {
+		ptr = set.page.Key(slot)
+	} else {
+		if err == BLTErrTimeout {
+			tree.err = err
+		} else if tree.err != BLTErrOk {
+			tree.err = BLTErrOverflow
 		}
-		// if librarian slot == found slot, advance to real slot
-		if set.page.Typ(slot) == Librarian {
-			if KeyCmp(ptr, key) == 0 {
-				slot++
-				ptr = set.page.Key(slot)
-			}
+		return true, tree.err
+	}
+	if lvl == 0 {
+		tree.mgr.recordAccess(key, set.latch.pageNo)
+	}
+
+	if !ValidatePage(set.page) {
+		panic("InsertKey: page is broken.")
+	}
+	// if librarian slot == found slot, advance to real slot
+	if set.page.Typ(slot) == Librarian {
+		if tree.mgr.compareKeys(ptr, key) == 0 {
+			slot++
+			ptr = set.page.Key(slot)
 		}
+	}
 
-		keyLen = uint8(len(ptr))
+	keyLen = len(ptr)
 
-		if set.page.Typ(slot) == Duplicate {
-			keyLen -= BtId
+	if set.page.Typ(slot) == Duplicate {
+		keyLen -= BtId
+	}
+
+	// check condition of the insert and compute merged value.
+	// they are done again when insert is retried after a split
+	if lvl == 0 && (tree.putCond != nil || tree.putMerge != nil) {
+		var stored []byte
+		var err BLTErr
+		exists := uniq && tree.keyEqual(ptr, keyLen, ins) && !set.page.Dead(slot) && !tree.mgr.expired(set.page, slot)
+		if exists {
+			stored = set.page.valueBytes(slot)
 		}
+		if tree.putCond != nil {
+			err = tree.putCond(exists, stored)
+		}
+		if err == BLTErrOk && tree.putMerge != nil {
+			value, err = tree.putMerge(exists, stored)
+		}
+		if err != BLTErrOk {
+			tree.mgr.PageUnlock(LockWrite, set.latch)
+			tree.mgr.UnpinLatch(set.latch)
+			tree.err = err
+			return true, err
+		}
+	}
 
-		// if inserting a duplicate key or unique key
-		//   check for adequate space on the page
-		//   and insert the new key before slot.
+	// if key already exists, update value and return
+	replace := false
+	if uniq && tree.keyEqual(ptr, keyLen, ins) {
+		val := *set.page.Value(slot)
+		if lvl == 0 && !set.page.Dead(slot) && !tree.mgr.expired(set.page, slot) {
+			tree.changeVal = val
+		}
+		if set.page.valueFits(slot, value) {
+			if set.page.Dead(slot) {
+				set.page.Act++
+				set.page.Garbage -= set.page.entrySize(slot)
+			}
+			// tail of old value is left unused
+			set.page.Garbage += uint32(len(val) - len(value))
+			tree.markDirty(set.latch)
+			set.page.SetDead(slot, false)
+			set.page.SetValue(value, slot)
+			if lvl == 0 {
+				tree.changeSeq = tree.mgr.nextChangeSeq()
+			}
 
-		if (uniq && (keyLen != uint8(len(ins)) || KeyCmp(ptr, ins) != 0)) || !uniq {
-			slot = tree.cleanPage(&set, uint8(len(ins)), slot, BtId)
-			if slot == 0 {
-				entry := tree.splitPage(&set)
-				if entry == 0 {
-					return tree.err
-				} else if err := tree.splitKeys(&set, &tree.mgr.latchs[entry]); err != BLTErrOk {
-					return err
-				} else {
-					continue
-				}
+			if !ValidatePage(set.page) {
+				panic("InsertKey: page is broken.")
 			}
-			return tree.insertSlot(&set, slot, ins, value, typ, true)
+			tree.mgr.PageUnlock(LockWrite, set.latch)
+			tree.mgr.UnpinLatch(set.latch)
+			return true, BLTErrOk
 		}
 
-		// if key already exists, update value and return
-		// Note: omit if-block for always true condition
-		//val := set.page.Value(slot)
-		//if len(val) >= len(value) {
-		if set.page.Dead(slot) {
-			set.page.Act++
-			//if set.page.Typ(slot) == Unique {
-			//	reuseSize := uint32(len(key) + 1 + len(value) + 1)
-			//	set.page.Garbage -= reuseSize
-			//}
-		}
-		//set.page.Garbage += len(val) - len(value)
-		set.latch.dirty = true
-		set.page.SetDead(slot, false)
-		set.page.SetValue(value[:], slot)
+		// new update value doesn't fit in existing value area,
+		// so existing slot is marked dead after cleanup and new key is inserted before it
+		replace = !set.page.Dead(slot)
+	}
 
-		if !ValidatePage(set.page) {
-			panic("InsertKey: page is broken.")
-		}
-		tree.mgr.PageUnlock(LockWrite, set.latch)
-		tree.mgr.UnpinLatch(set.latch)
-		return BLTErrOk
-		//}
+	// key is appended when it's before stopper key of the rightmost page
+	tail := slot == set.page.Cnt && GetID(&set.page.Right) == 0
 
-		// new update value doesn't fit in existing value area
-		// Note: omit logic for unreachable code
+	// if inserting a duplicate key or unique key
+	//   check for adequate space on the page
+	//   and insert the new key before slot.
+	// existing value is kept while the page is split,
+	// so the retry updates it again instead of inserting it newly
+	slot = tree.cleanPage(&set, ins, slot, uint8(len(value)))
+	if slot == 0 {
+		// split of root page needs two new pages
+		if !tree.mgr.hasCapacity(2) {
+			tree.mgr.PageUnlock(LockWrite, set.latch)
+			tree.mgr.UnpinLatch(set.latch)
+			tree.err = BLTErrCapacity
+			return true, tree.err
+		}
+		entry := tree.splitPage(&set, tail)
+		if entry == 0 {
+			tree.mgr.PageUnlock(LockWrite, set.latch)
+			tree.mgr.UnpinLatch(set.latch)
+			return true, tree.err
+		}
+		// retry after fence keys of the split are posted
+		return false, tree.splitKeys(posts, &set, &tree.mgr.latchs[entry])
+	}
+	if replace {
+		tree.killReplaced(&set, slot, ins)
 	}
+	return true, tree.insertSlot(&set, slot, ins, value, typ, true)
+}
 
-	//return BLTErrOk
+// killReplaced marks key ins at slot of cleaned page dead, so that ins is inserted
+// before it with new value. it's done after cleanPage, because cleanup of a page
+// which still needs a split would purge the key and its value before the retry.
+// key dropped by the cleanup as expired one isn't there any more
+func (tree *BLTree) killReplaced(set *PageSet, slot uint32, ins []byte) {
+	if set.page.Dead(slot) || !tree.keyEqual(set.page.Key(slot), len(set.page.Key(slot)), ins) {
+		return
+	}
+	set.page.SetDead(slot, true)
+	set.page.Garbage += set.page.entrySize(slot)
+	set.page.Act--
+	tree.markDirty(set.latch)
 }
 
 // iterator methods
@@ -1090,8 +2070,6 @@ func (tree *BLTree) InsertKey(key []byte, lvl uint8, value [BtId]byte, uniq bool
 // nextKey returns next slot on cursor page
 // or slide cursor right into next page
 func (tree *BLTree) nextKey(slot uint32) uint32 {
-	var set PageSet
-
 	for {
 		right := GetID(&tree.cursor.Right)
 
@@ -1110,19 +2088,9 @@ func (tree *BLTree) nextKey(slot uint32) uint32 {
 			break
 		}
 
-		tree.cursorPage = right
-
-		set.latch = tree.mgr.PinLatch(right, true, &tree.reads, &tree.writes)
-		if set.latch != nil {
-			set.page = tree.mgr.GetRefOfPageAtPool(set.latch)
-		} else {
+		if !tree.cursorRight(right) {
 			return 0
 		}
-
-		tree.mgr.PageLock(LockRead, set.latch)
-		MemCpyPage(tree.cursor, set.page)
-		tree.mgr.PageUnlock(LockRead, set.latch)
-		tree.mgr.UnpinLatch(set.latch)
 		slot = 0
 	}
 
@@ -1130,15 +2098,44 @@ func (tree *BLTree) nextKey(slot uint32) uint32 {
 	return 0
 }
 
+// cursorRight cache right page into cursor
+func (tree *BLTree) cursorRight(right Uid) bool {
+	var set PageSet
+	var err BLTErr
+
+	tree.cursorPage = right
+
+	start := tree.mgr.latencyStart()
+	set.latch, err = tree.mgr.pinLatch(right, true, &tree.reads, &tree.writes, tree.deadline)
+	if set.latch != nil {
+		set.page = tree.mgr.GetRefOfPageAtPool(set.latch)
+	} else {
+		tree.err = err
+		return false
+	}
+
+	if !tree.mgr.pageLockDeadline(LockRead, set.latch, tree.deadline) {
+		tree.mgr.UnpinLatch(set.latch)
+		tree.err = BLTErrTimeout
+		return false
+	}
+	MemCpyPage(tree.cursor, set.page)
+	tree.mgr.PageUnlock(LockRead, set.latch)
+	tree.mgr.UnpinLatch(set.latch)
+	tree.mgr.recordLatency(LatencyScanNext, start)
+	return true
+}
+
 // startKey cache page of keys into cursor and return starting slot for given key
 func (tree *BLTree) startKey(key []byte) uint32 {
 	var set PageSet
 
 	// cache page for retrieval
-	slot := tree.mgr.PageFetch(&set, key, 0, LockRead, &tree.reads, &tree.writes)
+	slot, err := tree.mgr.pageFetch(&set, key, 0, LockRead, &tree.reads, &tree.writes, tree.deadline)
 	if slot > 0 {
 		MemCpyPage(tree.cursor, set.page)
 	} else {
+		tree.err = err
 		return 0
 	}
 
@@ -1148,13 +2145,88 @@ func (tree *BLTree) startKey(key []byte) uint32 {
 	return slot
 }
 
+// walkLevel calls fn with each page of a level from page pageNo to the rightmost
+// page following right links. the page is read locked while fn is called.
+// the walk stops when fn returns false or error.
+// returns the child of the first live key of page pageNo, which is the leftmost
+// page of the lower level when pageNo is the leftmost page. 0 is returned for leaf level
+func (tree *BLTree) walkLevel(pageNo Uid, fn func(pageNo Uid, page *Page) (bool, BLTErr)) (Uid, BLTErr) {
+	var lower Uid
+	for right := pageNo; right > 0; {
+		latch, err := tree.mgr.pinLatch(right, true, &tree.reads, &tree.writes, tree.deadline)
+		if latch == nil {
+			tree.err = err
+			return 0, err
+		}
+		if !tree.mgr.pageLockDeadline(LockRead, latch, tree.deadline) {
+			tree.mgr.UnpinLatch(latch)
+			tree.err = BLTErrTimeout
+			return 0, tree.err
+		}
+		page := tree.mgr.GetRefOfPageAtPool(latch)
+
+		if right == pageNo && page.Lvl > 0 {
+			for slot := uint32(1); slot <= page.Cnt; slot++ {
+				if !page.Dead(slot) {
+					lower = GetIDFromValue(page.Value(slot))
+					break
+				}
+			}
+		}
+
+		cont, err := fn(right, page)
+		right = GetID(&page.Right)
+		tree.mgr.PageUnlock(LockRead, latch)
+		tree.mgr.UnpinLatch(latch)
+		if err != BLTErrOk {
+			tree.err = err
+			return 0, err
+		}
+		if !cont {
+			break
+		}
+	}
+	return lower, BLTErrOk
+}
+
 // nil argument for lowerKey means no lower bound
 // nil argument for upperKey means no upper bound
 // ATTENTION: this method call is not atomic with otehr tree operations
 func (tree *BLTree) RangeScan(lowerKey []byte, upperKey []byte) (num int, retKeyArr [][]byte, retValArr [][]byte) {
+	num, retKeyArr, retValArr, _ = tree.rangeScan(lowerKey, upperKey, 0)
+	return num, retKeyArr, retValArr
+}
+
+// RangeScanOpts is RangeScan which skips opts.Offset keys and returns at most
+// opts.Limit keys. skipped keys are not copied
+func (tree *BLTree) RangeScanOpts(lowerKey []byte, upperKey []byte, opts ScanOptions) (num int, retKeyArr [][]byte, retValArr [][]byte) {
+	retKeyArr = make([][]byte, 0)
+	retValArr = make([][]byte, 0)
+	itr := tree.GetRangeItrOpts(lowerKey, upperKey, opts)
+	for ok, key, val := itr.Next(); ok; ok, key, val = itr.Next() {
+		retKeyArr = append(retKeyArr, key)
+		retValArr = append(retValArr, val)
+	}
+	if itr.Err() != BLTErrOk {
+		return 0, *new([][]byte), *new([][]byte)
+	}
+	return len(retKeyArr), retKeyArr, retValArr
+}
+
+// RangeScanLimit is RangeScan which stops when total size of returned keys and values
+// exceeds maxBytes. at least one entry is returned even if it exceeds maxBytes.
+// when the scan is stopped, nextKey is the first key which is not returned.
+// pass it as lowerKey to continue the scan. otherwise nextKey is nil.
+// maxBytes <= 0 means no limit
+func (tree *BLTree) RangeScanLimit(lowerKey []byte, upperKey []byte, maxBytes int) (num int, retKeyArr [][]byte, retValArr [][]byte, nextKey []byte) {
+	return tree.rangeScan(lowerKey, upperKey, maxBytes)
+}
+
+func (tree *BLTree) rangeScan(lowerKey []byte, upperKey []byte, maxBytes int) (num int, retKeyArr [][]byte, retValArr [][]byte, nextKey []byte) {
 	retKeyArr = make([][]byte, 0)
 	retValArr = make([][]byte, 0)
 	itrCnt := 0
+	scanned := 0
 	var right Uid
 
 	freePinLatchs := func(latch *Latchs) {
@@ -1169,13 +2241,31 @@ func (tree *BLTree) RangeScan(lowerKey []byte, upperKey []byte) (num int, retKey
 	curSet := new(PageSet)
 	curSet.page = NewPage(tree.mgr.pageDataSize)
 
+	tree.startOp()
+
+	// bounds are compared with keys stored in pages
+	var err BLTErr
+	if lowerKey != nil {
+		if lowerKey, err = encodeKey(lowerKey); err != BLTErrOk {
+			tree.err = err
+			return 0, *new([][]byte), *new([][]byte), nil
+		}
+	}
+	if upperKey != nil {
+		if upperKey, err = encodeKey(upperKey); err != BLTErrOk {
+			tree.err = err
+			return 0, *new([][]byte), *new([][]byte), nil
+		}
+	}
+
 	//slot := tree.mgr.PageFetch(curSet, lowerKey, 0, LockRead, &tree.reads, &tree.writes)
-	slot := tree.mgr.PageFetch(tmpSet, lowerKey, 0, LockRead, &tree.reads, &tree.writes)
+	slot, err := tree.mgr.pageFetch(tmpSet, lowerKey, 0, LockRead, &tree.reads, &tree.writes, tree.deadline)
 	if slot > 0 {
 		MemCpyPage(curSet.page, tmpSet.page)
 		freePinLatchs(tmpSet.latch)
 	} else {
-		return 0, *new([][]byte), *new([][]byte)
+		tree.err = err
+		return 0, *new([][]byte), *new([][]byte), nil
 	}
 
 	getKV := func() bool {
@@ -1184,16 +2274,15 @@ func (tree *BLTree) RangeScan(lowerKey []byte, upperKey []byte) (num int, retKey
 		//	return true
 		//}
 		key := curSet.page.Key(slot)
-		val := curSet.page.Value(slot)
 
 		isAboveLower := false
 		isBelowUpper := false
 		isReachedStopper := false
 		// if upperKey is nil, then this condition is always false
-		if upperKey != nil && bytes.Compare(key, upperKey) <= 0 {
+		if upperKey != nil && tree.mgr.compareKeys(key, upperKey) <= 0 {
 			isBelowUpper = true
 		}
-		if lowerKey != nil && bytes.Compare(key, lowerKey) >= 0 {
+		if lowerKey != nil && tree.mgr.compareKeys(key, lowerKey) >= 0 {
 			isAboveLower = true
 		}
 		if upperKey == nil {
@@ -1209,12 +2298,26 @@ func (tree *BLTree) RangeScan(lowerKey []byte, upperKey []byte) (num int, retKey
 			return false
 		}
 
+		// empty value of stopper key is not decodable, so it is decoded after the checks
+		val, err := tree.mgr.decodeValue(*curSet.page.Value(slot))
+		if err != BLTErrOk {
+			tree.err = err
+			return false
+		}
+
 		//if bytes.Compare(key, upperKey)  0 {
 		//	return false
 		//}
 
-		retKeyArr = append(retKeyArr, key)
-		retValArr = append(retValArr, *val)
+		userKey := decodeKey(key)
+		if maxBytes > 0 && itrCnt > 0 && scanned+len(userKey)+len(val) > maxBytes {
+			nextKey = userKey
+			return false
+		}
+		scanned += len(userKey) + len(val)
+
+		retKeyArr = append(retKeyArr, userKey)
+		retValArr = append(retValArr, val)
 		itrCnt++
 		return true
 	}
@@ -1224,7 +2327,7 @@ func (tree *BLTree) RangeScan(lowerKey []byte, upperKey []byte) (num int, retKey
 			if slot == 0 {
 				slot++
 			}
-			if curSet.page.Dead(slot) {
+			if curSet.page.Dead(slot) || tree.mgr.expired(curSet.page, slot) {
 				slot++
 				continue
 			} else if curSet.page.Typ(slot) != Unique {
@@ -1261,32 +2364,153 @@ func (tree *BLTree) RangeScan(lowerKey []byte, upperKey []byte) (num int, retKey
 		//// free lock and unpin
 		//freePinLatchs(curSet.latch)
 
-		tmpSet.latch = tree.mgr.PinLatch(right, true, &tree.reads, &tree.writes)
+		start := tree.mgr.latencyStart()
+		tmpSet.latch, err = tree.mgr.pinLatch(right, true, &tree.reads, &tree.writes, tree.deadline)
 		if tmpSet.latch != nil {
 			tmpSet.page = tree.mgr.GetRefOfPageAtPool(tmpSet.latch)
 			slot = 0
 		} else {
 			//panic("PinLatch failed")
-			return 0, *new([][]byte), *new([][]byte)
+			tree.err = err
+			return 0, *new([][]byte), *new([][]byte), nil
+		}
+		if !tree.mgr.pageLockDeadline(LockRead, tmpSet.latch, tree.deadline) {
+			tree.mgr.UnpinLatch(tmpSet.latch)
+			tree.err = BLTErrTimeout
+			return 0, *new([][]byte), *new([][]byte), nil
+		}
+		// page whose keys are all above upperKey isn't read
+		if upperKey != nil && tree.mgr.keyBelowPage(tmpSet.page, upperKey) {
+			freePinLatchs(tmpSet.latch)
+			break
 		}
-		tree.mgr.PageLock(LockRead, tmpSet.latch)
 		MemCpyPage(curSet.page, tmpSet.page)
 		freePinLatchs(tmpSet.latch)
+		tree.mgr.recordLatency(LatencyScanNext, start)
 	}
 
 	//// free the last page latch
 	//freePinLatchs(curSet.latch)
-	return itrCnt, retKeyArr, retValArr
+	if tree.err != BLTErrOk {
+		return 0, *new([][]byte), *new([][]byte), nil
+	}
+	return itrCnt, retKeyArr, retValArr, nextKey
 }
 
+// GetRangeItr returns iterator of keys and values between lowerKey and upperKey
+// (both inclusive). nil argument for lowerKey means no lower bound and
+// nil argument for upperKey means no upper bound
 func (tree *BLTree) GetRangeItr(lowerKey []byte, upperKey []byte) *BLTreeItr {
-	elems, keys, vals := tree.RangeScan(lowerKey, upperKey)
-	return &BLTreeItr{
-		keys:   keys,
-		vals:   vals,
-		curIdx: 0,
-		elems:  uint32(elems),
+	return tree.GetRangeItrOpts(lowerKey, upperKey, ScanOptions{})
+}
+
+// GetRangeItrOpts is GetRangeItr which skips opts.Offset keys
+// and returns at most opts.Limit keys
+func (tree *BLTree) GetRangeItrOpts(lowerKey []byte, upperKey []byte, opts ScanOptions) *BLTreeItr {
+	return tree.newRangeItr(nil, lowerKey, upperKey, opts)
+}
+
+// newRangeItr returns iterator of the range which reads pages of snap,
+// or pages of the live tree when snap is nil
+func (tree *BLTree) newRangeItr(snap *Snapshot, lowerKey []byte, upperKey []byte, opts ScanOptions) *BLTreeItr {
+	itr := &BLTreeItr{tree: tree, page: NewPage(tree.mgr.pageDataSize), opts: opts, snap: snap}
+	itr.resetOpts()
+
+	tree.startOp()
+
+	// bounds are compared with keys stored in pages
+	var err BLTErr
+	if lowerKey != nil {
+		if itr.lowerKey, err = encodeKey(lowerKey); err != BLTErrOk {
+			itr.fail(err)
+			return itr
+		}
+	}
+	if upperKey != nil {
+		if itr.upperKey, err = encodeKey(upperKey); err != BLTErrOk {
+			itr.fail(err)
+			return itr
+		}
+	}
+
+	itr.seek(itr.lowerKey)
+	return itr
+}
+
+// Seek moves the iterator so that Next returns the first key of the range
+// which is not less than key. remaining Offset is dropped and Limit keeps counting
+func (itr *BLTreeItr) Seek(key []byte) {
+	if itr.page == nil {
+		return
+	}
+	itr.tree.startOp()
+	itr.done = false
+	itr.err = BLTErrOk
+	itr.skip = 0
+	itr.last = nil
+	itr.after = nil
+
+	key, err := encodeKey(key)
+	if err != BLTErrOk {
+		itr.fail(err)
+		return
+	}
+	if itr.lowerKey != nil && itr.tree.mgr.compareKeys(key, itr.lowerKey) < 0 {
+		key = itr.lowerKey
+	}
+	itr.seek(key)
+}
+
+// Reset moves the iterator back to the start of the range with its options
+func (itr *BLTreeItr) Reset() {
+	if itr.page == nil {
+		return
+	}
+	itr.tree.startOp()
+	itr.done = false
+	itr.err = BLTErrOk
+	itr.last = nil
+	itr.after = nil
+	itr.resetOpts()
+	itr.seek(itr.lowerKey)
+}
+
+func (itr *BLTreeItr) resetOpts() {
+	itr.skip = itr.opts.Offset
+	itr.left = -1
+	if itr.opts.Limit > 0 {
+		itr.left = itr.opts.Limit
+	}
+}
+
+// seek copies leaf page which contains key so that Next starts
+// from the first key which is not less than key
+func (itr *BLTreeItr) seek(key []byte) {
+	tree := itr.tree
+	if itr.snap != nil {
+		slot, err := itr.snap.fetchLeaf(key, itr.page)
+		if slot == 0 {
+			itr.fail(err)
+			return
+		}
+		itr.slot = slot - 1
+		return
 	}
+
+	var set PageSet
+	slot, err := tree.mgr.pageFetch(&set, key, 0, LockRead, &tree.reads, &tree.writes, tree.deadline)
+	if slot == 0 {
+		if err == BLTErrOk {
+			err = BLTErrStruct
+		}
+		itr.fail(err)
+		return
+	}
+	MemCpyPage(itr.page, set.page)
+	tree.mgr.PageUnlock(LockRead, set.latch)
+	tree.mgr.UnpinLatch(set.latch)
+
+	itr.slot = slot - 1
 }
 
 // for debugging
@@ -1321,14 +2545,14 @@ func ValidatePage(page *Page) bool {
 	//		if offset == 0 {
 	//			panic("ValidatePage: Librarian slot key offset is not zero!")
 	//		}
-	//		if offset > 32767 {
+	//		if offset > MaxKeyOffset {
 	//			panic("ValidatePage: Librarian slot key offset is too large!")
 	//		}
 	//		offset = page.ValueOffset(slot)
 	//		if offset == 0 {
 	//			panic("ValidatePage: Librarian slot value offset is not zero!")
 	//		}
-	//		if offset > 32767 {
+	//		if offset > MaxKeyOffset {
 	//			panic("ValidatePage: Librarian slot value offset is too large!")
 	//		}
 	//	default:
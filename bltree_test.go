@@ -3,7 +3,9 @@ package blink_tree
 import (
 	"bytes"
 	"encoding/binary"
+	"github.com/ryogrid/bltree-go-for-embedding/interfaces"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -32,7 +34,7 @@ func TestBLTree_collapseRoot(t *testing.T) {
 				{1, 1, 1, 1},
 				{1, 1, 1, 2},
 			} {
-				if err := tree.InsertKey(key, 0, [BtId]byte{1}, true); err != BLTErrOk {
+				if err := tree.InsertKey(key, 0, []byte{1, 0, 0, 0, 0, 0}, true); err != BLTErrOk {
 					t.Errorf("InsertKey() = %v, want %v", err, BLTErrOk)
 				}
 
@@ -70,7 +72,7 @@ func TestBLTree_insert_and_find(t *testing.T) {
 		t.Errorf("FindKey() = %v, want %v", valLen, -1)
 	}
 
-	if err := bltree.InsertKey([]byte{1, 1, 1, 1}, 0, [BtId]byte{0, 0, 0, 0, 0, 1}, true); err != BLTErrOk {
+	if err := bltree.InsertKey([]byte{1, 1, 1, 1}, 0, []byte{0, 0, 0, 0, 0, 1}, true); err != BLTErrOk {
 		t.Errorf("InsertKey() = %v, want %v", err, BLTErrOk)
 	}
 
@@ -90,7 +92,7 @@ func TestBLTree_insert_and_find_many(t *testing.T) {
 	for i := uint64(0); i < num; i++ {
 		bs := make([]byte, 8)
 		binary.BigEndian.PutUint64(bs, i)
-		if err := bltree.InsertKey(bs, 0, [BtId]byte{}, true); err != BLTErrOk {
+		if err := bltree.InsertKey(bs, 0, make([]byte, BtId), true); err != BLTErrOk {
 			t.Errorf("InsertKey() = %v, want %v", err, BLTErrOk)
 		}
 	}
@@ -143,7 +145,7 @@ func TestBLTree_delete(t *testing.T) {
 
 	key := []byte{1, 1, 1, 1}
 
-	if err := bltree.InsertKey(key, 0, [BtId]byte{0, 0, 0, 0, 0, 1}, true); err != BLTErrOk {
+	if err := bltree.InsertKey(key, 0, []byte{0, 0, 0, 0, 0, 1}, true); err != BLTErrOk {
 		t.Errorf("InsertKey() = %v, want %v", err, BLTErrOk)
 	}
 
@@ -171,7 +173,7 @@ func TestBLTree_deleteMany(t *testing.T) {
 	}
 
 	for i := range keys {
-		if err := bltree.InsertKey(keys[i], 0, [BtId]byte{0, 0, 0, 0, 0, 0}, true); err != BLTErrOk {
+		if err := bltree.InsertKey(keys[i], 0, []byte{0, 0, 0, 0, 0, 0}, true); err != BLTErrOk {
 			t.Errorf("InsertKey() = %v, want %v", err, BLTErrOk)
 		}
 		if i%2 == 0 {
@@ -209,7 +211,7 @@ func TestBLTree_deleteAll(t *testing.T) {
 	}
 
 	for i := range keys {
-		if err := bltree.InsertKey(keys[i], 0, [BtId]byte{0, 0, 0, 0, 0, 0}, true); err != BLTErrOk {
+		if err := bltree.InsertKey(keys[i], 0, []byte{0, 0, 0, 0, 0, 0}, true); err != BLTErrOk {
 			t.Errorf("InsertKey() = %v, want %v", err, BLTErrOk)
 		}
 	}
@@ -252,12 +254,12 @@ func TestBLTree_deleteManyConcurrently3(t *testing.T) {
 				if i%routineNum != n {
 					continue
 				}
-				if err := bltree.InsertKey(keys[i], 0, [BtId]byte{0, 1, 2, 3, 4, 5}, true); err != BLTErrOk {
+				if err := bltree.InsertKey(keys[i], 0, []byte{0, 1, 2, 3, 4, 5}, true); err != BLTErrOk {
 					t.Errorf("in goroutine%d InsertKey() = %v, want %v", n, err, BLTErrOk)
 				}
 
 				// insert dplicate key
-				if err := bltree.InsertKey(keys[i], 0, [BtId]byte{0, 1, 2, 3, 4, 5}, true); err != BLTErrOk {
+				if err := bltree.InsertKey(keys[i], 0, []byte{0, 1, 2, 3, 4, 5}, true); err != BLTErrOk {
 					t.Errorf("in goroutine%d InsertKey() = %v, want %v", n, err, BLTErrOk)
 				}
 
@@ -305,7 +307,7 @@ func TestBLTree_deleteManyConcurrently3(t *testing.T) {
 				}
 
 				// insert again
-				if err := bltree.InsertKey(keys[i], 0, [BtId]byte{0, 1, 2, 3, 4, 5}, true); err != BLTErrOk {
+				if err := bltree.InsertKey(keys[i], 0, []byte{0, 1, 2, 3, 4, 5}, true); err != BLTErrOk {
 					t.Errorf("in goroutine%d InsertKey() = %v, want %v", n, err, BLTErrOk)
 				}
 
@@ -350,7 +352,7 @@ func TestBLTree_deleteManyConcurrently2(t *testing.T) {
 				if i%routineNum != n {
 					continue
 				}
-				if err := bltree.InsertKey(keys[i], 0, [BtId]byte{0, 1, 2, 3, 4, 5}, true); err != BLTErrOk {
+				if err := bltree.InsertKey(keys[i], 0, []byte{0, 1, 2, 3, 4, 5}, true); err != BLTErrOk {
 					t.Errorf("in goroutine%d InsertKey() = %v, want %v", n, err, BLTErrOk)
 				}
 
@@ -369,7 +371,7 @@ func TestBLTree_deleteManyConcurrently2(t *testing.T) {
 					t.Errorf("DeleteKey() = %v, want %v", err, BLTErrOk)
 				}
 
-				if err := bltree.InsertKey(keys[i], 0, [BtId]byte{0, 1, 2, 3, 4, 5}, true); err != BLTErrOk {
+				if err := bltree.InsertKey(keys[i], 0, []byte{0, 1, 2, 3, 4, 5}, true); err != BLTErrOk {
 					t.Errorf("in goroutine%d InsertKey() = %v, want %v", n, err, BLTErrOk)
 				}
 			}
@@ -405,7 +407,7 @@ func TestBLTree_deleteManyConcurrently(t *testing.T) {
 				if i%routineNum != n {
 					continue
 				}
-				if err := bltree.InsertKey(keys[i], 0, [BtId]byte{}, true); err != BLTErrOk {
+				if err := bltree.InsertKey(keys[i], 0, make([]byte, BtId), true); err != BLTErrOk {
 					t.Errorf("in goroutine%d InsertKey() = %v, want %v", n, err, BLTErrOk)
 				}
 
@@ -476,7 +478,7 @@ func TestBLTree_restart(t *testing.T) {
 	for i := uint64(0); i <= firstNum; i++ {
 		bs := make([]byte, 8)
 		binary.BigEndian.PutUint64(bs, i)
-		if err := bltree.InsertKey(bs, 0, [BtId]byte{}, true); err != BLTErrOk {
+		if err := bltree.InsertKey(bs, 0, make([]byte, BtId), true); err != BLTErrOk {
 			t.Errorf("InsertKey() = %v, want %v", err, BLTErrOk)
 		}
 	}
@@ -494,7 +496,7 @@ func TestBLTree_restart(t *testing.T) {
 	for i := firstNum; i <= secondNum; i++ {
 		bs := make([]byte, 8)
 		binary.BigEndian.PutUint64(bs, i)
-		if err := bltree.InsertKey(bs, 0, [BtId]byte{}, true); err != BLTErrOk {
+		if err := bltree.InsertKey(bs, 0, make([]byte, BtId), true); err != BLTErrOk {
 			t.Errorf("InsertKey() = %v, want %v", err, BLTErrOk)
 		}
 	}
@@ -507,3 +509,1277 @@ func TestBLTree_restart(t *testing.T) {
 		}
 	}
 }
+
+func TestBLTree_insert_capacity(t *testing.T) {
+	pbm := NewParentBufMgrDummy(nil)
+	mgr := NewBufMgr(12, 20, pbm, nil)
+	allocated, _ := mgr.PageCapacity()
+	mgr.SetPageLimit(allocated + 4)
+	bltree := NewBLTree(mgr)
+
+	inserted := uint64(0)
+	for ; ; inserted++ {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, inserted)
+		err := bltree.InsertKey(bs, 0, make([]byte, BtId), true)
+		if err == BLTErrCapacity {
+			break
+		}
+		if err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+		if inserted > 100000 {
+			t.Fatalf("InsertKey() never returned %v", BLTErrCapacity)
+		}
+	}
+
+	// keys inserted before capacity is exhausted are kept
+	for i := uint64(0); i < inserted; i++ {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		if _, foundKey, _ := bltree.FindKey(bs, BtId); bytes.Compare(foundKey, bs) != 0 {
+			t.Errorf("FindKey() = %v, want %v", foundKey, bs)
+		}
+	}
+
+	// updating existing key doesn't need new page
+	bs := make([]byte, 8)
+	if err := bltree.InsertKey(bs, 0, []byte{1, 0, 0, 0, 0, 0}, true); err != BLTErrOk {
+		t.Errorf("InsertKey() = %v, want %v", err, BLTErrOk)
+	}
+}
+
+// parentBufMgrBlocking is ParentBufMgr whose FetchPPage blocks while blocked is set
+type parentBufMgrBlocking struct {
+	*ParentBufMgrDummy
+	blocked atomic.Bool
+	unblock chan struct{}
+}
+
+func (p *parentBufMgrBlocking) FetchPPage(pageID int32) interfaces.ParentPage {
+	if p.blocked.Load() {
+		<-p.unblock
+	}
+	return p.ParentBufMgrDummy.FetchPPage(pageID)
+}
+
+func TestBLTree_opTimeout(t *testing.T) {
+	pbm := &parentBufMgrBlocking{
+		ParentBufMgrDummy: NewParentBufMgrDummy(nil).(*ParentBufMgrDummy),
+		unblock:           make(chan struct{}),
+	}
+	mgr := NewBufMgr(12, HASH_TABLE_ENTRY_CHAIN_LEN*2, pbm, nil)
+	bltree := NewBLTree(mgr)
+
+	num := uint64(10000)
+	for i := uint64(0); i < num; i++ {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		if err := bltree.InsertKey(bs, 0, make([]byte, BtId), true); err != BLTErrOk {
+			t.Errorf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+
+	firstKey := make([]byte, 8)
+	bltree.SetOpTimeout(50 * time.Millisecond)
+
+	t.Run("stuck page fault", func(t *testing.T) {
+		pbm.blocked.Store(true)
+		start := time.Now()
+		if found, _, _ := bltree.FindKey(firstKey, BtId); found != -1 {
+			t.Errorf("FindKey() = %v, want %v", found, -1)
+		}
+		if bltree.err != BLTErrTimeout {
+			t.Errorf("FindKey() err = %v, want %v", bltree.err, BLTErrTimeout)
+		}
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("FindKey() took %v", elapsed)
+		}
+		pbm.blocked.Store(false)
+		close(pbm.unblock)
+
+		// page fault which was given up is retried
+		if _, foundKey, _ := bltree.FindKey(firstKey, BtId); bytes.Compare(foundKey, firstKey) != 0 {
+			t.Errorf("FindKey() = %v, want %v", foundKey, firstKey)
+		}
+	})
+
+	t.Run("latch wait", func(t *testing.T) {
+		var set PageSet
+		other := NewBLTree(mgr)
+		if slot := mgr.PageFetch(&set, firstKey, 0, LockWrite, &other.reads, &other.writes); slot == 0 {
+			t.Fatalf("PageFetch() failed")
+		}
+
+		if found, _, _ := bltree.FindKey(firstKey, BtId); found != -1 {
+			t.Errorf("FindKey() = %v, want %v", found, -1)
+		}
+		if bltree.err != BLTErrTimeout {
+			t.Errorf("FindKey() err = %v, want %v", bltree.err, BLTErrTimeout)
+		}
+		if err := bltree.InsertKey(firstKey, 0, []byte{1, 0, 0, 0, 0, 0}, true); err != BLTErrTimeout {
+			t.Errorf("InsertKey() = %v, want %v", err, BLTErrTimeout)
+		}
+
+		mgr.PageUnlock(LockWrite, set.latch)
+		mgr.UnpinLatch(set.latch)
+
+		if err := bltree.InsertKey(firstKey, 0, []byte{1, 0, 0, 0, 0, 0}, true); err != BLTErrOk {
+			t.Errorf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+		if _, foundKey, _ := bltree.FindKey(firstKey, BtId); bytes.Compare(foundKey, firstKey) != 0 {
+			t.Errorf("FindKey() = %v, want %v", foundKey, firstKey)
+		}
+	})
+}
+
+func TestBLTree_keyPrefix(t *testing.T) {
+	mgr := NewBufMgr(12, 64, NewParentBufMgrDummy(nil), nil)
+	tree := NewBLTree(mgr)
+
+	// long composite keys sharing most of their bytes
+	prefix := bytes.Repeat([]byte{'p'}, 200)
+	keyOf := func(i int) []byte {
+		key := make([]byte, len(prefix)+4)
+		copy(key, prefix)
+		binary.BigEndian.PutUint32(key[len(prefix):], uint32(i))
+		return key
+	}
+
+	num := 3000
+	for i := 0; i < num; i++ {
+		if err := tree.InsertKey(keyOf(i), 0, []byte{byte(i), 0, 0, 0, 0, 0}, true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+	for i := 0; i < num; i += 7 {
+		if err := tree.DeleteKey(keyOf(i), 0); err != BLTErrOk {
+			t.Fatalf("DeleteKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+	for i := 0; i < num; i++ {
+		want := -1
+		if i%7 != 0 {
+			want = BtId
+		}
+		if ret, _, foundValue := tree.FindKey(keyOf(i), BtId); ret != want || (ret == BtId && foundValue[0] != byte(i)) {
+			t.Errorf("FindKey(%v) = %v, %v, want %v", i, ret, foundValue, want)
+		}
+	}
+
+	// keys which share only a half of the prefix shorten key prefix of the
+	// rightmost leaf page, so its keys are stored longer
+	keyOfShorter := func(i int) []byte {
+		key := keyOf(i)
+		key[len(prefix)/2] = 'q'
+		return key
+	}
+	for i := 0; i < 100; i++ {
+		if err := tree.InsertKey(keyOfShorter(i), 0, []byte{byte(i), 0, 0, 0, 0, 0}, true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		if ret, _, foundValue := tree.FindKey(keyOfShorter(i), BtId); ret != BtId || foundValue[0] != byte(i) {
+			t.Errorf("FindKey(%v) = %v, %v, want %v", i, ret, foundValue, BtId)
+		}
+	}
+	// a page holds less than 20 whole keys. pages hold many more keys
+	// and separators because they are stored without prefix
+	report, err := ValidateTree(tree)
+	if err != BLTErrOk || !report.Valid() {
+		t.Fatalf("ValidateTree() = %v, %v, want valid tree", report, err)
+	}
+	if report.Pages > 80 {
+		t.Errorf("tree has %v pages, want at most %v", report.Pages, 80)
+	}
+	for lvl := uint8(0); lvl <= 1; lvl++ {
+		var set PageSet
+		if slot := mgr.PageFetch(&set, keyOf(num/2), lvl, LockRead, &tree.reads, &tree.writes); slot == 0 {
+			t.Fatalf("PageFetch() = %v at level %v", slot, lvl)
+		}
+		if !set.page.hasKeyPrefix() || !bytes.HasPrefix(set.page.keyPrefix(), prefix[:len(prefix)/2]) {
+			t.Errorf("page at level %v doesn't store key prefix", lvl)
+		}
+		if lvl == 0 && set.page.Act < 40 {
+			t.Errorf("leaf page holds %v keys, want at least %v", set.page.Act, 40)
+		}
+		mgr.PageUnlock(LockRead, set.latch)
+		mgr.UnpinLatch(set.latch)
+	}
+}
+
+func TestBLTree_RangeScanLimit(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	tree := NewBLTree(mgr)
+
+	num := 1000
+	for i := 0; i < num; i++ {
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, uint64(i))
+		if err := tree.InsertKey(key, 0, make([]byte, BtId), true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+
+	// each entry is 8 bytes key and 6 bytes value
+	upper := make([]byte, 8)
+	binary.BigEndian.PutUint64(upper, uint64(num-101))
+	var lower []byte
+	var scanned [][]byte
+	for chunks := 0; ; chunks++ {
+		cnt, keys, _, next := tree.RangeScanLimit(lower, upper, 14*64+10)
+		if cnt > 64 {
+			t.Fatalf("RangeScanLimit() = %v entries, want at most %v", cnt, 64)
+		}
+		scanned = append(scanned, keys...)
+		if next == nil {
+			if chunks != (num-100)/64 {
+				t.Errorf("RangeScanLimit() returned %v chunks, want %v", chunks+1, (num-100)/64+1)
+			}
+			break
+		}
+		if binary.BigEndian.Uint64(next) != binary.BigEndian.Uint64(keys[cnt-1])+1 {
+			t.Fatalf("RangeScanLimit() nextKey = %v, want key next to %v", next, keys[cnt-1])
+		}
+		lower = next
+	}
+	if len(scanned) != num-100 {
+		t.Errorf("RangeScanLimit() scanned %v entries, want %v", len(scanned), num-100)
+	}
+	for i, key := range scanned {
+		if binary.BigEndian.Uint64(key) != uint64(i) {
+			t.Fatalf("RangeScanLimit() key = %v, want %v", key, i)
+		}
+	}
+
+	// at least one entry is returned
+	if cnt, _, _, next := tree.RangeScanLimit(nil, nil, 1); cnt != 1 || next == nil {
+		t.Errorf("RangeScanLimit() = %v, %v, want 1 entry and next key", cnt, next)
+	}
+	if cnt, _, _, next := tree.RangeScanLimit(nil, nil, 0); cnt != num || next != nil {
+		t.Errorf("RangeScanLimit() without limit = %v, %v, want %v, nil", cnt, next, num)
+	}
+}
+
+func TestBLTree_IsKeyPathResident(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	bltree := NewBLTree(mgr)
+
+	num := 10000
+	for i := 0; i < num; i++ {
+		if err := bltree.InsertKey([]byte{byte(i >> 8), byte(i)}, 0, make([]byte, BtId), true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+
+	// the last inserted key was accessed just now
+	if !bltree.IsKeyPathResident([]byte{byte((num - 1) >> 8), byte(num - 1)}) {
+		t.Errorf("IsKeyPathResident() of last key = false, want true")
+	}
+
+	var evicted []byte
+	for i := 0; i < num && evicted == nil; i++ {
+		if key := []byte{byte(i >> 8), byte(i)}; !bltree.IsKeyPathResident(key) {
+			evicted = key
+		}
+	}
+	if evicted == nil {
+		t.Fatalf("IsKeyPathResident() is true for all keys, want some evicted leaves")
+	}
+
+	// IsKeyPathResident doesn't load pages
+	if bltree.IsKeyPathResident(evicted) {
+		t.Errorf("IsKeyPathResident() = true after a call, want false")
+	}
+	if ret, _, _ := bltree.FindKey(evicted, BtId); ret != BtId {
+		t.Fatalf("FindKey() = %v, want %v", ret, BtId)
+	}
+	if !bltree.IsKeyPathResident(evicted) {
+		t.Errorf("IsKeyPathResident() after FindKey() = false, want true")
+	}
+}
+
+func TestBLTree_GetRangeItr(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	bltree := NewBLTree(mgr)
+
+	num := uint64(5000)
+	key := func(i uint64) []byte {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		return bs
+	}
+	for i := uint64(0); i < num; i++ {
+		if err := bltree.InsertKey(key(i), 0, []byte{byte(i), 0, 0, 0, 0, 0}, true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+	for i := uint64(0); i < num; i += 5 {
+		if err := bltree.DeleteKey(key(i), 0); err != BLTErrOk {
+			t.Fatalf("DeleteKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+
+	tests := []struct {
+		name         string
+		lower, upper []byte
+	}{
+		{"all", nil, nil},
+		{"bounded", key(100), key(4500)},
+		{"deleted bounds", key(500), key(4000)},
+		{"open upper", key(2000), nil},
+		{"empty", key(num + 1), nil},
+	}
+	for _, tt := range tests {
+		wantNum, wantKeys, wantVals := bltree.RangeScan(tt.lower, tt.upper)
+		itr := bltree.GetRangeItr(tt.lower, tt.upper)
+		n := 0
+		for ok, k, v := itr.Next(); ok; ok, k, v = itr.Next() {
+			if n < wantNum && (!bytes.Equal(k, wantKeys[n]) || !bytes.Equal(v, wantVals[n])) {
+				t.Errorf("%v: Next() [%d] = %v, %v, want %v, %v", tt.name, n, k, v, wantKeys[n], wantVals[n])
+			}
+			n++
+		}
+		if itr.Err() != BLTErrOk {
+			t.Errorf("%v: Err() = %v, want %v", tt.name, itr.Err(), BLTErrOk)
+		}
+		if n != wantNum {
+			t.Errorf("%v: Next() returned %v keys, want %v", tt.name, n, wantNum)
+		}
+		if ok, _, _ := itr.Next(); ok {
+			t.Errorf("%v: Next() after the end = true, want false", tt.name)
+		}
+	}
+
+	// pages beyond the current page are read lazily
+	itr := bltree.GetRangeItr(nil, nil)
+	if ok, _, _ := itr.Next(); !ok {
+		t.Fatalf("Next() = false, want true")
+	}
+	for i := num / 2; i < num; i++ {
+		bltree.DeleteKey(key(i), 0)
+	}
+	n := uint64(1)
+	for ok, _, _ := itr.Next(); ok; ok, _, _ = itr.Next() {
+		n++
+	}
+	if n >= num-num/5 {
+		t.Errorf("Next() returned %v keys after deletion, want less than %v", n, num-num/5)
+	}
+
+	// iterator closed in the middle of the range
+	itr = bltree.GetRangeItr(nil, nil)
+	itr.Next()
+	itr.Close()
+	if ok, _, _ := itr.Next(); ok {
+		t.Errorf("Next() after Close() = true, want false")
+	}
+	itr.Close()
+	if itr.Err() != BLTErrOk {
+		t.Errorf("Err() after Close() = %v, want %v", itr.Err(), BLTErrOk)
+	}
+}
+
+func TestBLTree_Exists(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	bltree := NewBLTree(mgr)
+
+	key := func(i uint64) []byte {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		return bs
+	}
+	num := uint64(3000)
+	for i := uint64(0); i < num; i += 2 {
+		if err := bltree.InsertKey(key(i), 0, make([]byte, BtId), true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+	for i := uint64(0); i < num; i += 10 {
+		if err := bltree.DeleteKey(key(i), 0); err != BLTErrOk {
+			t.Fatalf("DeleteKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+	for i := uint64(0); i < num+10; i++ {
+		want := i%2 == 0 && i%10 != 0 && i < num
+		if got := bltree.Exists(key(i)); got != want {
+			t.Errorf("Exists(%v) = %v, want %v", i, got, want)
+		}
+	}
+	if bltree.Exists(append(key(2), 0)) || bltree.Exists(key(2)[:7]) {
+		t.Errorf("Exists() of longer or shorter key = true, want false")
+	}
+
+	dup := []byte("dup")
+	if err := bltree.InsertKey(dup, 0, make([]byte, BtId), false); err != BLTErrOk {
+		t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+	}
+	if !bltree.Exists(dup) {
+		t.Errorf("Exists() of duplicate key = false, want true")
+	}
+	if bltree.Exists([]byte("du")) {
+		t.Errorf("Exists() of prefix of duplicate key = true, want false")
+	}
+}
+
+func TestBLTree_RangeScanOpts(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	bltree := NewBLTree(mgr)
+
+	num := uint64(3000)
+	key := func(i uint64) []byte {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		return bs
+	}
+	for i := uint64(0); i < num; i++ {
+		if err := bltree.InsertKey(key(i), 0, []byte{byte(i), 0, 0, 0, 0, 0}, true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+
+	lower, upper := key(100), key(2000)
+	_, allKeys, _ := bltree.RangeScan(lower, upper)
+	tests := []struct {
+		name string
+		opts ScanOptions
+		from int
+		to   int
+	}{
+		{"no options", ScanOptions{}, 0, len(allKeys)},
+		{"limit", ScanOptions{Limit: 10}, 0, 10},
+		{"offset", ScanOptions{Offset: 500}, 500, len(allKeys)},
+		{"page", ScanOptions{Offset: 500, Limit: 250}, 500, 750},
+		{"limit beyond range", ScanOptions{Offset: len(allKeys) - 5, Limit: 10}, len(allKeys) - 5, len(allKeys)},
+		{"offset beyond range", ScanOptions{Offset: len(allKeys) + 1}, 0, 0},
+	}
+	for _, tt := range tests {
+		num, keys, vals := bltree.RangeScanOpts(lower, upper, tt.opts)
+		want := allKeys[tt.from:tt.to]
+		if num != len(want) || len(vals) != len(want) {
+			t.Errorf("%v: RangeScanOpts() = %v keys, want %v", tt.name, num, len(want))
+			continue
+		}
+		for i := range want {
+			if !bytes.Equal(keys[i], want[i]) {
+				t.Errorf("%v: RangeScanOpts() [%d] = %v, want %v", tt.name, i, keys[i], want[i])
+			}
+		}
+	}
+
+	num2, keys, vals := bltree.RangeScanOpts(lower, upper, ScanOptions{Offset: 10, Limit: 5, KeysOnly: true})
+	if num2 != 5 || !bytes.Equal(keys[0], allKeys[10]) || vals[0] != nil {
+		t.Errorf("RangeScanOpts() with KeysOnly = %v, %v, %v, want 5 keys from %v without values", num2, keys, vals, allKeys[10])
+	}
+
+	// Filter is applied before Offset and Limit
+	even := func(v []byte) bool { return v[0]%2 == 0 }
+	num2, keys, vals = bltree.RangeScanOpts(lower, upper, ScanOptions{Offset: 2, Limit: 3, Filter: even})
+	if num2 != 3 || !bytes.Equal(keys[0], key(104)) || !bytes.Equal(keys[2], key(108)) || vals[1][0] != 106 {
+		t.Errorf("RangeScanOpts() with Filter = %v, %v, %v, want keys %v to %v", num2, keys, vals, key(104), key(108))
+	}
+	num2, _, _ = bltree.RangeScanOpts(lower, upper, ScanOptions{Filter: func(v []byte) bool { return false }})
+	if num2 != 0 {
+		t.Errorf("RangeScanOpts() with Filter rejecting all = %v, want %v", num2, 0)
+	}
+
+	// pages of an iterator continue each other
+	var paged [][]byte
+	for offset := 0; ; offset += 300 {
+		itr := bltree.GetRangeItrOpts(nil, nil, ScanOptions{Offset: offset, Limit: 300})
+		n := 0
+		for ok, k, _ := itr.Next(); ok; ok, k, _ = itr.Next() {
+			paged = append(paged, k)
+			n++
+		}
+		if n < 300 {
+			break
+		}
+	}
+	if len(paged) != int(num) || !bytes.Equal(paged[len(paged)-1], key(num-1)) {
+		t.Errorf("GetRangeItrOpts() pages have %v keys, want %v", len(paged), num)
+	}
+}
+
+func TestBLTreeItr_SeekReset(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	bltree := NewBLTree(mgr)
+
+	key := func(i uint64) []byte {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		return bs
+	}
+	for i := uint64(0); i < 3000; i += 2 {
+		if err := bltree.InsertKey(key(i), 0, make([]byte, BtId), true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+
+	itr := bltree.GetRangeItrOpts(key(100), key(2000), ScanOptions{Offset: 1, Limit: 100})
+	first := func() []byte {
+		ok, k, _ := itr.Next()
+		if !ok {
+			return nil
+		}
+		return k
+	}
+	if got := first(); !bytes.Equal(got, key(102)) {
+		t.Errorf("Next() = %v, want %v", got, key(102))
+	}
+
+	tests := []struct {
+		name string
+		seek []byte
+		want []byte
+	}{
+		{"forward", key(1501), key(1502)},
+		{"backward", key(500), key(500)},
+		{"below lower bound", key(0), key(100)},
+		{"above upper bound", key(2001), nil},
+	}
+	for _, tt := range tests {
+		itr.Seek(tt.seek)
+		if got := first(); !bytes.Equal(got, tt.want) {
+			t.Errorf("%v: Next() after Seek(%v) = %v, want %v", tt.name, tt.seek, got, tt.want)
+		}
+	}
+
+	// limit keeps counting after Seek and is restored by Reset
+	itr.Reset()
+	n := 0
+	for ok, k, _ := itr.Next(); ok; ok, k, _ = itr.Next() {
+		if n == 0 && !bytes.Equal(k, key(102)) {
+			t.Errorf("Next() after Reset() = %v, want %v", k, key(102))
+		}
+		n++
+	}
+	if n != 100 {
+		t.Errorf("Next() after Reset() returned %v keys, want %v", n, 100)
+	}
+
+	itr.Close()
+	itr.Reset()
+	if ok, _, _ := itr.Next(); ok {
+		t.Errorf("Next() after Close() and Reset() = true, want false")
+	}
+}
+
+func TestBLTreeItr_deletedRightPage(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	bltree := NewBLTree(mgr)
+
+	key := func(i uint64) []byte {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		return bs
+	}
+	num := uint64(3000)
+	for i := uint64(0); i < num; i++ {
+		if err := bltree.InsertKey(key(i), 0, make([]byte, BtId), true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+
+	itr := bltree.GetRangeItr(nil, nil)
+	for i := uint64(0); i < 10; i++ {
+		if ok, k, _ := itr.Next(); !ok || !bytes.Equal(k, key(i)) {
+			t.Fatalf("Next() = %v, %v, want %v", ok, k, key(i))
+		}
+	}
+
+	// emptying the leaf page of the iterator pulls its right page into it,
+	// and the right page which the copy links to is freed
+	leaf, _ := bltree.leftmostPage(0)
+	latch, _ := mgr.pinLatch(leaf, true, &bltree.reads, &bltree.writes, bltree.deadline)
+	fence := binary.BigEndian.Uint64(mgr.GetRefOfPageAtPool(latch).Key(mgr.GetRefOfPageAtPool(latch).Cnt))
+	mgr.UnpinLatch(latch)
+	for i := uint64(0); i <= fence; i++ {
+		if err := bltree.DeleteKey(key(i), 0); err != BLTErrOk {
+			t.Fatalf("DeleteKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+
+	// keys of the copy are returned, and then keys of the right page once
+	prev := key(9)
+	cnt := uint64(0)
+	for ok, k, _ := itr.Next(); ok; ok, k, _ = itr.Next() {
+		if bytes.Compare(prev, k) >= 0 {
+			t.Fatalf("Next() = %v after %v, want ascending keys", k, prev)
+		}
+		if binary.BigEndian.Uint64(k) > fence {
+			cnt++
+		}
+		prev = k
+	}
+	if itr.Err() != BLTErrOk || cnt != num-fence-1 {
+		t.Errorf("Next() returned %v keys after the deleted page with %v, want %v", cnt, itr.Err(), num-fence-1)
+	}
+}
+
+func TestBLTree_GetRangeItr_exclusiveBounds(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	bltree := NewBLTree(mgr)
+
+	key := func(i uint64) []byte {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		return bs
+	}
+	for i := uint64(0); i < 1000; i++ {
+		if err := bltree.InsertKey(key(i), 0, make([]byte, BtId), true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+
+	tests := []struct {
+		name        string
+		opts        ScanOptions
+		first, last uint64
+	}{
+		{"closed", ScanOptions{}, 100, 500},
+		{"half open", ScanOptions{ExcludeUpper: true}, 100, 499},
+		{"left open", ScanOptions{ExcludeLower: true}, 101, 500},
+		{"open", ScanOptions{ExcludeLower: true, ExcludeUpper: true}, 101, 499},
+	}
+	for _, tt := range tests {
+		num, keys, _ := bltree.RangeScanOpts(key(100), key(500), tt.opts)
+		if num != int(tt.last-tt.first+1) || !bytes.Equal(keys[0], key(tt.first)) || !bytes.Equal(keys[num-1], key(tt.last)) {
+			t.Errorf("%v: RangeScanOpts() = %v keys from %v to %v, want from %v to %v", tt.name, num, keys[0], keys[num-1], key(tt.first), key(tt.last))
+		}
+	}
+
+	// excluded lower bound is kept after Seek to it
+	itr := bltree.GetRangeItrOpts(key(100), nil, ScanOptions{ExcludeLower: true})
+	itr.Seek(key(0))
+	if ok, k, _ := itr.Next(); !ok || !bytes.Equal(k, key(101)) {
+		t.Errorf("Next() after Seek() = %v, want %v", k, key(101))
+	}
+}
+
+func TestBLTree_FindKeyErr(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	bltree := NewBLTree(mgr)
+
+	key := []byte{1, 2, 3}
+	if err := bltree.InsertKey(key, 0, []byte{1, 0, 0, 0, 0, 0}, true); err != BLTErrOk {
+		t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+	}
+
+	found, foundKey, foundValue, err := bltree.FindKeyErr(key, BtId)
+	if !found || err != BLTErrOk || !bytes.Equal(foundKey, key) || !bytes.Equal(foundValue, []byte{1, 0, 0, 0, 0, 0}) {
+		t.Errorf("FindKeyErr() = %v, %v, %v, %v, want %v, %v, %v, %v", found, foundKey, foundValue, err, true, key, []byte{1, 0, 0, 0, 0, 0}, BLTErrOk)
+	}
+
+	if found, _, _, err := bltree.FindKeyErr([]byte{9}, BtId); found || err != BLTErrOk {
+		t.Errorf("FindKeyErr() of missing key = %v, %v, want %v, %v", found, err, false, BLTErrOk)
+	}
+
+	// page in failure is reported as error, not as a missing key
+	if err := mgr.EvictPage(RootPage); err != BLTErrOk {
+		t.Fatalf("EvictPage() = %v, want %v", err, BLTErrOk)
+	}
+	mgr.SetFaultInjector(func(point FaultPoint, pageNo Uid) Fault {
+		if point == FaultPageIn && pageNo == RootPage {
+			return Fault{Err: BLTErrRead}
+		}
+		return Fault{}
+	})
+	if found, _, _, err := bltree.FindKeyErr(key, BtId); found || err == BLTErrOk {
+		t.Errorf("FindKeyErr() with page in failure = %v, %v, want %v, error", found, err, false)
+	}
+	if ret, _, _ := bltree.FindKey(key, BtId); ret != -1 {
+		t.Errorf("FindKey() with page in failure = %v, want %v", ret, -1)
+	}
+	mgr.SetFaultInjector(nil)
+}
+
+func TestBLTree_InsertKey_values(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	bltree := NewBLTree(mgr)
+	if max := mgr.MaxValueSize(); max != MaxKey {
+		t.Errorf("MaxValueSize() = %v, want %v", max, MaxKey)
+	}
+	if max := NewBufMgr(9, 48, NewParentBufMgrDummy(nil), nil).MaxValueSize(); max != BtId {
+		t.Errorf("MaxValueSize() of small pages = %v, want %v", max, BtId)
+	}
+
+	valueOf := func(i int) []byte {
+		return bytes.Repeat([]byte{byte(i)}, i%(MaxKey+1))
+	}
+	num := 3000
+	for i := 0; i < num; i++ {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, uint64(i))
+		if err := bltree.InsertKey(bs, 0, valueOf(i), true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+	for i := 0; i < num; i++ {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, uint64(i))
+		if ret, _, val := bltree.FindKey(bs, MaxKey); ret != len(valueOf(i)) || !bytes.Equal(val, valueOf(i)) {
+			t.Fatalf("FindKey(%v) = %v, %v, want %v bytes", i, ret, val, len(valueOf(i)))
+		}
+	}
+
+	if err := bltree.InsertKey([]byte{1}, 0, make([]byte, MaxKey+1), true); err != BLTErrOverflow {
+		t.Errorf("InsertKey() of too long value = %v, want %v", err, BLTErrOverflow)
+	}
+	if err := bltree.InsertKey([]byte{1}, 1, []byte{1}, true); err != BLTErrStruct {
+		t.Errorf("InsertKey() of short child page number = %v, want %v", err, BLTErrStruct)
+	}
+}
+
+func TestBLTree_PutReport(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	bltree := NewBLTree(mgr)
+
+	keyOf := func(i int) []byte {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, uint64(i))
+		return bs
+	}
+	num := 2000
+	for i := 0; i < num; i++ {
+		if replaced, old, err := bltree.PutReport(keyOf(i), []byte{byte(i)}); replaced || old != nil || err != BLTErrOk {
+			t.Fatalf("PutReport() of new key = %v, %v, %v, want %v, %v, %v", replaced, old, err, false, nil, BLTErrOk)
+		}
+	}
+
+	// longer values don't fit in place and split pages
+	for i := 0; i < num; i++ {
+		value := bytes.Repeat([]byte{byte(i + 1)}, 1+i%20)
+		if replaced, old, err := bltree.PutReport(keyOf(i), value); !replaced || !bytes.Equal(old, []byte{byte(i)}) || err != BLTErrOk {
+			t.Fatalf("PutReport() of existing key = %v, %v, %v, want %v, %v, %v", replaced, old, err, true, []byte{byte(i)}, BLTErrOk)
+		}
+	}
+	if cnt, _ := bltree.Count(); cnt != num {
+		t.Errorf("Count() = %v, want %v", cnt, num)
+	}
+
+	// deleted key is inserted newly
+	if err := bltree.DeleteKey(keyOf(7), 0); err != BLTErrOk {
+		t.Fatalf("DeleteKey() = %v, want %v", err, BLTErrOk)
+	}
+	if replaced, old, err := bltree.PutReport(keyOf(7), []byte{7}); replaced || old != nil || err != BLTErrOk {
+		t.Errorf("PutReport() of deleted key = %v, %v, %v, want %v, %v, %v", replaced, old, err, false, nil, BLTErrOk)
+	}
+}
+
+func TestBLTree_InsertKey_inPlaceUpdate(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	bltree := NewBLTree(mgr)
+
+	keyOf := func(i int) []byte {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, uint64(i))
+		return bs
+	}
+	num := 5000
+	for i := 0; i < num; i++ {
+		bltree.InsertKey(keyOf(i), 0, make([]byte, BtId), true)
+	}
+	garbage, _ := bltree.GarbageStats()
+	stats, _ := bltree.Stats()
+
+	// values of the same size are overwritten at their offsets
+	for round := 1; round <= 3; round++ {
+		for i := 0; i < num; i++ {
+			if err := bltree.InsertKey(keyOf(i), 0, bytes.Repeat([]byte{byte(round)}, BtId), true); err != BLTErrOk {
+				t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+			}
+		}
+	}
+	if after, _ := bltree.GarbageStats(); after.LeafBytes != garbage.LeafBytes {
+		t.Errorf("GarbageStats() after updates = %v, want %v", after.LeafBytes, garbage.LeafBytes)
+	}
+	if after, _ := bltree.Stats(); after.Pages[0] != stats.Pages[0] {
+		t.Errorf("Stats() leaf pages after updates = %v, want %v", after.Pages[0], stats.Pages[0])
+	}
+	if _, _, val := bltree.FindKey(keyOf(num/2), BtId); !bytes.Equal(val, bytes.Repeat([]byte{3}, BtId)) {
+		t.Errorf("FindKey() = %v, want the last value", val)
+	}
+}
+
+func TestBLTree_Update_growingValues(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	bltree := NewBLTree(mgr)
+
+	keyOf := func(i int) []byte {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, uint64(i))
+		return bs
+	}
+	num := 2000
+	for i := 0; i < num; i++ {
+		bltree.InsertKey(keyOf(i), 0, []byte{0}, true)
+	}
+
+	// values which outgrow their slots are inserted again while pages
+	// are cleaned up and split, and existing keys are never lost meanwhile
+	for round := 1; round <= 30; round++ {
+		for i := round % 7; i < num; i += 3 {
+			if err := bltree.Update(keyOf(i), bytes.Repeat([]byte{byte(round)}, round+i%11)); err != BLTErrOk {
+				t.Fatalf("Update() = %v, want %v", err, BLTErrOk)
+			}
+		}
+	}
+	if report, err := ValidateTree(bltree); err != BLTErrOk || !report.Valid() || report.Keys != uint64(num) {
+		t.Errorf("ValidateTree() = %v, %v, want valid tree of %v keys", report, err, num)
+	}
+}
+
+func TestBLTree_Remove(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	bltree := NewBLTree(mgr)
+
+	keyOf := func(i int) []byte {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, uint64(i))
+		return bs
+	}
+	num := 2000
+	for i := 0; i < num; i++ {
+		value := bytes.Repeat([]byte{byte(i)}, 1+i%20)
+		if err := bltree.InsertKey(keyOf(i), 0, value, true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+
+	for i := 0; i < num; i += 2 {
+		want := bytes.Repeat([]byte{byte(i)}, 1+i%20)
+		if found, value, err := bltree.Remove(keyOf(i)); !found || !bytes.Equal(value, want) || err != BLTErrOk {
+			t.Fatalf("Remove() of existing key = %v, %v, %v, want %v, %v, %v", found, value, err, true, want, BLTErrOk)
+		}
+	}
+	// removed and never inserted keys aren't found
+	for _, i := range []int{0, 10, num, num + 1} {
+		if found, value, err := bltree.Remove(keyOf(i)); found || value != nil || err != BLTErrOk {
+			t.Errorf("Remove() of missing key = %v, %v, %v, want %v, %v, %v", found, value, err, false, nil, BLTErrOk)
+		}
+	}
+	if cnt, _ := bltree.Count(); cnt != num/2 {
+		t.Errorf("Count() = %v, want %v", cnt, num/2)
+	}
+}
+
+func TestBLTree_Update(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	bltree := NewBLTree(mgr)
+
+	keyOf := func(i int) []byte {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, uint64(i))
+		return bs
+	}
+	num := 2000
+	for i := 0; i < num; i += 2 {
+		if err := bltree.InsertKey(keyOf(i), 0, []byte{byte(i)}, true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+
+	for i := 0; i < num; i++ {
+		want := BLTErrOk
+		if i%2 == 1 {
+			want = BLTErrNotFound
+		}
+		// longer values split pages
+		if err := bltree.Update(keyOf(i), bytes.Repeat([]byte{byte(i + 1)}, 1+i%20)); err != want {
+			t.Fatalf("Update(%v) = %v, want %v", i, err, want)
+		}
+	}
+	for i := 0; i < num; i++ {
+		ret, _, val := bltree.FindKey(keyOf(i), MaxKey)
+		if i%2 == 1 {
+			if ret >= 0 {
+				t.Fatalf("FindKey(%v) of key not updated = %v, want %v", i, ret, -1)
+			}
+			continue
+		}
+		if !bytes.Equal(val, bytes.Repeat([]byte{byte(i + 1)}, 1+i%20)) {
+			t.Fatalf("FindKey(%v) = %v, want updated value", i, val)
+		}
+	}
+
+	// deleted key can't be updated
+	if err := bltree.DeleteKey(keyOf(0), 0); err != BLTErrOk {
+		t.Fatalf("DeleteKey() = %v, want %v", err, BLTErrOk)
+	}
+	if err := bltree.Update(keyOf(0), []byte{1}); err != BLTErrNotFound {
+		t.Errorf("Update() of deleted key = %v, want %v", err, BLTErrNotFound)
+	}
+	// the condition doesn't remain after failure
+	if err := bltree.InsertKey(keyOf(1), 0, []byte{1}, true); err != BLTErrOk {
+		t.Errorf("InsertKey() after failed Update() = %v, want %v", err, BLTErrOk)
+	}
+}
+
+func TestBLTree_CompareAndSwap(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	bltree := NewBLTree(mgr)
+
+	key := []byte{1, 2, 3}
+	if err := bltree.CompareAndSwap(key, []byte{0}, []byte{1}); err != BLTErrMismatch {
+		t.Errorf("CompareAndSwap() of missing key = %v, want %v", err, BLTErrMismatch)
+	}
+	if err := bltree.CompareAndSwap(key, nil, make([]byte, 8)); err != BLTErrOk {
+		t.Errorf("CompareAndSwap() inserting key = %v, want %v", err, BLTErrOk)
+	}
+	if err := bltree.CompareAndSwap(key, nil, []byte{1}); err != BLTErrMismatch {
+		t.Errorf("CompareAndSwap() inserting existing key = %v, want %v", err, BLTErrMismatch)
+	}
+
+	// counter incremented by goroutines without external lock
+	workers, incs := 4, 200
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tree := NewBLTree(mgr)
+			for n := 0; n < incs; {
+				_, _, val := tree.FindKey(key, 8)
+				next := make([]byte, 8)
+				binary.BigEndian.PutUint64(next, binary.BigEndian.Uint64(val)+1)
+				switch err := tree.CompareAndSwap(key, val, next); err {
+				case BLTErrOk:
+					n++
+				case BLTErrMismatch:
+				default:
+					t.Errorf("CompareAndSwap() = %v, want %v", err, BLTErrOk)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if _, _, val := bltree.FindKey(key, 8); binary.BigEndian.Uint64(val) != uint64(workers*incs) {
+		t.Errorf("FindKey() = %v, want %v", binary.BigEndian.Uint64(val), workers*incs)
+	}
+}
+
+func TestBLTree_DeleteIf(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	bltree := NewBLTree(mgr)
+
+	key := []byte{1, 2, 3}
+	if err := bltree.DeleteIf(key, []byte{1}); err != BLTErrMismatch {
+		t.Errorf("DeleteIf() of missing key = %v, want %v", err, BLTErrMismatch)
+	}
+	bltree.InsertKey(key, 0, []byte{1}, true)
+	if err := bltree.DeleteIf(key, []byte{2}); err != BLTErrMismatch {
+		t.Errorf("DeleteIf() of other value = %v, want %v", err, BLTErrMismatch)
+	}
+	if !bltree.Exists(key) {
+		t.Errorf("Exists() after DeleteIf() of other value = false, want true")
+	}
+	if err := bltree.DeleteIf(key, []byte{1}); err != BLTErrOk {
+		t.Errorf("DeleteIf() = %v, want %v", err, BLTErrOk)
+	}
+	if bltree.Exists(key) {
+		t.Errorf("Exists() after DeleteIf() = true, want false")
+	}
+
+	// lock is acquired by inserting owner and released only by the owner
+	workers, rounds := 4, 200
+	holders := int32(0)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(owner byte) {
+			defer wg.Done()
+			tree := NewBLTree(mgr)
+			for n := 0; n < rounds; {
+				if tree.CompareAndSwap(key, nil, []byte{owner}) != BLTErrOk {
+					continue
+				}
+				if atomic.AddInt32(&holders, 1) != 1 {
+					t.Errorf("lock is held by more than one owner")
+				}
+				atomic.AddInt32(&holders, -1)
+				// release by another owner fails
+				if err := tree.DeleteIf(key, []byte{owner + 1}); err != BLTErrMismatch {
+					t.Errorf("DeleteIf() by other owner = %v, want %v", err, BLTErrMismatch)
+				}
+				if err := tree.DeleteIf(key, []byte{owner}); err != BLTErrOk {
+					t.Errorf("DeleteIf() = %v, want %v", err, BLTErrOk)
+					return
+				}
+				n++
+			}
+		}(byte(w * 2))
+	}
+	wg.Wait()
+	if bltree.Exists(key) {
+		t.Errorf("Exists() after all releases = true, want false")
+	}
+}
+
+func TestBLTree_Merge(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	bltree := NewBLTree(mgr)
+
+	keyOf := func(i int) []byte {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, uint64(i))
+		return bs
+	}
+
+	// values growing by appends split pages
+	num, rounds := 500, 20
+	for r := 0; r < rounds; r++ {
+		for i := 0; i < num; i++ {
+			err := bltree.Merge(keyOf(i), func(old []byte, exists bool) []byte {
+				if exists != (r > 0) {
+					t.Fatalf("Merge() of round %v passed exists %v", r, exists)
+				}
+				return append(old, byte(i))
+			})
+			if err != BLTErrOk {
+				t.Fatalf("Merge() = %v, want %v", err, BLTErrOk)
+			}
+		}
+	}
+	for i := 0; i < num; i++ {
+		want := bytes.Repeat([]byte{byte(i)}, rounds)
+		if _, _, val := bltree.FindKey(keyOf(i), rounds); !bytes.Equal(val, want) {
+			t.Fatalf("FindKey() = %v, want %v", val, want)
+		}
+	}
+	if cnt, _ := bltree.Count(); cnt != num {
+		t.Errorf("Count() = %v, want %v", cnt, num)
+	}
+
+	tooLong := bltree.Merge(keyOf(0), func(old []byte, _ bool) []byte {
+		return make([]byte, mgr.MaxValueSize()+1)
+	})
+	if tooLong != BLTErrOverflow {
+		t.Errorf("Merge() of too long value = %v, want %v", tooLong, BLTErrOverflow)
+	}
+
+	// counter incremented by goroutines without external lock
+	key := []byte{0xff, 1}
+	workers, incs := 4, 200
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tree := NewBLTree(mgr)
+			for n := 0; n < incs; n++ {
+				err := tree.Merge(key, func(old []byte, exists bool) []byte {
+					next := make([]byte, 8)
+					if exists {
+						binary.BigEndian.PutUint64(next, binary.BigEndian.Uint64(old)+1)
+					} else {
+						binary.BigEndian.PutUint64(next, 1)
+					}
+					return next
+				})
+				if err != BLTErrOk {
+					t.Errorf("Merge() = %v, want %v", err, BLTErrOk)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if _, _, val := bltree.FindKey(key, 8); binary.BigEndian.Uint64(val) != uint64(workers*incs) {
+		t.Errorf("FindKey() = %v, want %v", binary.BigEndian.Uint64(val), workers*incs)
+	}
+}
+
+func TestBLTree_splitPage_tail(t *testing.T) {
+	for _, ascending := range []bool{true, false} {
+		mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+		bltree := NewBLTree(mgr)
+
+		num := 30000
+		for i := 0; i < num; i++ {
+			bs := make([]byte, 8)
+			if ascending {
+				binary.BigEndian.PutUint64(bs, uint64(i))
+			} else {
+				binary.BigEndian.PutUint64(bs, uint64(num-i))
+			}
+			if err := bltree.InsertKey(bs, 0, make([]byte, BtId), true); err != BLTErrOk {
+				t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+			}
+		}
+		if report, err := ValidateTree(bltree); err != BLTErrOk || !report.Valid() {
+			t.Fatalf("ValidateTree() = %v, %v, want no problem", report.Problems, err)
+		}
+
+		// pages split for appended keys are left almost full
+		stats, _ := bltree.Stats()
+		if ascending && stats.FillFactor < 0.65 {
+			t.Errorf("Stats() FillFactor of ascending keys = %v, want at least %v", stats.FillFactor, 0.65)
+		} else if !ascending && stats.FillFactor > 0.5 {
+			t.Errorf("Stats() FillFactor of descending keys = %v, want at most %v", stats.FillFactor, 0.5)
+		}
+	}
+}
+
+func TestBLTree_DeleteDuplicate(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	bltree := NewBLTree(mgr)
+
+	// duplicate keys over several leaf pages and a unique key equal to them
+	dup := []byte("dup")
+	if err := bltree.InsertKey(dup, 0, []byte("unique"), true); err != BLTErrOk {
+		t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+	}
+	num := 1000
+	for i := 0; i < num; i++ {
+		if err := bltree.InsertDuplicate(dup, []byte{byte(i % 10), 0, 0, 0, 0, 0}); err != BLTErrOk {
+			t.Fatalf("InsertDuplicate() = %v, want %v", err, BLTErrOk)
+		}
+	}
+	if values, err := bltree.FindAll(dup); err != BLTErrOk || len(values) != num+1 {
+		t.Fatalf("FindAll() = %v values, %v, want %v", len(values), err, num+1)
+	}
+
+	deleted := 0
+	mgr.SetChangeHook(func(ev ChangeEvent) {
+		if ev.Op != ChangeDelete || ev.Value[0] != 3 {
+			t.Errorf("hook event = %v, want delete of value %v", ev, 3)
+		}
+		deleted++
+	})
+	// every key with the value is deleted one by one
+	value := []byte{3, 0, 0, 0, 0, 0}
+	for i := 0; i < num/10; i++ {
+		if found, err := bltree.DeleteDuplicate(dup, value); !found || err != BLTErrOk {
+			t.Fatalf("DeleteDuplicate() = %v, %v, want %v, %v", found, err, true, BLTErrOk)
+		}
+	}
+	if found, err := bltree.DeleteDuplicate(dup, value); found || err != BLTErrOk {
+		t.Errorf("DeleteDuplicate() of deleted value = %v, %v, want %v, %v", found, err, false, BLTErrOk)
+	}
+	if deleted != num/10 {
+		t.Errorf("hook called %v times, want %v", deleted, num/10)
+	}
+	mgr.SetChangeHook(nil)
+
+	if found, err := bltree.DeleteDuplicate(dup, []byte("unique")); !found || err != BLTErrOk {
+		t.Errorf("DeleteDuplicate() of unique key = %v, %v, want %v, %v", found, err, true, BLTErrOk)
+	}
+	values, err := bltree.FindAll(dup)
+	if err != BLTErrOk || len(values) != num-num/10 {
+		t.Fatalf("FindAll() = %v values, %v, want %v", len(values), err, num-num/10)
+	}
+	for _, v := range values {
+		if v[0] == 3 {
+			t.Fatalf("FindAll() = %v, want no value %v", v, value)
+		}
+	}
+	if report, err := ValidateTree(bltree); err != BLTErrOk || !report.Valid() {
+		t.Errorf("ValidateTree() = %v, %v, want no problem", report.Problems, err)
+	}
+}
+
+// parentBufMgrLarge is ParentBufMgr whose pages hold size bytes
+type parentBufMgrLarge struct {
+	mu     sync.Mutex
+	size   int
+	lastId int32
+	pages  map[int32]*parentPageLarge
+}
+
+type parentPageLarge struct {
+	id    int32
+	pins  int32
+	bytes []byte
+}
+
+func (p *parentPageLarge) DecPPinCount()       { atomic.AddInt32(&p.pins, -1) }
+func (p *parentPageLarge) PPinCount() int32    { return atomic.LoadInt32(&p.pins) }
+func (p *parentPageLarge) GetPPageId() int32   { return p.id }
+func (p *parentPageLarge) DataAsSlice() []byte { return p.bytes }
+
+func (p *parentBufMgrLarge) FetchPPage(pageID int32) interfaces.ParentPage {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ppage := p.pages[pageID]
+	atomic.AddInt32(&ppage.pins, 1)
+	return ppage
+}
+
+func (p *parentBufMgrLarge) UnpinPPage(pageID int32, isDirty bool) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pages[pageID].DecPPinCount()
+	return nil
+}
+
+func (p *parentBufMgrLarge) NewPPage() interfaces.ParentPage {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.lastId++
+	ppage := &parentPageLarge{id: p.lastId, pins: 1, bytes: make([]byte, p.size)}
+	p.pages[ppage.id] = ppage
+	return ppage
+}
+
+func (p *parentBufMgrLarge) DeallocatePPage(pageID int32, isNoWait bool) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.pages, pageID)
+	return nil
+}
+
+func TestBLTree_largePages(t *testing.T) {
+	bits := uint8(17)
+	pbm := &parentBufMgrLarge{size: 1 << bits, pages: make(map[int32]*parentPageLarge)}
+	mgr := NewBufMgr(bits, HASH_TABLE_ENTRY_CHAIN_LEN*2, pbm, nil)
+	tree := NewBLTree(mgr)
+
+	num := uint64(30000)
+	for i := uint64(0); i < num; i++ {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		if err := tree.InsertKey(bs, 0, bs[:BtId], true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+	for i := uint64(0); i < num; i++ {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		if ret, foundKey, foundValue := tree.FindKey(bs, BtId); ret != BtId || !bytes.Equal(foundKey, bs) || !bytes.Equal(foundValue, bs[:BtId]) {
+			t.Errorf("FindKey() = %v, %v, %v, want %v, %v, %v", ret, foundKey, foundValue, BtId, bs, bs[:BtId])
+		}
+	}
+	report, err := ValidateTree(tree)
+	if err != BLTErrOk || !report.Valid() {
+		t.Fatalf("ValidateTree() = %v, %v, want valid tree", report, err)
+	}
+
+	// keys of the leftmost leaf page are stored beyond 16 bits offset
+	var set PageSet
+	if slot := mgr.PageFetch(&set, make([]byte, 8), 0, LockRead, &tree.reads, &tree.writes); slot == 0 {
+		t.Fatalf("PageFetch() failed")
+	}
+	if off := set.page.KeyOffset(1); off <= 0xffff {
+		t.Errorf("Page.KeyOffset(1) = %v, want more than %v", off, 0xffff)
+	}
+	mgr.PageUnlock(LockRead, set.latch)
+	mgr.UnpinLatch(set.latch)
+
+	// pages written out to parent are read back
+	mgr.Close()
+	lastPageZeroId := mgr.GetMappedPPageIdOfPageZero()
+	mgr = NewBufMgr(bits, HASH_TABLE_ENTRY_CHAIN_LEN*2, pbm, &lastPageZeroId)
+	tree = NewBLTree(mgr)
+	for i := uint64(0); i < num; i += 7 {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		if _, foundKey, _ := tree.FindKey(bs, BtId); !bytes.Equal(foundKey, bs) {
+			t.Errorf("FindKey() after restart = %v, want %v", foundKey, bs)
+		}
+	}
+}
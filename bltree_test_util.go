@@ -21,7 +21,7 @@ func InsertAndFindConcurrently(t *testing.T, routineNum int, mgr *BufMgr, keys [
 				if i%routineNum != n {
 					continue
 				}
-				if err := bltree.InsertKey(keys[i], 0, [BtId]byte{}, true); err != BLTErrOk {
+				if err := bltree.InsertKey(keys[i], 0, make([]byte, BtId), true); err != BLTErrOk {
 					t.Errorf("in goroutine%d InsertKey() = %v, want %v", n, err, BLTErrOk)
 				}
 
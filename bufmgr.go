@@ -7,6 +7,7 @@ import (
 	"github.com/ryogrid/bltree-go-for-embedding/interfaces"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 const HASH_TABLE_ENTRY_CHAIN_LEN = 16
@@ -22,18 +23,47 @@ type (
 		pageBits     uint8  // page size in bits
 		pageDataSize uint32 // page data size
 
-		pageZero      PageZero
-		lock          SpinLatch   // allocation area lite latch
-		latchDeployed uint32      // highest number of latch entries deployed
-		nLatchPage    uint        // number of latch pages at BT_latch
-		latchTotal    uint        // number of page latch entries
-		latchHash     uint        // number of latch hash table slots (latch hash table slots の数)
-		latchVictim   uint32      // next latch entry to examine
-		hashTable     []HashEntry // the buffer pool hash table entries
-		latchs        []Latchs    // mapped latch set from buffer pool
-		pagePool      []Page      // mapped to the buffer pool pages
-		pbm           interfaces.ParentBufMgr
-		pageIdConvMap sync.Map // page id conversion map: Uid -> types.PageID
+		pageZero       PageZero
+		lock           SpinLatch                   // allocation area lite latch
+		latchDeployed  uint32                      // highest number of latch entries deployed
+		nLatchPage     uint                        // number of latch pages at BT_latch
+		latchTotal     uint                        // number of page latch entries
+		latchHash      uint                        // number of latch hash table slots (latch hash table slots の数)
+		latchVictim    uint32                      // next latch entry to examine
+		hashTable      []HashEntry                 // the buffer pool hash table entries
+		latchs         []Latchs                    // mapped latch set from buffer pool
+		blooms         []atomic.Pointer[leafBloom] // Bloom filters of leaf pages by latch entry
+		pagePool       []Page                      // mapped to the buffer pool pages
+		pbm            interfaces.ParentBufMgr
+		pageIdConvMap  *PageIdMap                       // page id conversion map: Uid -> types.PageID
+		pageLimit      Uid                              // largest page number which can be allocated (0 means MaxPageNo)
+		hotKeys        atomic.Pointer[HotKeySketch]     // hot key tracking (nil means disabled)
+		changeHook     atomic.Pointer[ChangeHook]       // change data capture hook (nil means disabled)
+		changeSeq      uint64                           // last assigned change sequence number
+		splits         uint64                           // count of page splits since the buffer manager was created
+		rootSplits     uint64                           // count of root splits since the buffer manager was created
+		compactFilter  atomic.Pointer[CompactionFilter] // filter applied on compaction of leaf pages
+		mergeOp        atomic.Pointer[MergeOperator]    // operator applied by InsertMerge (nil means not set)
+		valueCodec     ValueCodec                       // codec of values stored in leaf pages (nil means raw)
+		pageCodec      PageCodec                        // codec of pages written to parent buffer manager (nil means raw)
+		pageEncryptor  PageEncryptor                    // encryptor of pages written to parent buffer manager (nil means plain)
+		expiration     bool                             // values are stored with expiration time
+		clock          func() time.Time                 // current time of expiration (nil means time.Now)
+		keyValidator   KeyValidator                     // validator of keys passed to InsertKey and DeleteKey (nil means no check)
+		writeValidator WriteValidator                   // validator which can veto writes of leaf keys (nil means no check)
+		keyCompare     KeyCompare                       // order of keys stored in pages (nil means bytes order)
+		bloomBits      uint32                           // bits per key of Bloom filters of leaf pages (0 means disabled)
+		latencyHists   atomic.Pointer[opLatencies]      // latency histograms of operations (nil means disabled)
+		dirtyCnt       int64                            // count of dirty pages in buffer pool
+		dirtyQuota     uint32                           // max count of dirty pages in buffer pool (0 means no limit)
+		residentQuota  uint32                           // max count of pages in buffer pool (0 means limited by latchTotal)
+		underflowBytes uint32                           // live bytes of leaf page under which it's merged into the right page (0 means disabled)
+		cleanupBytes   uint32                           // free bytes of data area which cleanup of a page must leave, or the page is split
+		prunedFree     []Uid                            // free page numbers whose parent pages are deallocated
+		faultInjector  atomic.Pointer[FaultInjector]    // fault injection for chaos testing (nil means disabled)
+		snapshots      atomic.Pointer[[]*Snapshot]      // open snapshots which pages are copied into before they are modified
+		halfSplits     atomic.Bool                      // half splits are completed before the first operation (see completeHalfSplits)
+		halfSplitsLock sync.Mutex                       // serializes completion of half splits
 
 		err BLTErr // last error
 	}
@@ -49,10 +79,91 @@ func (z *PageZero) SetAllocRight(pageNo Uid) {
 	PutID(z.AllocRight(), pageNo)
 }
 
-// NewBufMgr creates a new buffer manager
+// NewBufMgr creates a new buffer manager. the tree whose page zero is
+// lastPageZeroId is opened like OpenBufMgr when it's not nil
 func NewBufMgr(bits uint8, nodeMax uint, pbm interfaces.ParentBufMgr, lastPageZeroId *int32) *BufMgr {
-	initit := true
+	if lastPageZeroId != nil {
+		mgr, err := OpenBufMgr(bits, nodeMax, pbm, *lastPageZeroId)
+		if err != BLTErrOk {
+			panic(fmt.Sprintf("Unable to open btree: %v\n", err))
+		}
+		return mgr
+	}
+
+	mgr := newBufMgr(bits, nodeMax, pbm)
+
+	if mgr.initPageZero(MinLvl+1) != BLTErrOk {
+		panic("Unable to create btree page zero\n")
+	}
+
+	alloc := NewPage(mgr.pageDataSize)
+	alloc.Bits = mgr.pageBits
+
+	for lvl := MinLvl - 1; lvl >= 0; lvl-- {
+		z := uint32(1) // size of BLTVal
+		if lvl > 0 {   // only page 0
+			z += BtId
+		}
+		alloc.SetKeyOffset(1, mgr.pageDataSize-3-z)
+		// create stopper key
+		alloc.SetKey([]byte{0xff, 0xff}, 1)
+
+		if lvl > 0 {
+			var value [BtId]byte
+			PutID(&value, Uid(MinLvl-lvl+1))
+			alloc.SetValue(value[:], 1)
+		} else {
+			alloc.SetValue([]byte{}, 1)
+		}
+
+		alloc.Min = alloc.KeyOffset(1)
+		alloc.Lvl = uint8(lvl)
+		alloc.Cnt = 1
+		alloc.Act = 1
+		alloc.setHighFence()
+
+		if err3 := mgr.PageOut(alloc, Uid(MinLvl-lvl), true); err3 != BLTErrOk {
+			panic("Unable to create btree page zero\n")
+		}
+	}
+
+	return mgr
+}
+
+// OpenBufMgr creates a buffer manager of the tree whose page zero is lastPageZeroId
+// like NewBufMgr. BLTErrVersion is returned without opening the tree when page zero
+// isn't written in PageFormatVersion. other pages of the tree written in another
+// version are reported by BLTErrVersion when they are read.
+// half splits left by a crash are completed by RepairHalfSplits before
+// the first operation on the opened tree, which is after comparator, codecs
+// and encryptor of the tree are set. it walks all pages of the tree once
+func OpenBufMgr(bits uint8, nodeMax uint, pbm interfaces.ParentBufMgr, lastPageZeroId int32) (*BufMgr, BLTErr) {
+	mgr := newBufMgr(bits, nodeMax, pbm)
+	var page Page
+
+	ppageZero := mgr.pbm.FetchPPage(lastPageZeroId)
+	if ppageZero == nil {
+		panic("failed to fetch page")
+	}
+	if ppageZero.DataAsSlice()[versionOffset] != PageFormatVersion {
+		mgr.pbm.UnpinPPage(lastPageZeroId, false)
+		return nil, BLTErrVersion
+	}
 
+	page.Data = ppageZero.DataAsSlice()[PageHeaderSize:]
+	mgr.pageZero.alloc = ppageZero.DataAsSlice()
+	mgr.loadPageIdMapping(ppageZero)
+
+	if err2 := binary.Read(bytes.NewReader(mgr.pageZero.alloc), binary.LittleEndian, &page.PageHeader); err2 != nil {
+		panic(fmt.Sprintf("Unable to read btree file: %v\n", err2))
+	}
+	mgr.halfSplits.Store(true)
+
+	return mgr, BLTErrOk
+}
+
+// newBufMgr creates a buffer manager which has no page zero
+func newBufMgr(bits uint8, nodeMax uint, pbm interfaces.ParentBufMgr) *BufMgr {
 	// determine sanity of page size
 	if bits > BtMaxBits {
 		bits = BtMaxBits
@@ -68,30 +179,12 @@ func NewBufMgr(bits uint8, nodeMax uint, pbm interfaces.ParentBufMgr, lastPageZe
 	mgr := BufMgr{}
 
 	mgr.pbm = pbm
-	mgr.pageIdConvMap = sync.Map{}
+	mgr.pageIdConvMap = NewPageIdMap(false)
 
 	mgr.pageSize = 1 << bits
 	mgr.pageBits = bits
 	mgr.pageDataSize = mgr.pageSize - PageHeaderSize
-
-	if lastPageZeroId != nil {
-		var page Page
-
-		ppageZero := mgr.pbm.FetchPPage(int32(*lastPageZeroId))
-		if ppageZero == nil {
-			panic("failed to fetch page")
-		}
-
-		page.Data = ppageZero.DataAsSlice()[PageHeaderSize:]
-		mgr.pageZero.alloc = ppageZero.DataAsSlice()
-		mgr.loadPageIdMapping(ppageZero)
-
-		if err2 := binary.Read(bytes.NewReader(mgr.pageZero.alloc), binary.LittleEndian, &page.PageHeader); err2 != nil {
-			panic(fmt.Sprintf("Unable to read btree file: %v\n", err2))
-		}
-
-		initit = false
-	}
+	mgr.cleanupBytes = mgr.pageDataSize / 5
 
 	// calculate number of latch hash table entries
 	// Note: in original code, calculate using HashEntry size
@@ -102,74 +195,63 @@ func NewBufMgr(bits uint8, nodeMax uint, pbm interfaces.ParentBufMgr, lastPageZe
 
 	mgr.hashTable = make([]HashEntry, mgr.latchHash)
 	mgr.latchs = make([]Latchs, mgr.latchTotal)
+	mgr.blooms = make([]atomic.Pointer[leafBloom], mgr.latchTotal)
 	mgr.pagePool = make([]Page, mgr.latchTotal)
 
-	var allocBytes []byte
-	if initit {
-		alloc := NewPage(mgr.pageDataSize)
-		alloc.Bits = mgr.pageBits
-		PutID(&alloc.Right, MinLvl+1)
-
-		if mgr.PageOut(alloc, 0, true) != BLTErrOk {
-			panic("Unable to create btree page zero\n")
-		}
-
-		// store page zero data to map to BufMgr::pageZero.alloc
-		buf := bytes.NewBuffer(make([]byte, 0, mgr.pageSize))
-		if err2 := binary.Write(buf, binary.LittleEndian, alloc.PageHeader); err2 != nil {
-			panic(fmt.Sprintf("Unable to output page header as bytes: %v\n", err2))
-		}
-		allocBytes = buf.Bytes()
-		allocBytes = append(allocBytes, make([]byte, mgr.pageSize-PageHeaderSize)...)
-		mgr.pageZero.alloc = allocBytes
-
-		alloc = NewPage(mgr.pageDataSize)
-		alloc.Bits = mgr.pageBits
+	return &mgr
+}
 
-		for lvl := MinLvl - 1; lvl >= 0; lvl-- {
-			z := uint32(1) // size of BLTVal
-			if lvl > 0 {   // only page 0
-				z += BtId
-			}
-			alloc.SetKeyOffset(1, mgr.pageDataSize-3-z)
-			// create stopper key
-			alloc.SetKey([]byte{0xff, 0xff}, 1)
-
-			if lvl > 0 {
-				var value [BtId]byte
-				PutID(&value, Uid(MinLvl-lvl+1))
-				alloc.SetValue(value[:], 1)
-			} else {
-				alloc.SetValue([]byte{}, 1)
-			}
+// initPageZero creates page zero whose next allocated page number is allocRight
+func (mgr *BufMgr) initPageZero(allocRight Uid) BLTErr {
+	alloc := NewPage(mgr.pageDataSize)
+	alloc.Bits = mgr.pageBits
+	PutID(&alloc.Right, allocRight)
 
-			alloc.Min = alloc.KeyOffset(1)
-			alloc.Lvl = uint8(lvl)
-			alloc.Cnt = 1
-			alloc.Act = 1
+	if err := mgr.PageOut(alloc, 0, true); err != BLTErrOk {
+		return err
+	}
 
-			if err3 := mgr.PageOut(alloc, Uid(MinLvl-lvl), true); err3 != BLTErrOk {
-				panic("Unable to create btree page zero\n")
-			}
-		}
+	// store page zero data to map to BufMgr::pageZero.alloc
+	buf := bytes.NewBuffer(make([]byte, 0, mgr.pageSize))
+	if err2 := binary.Write(buf, binary.LittleEndian, alloc.PageHeader); err2 != nil {
+		panic(fmt.Sprintf("Unable to output page header as bytes: %v\n", err2))
 	}
+	allocBytes := buf.Bytes()
+	allocBytes = append(allocBytes, make([]byte, mgr.pageSize-PageHeaderSize)...)
+	mgr.pageZero.alloc = allocBytes
 
-	return &mgr
+	return BLTErrOk
 }
 
 func (mgr *BufMgr) PageIn(page *Page, pageNo Uid) BLTErr {
+	return mgr.pageIn(page, pageNo, time.Time{})
+}
+
+// pageIn is PageIn which gives up waiting for parent at deadline
+// zero deadline means no deadline
+func (mgr *BufMgr) pageIn(page *Page, pageNo Uid, deadline time.Time) BLTErr {
 	//fmt.Println("PageIn pageNo: ", pageNo)
 
+	if err := mgr.injectFault(FaultPageIn, pageNo); err != BLTErrOk {
+		mgr.err = err
+		return err
+	}
+
 	if ppageId, ok := mgr.pageIdConvMap.Load(pageNo); ok {
-		ppage := mgr.pbm.FetchPPage(ppageId.(int32))
+		ppage, err := mgr.fetchPPage(ppageId, deadline)
+		if err != BLTErrOk {
+			return err
+		}
 		if ppage == nil {
 			panic("failed to fetch page")
 		}
-		headerBuf := bytes.NewBuffer(ppage.DataAsSlice()[:PageHeaderSize])
-		binary.Read(headerBuf, binary.LittleEndian, &page.PageHeader)
 		//page.Data = (ppage.DataAsSlice())[PageHeaderSize:]
-		page.Data = make([]byte, mgr.pageDataSize)
-		copy(page.Data, (ppage.DataAsSlice())[PageHeaderSize:])
+		if err := readPageImage(ppage.DataAsSlice(), page, mgr.pageDataSize, mgr.imageFilterOf(pageNo)); err != BLTErrOk {
+			// page which isn't loaded doesn't keep parent page pinned
+			mgr.pbm.UnpinPPage(ppageId, false)
+			mgr.err = err
+			return err
+		}
 	} else {
 		panic("page mapping not found")
 	}
@@ -181,11 +263,54 @@ func (mgr *BufMgr) PageIn(page *Page, pageNo Uid) BLTErr {
 	return BLTErrOk
 }
 
+// fetchPPage fetches parent page. when deadline is set, fetch is done
+// in another goroutine and waiting for it is given up at deadline.
+// the page which is fetched after giving up is unpinned in background
+func (mgr *BufMgr) fetchPPage(ppageId int32, deadline time.Time) (interfaces.ParentPage, BLTErr) {
+	if deadline.IsZero() {
+		return mgr.pbm.FetchPPage(ppageId), BLTErrOk
+	}
+	if !time.Now().Before(deadline) {
+		return nil, BLTErrTimeout
+	}
+
+	fetched := make(chan interfaces.ParentPage, 1)
+	go func() {
+		fetched <- mgr.pbm.FetchPPage(ppageId)
+	}()
+
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+
+	select {
+	case ppage := <-fetched:
+		return ppage, BLTErrOk
+	case <-timer.C:
+		go func() {
+			if ppage := <-fetched; ppage != nil {
+				mgr.pbm.UnpinPPage(ppageId, false)
+			}
+		}()
+		return nil, BLTErrTimeout
+	}
+}
+
 // writePage writes a page to permanent location in BLTree file,
 // and clear the dirty bit (← clear していない...)
 func (mgr *BufMgr) PageOut(page *Page, pageNo Uid, isDirty bool) BLTErr {
+	return mgr.pageOut(page, pageNo, isDirty, 0, time.Time{})
+}
+
+// pageOut is PageOut which gives up waiting for parent at deadline
+// zero deadline means no deadline. lsn is passed to parent with dirty page
+func (mgr *BufMgr) pageOut(page *Page, pageNo Uid, isDirty bool, lsn uint64, deadline time.Time) BLTErr {
 	//fmt.Println("PageOut pageNo: ", pageNo)
 
+	if err := mgr.injectFault(FaultPageOut, pageNo); err != BLTErrOk {
+		mgr.err = err
+		return err
+	}
+
 	if !ValidatePage(page) {
 		panic("PageOut: page is broken")
 	}
@@ -196,11 +321,16 @@ func (mgr *BufMgr) PageOut(page *Page, pageNo Uid, isDirty bool) BLTErr {
 		isNoEntry = true
 		ppageId = int32(-1)
 	} else {
-		ppageId = val.(int32)
+		ppageId = val
 	}
 
 	var ppage interfaces.ParentPage = nil
 
+	if isNoEntry && page.Free {
+		// parent page was deallocated by PruneFreePages
+		return BLTErrOk
+	}
+
 	if isNoEntry {
 		// called for not existing page case
 
@@ -211,14 +341,12 @@ func (mgr *BufMgr) PageOut(page *Page, pageNo Uid, isDirty bool) BLTErr {
 		//fmt.Println("PageOut: new page... : ", pageNo)
 		ppage = mgr.pbm.NewPPage()
 		if ppage == nil {
-			panic("failed to create new page")
+			// parent can't supply page any more
+			mgr.err = BLTErrCapacity
+			return mgr.err
 		}
 		if isDirty {
-			copy(ppage.DataAsSlice()[PageHeaderSize:], page.Data)
-			headerBuf := bytes.NewBuffer(make([]byte, 0, PageHeaderSize))
-			binary.Write(headerBuf, binary.LittleEndian, page.PageHeader)
-			headerBytes := headerBuf.Bytes()
-			copy(ppage.DataAsSlice()[:PageHeaderSize], headerBytes)
+			writePageImage(ppage.DataAsSlice(), page, lsn, mgr.imageFilterOf(pageNo))
 			if _, ok := mgr.pageIdConvMap.Load(pageNo); ok {
 				panic("page already exists")
 			}
@@ -228,7 +356,11 @@ func (mgr *BufMgr) PageOut(page *Page, pageNo Uid, isDirty bool) BLTErr {
 	}
 
 	if ppage == nil {
-		ppage = mgr.pbm.FetchPPage(ppageId)
+		var err BLTErr
+		if ppage, err = mgr.fetchPPage(ppageId, deadline); err != BLTErrOk {
+			mgr.err = err
+			return err
+		}
 		if ppage == nil {
 			panic("failed to fetch page")
 		}
@@ -239,14 +371,14 @@ func (mgr *BufMgr) PageOut(page *Page, pageNo Uid, isDirty bool) BLTErr {
 	}
 
 	if isDirty && !isNoEntry {
-		headerBuf := bytes.NewBuffer(make([]byte, 0, PageHeaderSize))
-		binary.Write(headerBuf, binary.LittleEndian, page.PageHeader)
-		headerBytes := headerBuf.Bytes()
-		copy(ppage.DataAsSlice()[:PageHeaderSize], headerBytes)
-		copy(ppage.DataAsSlice()[PageHeaderSize:], page.Data)
+		writePageImage(ppage.DataAsSlice(), page, lsn, mgr.imageFilterOf(pageNo))
 	}
 
-	mgr.pbm.UnpinPPage(ppageId, isDirty)
+	if pbm, ok := mgr.pbm.(interfaces.ParentBufMgrWithLSN); ok && isDirty {
+		pbm.UnpinPPageWithLSN(ppageId, isDirty, lsn)
+	} else {
+		mgr.pbm.UnpinPPage(ppageId, isDirty)
+	}
 
 	//fmt.Println("PageOut: unpin paged. pageNo:", pageNo, "ppageId:", ppageId, "pin count: ", ppage.PPinCount())
 
@@ -255,7 +387,7 @@ func (mgr *BufMgr) PageOut(page *Page, pageNo Uid, isDirty bool) BLTErr {
 
 // flush page 0 and dirty pool pages
 // persist page id mapping info and free page IDs
-func (mgr *BufMgr) Close() {
+func (mgr *BufMgr) Close() BLTErr {
 	num := 0
 
 	// flush page 0
@@ -263,8 +395,13 @@ func (mgr *BufMgr) Close() {
 	pageZero := &pageZeroVal
 	pageZero.PageHeader.Right = *mgr.pageZero.AllocRight()
 	pageZero.PageHeader.Bits = mgr.pageBits
+	pageZero.PageHeader.Cnt = mgr.pageZero.comparatorHash()
+	pageZero.PageHeader.KeyWidth, pageZero.PageHeader.ValWidth = mgr.pageZero.fixedWidth()
 	pageZero.Data = mgr.pageZero.alloc[PageHeaderSize:]
 
+	// free pages are not written and not serialized to page id mapping info
+	mgr.PruneFreePages()
+
 	// flush dirty pool pages
 	var slot uint32
 	for slot = 1; slot <= mgr.latchDeployed; slot++ {
@@ -272,8 +409,10 @@ func (mgr *BufMgr) Close() {
 		latch := &mgr.latchs[slot]
 
 		if latch.dirty {
-			mgr.PageOut(page, latch.pageNo, true)
-			latch.dirty = false
+			if err := mgr.pageOut(page, latch.pageNo, true, latch.lsn, time.Time{}); err != BLTErrOk {
+				return err
+			}
+			mgr.clearDirty(latch)
 			num++
 		}
 	}
@@ -281,59 +420,55 @@ func (mgr *BufMgr) Close() {
 	fmt.Println(num, "dirty pages flushed")
 
 	// Note: pbm.FetchPPage and mgr.PageOut is called in these methods call
-	mgr.serializePageIdMappingToPage(pageZero)
-
-	mgr.deleterFreePages()
-
-	mgr.PageOut(pageZero, 0, true)
-}
-
-// deallocate free pages from parent's buffer pool
-// these page ID is not used in BLTree forever
-func (mgr *BufMgr) deleterFreePages() {
-	makeFreePageMap := func() *sync.Map {
-		freePageMap := sync.Map{}
-		var read uint
-		var write uint
-		set := &PageSet{}
-		set.page = &Page{}
-		PutID(&set.page.Right, GetID(&mgr.pageZero.chain))
-		for {
-			freePageNo := GetID(&set.page.Right)
-			if freePageNo > 0 {
-				set.latch = mgr.PinLatch(freePageNo, false, &read, &write)
-				if set.latch != nil {
-					set.page = mgr.GetRefOfPageAtPool(set.latch)
-					if set.page.Free {
-						//fmt.Println("free page found: ", freePageNo)
-						freePageMap.Store(freePageNo, true)
-					} else {
-						break
-					}
-				} else {
-					break
-				}
-			} else {
-				break
-			}
-		}
-		return &freePageMap
+	if err := mgr.serializePageIdMappingToPage(pageZero); err != BLTErrOk {
+		return err
 	}
 
-	freePageMap := makeFreePageMap()
-	freePageMap.Range(func(key, value interface{}) bool {
-		pageNo := key.(Uid)
+	return mgr.PageOut(pageZero, 0, true)
+}
+
+// PruneFreePages deallocates parent pages of pages on the free chain and
+// removes their entries from page id conversion map, so that the map holds
+// only pages in use. pruned page numbers are kept in memory and reused by NewPage.
+// it can be called while the tree is in use. returns count of pruned pages
+func (mgr *BufMgr) PruneFreePages() int {
+	var reads uint
+	var writes uint
+
+	mgr.lock.SpinWriteLock()
+	defer mgr.lock.SpinReleaseWrite()
+
+	cnt := 0
+	pageNo := GetID(&mgr.pageZero.chain)
+	for pageNo > 0 {
+		latch := mgr.PinLatch(pageNo, true, &reads, &writes)
+		if latch == nil {
+			break
+		}
+		page := mgr.GetRefOfPageAtPool(latch)
+		if !page.Free {
+			mgr.UnpinLatch(latch)
+			break
+		}
+		next := GetID(&page.Right)
+
+		// pool page is not written back after its parent page is deallocated
+		mgr.clearDirty(latch)
+		mgr.UnpinLatch(latch)
 		if ppageId, ok := mgr.pageIdConvMap.Load(pageNo); ok {
-			mgr.pbm.DeallocatePPage(ppageId.(int32), true)
+			mgr.pbm.DeallocatePPage(ppageId, true)
 			mgr.pageIdConvMap.Delete(pageNo)
 		}
-		//fmt.Println("deallocate free page: ", pageNo)
+		mgr.prunedFree = append(mgr.prunedFree, pageNo)
+		cnt++
 
-		return true
-	})
+		PutID(&mgr.pageZero.chain, next)
+		pageNo = next
+	}
+	return cnt
 }
 
-func (mgr *BufMgr) serializePageIdMappingToPage(pageZero *Page) {
+func (mgr *BufMgr) serializePageIdMappingToPage(pageZero *Page) BLTErr {
 	// format
 	// page 0: | page header (26bytes) | next parent page Id for page Id mapping info (4bytes) | mapping count or free blink-tree page count in page (4bytes) | entry-0 (12bytes) | entry-1 (12bytes) | ... |
 	// entry: | blink tree page id (int64 8bytes) | parent page id (uint32 4bytes) |
@@ -347,9 +482,13 @@ func (mgr *BufMgr) serializePageIdMappingToPage(pageZero *Page) {
 	var curPage Page
 	mappingCnt := uint32(0)
 
-	serializeIdMappingEntryFunc := func(key, value interface{}) {
-		pageNo := key.(Uid)
-		ppageId := value.(int32)
+	// count of entries is limited by the serialization format
+	if mgr.pageIdConvMap.Len() > MaxPageIdMappingCnt {
+		mgr.err = BLTErrCapacity
+		return mgr.err
+	}
+
+	serializeIdMappingEntryFunc := func(pageNo Uid, ppageId int32) {
 		buf := make([]byte, PageIdMappingEntrySize)
 		binary.LittleEndian.PutUint64(buf[:PageIdMappingBLETreePageSize], uint64(pageNo))
 		binary.LittleEndian.PutUint32(buf[PageIdMappingBLETreePageSize:PageIdMappingBLETreePageSize+PageIdMappingPPageSize], uint32(ppageId))
@@ -364,16 +503,18 @@ func (mgr *BufMgr) serializePageIdMappingToPage(pageZero *Page) {
 
 	isPageZero := true
 
-	itrFunc := func(key, value interface{}) bool {
+	itrFunc := func(pageNo Uid, ppageId int32) bool {
 		// write data
-		serializeIdMappingEntryFunc(key, value)
+		serializeIdMappingEntryFunc(pageNo, ppageId)
 
 		mappingCnt++
 		if mappingCnt >= maxSerializeNum {
 			// reached capacity limit
 			ppage := mgr.pbm.NewPPage()
 			if ppage == nil {
-				panic("failed to create new page")
+				// parent can't supply page for mapping info
+				mgr.err = BLTErrCapacity
+				return false
 			}
 			nextPageId := ppage.GetPPageId()
 			// write mapping data header
@@ -402,6 +543,12 @@ func (mgr *BufMgr) serializePageIdMappingToPage(pageZero *Page) {
 	}
 
 	mgr.pageIdConvMap.Range(itrFunc)
+	if mgr.err == BLTErrCapacity {
+		if !isPageZero {
+			mgr.pbm.UnpinPPage(pageId, true)
+		}
+		return mgr.err
+	}
 
 	// write mapping data header
 	buf := make([]byte, PPageIdSize)
@@ -418,6 +565,8 @@ func (mgr *BufMgr) serializePageIdMappingToPage(pageZero *Page) {
 		// (calling PageOut is unnecessary due to the page header is not used in this case)
 		mgr.pbm.UnpinPPage(int32(pageId), true)
 	}
+
+	return BLTErrOk
 }
 
 func (mgr *BufMgr) loadPageIdMapping(pageZero interfaces.ParentPage) {
@@ -492,6 +641,13 @@ func (mgr *BufMgr) PoolAudit() {
 
 // latchLink
 func (mgr *BufMgr) LatchLink(hashIdx uint, slot uint, pageNo Uid, loadIt bool, reads *uint) BLTErr {
+	return mgr.latchLink(hashIdx, slot, pageNo, loadIt, reads, time.Time{})
+}
+
+// latchLink is LatchLink which gives up page fault at deadline.
+// when page fault is given up, latch is left linked and unpinned
+// with invalid flag and the page is loaded at next pin
+func (mgr *BufMgr) latchLink(hashIdx uint, slot uint, pageNo Uid, loadIt bool, reads *uint, deadline time.Time) BLTErr {
 	page := &mgr.pagePool[slot]
 	latch := &mgr.latchs[slot]
 
@@ -511,10 +667,18 @@ func (mgr *BufMgr) LatchLink(hashIdx uint, slot uint, pageNo Uid, loadIt bool, r
 	latch.split = 0
 	latch.prev = 0
 	latch.pin = 1
+	latch.invalid = false
 
 	if loadIt {
-		if mgr.err = mgr.PageIn(page, pageNo); mgr.err != BLTErrOk {
-			return mgr.err
+		start := mgr.latencyStart()
+		err := mgr.pageIn(page, pageNo, deadline)
+		mgr.recordLatency(LatencyPageFault, start)
+		if err != BLTErrOk {
+			latch.invalid = true
+			mgr.clearDirty(latch)
+			latch.pin = 0
+			mgr.err = err
+			return err
 		}
 		*reads++
 	}
@@ -530,38 +694,57 @@ func (mgr *BufMgr) GetRefOfPageAtPool(latch *Latchs) *Page {
 
 // PinLatch pins a page in the buffer pool
 func (mgr *BufMgr) PinLatch(pageNo Uid, loadIt bool, reads *uint, writes *uint) *Latchs {
+	latch, _ := mgr.pinLatch(pageNo, loadIt, reads, writes, time.Time{})
+	return latch
+}
+
+// pinLatch is PinLatch which gives up page fault at deadline
+// zero deadline means no deadline
+func (mgr *BufMgr) pinLatch(pageNo Uid, loadIt bool, reads *uint, writes *uint, deadline time.Time) (*Latchs, BLTErr) {
 	hashIdx := uint(pageNo) % mgr.latchHash
 
 	// try to find our entry
 	mgr.hashTable[hashIdx].latch.SpinWriteLock()
 	defer mgr.hashTable[hashIdx].latch.SpinReleaseWrite()
 
-	slot := mgr.hashTable[hashIdx].slot
-	for slot > 0 {
-		latch := &mgr.latchs[slot]
-		if latch.pageNo == pageNo {
-			break
-		}
-		slot = latch.next
-	}
+	slot := mgr.lookupLatch(hashIdx, pageNo)
 
 	// found our entry increment clock
 	if slot > 0 {
 		latch := &mgr.latchs[slot]
+
+		// retry page fault which was given up
+		if latch.invalid {
+			if loadIt {
+				if err := mgr.pageIn(&mgr.pagePool[slot], pageNo, deadline); err != BLTErrOk {
+					mgr.err = err
+					return nil, err
+				}
+				*reads++
+			}
+			latch.invalid = false
+		}
 		atomic.AddUint32(&latch.pin, 1)
 
-		return latch
+		return latch, BLTErrOk
+	}
+
+	// give up before page fault if deadline is already exceeded
+	if loadIt && !deadline.IsZero() && !time.Now().Before(deadline) {
+		mgr.err = BLTErrTimeout
+		return nil, mgr.err
 	}
 
 	// see if there are any unused pool entries
+	limit := mgr.residentLimit()
 	slot = uint(atomic.AddUint32(&mgr.latchDeployed, 1))
-	if slot < mgr.latchTotal {
+	if slot < limit {
 		latch := &mgr.latchs[slot]
-		if mgr.LatchLink(hashIdx, slot, pageNo, loadIt, reads) != BLTErrOk {
-			return nil
+		if err := mgr.latchLink(hashIdx, slot, pageNo, loadIt, reads, deadline); err != BLTErrOk {
+			return nil, err
 		}
 
-		return latch
+		return latch, BLTErrOk
 	}
 
 	atomic.AddUint32(&mgr.latchDeployed, DECREMENT)
@@ -571,7 +754,7 @@ func (mgr *BufMgr) PinLatch(pageNo Uid, loadIt bool, reads *uint, writes *uint)
 
 		// try to get write lock on hash chain
 		// skip entry if not obtained or has outstanding pins
-		slot %= mgr.latchTotal
+		slot %= limit
 
 		if slot == 0 {
 			continue
@@ -601,13 +784,17 @@ func (mgr *BufMgr) PinLatch(pageNo Uid, loadIt bool, reads *uint, writes *uint)
 
 		//if latch.dirty {
 		//if err := mgr.PageOut(&page, latch.pageNo, latch.dirty); err != BLTErrOk {
-		if err := mgr.PageOut(&page, latch.pageNo, latch.dirty); err != BLTErrOk {
-			return nil
+		// invalid entry holds no loaded page and no pin of parent page
+		if latch.invalid {
+			mgr.clearDirty(latch)
+		} else if err := mgr.pageOut(&page, latch.pageNo, latch.dirty, latch.lsn, deadline); err != BLTErrOk {
+			mgr.hashTable[idx].latch.SpinReleaseWrite()
+			return nil, err
 		} else {
 			//for relase parent page's memory
 			page.Data = nil
 
-			latch.dirty = false
+			mgr.clearDirty(latch)
 			*writes++
 		}
 		//}
@@ -623,14 +810,56 @@ func (mgr *BufMgr) PinLatch(pageNo Uid, loadIt bool, reads *uint, writes *uint)
 			mgr.latchs[latch.next].prev = latch.prev
 		}
 
-		if mgr.LatchLink(hashIdx, slot, pageNo, loadIt, reads) != BLTErrOk {
+		if err := mgr.latchLink(hashIdx, slot, pageNo, loadIt, reads, deadline); err != BLTErrOk {
 			mgr.hashTable[idx].latch.SpinReleaseWrite()
-			return nil
+			return nil, err
 		}
 		mgr.hashTable[idx].latch.SpinReleaseWrite()
 
-		return latch
+		return latch, BLTErrOk
+	}
+}
+
+// lookupLatch returns latch entry of pageNo on hash chain hashIdx or 0.
+// hash chain must be locked
+func (mgr *BufMgr) lookupLatch(hashIdx uint, pageNo Uid) uint {
+	slot := mgr.hashTable[hashIdx].slot
+	for slot > 0 {
+		latch := &mgr.latchs[slot]
+		if latch.pageNo == pageNo {
+			break
+		}
+		slot = latch.next
+	}
+	return slot
+}
+
+// IsResident reports whether page pageNo is in the buffer pool, so that
+// accessing it doesn't fetch the page from parent buffer manager.
+// the result is a hint. the page may be evicted right after it's returned
+func (mgr *BufMgr) IsResident(pageNo Uid) bool {
+	hashIdx := uint(pageNo) % mgr.latchHash
+	mgr.hashTable[hashIdx].latch.SpinReadLock()
+	defer mgr.hashTable[hashIdx].latch.SpinReleaseRead()
+
+	slot := mgr.lookupLatch(hashIdx, pageNo)
+	return slot > 0 && !mgr.latchs[slot].invalid
+}
+
+// pinResident pins page pageNo only when it's in the buffer pool.
+// returns nil instead of fetching the page
+func (mgr *BufMgr) pinResident(pageNo Uid) *Latchs {
+	hashIdx := uint(pageNo) % mgr.latchHash
+	mgr.hashTable[hashIdx].latch.SpinWriteLock()
+	defer mgr.hashTable[hashIdx].latch.SpinReleaseWrite()
+
+	slot := mgr.lookupLatch(hashIdx, pageNo)
+	if slot == 0 || mgr.latchs[slot].invalid {
+		return nil
 	}
+	latch := &mgr.latchs[slot]
+	atomic.AddUint32(&latch.pin, 1)
+	return latch
 }
 
 // UnpinLatch unpins a page in the buffer pool
@@ -655,13 +884,17 @@ func (mgr *BufMgr) NewPage(set *PageSet, contents *Page, reads *uint, writes *ui
 	if pageNo > 0 {
 		// register new page to parent buffer pool if needed
 		if _, ok := mgr.pageIdConvMap.Load(pageNo); !ok {
-			mgr.PageOut(contents, pageNo, true)
+			if err := mgr.PageOut(contents, pageNo, true); err != BLTErrOk {
+				mgr.lock.SpinReleaseWrite()
+				return err
+			}
 		}
 
 		set.latch = mgr.PinLatch(pageNo, true, reads, writes)
 		if set.latch != nil {
 			set.page = mgr.GetRefOfPageAtPool(set.latch)
 		} else {
+			mgr.lock.SpinReleaseWrite()
 			mgr.err = BLTErrStruct
 			return mgr.err
 		}
@@ -671,19 +904,37 @@ func (mgr *BufMgr) NewPage(set *PageSet, contents *Page, reads *uint, writes *ui
 		mgr.lock.SpinReleaseWrite()
 		MemCpyPage(set.page, contents)
 
-		set.latch.dirty = true
+		mgr.markDirty(set.latch)
 		mgr.err = BLTErrOk
 		return mgr.err
 	}
 
-	pageNo = GetID(mgr.pageZero.AllocRight())
-	mgr.pageZero.SetAllocRight(pageNo + 1)
+	// then pruned free pages, else allocate empty page
+	pruned := len(mgr.prunedFree)
+	if pruned > 0 {
+		pageNo = mgr.prunedFree[pruned-1]
+	} else {
+		pageNo = GetID(mgr.pageZero.AllocRight())
+		if pageNo > mgr.maxPageNo() {
+			mgr.lock.SpinReleaseWrite()
+			mgr.err = BLTErrCapacity
+			return mgr.err
+		}
+	}
 
 	//fmt.Println("NewPPage(2):  pageNo: ", pageNo)
 
 	// register new page to parent buffer pool if needed
 	if _, ok := mgr.pageIdConvMap.Load(pageNo); !ok {
-		mgr.PageOut(contents, pageNo, true)
+		if err := mgr.PageOut(contents, pageNo, true); err != BLTErrOk {
+			mgr.lock.SpinReleaseWrite()
+			return err
+		}
+	}
+	if pruned > 0 {
+		mgr.prunedFree = mgr.prunedFree[:pruned-1]
+	} else {
+		mgr.pageZero.SetAllocRight(pageNo + 1)
 	}
 
 	// unlock allocation latch
@@ -700,15 +951,112 @@ func (mgr *BufMgr) NewPage(set *PageSet, contents *Page, reads *uint, writes *ui
 
 	set.page.Data = make([]byte, mgr.pageDataSize)
 	MemCpyPage(set.page, contents)
-	set.latch.dirty = true
+	mgr.markDirty(set.latch)
 	mgr.err = BLTErrOk
 
 	return mgr.err
 }
 
+// SetPageLimit sets the largest page number which can be allocated.
+// NewPage returns BLTErrCapacity after the limit is reached.
+// 0 means no limit except MaxPageNo
+func (mgr *BufMgr) SetPageLimit(limit Uid) {
+	mgr.lock.SpinWriteLock()
+	defer mgr.lock.SpinReleaseWrite()
+
+	mgr.pageLimit = limit
+}
+
+// maxPageNo returns the largest page number which can be allocated
+func (mgr *BufMgr) maxPageNo() Uid {
+	if mgr.pageLimit == 0 || mgr.pageLimit > MaxPageNo {
+		return MaxPageNo
+	}
+	return mgr.pageLimit
+}
+
+// PageCapacity returns count of page numbers allocated so far
+// (including pages on free chain) and the largest allocatable page number
+func (mgr *BufMgr) PageCapacity() (allocated Uid, limit Uid) {
+	mgr.lock.SpinWriteLock()
+	defer mgr.lock.SpinReleaseWrite()
+
+	return GetID(mgr.pageZero.AllocRight()), mgr.maxPageNo()
+}
+
+// MaxValueSize returns the largest length of value which can be stored in leaf pages.
+// an entry of the longest key and value must fit in half of data area of a page,
+// so it's smaller than MaxKey for small pages, but it's at least BtId
+func (mgr *BufMgr) MaxValueSize() int {
+	size := int(mgr.pageDataSize)/2 - (MaxKey + 1) - 1 - 2*SlotSize
+	switch {
+	case size > MaxKey:
+		return MaxKey
+	case size < BtId:
+		return BtId
+	}
+	return size
+}
+
+// MaxKeySize returns the largest length of key which can be inserted.
+// the length is of key stored in page, i.e. keys beginning with 0xff are
+// escaped and duplicate keys have BtId bytes of sequence suffix.
+// an entry of the longest key and value must fit in a quarter of data area
+// of a page, so it's at least MaxKey and grows with page size
+func (mgr *BufMgr) MaxKeySize() int {
+	size := int(mgr.pageDataSize)/4 - 2 - MaxKey - 2*SlotSize
+	switch {
+	case size > MaxLongKey:
+		return MaxLongKey
+	case size < MaxKey:
+		return MaxKey
+	}
+	return size
+}
+
+// hasCapacity reports whether cnt more pages can be allocated by NewPage
+// without reaching the page number limit. pages on the free chain are
+// counted as one page because the chain is not walked.
+func (mgr *BufMgr) hasCapacity(cnt Uid) bool {
+	mgr.lock.SpinWriteLock()
+	defer mgr.lock.SpinReleaseWrite()
+
+	if GetID(&mgr.pageZero.chain) > 0 {
+		cnt--
+	}
+	if pruned := Uid(len(mgr.prunedFree)); pruned < cnt {
+		cnt -= pruned
+	} else {
+		cnt = 0
+	}
+	if cnt == 0 {
+		return true
+	}
+	return GetID(mgr.pageZero.AllocRight())+cnt-1 <= mgr.maxPageNo()
+}
+
 // PageFetch find and fetch page at given level for given key
 // leave page read or write locked as requested
 func (mgr *BufMgr) PageFetch(set *PageSet, key []byte, lvl uint8, lock BLTLockMode, reads *uint, writes *uint) uint32 {
+	slot, _ := mgr.pageFetch(set, key, lvl, lock, reads, writes, time.Time{})
+	return slot
+}
+
+// pageFetch is PageFetch which gives up page faults and latch waits at deadline.
+// zero deadline means no deadline. when BLTErrTimeout is returned,
+// no page is left pinned or locked
+func (mgr *BufMgr) pageFetch(set *PageSet, key []byte, lvl uint8, lock BLTLockMode, reads *uint, writes *uint, deadline time.Time) (uint32, BLTErr) {
+	return mgr.fetchPage(set, key, lvl, lock, reads, writes, deadline, false)
+}
+
+// probeLeaf is pageFetch of leaf page with read lock for lookup of key.
+// 0 slot with BLTErrOk is returned without any page locked
+// when Bloom filter of the leaf page tells key is absent
+func (mgr *BufMgr) probeLeaf(set *PageSet, key []byte, reads *uint, writes *uint, deadline time.Time) (uint32, BLTErr) {
+	return mgr.fetchPage(set, key, 0, LockRead, reads, writes, deadline, true)
+}
+
+func (mgr *BufMgr) fetchPage(set *PageSet, key []byte, lvl uint8, lock BLTLockMode, reads *uint, writes *uint, deadline time.Time, probe bool) (uint32, BLTErr) {
 	pageNo := RootPage
 	prevPage := Uid(0)
 	drill := uint8(0xff)
@@ -718,6 +1066,14 @@ func (mgr *BufMgr) PageFetch(set *PageSet, key []byte, lvl uint8, lock BLTLockMo
 	mode := LockNone
 	prevMode := LockNone
 
+	releasePrev := func() {
+		if prevPage > 0 {
+			mgr.PageUnlock(prevMode, prevLatch)
+			mgr.UnpinLatch(prevLatch)
+			prevPage = Uid(0)
+		}
+	}
+
 	// start at the root of btree and drill down
 	for pageNo > 0 {
 		// determine lock mode of drill level
@@ -727,24 +1083,27 @@ func (mgr *BufMgr) PageFetch(set *PageSet, key []byte, lvl uint8, lock BLTLockMo
 			mode = LockRead
 		}
 
-		set.latch = mgr.PinLatch(pageNo, true, reads, writes)
+		var err BLTErr
+		set.latch, err = mgr.pinLatch(pageNo, true, reads, writes, deadline)
 		if set.latch == nil {
-			return 0
+			releasePrev()
+			return 0, err
 		}
 
 		// obtain access lock using lock chaining with Access mode
 		if pageNo > RootPage {
-			mgr.PageLock(LockAccess, set.latch)
+			if !mgr.pageLockDeadline(LockAccess, set.latch, deadline) {
+				mgr.UnpinLatch(set.latch)
+				releasePrev()
+				mgr.err = BLTErrTimeout
+				return 0, mgr.err
+			}
 		}
 
 		set.page = mgr.GetRefOfPageAtPool(set.latch)
 
 		// release & unpin parent page
-		if prevPage > 0 {
-			mgr.PageUnlock(prevMode, prevLatch)
-			mgr.UnpinLatch(prevLatch)
-			prevPage = Uid(0)
-		}
+		releasePrev()
 
 		// skip Atomic lock on leaf page if already held
 		// Note: not supported in this golang implementation
@@ -757,7 +1116,14 @@ func (mgr *BufMgr) PageFetch(set *PageSet, key []byte, lvl uint8, lock BLTLockMo
 		//}
 
 		// obtain mode lock using lock chaining through AccessLock
-		mgr.PageLock(mode, set.latch)
+		if !mgr.pageLockDeadline(mode, set.latch, deadline) {
+			if pageNo > RootPage {
+				mgr.PageUnlock(LockAccess, set.latch)
+			}
+			mgr.UnpinLatch(set.latch)
+			mgr.err = BLTErrTimeout
+			return 0, mgr.err
+		}
 
 		// Note: not supported in this golang implementation
 		//if (mode & LockAtomic) {
@@ -766,7 +1132,7 @@ func (mgr *BufMgr) PageFetch(set *PageSet, key []byte, lvl uint8, lock BLTLockMo
 
 		if set.page.Free {
 			mgr.err = BLTErrStruct
-			return 0
+			return 0, mgr.err
 		}
 
 		if pageNo > RootPage {
@@ -777,7 +1143,7 @@ func (mgr *BufMgr) PageFetch(set *PageSet, key []byte, lvl uint8, lock BLTLockMo
 		if set.page.Lvl != drill {
 			if set.latch.pageNo != RootPage {
 				mgr.err = BLTErrStruct
-				return 0
+				return 0, mgr.err
 			}
 
 			drill = set.page.Lvl
@@ -799,7 +1165,15 @@ func (mgr *BufMgr) PageFetch(set *PageSet, key []byte, lvl uint8, lock BLTLockMo
 			goto sliderRight
 		}
 
-		slot = set.page.FindSlot(key)
+		// the leaf page covers key when key isn't greater than its fence key
+		if probe && drill == 0 && (GetID(&set.page.Right) == 0 || set.page.cmpKey(set.page.Cnt, key, mgr.keyCompare) >= 0) {
+			if !mgr.leafMayContain(set.latch, set.page, key) {
+				releasePrev()
+				return 0, BLTErrOk
+			}
+		}
+
+		slot = set.page.findSlot(key, mgr.keyCompare)
 		if slot > 0 {
 			if drill == lvl {
 				//if slot*SlotSize+(set.page.Act-1)*EntrySizeForDebug+3 > mgr.pageDataSize {
@@ -809,7 +1183,7 @@ func (mgr *BufMgr) PageFetch(set *PageSet, key []byte, lvl uint8, lock BLTLockMo
 				if !ValidatePage(set.page) {
 					panic("PageFetch: page is broken")
 				}
-				return slot
+				return slot, BLTErrOk
 			}
 
 			for set.page.Dead(slot) {
@@ -832,7 +1206,7 @@ func (mgr *BufMgr) PageFetch(set *PageSet, key []byte, lvl uint8, lock BLTLockMo
 
 	// return error on end of right chain
 	mgr.err = BLTErrStruct
-	return 0
+	return 0, mgr.err
 }
 
 // FreePage
@@ -848,7 +1222,7 @@ func (mgr *BufMgr) PageFree(set *PageSet) {
 	// store chain
 	set.page.Right = mgr.pageZero.chain
 	PutID(&mgr.pageZero.chain, set.latch.pageNo)
-	set.latch.dirty = true
+	mgr.markDirty(set.latch)
 	set.page.Free = true
 	if _, ok := mgr.pageIdConvMap.Load(set.latch.pageNo); ok {
 		mgr.PageOut(set.page, set.latch.pageNo, false)
@@ -872,19 +1246,53 @@ func (mgr *BufMgr) PageFree(set *PageSet) {
 //
 // place write, read, or parent lock on requested page_no
 func (mgr *BufMgr) PageLock(mode BLTLockMode, latch *Latchs) {
+	mgr.injectFault(FaultLatch, latch.pageNo)
+
 	switch mode {
 	case LockRead:
 		latch.readWr.ReadLock()
 	case LockWrite:
 		latch.readWr.WriteLock()
+		mgr.preservePage(latch)
 	case LockAccess:
 		latch.access.ReadLock()
 	case LockDelete:
 		latch.access.WriteLock()
 	case LockParent:
 		latch.parent.WriteLock()
-		//case LockAtomic: // Note: not supported in this golang implementation
+	case LockAtomic:
+		latch.atomic.WriteLock()
+	}
+}
+
+// pageLockDeadline is PageLock which gives up waiting at deadline.
+// zero deadline means no deadline. returns false when lock is not obtained
+func (mgr *BufMgr) pageLockDeadline(mode BLTLockMode, latch *Latchs, deadline time.Time) bool {
+	if deadline.IsZero() {
+		mgr.PageLock(mode, latch)
+		return true
+	}
+
+	mgr.injectFault(FaultLatch, latch.pageNo)
+
+	switch mode {
+	case LockRead:
+		return latch.readWr.ReadLockDeadline(deadline)
+	case LockWrite:
+		if !latch.readWr.WriteLockDeadline(deadline) {
+			return false
+		}
+		mgr.preservePage(latch)
+	case LockAccess:
+		return latch.access.ReadLockDeadline(deadline)
+	case LockDelete:
+		return latch.access.WriteLockDeadline(deadline)
+	case LockParent:
+		return latch.parent.WriteLockDeadline(deadline)
+	case LockAtomic:
+		return latch.atomic.WriteLockDeadline(deadline)
 	}
+	return true
 }
 
 func (mgr *BufMgr) PageUnlock(mode BLTLockMode, latch *Latchs) {
@@ -892,6 +1300,8 @@ func (mgr *BufMgr) PageUnlock(mode BLTLockMode, latch *Latchs) {
 	case LockRead:
 		latch.readWr.ReadRelease()
 	case LockWrite:
+		// the page may be modified
+		mgr.blooms[latch.entry].Store(nil)
 		latch.readWr.WriteRelease()
 	case LockAccess:
 		latch.access.ReadRelease()
@@ -899,20 +1309,24 @@ func (mgr *BufMgr) PageUnlock(mode BLTLockMode, latch *Latchs) {
 		latch.access.WriteRelease()
 	case LockParent:
 		latch.parent.WriteRelease()
-		//case LockAtomic:
-		//Note: not supported in this golang implementation
+	case LockAtomic:
+		latch.atomic.WriteRelease()
 	}
 }
 
 func (mgr *BufMgr) GetMappedPPageIdOfPageZero() int32 {
 	if val, ok := mgr.pageIdConvMap.Load(Uid(0)); ok {
-		ret := val.(int32)
-		return ret
+		return val
 	} else {
 		panic("page zero mapping not found")
 	}
 }
 
-func (mgr *BufMgr) GetPageIdConvMap() *sync.Map {
-	return &mgr.pageIdConvMap
+func (mgr *BufMgr) GetPageIdConvMap() *PageIdMap {
+	return mgr.pageIdConvMap
+}
+
+// PageIdMapStats returns size and memory usage of page id conversion map
+func (mgr *BufMgr) PageIdMapStats() PageIdMapStats {
+	return mgr.pageIdConvMap.Stats()
 }
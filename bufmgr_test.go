@@ -2,7 +2,10 @@ package blink_tree
 
 import (
 	"bytes"
+	"encoding/binary"
+	"github.com/ryogrid/bltree-go-for-embedding/interfaces"
 	"reflect"
+	"sync"
 	"testing"
 )
 
@@ -449,3 +452,151 @@ func TestBufMgr_NewPage(t *testing.T) {
 		})
 	}
 }
+
+// parentBufMgrLimited is ParentBufMgr which can supply only limited count of pages
+type parentBufMgrLimited struct {
+	*ParentBufMgrDummy
+	remain int
+}
+
+func (p *parentBufMgrLimited) NewPPage() interfaces.ParentPage {
+	if p.remain <= 0 {
+		return nil
+	}
+	p.remain--
+	return p.ParentBufMgrDummy.NewPPage()
+}
+
+func TestBufMgr_NewPage_Capacity(t *testing.T) {
+	t.Run("page number limit", func(t *testing.T) {
+		mgr := NewBufMgr(12, 20, NewParentBufMgrDummy(nil), nil)
+		allocated, _ := mgr.PageCapacity()
+		mgr.SetPageLimit(allocated)
+
+		reads, writes := uint(0), uint(0)
+		var set PageSet
+		if err := mgr.NewPage(&set, NewPage(mgr.pageDataSize), &reads, &writes); err != BLTErrOk {
+			t.Errorf("NewPage() = %v, want %v", err, BLTErrOk)
+		}
+		if err := mgr.NewPage(&set, NewPage(mgr.pageDataSize), &reads, &writes); err != BLTErrCapacity {
+			t.Errorf("NewPage() = %v, want %v", err, BLTErrCapacity)
+		}
+		if got, _ := mgr.PageCapacity(); got != allocated+1 {
+			t.Errorf("PageCapacity() = %v, want %v", got, allocated+1)
+		}
+	})
+	t.Run("parent page exhausted", func(t *testing.T) {
+		pbm := &parentBufMgrLimited{NewParentBufMgrDummy(nil).(*ParentBufMgrDummy), MinLvl + 1}
+		mgr := NewBufMgr(12, 20, pbm, nil)
+
+		reads, writes := uint(0), uint(0)
+		var set PageSet
+		if err := mgr.NewPage(&set, NewPage(mgr.pageDataSize), &reads, &writes); err != BLTErrCapacity {
+			t.Errorf("NewPage() = %v, want %v", err, BLTErrCapacity)
+		}
+		if got, _ := mgr.PageCapacity(); got != MinLvl+1 {
+			t.Errorf("PageCapacity() = %v, want %v", got, MinLvl+1)
+		}
+	})
+}
+
+func TestBufMgr_IsResident(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	bltree := NewBLTree(mgr)
+
+	for i := 0; i < 10000; i++ {
+		if err := bltree.InsertKey([]byte{byte(i >> 8), byte(i)}, 0, make([]byte, BtId), true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+
+	allocated, _ := mgr.PageCapacity()
+	resident := 0
+	for pageNo := Uid(1); pageNo < allocated; pageNo++ {
+		if mgr.IsResident(pageNo) {
+			resident++
+		}
+	}
+	if resident == 0 || resident >= int(allocated)-1 {
+		t.Errorf("IsResident() is true for %v of %v pages, want some of them", resident, allocated-1)
+	}
+
+	// pinning a page loads it
+	var reads, writes uint
+	for pageNo := Uid(1); pageNo < allocated; pageNo++ {
+		if !mgr.IsResident(pageNo) {
+			latch := mgr.PinLatch(pageNo, true, &reads, &writes)
+			if !mgr.IsResident(pageNo) {
+				t.Errorf("IsResident(%v) after PinLatch() = false, want true", pageNo)
+			}
+			mgr.UnpinLatch(latch)
+			break
+		}
+	}
+
+	if mgr.IsResident(allocated + 100) {
+		t.Errorf("IsResident() of not allocated page = true, want false")
+	}
+}
+
+func TestBufMgr_PruneFreePages(t *testing.T) {
+	pbmPageMap := &sync.Map{}
+	pbm := NewParentBufMgrDummy(pbmPageMap)
+	mgr := NewBufMgr(12, 48, pbm, nil)
+	bltree := NewBLTree(mgr)
+
+	keyOf := func(i uint64) []byte {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		return bs
+	}
+
+	num := uint64(5000)
+	for i := uint64(0); i < num; i++ {
+		if err := bltree.InsertKey(keyOf(i), 0, make([]byte, BtId), true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+	for i := uint64(0); i < num-100; i++ {
+		if err := bltree.DeleteKey(keyOf(i), 0); err != BLTErrOk {
+			t.Fatalf("DeleteKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+
+	before := mgr.GetPageIdConvMap().Len()
+	pruned := mgr.PruneFreePages()
+	if pruned == 0 {
+		t.Fatalf("PruneFreePages() = %v, want more than 0", pruned)
+	}
+	if got := mgr.GetPageIdConvMap().Len(); got != before-int64(pruned) {
+		t.Errorf("page id mapping entries after PruneFreePages() = %v, want %v", got, before-int64(pruned))
+	}
+	if got := mgr.PruneFreePages(); got != 0 {
+		t.Errorf("PruneFreePages() twice = %v, want %v", got, 0)
+	}
+
+	// pruned pages are reused before new pages are allocated
+	allocated, _ := mgr.PageCapacity()
+	for i := uint64(0); i < num/4; i++ {
+		if err := bltree.InsertKey(keyOf(i), 0, make([]byte, BtId), true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+	if got, _ := mgr.PageCapacity(); got != allocated {
+		t.Errorf("PageCapacity() after reinsert = %v, want %v", got, allocated)
+	}
+
+	// free pages are not serialized and the tree is restored
+	if err := mgr.Close(); err != BLTErrOk {
+		t.Fatalf("Close() = %v, want %v", err, BLTErrOk)
+	}
+	lastPageZeroId := mgr.GetMappedPPageIdOfPageZero()
+	mgr = NewBufMgr(12, 48, NewParentBufMgrDummy(pbmPageMap), &lastPageZeroId)
+	bltree = NewBLTree(mgr)
+	for i := uint64(0); i < num; i++ {
+		want := i < num/4 || i >= num-100
+		if ret, _, _ := bltree.FindKey(keyOf(i), BtId); (ret >= 0) != want {
+			t.Errorf("FindKey(%v) found = %v, want %v", i, ret >= 0, want)
+		}
+	}
+}
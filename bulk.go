@@ -0,0 +1,269 @@
+package blink_tree
+
+// BulkLoadIter returns pairs to be loaded by BulkLoad in strictly ascending
+// order of keys. ok is false when pairs are exhausted
+type BulkLoadIter func() (key []byte, value []byte, ok bool)
+
+type (
+	// bulkLoader builds pages of all levels from left to right.
+	// fence key of each written page is put to the page being built in the upper level
+	bulkLoader struct {
+		tree   *BLTree
+		limit  uint32       // max used bytes of a page (slots, keys and values)
+		reuse  Uid          // leaf page of the empty tree which is used as the first leaf page
+		final  bool         // levels are being closed
+		levels []*bulkLevel // index is level
+	}
+
+	// bulkLevel is the rightmost page of a level being built
+	bulkLevel struct {
+		frame  *Page
+		nxt    uint32               // lowest offset of keys and values in frame
+		last   *Latchs              // pinned last written page whose right link is set by the next page
+		prefix []byte               // key prefix of frame which keys are stored without
+		low    [FenceHintSize]uint8 // LowFence of frame, which is hint of fence key of the last written page
+	}
+)
+
+// BulkLoad loads pairs of iter into the empty tree. leaf pages are built from left
+// to right, each filled up to fillFactor of its data area, and pages of upper levels
+// are built likewise from their fence keys without splits. fillFactor out of (0, 1] means 1.
+// it returns count of loaded keys, which are reported to ChangeHook.
+// BLTErrNotEmpty is returned unless the tree is a root page over an empty
+// leaf page like a new tree, and BLTErrOrder when keys are not strictly ascending.
+// when an error is returned after some keys are loaded, the tree is completed with them.
+// ATTENTION: like RangeScan, this method call is not atomic with other tree operations,
+// so it should be called while no other operation is in progress
+func (tree *BLTree) BulkLoad(iter BulkLoadIter, fillFactor float64) (int, BLTErr) {
+	tree.startOp()
+	tree.startStructureMod()
+	defer tree.mgr.enforceDirtyQuota(&tree.reads, &tree.writes)
+
+	leaf, err := tree.emptyLeaf()
+	if err != BLTErrOk {
+		return 0, err
+	}
+
+	dataSize := tree.mgr.pageDataSize
+	if fillFactor <= 0 || fillFactor > 1 {
+		fillFactor = 1
+	}
+	b := &bulkLoader{tree: tree, limit: uint32(float64(dataSize) * fillFactor), reuse: leaf}
+	// room for stopper key of the rightmost leaf page
+	if max := dataSize - 2*SlotSize - 2 - 1 - 1; b.limit > max {
+		b.limit = max
+	}
+	b.levels = []*bulkLevel{b.newLevel(0)}
+
+	loaded := 0
+	var prev []byte
+	for {
+		key, value, ok := iter()
+		if !ok {
+			break
+		}
+		var ins, stored []byte
+		if ins, err = tree.insertingKey(key, true); err != BLTErrOk {
+			break
+		}
+		if stored, err = tree.storedValue(key, value); err != BLTErrOk {
+			break
+		}
+		if prev != nil && tree.mgr.compareKeys(prev, ins) >= 0 {
+			err = BLTErrOrder
+			break
+		}
+		if err = b.add(0, ins, stored); err != BLTErrOk {
+			break
+		}
+		if seq := tree.mgr.nextChangeSeq(); seq > 0 {
+			tree.mgr.notifyChange(seq, ChangeInsert, key, nil, value)
+		}
+		prev = ins
+		loaded++
+	}
+
+	if loaded == 0 {
+		if err != BLTErrOk {
+			tree.err = err
+		}
+		return 0, err
+	}
+	if closeErr := b.finish(); closeErr != BLTErrOk {
+		err = closeErr
+	}
+	if err != BLTErrOk {
+		tree.err = err
+	}
+	return loaded, err
+}
+
+// emptyLeaf returns the only leaf page of the tree
+// when the tree is a root page over an empty leaf page
+func (tree *BLTree) emptyLeaf() (Uid, BLTErr) {
+	var leaf Uid
+	for pageNo := RootPage; pageNo > 0; {
+		latch, err := tree.mgr.pinLatch(pageNo, true, &tree.reads, &tree.writes, tree.deadline)
+		if latch == nil {
+			tree.err = err
+			return 0, err
+		}
+		tree.mgr.PageLock(LockRead, latch)
+		page := tree.mgr.GetRefOfPageAtPool(latch)
+		// only stopper key is live
+		empty := page.Act == 1 && GetID(&page.Right) == 0 && page.Lvl <= 1
+		next := Uid(0)
+		if empty && page.Lvl > 0 {
+			next = GetIDFromValue(page.Value(page.Cnt))
+		}
+		tree.mgr.PageUnlock(LockRead, latch)
+		tree.mgr.UnpinLatch(latch)
+
+		if !empty {
+			tree.err = BLTErrNotEmpty
+			return 0, tree.err
+		}
+		leaf, pageNo = pageNo, next
+	}
+	return leaf, BLTErrOk
+}
+
+func (b *bulkLoader) newLevel(lvl uint8) *bulkLevel {
+	frame := NewPage(b.tree.mgr.pageDataSize)
+	frame.Bits = b.tree.mgr.pageBits
+	frame.Lvl = lvl
+	b.tree.mgr.setFixedWidth(frame)
+	return &bulkLevel{frame: frame, nxt: b.tree.mgr.pageDataSize}
+}
+
+// add puts key with value to the page being built in the level.
+// the page is written first when the entry doesn't fit in it
+func (b *bulkLoader) add(lvl uint8, key []byte, value []byte) BLTErr {
+	if int(lvl) == len(b.levels) {
+		b.levels = append(b.levels, b.newLevel(lvl))
+	}
+	l := b.levels[lvl]
+
+	// a page has at least one key
+	slots := l.frame.Cnt + 2
+	if l.frame.Cnt == 0 {
+		slots = 1
+	}
+	used := slots*SlotSize + b.tree.mgr.pageDataSize - l.nxt + uint32(len(key)+len(value)) + 2
+	if l.frame.Cnt > 0 && used > b.limit {
+		if err := b.flush(lvl); err != BLTErrOk {
+			return err
+		}
+	}
+	l.put(key, value)
+	return BLTErrOk
+}
+
+// put appends key with value to the frame like splitPage
+func (l *bulkLevel) put(key []byte, value []byte) {
+	frame := l.frame
+	pre := sharedPrefixLen(key, l.prefix)
+	l.nxt = frame.putEntry(l.nxt, key, pre, value)
+
+	idx := frame.Cnt
+	// add librarian slot
+	if idx > 0 {
+		idx++
+		frame.setEntry(idx, l.nxt, key, pre, value)
+		frame.SetTyp(idx, Librarian)
+		frame.SetDead(idx, true)
+	}
+
+	// add actual slot
+	idx++
+	frame.setEntry(idx, l.nxt, key, pre, value)
+	frame.SetTyp(idx, Unique)
+
+	frame.Cnt = idx
+	frame.Act++
+	frame.Min = l.nxt
+}
+
+// flush writes the page being built in the level, links it from the last
+// written page of the level and puts its fence key to the upper level
+func (b *bulkLoader) flush(lvl uint8) BLTErr {
+	tree := b.tree
+	l := b.levels[lvl]
+	var set PageSet
+
+	if lvl == 0 && b.reuse > 0 {
+		latch, err := tree.mgr.pinLatch(b.reuse, true, &tree.reads, &tree.writes, tree.deadline)
+		if latch == nil {
+			return err
+		}
+		set.latch = latch
+		set.page = tree.mgr.GetRefOfPageAtPool(latch)
+		b.reuse = 0
+	} else {
+		// keep pages to close all levels after this page
+		if lvl == 0 && !b.final && !tree.mgr.hasCapacity(Uid(1+3*(len(b.levels)+1))) {
+			return BLTErrCapacity
+		}
+		if err := tree.mgr.NewPage(&set, l.frame, &tree.reads, &tree.writes); err != BLTErrOk {
+			return err
+		}
+	}
+	l.frame.LowFence = l.low
+	l.frame.setHighFence()
+	tree.mgr.PageLock(LockWrite, set.latch)
+	MemCpyPage(set.page, l.frame)
+	tree.markDirty(set.latch)
+	if !ValidatePage(set.page) {
+		panic("BulkLoad: page is broken.")
+	}
+	tree.mgr.PageUnlock(LockWrite, set.latch)
+
+	if l.last != nil {
+		tree.mgr.PageLock(LockWrite, l.last)
+		PutID(&tree.mgr.GetRefOfPageAtPool(l.last).Right, set.latch.pageNo)
+		tree.markDirty(l.last)
+		tree.mgr.PageUnlock(LockWrite, l.last)
+		tree.mgr.UnpinLatch(l.last)
+	}
+
+	fence := l.frame.Key(l.frame.Cnt)
+	*l = *b.newLevel(lvl)
+	l.last = set.latch
+	l.low = fenceHint(fence)
+
+	var child [BtId]byte
+	PutID(&child, set.latch.pageNo)
+	return b.add(lvl+1, fence, child[:])
+}
+
+// finish puts stopper key to the rightmost leaf page and writes the rightmost
+// page of each level. the only page of the top level is written to the root page
+func (b *bulkLoader) finish() BLTErr {
+	tree := b.tree
+	b.final = true
+	b.levels[0].put([]byte{0xff, 0xff}, []byte{})
+
+	for lvl := 0; lvl < len(b.levels); lvl++ {
+		l := b.levels[lvl]
+		if lvl > 0 && lvl == len(b.levels)-1 && l.last == nil {
+			latch, err := tree.mgr.pinLatch(RootPage, true, &tree.reads, &tree.writes, tree.deadline)
+			if latch == nil {
+				return err
+			}
+			l.frame.setHighFence()
+			tree.mgr.PageLock(LockWrite, latch)
+			MemCpyPage(tree.mgr.GetRefOfPageAtPool(latch), l.frame)
+			tree.markDirty(latch)
+			tree.mgr.PageUnlock(LockWrite, latch)
+			tree.mgr.UnpinLatch(latch)
+			return BLTErrOk
+		}
+
+		if err := b.flush(uint8(lvl)); err != BLTErrOk {
+			return err
+		}
+		tree.mgr.UnpinLatch(l.last)
+		l.last = nil
+	}
+	return BLTErrOk
+}
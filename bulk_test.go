@@ -0,0 +1,132 @@
+package blink_tree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestBLTree_BulkLoad(t *testing.T) {
+	keyOf := func(i int) []byte {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, uint64(i))
+		return bs
+	}
+	valueOf := func(i int) []byte {
+		return bytes.Repeat([]byte{byte(i)}, i%12)
+	}
+	iterOf := func(from int, to int) BulkLoadIter {
+		i := from
+		return func() ([]byte, []byte, bool) {
+			if i >= to {
+				return nil, nil, false
+			}
+			i++
+			return keyOf(i - 1), valueOf(i - 1), true
+		}
+	}
+
+	tests := []struct {
+		name       string
+		num        int
+		fillFactor float64
+	}{
+		{name: "no key", num: 0, fillFactor: 1},
+		{name: "single leaf page", num: 10, fillFactor: 1},
+		{name: "full pages", num: 100000, fillFactor: 0},
+		{name: "pages filled to 70%", num: 100000, fillFactor: 0.7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+			bltree := NewBLTree(mgr)
+
+			if loaded, err := bltree.BulkLoad(iterOf(0, tt.num), tt.fillFactor); loaded != tt.num || err != BLTErrOk {
+				t.Fatalf("BulkLoad() = %v, %v, want %v, %v", loaded, err, tt.num, BLTErrOk)
+			}
+			if report, err := ValidateTree(bltree); err != BLTErrOk || !report.Valid() {
+				t.Fatalf("ValidateTree() after BulkLoad = %v, %v, want no problem", report.Problems, err)
+			}
+			stats, _ := bltree.Stats()
+			if stats.Keys != uint64(tt.num) || stats.Splits != 0 {
+				t.Errorf("Stats() = %+v, want %v keys without splits", stats, tt.num)
+			}
+			if want := tt.fillFactor; tt.num > 1000 && want > 0 && (stats.FillFactor < want-0.05 || stats.FillFactor > want) {
+				t.Errorf("Stats() FillFactor = %v, want about %v", stats.FillFactor, want)
+			}
+			for i := 0; i < tt.num; i++ {
+				if _, _, val := bltree.FindKey(keyOf(i), MaxKey); !bytes.Equal(val, valueOf(i)) {
+					t.Fatalf("FindKey(%v) = %v, want %v", i, val, valueOf(i))
+				}
+			}
+
+			// loaded tree is modified as usual
+			for i := tt.num; i < tt.num+1000; i++ {
+				if err := bltree.InsertKey(keyOf(i), 0, valueOf(i), true); err != BLTErrOk {
+					t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+				}
+			}
+			for i := 0; i < tt.num; i += 3 {
+				if err := bltree.DeleteKey(keyOf(i), 0); err != BLTErrOk {
+					t.Fatalf("DeleteKey() = %v, want %v", err, BLTErrOk)
+				}
+			}
+			if report, err := ValidateTree(bltree); err != BLTErrOk || !report.Valid() {
+				t.Errorf("ValidateTree() after modifications = %v, %v, want no problem", report.Problems, err)
+			}
+
+			if tt.num > 0 {
+				if loaded, err := bltree.BulkLoad(iterOf(0, 1), 1); loaded != 0 || err != BLTErrNotEmpty {
+					t.Errorf("BulkLoad() to tree with keys = %v, %v, want %v, %v", loaded, err, 0, BLTErrNotEmpty)
+				}
+			}
+		})
+	}
+}
+
+func TestBLTree_BulkLoad_errors(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	bltree := NewBLTree(mgr)
+
+	// keys before the unordered key are loaded
+	keys := [][]byte{{1}, {2}, {3}, {3}, {4}}
+	i := 0
+	iter := func() ([]byte, []byte, bool) {
+		if i == len(keys) {
+			return nil, nil, false
+		}
+		i++
+		return keys[i-1], []byte{1}, true
+	}
+	if loaded, err := bltree.BulkLoad(iter, 1); loaded != 3 || err != BLTErrOrder {
+		t.Errorf("BulkLoad() of unordered keys = %v, %v, want %v, %v", loaded, err, 3, BLTErrOrder)
+	}
+	if cnt, _ := bltree.Count(); cnt != 3 {
+		t.Errorf("Count() = %v, want %v", cnt, 3)
+	}
+	if report, err := ValidateTree(bltree); err != BLTErrOk || !report.Valid() {
+		t.Errorf("ValidateTree() = %v, %v, want no problem", report.Problems, err)
+	}
+
+	// pages run out during the load
+	mgr = NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	mgr.SetPageLimit(30)
+	bltree = NewBLTree(mgr)
+	n := 0
+	iter = func() ([]byte, []byte, bool) {
+		n++
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, uint64(n))
+		return bs, make([]byte, 8), true
+	}
+	loaded, err := bltree.BulkLoad(iter, 1)
+	if loaded == 0 || err != BLTErrCapacity {
+		t.Fatalf("BulkLoad() over page limit = %v, %v, want %v", loaded, err, BLTErrCapacity)
+	}
+	if cnt, _ := bltree.Count(); cnt != loaded {
+		t.Errorf("Count() = %v, want %v", cnt, loaded)
+	}
+	if report, err := ValidateTree(bltree); err != BLTErrOk || !report.Valid() {
+		t.Errorf("ValidateTree() = %v, %v, want no problem", report.Problems, err)
+	}
+}
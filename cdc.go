@@ -0,0 +1,99 @@
+package blink_tree
+
+import "sync/atomic"
+
+// ChangeOp is kind of change reported to ChangeHook
+type ChangeOp uint8
+
+const (
+	ChangeInsert ChangeOp = iota // key is inserted or its value is updated
+	ChangeDelete                 // key is deleted
+)
+
+type (
+	// ChangeEvent is a change of leaf key reported to ChangeHook
+	ChangeEvent struct {
+		Seq   uint64   // change sequence number (monotonically increasing in BufMgr)
+		Op    ChangeOp // kind of change
+		Key   []byte   // changed key
+		Value []byte   // inserted value or deleted value
+		// value of key before the change. nil when key is newly inserted.
+		// it's the same as Value for deletion
+		OldValue []byte
+	}
+
+	// ChangeHook is called after each successful change of leaf key
+	ChangeHook func(ev ChangeEvent)
+
+	// ChangeListener receives changes of leaf keys like ChangeHook.
+	// oldValue is nil when key is newly inserted and newValue is nil when key is deleted
+	ChangeListener interface {
+		OnChange(op ChangeOp, key []byte, oldValue []byte, newValue []byte)
+	}
+)
+
+// SetChangeHook sets hook for change data capture. nil removes the hook.
+//
+// hook is called synchronously on goroutine of InsertKey or DeleteKey
+// after page latches are released, so hooks of different BLTree handles
+// may be called concurrently and out of Seq order. sequence numbers are
+// assigned while leaf page is latched, so changes of a key are ordered by Seq.
+// changes which are done while no hook is set are not numbered
+func (mgr *BufMgr) SetChangeHook(hook ChangeHook) {
+	if hook == nil {
+		mgr.changeHook.Store(nil)
+		return
+	}
+	mgr.changeHook.Store(&hook)
+}
+
+// SetChangeListener sets change hook which passes each change to l.
+// it replaces hook set by SetChangeHook. nil removes the hook
+func (mgr *BufMgr) SetChangeListener(l ChangeListener) {
+	if l == nil {
+		mgr.SetChangeHook(nil)
+		return
+	}
+	mgr.SetChangeHook(func(ev ChangeEvent) {
+		newValue := ev.Value
+		if ev.Op == ChangeDelete {
+			newValue = nil
+		}
+		l.OnChange(ev.Op, ev.Key, ev.OldValue, newValue)
+	})
+}
+
+// ChangeSeq returns last assigned change sequence number
+func (mgr *BufMgr) ChangeSeq() uint64 {
+	return atomic.LoadUint64(&mgr.changeSeq)
+}
+
+// nextChangeSeq assigns new change sequence number.
+// returns 0 when no hook is set
+func (mgr *BufMgr) nextChangeSeq() uint64 {
+	if mgr.changeHook.Load() == nil {
+		return 0
+	}
+	return atomic.AddUint64(&mgr.changeSeq, 1)
+}
+
+// notifyChange calls change hook if it is set. value is the inserted value,
+// and it's ignored on deletion whose value is oldValue
+func (mgr *BufMgr) notifyChange(seq uint64, op ChangeOp, key []byte, oldValue []byte, value []byte) {
+	if hook := mgr.changeHook.Load(); hook != nil {
+		if op == ChangeDelete {
+			value = oldValue
+		}
+		(*hook)(ChangeEvent{Seq: seq, Op: op, Key: key, Value: value, OldValue: oldValue})
+	}
+}
+
+// changedValue decodes value overwritten or deleted by a change.
+// nil is returned when no value is overwritten
+func (mgr *BufMgr) changedValue(stored []byte) []byte {
+	if stored == nil {
+		return nil
+	}
+	val, _ := mgr.decodeValue(stored)
+	return val
+}
@@ -0,0 +1,122 @@
+package blink_tree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestBufMgr_ChangeHook(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	bltree := NewBLTree(mgr)
+
+	events := make([]ChangeEvent, 0)
+	mgr.SetChangeHook(func(ev ChangeEvent) {
+		events = append(events, ev)
+	})
+
+	bltree.InsertKey([]byte{1}, 0, []byte{1, 0, 0, 0, 0, 0}, true)
+	bltree.InsertKey([]byte{1}, 0, []byte{2, 0, 0, 0, 0, 0}, true)
+	bltree.DeleteKey([]byte{1}, 0)
+	// deleting not existing key is not a change
+	bltree.DeleteKey([]byte{2}, 0)
+
+	want := []ChangeEvent{
+		{Seq: 1, Op: ChangeInsert, Key: []byte{1}, Value: []byte{1, 0, 0, 0, 0, 0}},
+		{Seq: 2, Op: ChangeInsert, Key: []byte{1}, Value: []byte{2, 0, 0, 0, 0, 0}},
+		{Seq: 3, Op: ChangeDelete, Key: []byte{1}, Value: []byte{2, 0, 0, 0, 0, 0}},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("hook called %d times, want %d: %v", len(events), len(want), events)
+	}
+	for i := range want {
+		if events[i].Seq != want[i].Seq || events[i].Op != want[i].Op ||
+			!bytes.Equal(events[i].Key, want[i].Key) || !bytes.Equal(events[i].Value, want[i].Value) {
+			t.Errorf("event[%d] = %v, want %v", i, events[i], want[i])
+		}
+	}
+
+	// splits and page deletions don't report changes of upper levels
+	events = events[:0]
+	num := 2000
+	for i := 0; i < num; i++ {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, uint64(i))
+		bltree.InsertKey(bs, 0, make([]byte, BtId), true)
+	}
+	for i := 0; i < num; i++ {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, uint64(i))
+		bltree.DeleteKey(bs, 0)
+	}
+	if len(events) != num*2 {
+		t.Fatalf("hook called %d times, want %d", len(events), num*2)
+	}
+	for i := 1; i < len(events); i++ {
+		if events[i].Seq <= events[i-1].Seq {
+			t.Fatalf("event Seq %d follows %d", events[i].Seq, events[i-1].Seq)
+		}
+	}
+	if got := mgr.ChangeSeq(); got != events[len(events)-1].Seq {
+		t.Errorf("ChangeSeq() = %v, want %v", got, events[len(events)-1].Seq)
+	}
+
+	mgr.SetChangeHook(nil)
+	bltree.InsertKey([]byte{1}, 0, []byte{1, 0, 0, 0, 0, 0}, true)
+	if len(events) != num*2 {
+		t.Errorf("hook called after it is removed")
+	}
+}
+
+type changeRecorder struct {
+	events [][4][]byte // key, old value, new value and op
+}
+
+func (r *changeRecorder) OnChange(op ChangeOp, key []byte, oldValue []byte, newValue []byte) {
+	r.events = append(r.events, [4][]byte{key, oldValue, newValue, {byte(op)}})
+}
+
+func TestBufMgr_SetChangeListener(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	bltree := NewBLTree(mgr)
+
+	r := &changeRecorder{}
+	mgr.SetChangeListener(r)
+
+	bltree.InsertKey([]byte{1}, 0, []byte{1}, true)
+	bltree.InsertKey([]byte{1}, 0, []byte{2}, true)
+	bltree.InsertBatch([][]byte{{1}, {2}}, [][]byte{{3}, {4}})
+	var batch WriteBatch
+	batch.Put([]byte{2}, []byte{5})
+	batch.Delete([]byte{1})
+	bltree.Write(&batch)
+	bltree.DeleteKey([]byte{2}, 0)
+
+	want := [][4][]byte{
+		{{1}, nil, {1}, {byte(ChangeInsert)}},
+		{{1}, {1}, {2}, {byte(ChangeInsert)}},
+		{{1}, {2}, {3}, {byte(ChangeInsert)}},
+		{{2}, nil, {4}, {byte(ChangeInsert)}},
+		// changes of a batch are reported in key order
+		{{1}, {3}, nil, {byte(ChangeDelete)}},
+		{{2}, {4}, {5}, {byte(ChangeInsert)}},
+		{{2}, {5}, nil, {byte(ChangeDelete)}},
+	}
+	if len(r.events) != len(want) {
+		t.Fatalf("listener called %d times, want %d: %v", len(r.events), len(want), r.events)
+	}
+	for i := range want {
+		for j := range want[i] {
+			if !bytes.Equal(r.events[i][j], want[i][j]) || (r.events[i][j] == nil) != (want[i][j] == nil) {
+				t.Errorf("event[%d] = %v, want %v", i, r.events[i], want[i])
+				break
+			}
+		}
+	}
+
+	mgr.SetChangeListener(nil)
+	bltree.InsertKey([]byte{1}, 0, []byte{1}, true)
+	if len(r.events) != len(want) {
+		t.Errorf("listener called after it is removed")
+	}
+}
@@ -0,0 +1,50 @@
+package blink_tree
+
+// KV is a key and its value sent by ScanChan
+type KV struct {
+	Key   []byte
+	Value []byte
+}
+
+// ScanChan scans keys between lowerKey and upperKey (both inclusive) in ascending
+// order on a background goroutine and sends them to the returned channel which has
+// buf buffer slots. nil argument for lowerKey means no lower bound and nil argument
+// for upperKey means no upper bound. keys and values are owned by the receiver.
+// the scan waits while the channel is full without holding page latches, and stops
+// when done is closed. the KV channel is closed when the scan finishes, and then
+// the error channel receives one result, BLTErrOk unless the scan failed.
+// the scan uses its own tree handle, so the tree can be used while it runs.
+// ATTENTION: like RangeScan, the scan is not atomic with other tree operations
+func (tree *BLTree) ScanChan(lowerKey []byte, upperKey []byte, buf int, done <-chan struct{}) (<-chan KV, <-chan BLTErr) {
+	kvs := make(chan KV, buf)
+	errc := make(chan BLTErr, 1)
+
+	scanTree := NewBLTree(tree.mgr)
+	scanTree.opTimeout = tree.opTimeout
+
+	go func() {
+		defer close(errc)
+		defer close(kvs)
+
+		itr := scanTree.GetRangeItr(lowerKey, upperKey)
+		defer itr.Close()
+		for ok, key, value := itr.Next(); ok; ok, key, value = itr.Next() {
+			// done has priority over the receiver which is ready
+			select {
+			case <-done:
+				errc <- BLTErrOk
+				return
+			default:
+			}
+			select {
+			case kvs <- KV{Key: key, Value: value}:
+			case <-done:
+				errc <- BLTErrOk
+				return
+			}
+		}
+		errc <- itr.Err()
+	}()
+
+	return kvs, errc
+}
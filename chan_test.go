@@ -0,0 +1,63 @@
+package blink_tree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestBLTree_ScanChan(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	bltree := NewBLTree(mgr)
+
+	num := uint64(3000)
+	key := func(i uint64) []byte {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		return bs
+	}
+	for i := uint64(0); i < num; i++ {
+		if err := bltree.InsertKey(key(i), 0, []byte{byte(i), 0, 0, 0, 0, 0}, true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+
+	t.Run("whole range", func(t *testing.T) {
+		_, wantKeys, wantVals := bltree.RangeScan(key(100), key(2000))
+		kvs, errc := bltree.ScanChan(key(100), key(2000), 4, nil)
+		n := 0
+		for kv := range kvs {
+			if n >= len(wantKeys) || !bytes.Equal(kv.Key, wantKeys[n]) || !bytes.Equal(kv.Value, wantVals[n]) {
+				t.Fatalf("ScanChan() [%d] = %v, %v", n, kv.Key, kv.Value)
+			}
+			n++
+		}
+		if err := <-errc; err != BLTErrOk || n != len(wantKeys) {
+			t.Errorf("ScanChan() = %v keys, %v, want %v, %v", n, err, len(wantKeys), BLTErrOk)
+		}
+	})
+
+	t.Run("done", func(t *testing.T) {
+		done := make(chan struct{})
+		kvs, errc := bltree.ScanChan(nil, nil, 0, done)
+		for i := 0; i < 10; i++ {
+			if kv := <-kvs; !bytes.Equal(kv.Key, key(uint64(i))) {
+				t.Fatalf("ScanChan() [%d] = %v, want %v", i, kv.Key, key(uint64(i)))
+			}
+		}
+		close(done)
+		// the producer stops and closes the channel
+		n := 0
+		for range kvs {
+			n++
+		}
+		if err := <-errc; err != BLTErrOk || n > 1 {
+			t.Errorf("ScanChan() after done = %v more keys, %v, want at most 1, %v", n, err, BLTErrOk)
+		}
+
+		// the tree is usable while and after the scan
+		if ret, _, _ := bltree.FindKey(key(5), BtId); ret < 0 {
+			t.Errorf("FindKey() = %v, want found", ret)
+		}
+	})
+}
@@ -0,0 +1,159 @@
+package blink_tree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+)
+
+const (
+	// bitsOffset is offset of Bits in page image
+	bitsOffset = 4 * 4
+	// versionOffset is offset of Version in page image, which follows Right
+	versionOffset = 4*4 + 1 + 1 + 1 + 1 + BtId
+	// keyWidthOffset is offset of KeyWidth in page image, which follows Version and Kind.
+	// ValWidth follows it
+	keyWidthOffset = versionOffset + 1 + 1
+	// encodedOffset is offset of Encoded in page image, which follows KeyWidth, ValWidth and Prefixed
+	encodedOffset = keyWidthOffset + 1 + 1 + 1
+	// keyIdOffset is offset of KeyId in page image, which follows Encoded
+	keyIdOffset = encodedOffset + 4
+	// fenceOffset is offset of LowFence and HighFence in page image, which follow KeyId
+	fenceOffset = keyIdOffset + 4
+	// checksumOffset is offset of Checksum in page image. Checksum is the last field of PageHeader
+	checksumOffset = PageHeaderSize - 4
+	// lsnOffset is offset of LSN in page image, which precedes Checksum
+	lsnOffset = checksumOffset - 8
+)
+
+var checksumTable = crc32.MakeTable(crc32.Castagnoli)
+
+// pageChecksum returns CRC32 of header and data of page image except Checksum field
+func pageChecksum(image []byte) uint32 {
+	crc := crc32.Update(0, checksumTable, image[:checksumOffset])
+	return crc32.Update(crc, checksumTable, image[PageHeaderSize:])
+}
+
+// imageFilter is codec and encryptor applied to data area of image of page pageNo.
+// nil codec and encryptor mean data area is written as is
+type imageFilter struct {
+	codec     PageCodec
+	encryptor PageEncryptor
+	pageNo    Uid
+}
+
+// writePageImage writes header and data of page to image of parent page
+// with checksum of them. LSN of the image is raised to lsn.
+// data is encoded by codec of filter unless encoded data isn't shorter,
+// and then encrypted by encryptor of filter with fence hints, which hold leading bytes of keys
+func writePageImage(image []byte, page *Page, lsn uint64, filter imageFilter) {
+	header := page.PageHeader
+	header.Version = PageFormatVersion
+	header.Encoded = 0
+	header.KeyId = 0
+	if lsn > header.LSN {
+		header.LSN = lsn
+	}
+	data := page.Data
+	if filter.codec != nil {
+		if encoded := filter.codec.Encode(nil, page.Data); len(encoded) < len(page.Data) {
+			data = encoded
+			header.Encoded = uint32(len(encoded))
+		}
+	}
+	if filter.encryptor != nil {
+		header.KeyId = filter.encryptor.KeyId()
+	}
+
+	headerBuf := bytes.NewBuffer(make([]byte, 0, PageHeaderSize))
+	binary.Write(headerBuf, binary.LittleEndian, header)
+	copy(image[:PageHeaderSize], headerBuf.Bytes())
+	copy(image[PageHeaderSize:], data)
+
+	if filter.encryptor != nil {
+		plain := append(append(make([]byte, 0, 2*FenceHintSize+len(data)), header.LowFence[:]...), header.HighFence[:]...)
+		plain = append(plain, data...)
+		encrypted := filter.encryptor.Encrypt(nil, plain, header.KeyId, filter.pageNo)
+		if len(encrypted) != len(plain) || header.KeyId == 0 {
+			panic("PageEncryptor: key id is 0 or length of encrypted data differs")
+		}
+		copy(image[fenceOffset:], encrypted[:2*FenceHintSize])
+		copy(image[PageHeaderSize:], encrypted[2*FenceHintSize:])
+	}
+
+	image = image[:PageHeaderSize+len(data)]
+	binary.LittleEndian.PutUint32(image[checksumOffset:], pageChecksum(image))
+}
+
+// readPageImage reads header and data of page from image of parent page.
+// BLTErrVersion is returned when the image isn't written in PageFormatVersion,
+// BLTErrCorrupt when header and data don't match their checksum,
+// BLTErrDecrypt when encrypted data can't be decrypted by encryptor of filter,
+// and BLTErrCodec when encoded data can't be decoded by codec of filter
+func readPageImage(image []byte, page *Page, pageDataSize uint32, filter imageFilter) BLTErr {
+	if image[versionOffset] != PageFormatVersion {
+		return BLTErrVersion
+	}
+	size := binary.LittleEndian.Uint32(image[encodedOffset:])
+	if size == 0 {
+		size = pageDataSize
+	} else if size >= pageDataSize {
+		return BLTErrCorrupt
+	}
+	image = image[:PageHeaderSize+size]
+	if binary.LittleEndian.Uint32(image[checksumOffset:]) != pageChecksum(image) {
+		return BLTErrCorrupt
+	}
+
+	var header PageHeader
+	binary.Read(bytes.NewReader(image[:PageHeaderSize]), binary.LittleEndian, &header)
+	data := image[PageHeaderSize:]
+	if header.KeyId != 0 {
+		if filter.encryptor == nil {
+			return BLTErrDecrypt
+		}
+		encrypted := append(append([]byte{}, image[fenceOffset:fenceOffset+2*FenceHintSize]...), data...)
+		decrypted, err := filter.encryptor.Decrypt(nil, encrypted, header.KeyId, filter.pageNo)
+		if err != nil || len(decrypted) != len(encrypted) {
+			return BLTErrDecrypt
+		}
+		copy(header.LowFence[:], decrypted)
+		copy(header.HighFence[:], decrypted[FenceHintSize:])
+		data = decrypted[2*FenceHintSize:]
+	}
+	if header.Encoded == 0 {
+		page.Data = make([]byte, pageDataSize)
+		copy(page.Data, data)
+	} else {
+		if filter.codec == nil {
+			return BLTErrCodec
+		}
+		decoded, err := filter.codec.Decode(make([]byte, 0, pageDataSize), data)
+		if err != nil || uint32(len(decoded)) != pageDataSize {
+			return BLTErrCodec
+		}
+		page.Data = decoded
+	}
+	header.Encoded = 0
+	header.KeyId = 0
+	page.PageHeader = header
+	return BLTErrOk
+}
+
+// PageImageSize returns count of leading bytes of page image stored in parent
+// buffer manager which are used by the page. it's shorter than page size when
+// data area of the page is encoded by PageCodec, and parent buffer manager
+// can keep only these bytes
+func PageImageSize(image []byte) int {
+	if size := binary.LittleEndian.Uint32(image[encodedOffset:]); size > 0 {
+		return PageHeaderSize + int(size)
+	}
+	return 1 << image[bitsOffset]
+}
+
+// PageImageLSN returns LSN in header of page image stored in parent buffer manager,
+// which is the largest LSN attached to changes of the page before it was written.
+// embedders can compare it with their WAL to detect stale page images
+func PageImageLSN(image []byte) uint64 {
+	return binary.LittleEndian.Uint64(image[lsnOffset:])
+}
@@ -0,0 +1,154 @@
+package blink_tree
+
+import (
+	"encoding/binary"
+	"sync"
+	"testing"
+)
+
+func TestBufMgr_PageIn_checksum(t *testing.T) {
+	pbmPageMap := &sync.Map{}
+	pbm := NewParentBufMgrDummy(pbmPageMap)
+	mgr := NewBufMgr(12, 48, pbm, nil)
+	bltree := NewBLTree(mgr)
+
+	keyOf := func(i uint64) []byte {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		return bs
+	}
+	num := uint64(1000)
+	for i := uint64(0); i < num; i++ {
+		if err := bltree.InsertKey(keyOf(i), 0, make([]byte, BtId), true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+	var set PageSet
+	if slot := mgr.PageFetch(&set, keyOf(num/2), 0, LockRead, &bltree.reads, &bltree.writes); slot == 0 {
+		t.Fatalf("PageFetch() = %v", slot)
+	}
+	pageNo := set.latch.pageNo
+	mgr.PageUnlock(LockRead, set.latch)
+	mgr.UnpinLatch(set.latch)
+	if err := mgr.Close(); err != BLTErrOk {
+		t.Fatalf("Close() = %v, want %v", err, BLTErrOk)
+	}
+
+	// flip a bit of the leaf page in parent
+	ppageId, _ := mgr.pageIdConvMap.Load(pageNo)
+	ppage := pbm.FetchPPage(ppageId)
+	ppage.DataAsSlice()[PageHeaderSize+100] ^= 1
+	pbm.UnpinPPage(ppageId, true)
+
+	lastPageZeroId := mgr.GetMappedPPageIdOfPageZero()
+	mgr = NewBufMgr(12, 48, NewParentBufMgrDummy(pbmPageMap), &lastPageZeroId)
+	bltree = NewBLTree(mgr)
+	if err := mgr.PageIn(NewPage(mgr.pageDataSize), pageNo); err != BLTErrCorrupt {
+		t.Errorf("PageIn() = %v, want %v", err, BLTErrCorrupt)
+	}
+	if _, _, _, err := bltree.FindKeyErr(keyOf(num/2), BtId); err != BLTErrCorrupt {
+		t.Errorf("FindKeyErr() of corrupted page = %v, want %v", err, BLTErrCorrupt)
+	}
+	// other pages are still read
+	if found, _, _, err := bltree.FindKeyErr(keyOf(0), BtId); !found || err != BLTErrOk {
+		t.Errorf("FindKeyErr() = %v, %v, want %v, %v", found, err, true, BLTErrOk)
+	}
+}
+
+func TestBufMgr_PageIn_corruptPins(t *testing.T) {
+	pbmPageMap := &sync.Map{}
+	pbm := NewParentBufMgrDummy(pbmPageMap)
+	mgr := NewBufMgr(12, 48, pbm, nil)
+	bltree := NewBLTree(mgr)
+
+	keyOf := func(i uint64) []byte {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		return bs
+	}
+	num := uint64(5000)
+	for i := uint64(0); i < num; i++ {
+		if err := bltree.InsertKey(keyOf(i), 0, make([]byte, BtId), true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+	var set PageSet
+	if slot := mgr.PageFetch(&set, keyOf(num/2), 0, LockRead, &bltree.reads, &bltree.writes); slot == 0 {
+		t.Fatalf("PageFetch() = %v", slot)
+	}
+	pageNo := set.latch.pageNo
+	mgr.PageUnlock(LockRead, set.latch)
+	mgr.UnpinLatch(set.latch)
+	if err := mgr.Close(); err != BLTErrOk {
+		t.Fatalf("Close() = %v, want %v", err, BLTErrOk)
+	}
+
+	ppageId, _ := mgr.pageIdConvMap.Load(pageNo)
+	ppage := pbm.FetchPPage(ppageId)
+	ppage.DataAsSlice()[PageHeaderSize+100] ^= 1
+	pbm.UnpinPPage(ppageId, true)
+	pins := ppage.PPinCount()
+
+	lastPageZeroId := mgr.GetMappedPPageIdOfPageZero()
+	mgr = NewBufMgr(12, 48, NewParentBufMgrDummy(pbmPageMap), &lastPageZeroId)
+	bltree = NewBLTree(mgr)
+
+	// failed reads don't leave the parent page pinned
+	for i := 0; i < 5; i++ {
+		if _, _, _, err := bltree.FindKeyErr(keyOf(num/2), BtId); err != BLTErrCorrupt {
+			t.Errorf("FindKeyErr() of corrupted page = %v, want %v", err, BLTErrCorrupt)
+		}
+	}
+	if got := ppage.PPinCount(); got != pins {
+		t.Errorf("PPinCount() after failed reads = %v, want %v", got, pins)
+	}
+
+	// nor does eviction of the entry of the corrupted page
+	for i := uint64(0); i < num; i += 10 {
+		if i/500 != num/2/500 {
+			bltree.FindKeyErr(keyOf(i), BtId)
+		}
+	}
+	if got := ppage.PPinCount(); got != pins {
+		t.Errorf("PPinCount() after eviction = %v, want %v", got, pins)
+	}
+}
+
+func TestOpenBufMgr_version(t *testing.T) {
+	pbmPageMap := &sync.Map{}
+	pbm := NewParentBufMgrDummy(pbmPageMap)
+	mgr := NewBufMgr(12, 48, pbm, nil)
+	bltree := NewBLTree(mgr)
+
+	if err := bltree.InsertKey([]byte{1}, 0, []byte{1}, true); err != BLTErrOk {
+		t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+	}
+	if err := mgr.Close(); err != BLTErrOk {
+		t.Fatalf("Close() = %v, want %v", err, BLTErrOk)
+	}
+	lastPageZeroId := mgr.GetMappedPPageIdOfPageZero()
+	if _, err := OpenBufMgr(12, 48, pbm, lastPageZeroId); err != BLTErrOk {
+		t.Fatalf("OpenBufMgr() = %v, want %v", err, BLTErrOk)
+	}
+
+	// leaf page written in another version isn't read
+	leafId, _ := mgr.pageIdConvMap.Load(RootPage + 1)
+	leaf := pbm.FetchPPage(leafId)
+	leaf.DataAsSlice()[versionOffset] = PageFormatVersion + 1
+	pbm.UnpinPPage(leafId, true)
+	mgr, err := OpenBufMgr(12, 48, pbm, lastPageZeroId)
+	if err != BLTErrOk {
+		t.Fatalf("OpenBufMgr() = %v, want %v", err, BLTErrOk)
+	}
+	if _, _, _, err := NewBLTree(mgr).FindKeyErr([]byte{1}, 1); err != BLTErrVersion {
+		t.Errorf("FindKeyErr() = %v, want %v", err, BLTErrVersion)
+	}
+
+	// tree whose page zero is written in another version isn't opened
+	zero := pbm.FetchPPage(lastPageZeroId)
+	zero.DataAsSlice()[versionOffset] = 0
+	pbm.UnpinPPage(lastPageZeroId, true)
+	if mgr, err := OpenBufMgr(12, 48, pbm, lastPageZeroId); mgr != nil || err != BLTErrVersion {
+		t.Errorf("OpenBufMgr() = %v, %v, want %v, %v", mgr, err, nil, BLTErrVersion)
+	}
+}
@@ -0,0 +1,60 @@
+package blink_tree
+
+// ValueCodec compresses values stored in leaf pages (e.g. snappy or zstd)
+type ValueCodec interface {
+	// Encode appends encoded src to dst and returns it
+	Encode(dst []byte, src []byte) []byte
+	// Decode appends decoded src to dst and returns it
+	Decode(dst []byte, src []byte) ([]byte, error)
+}
+
+// SetValueCodec sets codec which is applied to each value on InsertKey
+// and on reading values. nil means values are stored as is.
+//
+// codec is not persisted, so it must be set before any operation on the tree
+// and the same codec must be set whenever the tree is opened.
+// encoded value must not be longer than MaxValueSize bytes, including
+// expiration time when expiration is enabled. BLTErrOverflow is returned otherwise
+func (mgr *BufMgr) SetValueCodec(codec ValueCodec) {
+	mgr.valueCodec = codec
+}
+
+// encodeValue encodes value to be stored in leaf page.
+// expiration time is left zero when expiration is enabled.
+// BLTErrOverflow is returned when the stored value including expiration time
+// is longer than MaxValueSize bytes
+func (mgr *BufMgr) encodeValue(value []byte) ([]byte, BLTErr) {
+	if mgr.valueCodec != nil {
+		value = mgr.valueCodec.Encode(nil, value)
+	}
+	size := len(value)
+	if mgr.expiration {
+		size += expirySize
+	}
+	if size > mgr.MaxValueSize() {
+		return nil, BLTErrOverflow
+	}
+	if mgr.expiration {
+		value = append(make([]byte, expirySize, expirySize+len(value)), value...)
+	}
+	return value, BLTErrOk
+}
+
+// decodeValue decodes value stored in leaf page
+func (mgr *BufMgr) decodeValue(stored []byte) ([]byte, BLTErr) {
+	if mgr.expiration {
+		if len(stored) < expirySize {
+			return nil, BLTErrCodec
+		}
+		stored = stored[expirySize:]
+	}
+	if mgr.valueCodec == nil {
+		return stored, BLTErrOk
+	}
+
+	decoded, err := mgr.valueCodec.Decode(nil, stored)
+	if err != nil {
+		return nil, BLTErrCodec
+	}
+	return decoded, BLTErrOk
+}
@@ -0,0 +1,107 @@
+package blink_tree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// trimCodec removes trailing zero bytes of BtId bytes value
+type trimCodec struct {
+	failDecode bool
+}
+
+func (c *trimCodec) Encode(dst []byte, src []byte) []byte {
+	return append(dst, bytes.TrimRight(src, "\x00")...)
+}
+
+func (c *trimCodec) Decode(dst []byte, src []byte) ([]byte, error) {
+	if c.failDecode || len(src) > BtId {
+		return nil, errors.New("broken value")
+	}
+	dst = append(dst, src...)
+	return append(dst, make([]byte, BtId-len(src))...), nil
+}
+
+func TestBufMgr_ValueCodec(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	codec := &trimCodec{}
+	mgr.SetValueCodec(codec)
+	bltree := NewBLTree(mgr)
+	keyOf := func(i uint64) []byte {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		return bs
+	}
+
+	num := uint64(1000)
+	for i := uint64(0); i < num; i++ {
+		if err := bltree.InsertKey(keyOf(i), 0, []byte{1, 0, 0, 0, 0, 0}, true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+	// longer encoded value doesn't fit in existing value area
+	for i := uint64(0); i < num; i += 2 {
+		if err := bltree.InsertKey(keyOf(i), 0, []byte{1, 2, 3, 4, 5, 6}, true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+	// shorter encoded value is updated in place
+	for i := uint64(0); i < num; i += 4 {
+		if err := bltree.InsertKey(keyOf(i), 0, []byte{7, 0, 0, 0, 0, 0}, true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+
+	wantOf := func(i uint64) []byte {
+		switch {
+		case i%4 == 0:
+			return []byte{7, 0, 0, 0, 0, 0}
+		case i%2 == 0:
+			return []byte{1, 2, 3, 4, 5, 6}
+		default:
+			return []byte{1, 0, 0, 0, 0, 0}
+		}
+	}
+	for i := uint64(0); i < num; i++ {
+		if valLen, _, val := bltree.FindKey(keyOf(i), BtId); valLen != BtId || !bytes.Equal(val, wantOf(i)) {
+			t.Fatalf("FindKey(%d) = %v, %v, want %v", i, valLen, val, wantOf(i))
+		}
+	}
+	cnt, keys, vals := bltree.RangeScan(nil, nil)
+	if cnt != int(num) {
+		t.Fatalf("RangeScan() = %v entries, want %v", cnt, num)
+	}
+	for i := range keys {
+		if !bytes.Equal(vals[i], wantOf(uint64(i))) {
+			t.Errorf("RangeScan() value of %v = %v, want %v", keys[i], vals[i], wantOf(uint64(i)))
+		}
+	}
+
+	codec.failDecode = true
+	if valLen, _, _ := bltree.FindKey(keyOf(1), BtId); valLen >= 0 || bltree.err != BLTErrCodec {
+		t.Errorf("FindKey() = %v, err %v, want -1, err %v", valLen, bltree.err, BLTErrCodec)
+	}
+}
+
+func TestBufMgr_encodeValue_maxSize(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	mgr.SetExpiration(true)
+	bltree := NewBLTree(mgr)
+
+	// expiration time is counted in the stored value
+	max := mgr.MaxValueSize() - expirySize
+	if err := bltree.InsertKey([]byte{1}, 0, make([]byte, max), true); err != BLTErrOk {
+		t.Errorf("InsertKey() of %v bytes value = %v, want %v", max, err, BLTErrOk)
+	}
+	if err := bltree.InsertKey([]byte{2}, 0, make([]byte, max+1), true); err != BLTErrOverflow {
+		t.Errorf("InsertKey() of %v bytes value = %v, want %v", max+1, err, BLTErrOverflow)
+	}
+
+	// so is the value encoded by codec
+	mgr.SetValueCodec(&trimCodec{})
+	if err := bltree.InsertKey([]byte{3}, 0, append(bytes.Repeat([]byte{1}, max+1), 0), true); err != BLTErrOverflow {
+		t.Errorf("InsertKey() of %v bytes encoded value = %v, want %v", max+1, err, BLTErrOverflow)
+	}
+}
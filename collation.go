@@ -0,0 +1,84 @@
+package blink_tree
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// Collation is a built-in order of keys which can be selected by SetCollation
+type Collation uint8
+
+const (
+	// CollationBinary orders keys by bytes.Compare
+	CollationBinary Collation = iota
+	// CollationASCIICase orders keys by bytes ignoring case of ASCII letters
+	CollationASCIICase
+	// CollationUTF8Fold orders keys by code points after Unicode simple case folding.
+	// bytes which are not valid UTF-8 sort after all code points by their values
+	CollationUTF8Fold
+)
+
+// collationNames are comparator names of collations persisted in page zero
+var collationNames = [...]string{
+	CollationASCIICase: "blink_tree.ascii_case",
+	CollationUTF8Fold:  "blink_tree.utf8_fold",
+}
+
+// SetCollation sets built-in order of keys like SetKeyComparator.
+// keys which differ only in case are the same key, and the key
+// which is inserted first is kept with the value updated by later inserts
+func (mgr *BufMgr) SetCollation(c Collation) BLTErr {
+	switch c {
+	case CollationBinary:
+		return mgr.SetKeyComparator("", nil)
+	case CollationASCIICase:
+		return mgr.SetKeyComparator(collationNames[c], compareASCIICase)
+	case CollationUTF8Fold:
+		return mgr.SetKeyComparator(collationNames[c], compareUTF8Fold)
+	}
+	return BLTErrCompare
+}
+
+func compareASCIICase(a, b []byte) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if ca, cb := lowerASCII(a[i]), lowerASCII(b[i]); ca != cb {
+			return int(ca) - int(cb)
+		}
+	}
+	return len(a) - len(b)
+}
+
+func lowerASCII(c byte) byte {
+	if 'A' <= c && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
+
+func compareUTF8Fold(a, b []byte) int {
+	for len(a) > 0 && len(b) > 0 {
+		ra, na := foldRune(a)
+		rb, nb := foldRune(b)
+		if ra != rb {
+			return int(ra) - int(rb)
+		}
+		a, b = a[na:], b[nb:]
+	}
+	return len(a) - len(b)
+}
+
+// foldRune returns the smallest rune of case folding orbit of the first rune of key
+// and its size. invalid byte is returned as a value beyond unicode.MaxRune
+func foldRune(key []byte) (rune, int) {
+	r, size := utf8.DecodeRune(key)
+	if r == utf8.RuneError && size <= 1 {
+		return unicode.MaxRune + 1 + rune(key[0]), 1
+	}
+	folded := r
+	for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+		if f < folded {
+			folded = f
+		}
+	}
+	return folded, size
+}
@@ -0,0 +1,59 @@
+package blink_tree
+
+import (
+	"testing"
+)
+
+func TestBufMgr_SetCollation(t *testing.T) {
+	tests := []struct {
+		collation Collation
+		a, b      string
+		want      int // sign of comparison
+	}{
+		{CollationASCIICase, "abc", "ABC", 0},
+		{CollationASCIICase, "abc", "ABD", -1},
+		{CollationASCIICase, "ab", "ABC", -1},
+		{CollationASCIICase, "Ä", "ä", -1},
+		{CollationUTF8Fold, "straße", "STRASSE", 1},
+		{CollationUTF8Fold, "Äpfel", "äPFEL", 0},
+		{CollationUTF8Fold, "Σίσυφος", "ΣΊΣΥΦΟΣ", 0},
+		{CollationUTF8Fold, "a", "B", -1},
+		{CollationUTF8Fold, "z\xff", "Z\xfe", 1},
+	}
+	for _, tt := range tests {
+		mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+		if err := mgr.SetCollation(tt.collation); err != BLTErrOk {
+			t.Fatalf("SetCollation() = %v, want %v", err, BLTErrOk)
+		}
+		got := mgr.compareUserKeys([]byte(tt.a), []byte(tt.b))
+		if (got > 0) != (tt.want > 0) || (got < 0) != (tt.want < 0) {
+			t.Errorf("compare(%q, %q) with collation %v = %v, want sign of %v", tt.a, tt.b, tt.collation, got, tt.want)
+		}
+	}
+
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	if err := mgr.SetCollation(CollationUTF8Fold); err != BLTErrOk {
+		t.Fatalf("SetCollation() = %v, want %v", err, BLTErrOk)
+	}
+	bltree := NewBLTree(mgr)
+	for i, key := range []string{"Apple", "banana", "APPLE", "Cherry", "BANANA"} {
+		if err := bltree.InsertKey([]byte(key), 0, []byte{byte(i), 0, 0, 0, 0, 0}, true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+	// keys which differ only in case are the same key
+	if cnt, _ := bltree.Count(); cnt != 3 {
+		t.Errorf("Count() = %v, want %v", cnt, 3)
+	}
+	if ret, key, val := bltree.FindKey([]byte("aPPlE"), BtId); ret < 0 || string(key) != "Apple" || val[0] != 2 {
+		t.Errorf("FindKey() = %v, %s, %v, want %v, %s, %v", ret, key, val, BtId, "Apple", 2)
+	}
+	if _, keys, _ := bltree.RangeScan([]byte("b"), []byte("CHERRY")); len(keys) != 2 || string(keys[0]) != "banana" {
+		t.Errorf("RangeScan() = %q, want %q", keys, []string{"banana", "Cherry"})
+	}
+
+	// the collation can't be changed after keys are inserted
+	if err := mgr.SetCollation(CollationASCIICase); err != BLTErrCompare {
+		t.Errorf("SetCollation() of other collation = %v, want %v", err, BLTErrCompare)
+	}
+}
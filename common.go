@@ -15,13 +15,16 @@ const (
 	BtRO = 0x6f72 // ro
 	BtRW = 0x7772 // rw
 
-	BtMaxBits = 24             // maximum page size in bits
+	BtMaxBits = 22             // maximum page size in bits (see MaxKeyOffset)
 	BtMinBits = 9              // minimum page size in bits
 	BtMinPage = 1 << BtMinBits // minimum page size
 	BtMaxPage = 1 << BtMaxBits // maximum page size
 
 	BtId = 6 // Define the length of the page and key pointers
 
+	MaxPageNo           = Uid(1)<<(8*BtId) - 1 // largest page number which fits in BtId bytes
+	MaxPageIdMappingCnt = 0xffffffff           // max count of page id mapping entries which can be serialized
+
 	ClockBit = uint32(0x8000) // the bit in pool->pin
 
 	AllocPage = 0      // allocation & lock manager hash table
@@ -0,0 +1,55 @@
+package blink_tree
+
+// CompactionFilter is called for each live key of leaf page when the page is
+// physically compacted. when keep is false, the entry is dropped.
+// when newVal is not nil, value of the entry is rewritten with it.
+// newVal must not be longer than val (after encoding by ValueCodec).
+// longer newVal is ignored.
+type CompactionFilter func(key []byte, val []byte) (keep bool, newVal []byte)
+
+// SetCompactionFilter sets filter which is applied on compaction of leaf pages.
+// nil removes the filter.
+//
+// filter is called while the leaf page is write latched, so it must not call
+// methods of BLTree. dropped or rewritten entries are not reported to ChangeHook
+func (mgr *BufMgr) SetCompactionFilter(filter CompactionFilter) {
+	if filter == nil {
+		mgr.compactFilter.Store(nil)
+		return
+	}
+	mgr.compactFilter.Store(&filter)
+}
+
+// applyCompactionFilter applies compaction filter to an entry of leaf page
+// returns false when the entry should be dropped. expired entries are dropped
+// without calling the filter
+func (mgr *BufMgr) applyCompactionFilter(key []byte, val []byte) (bool, []byte) {
+	if mgr.expiration && mgr.expiredValue(val) {
+		return false, nil
+	}
+	filter := mgr.compactFilter.Load()
+	if filter == nil {
+		return true, val
+	}
+
+	decoded, err := mgr.decodeValue(val)
+	if err != BLTErrOk {
+		// entry which can't be decoded is kept as is
+		return true, val
+	}
+
+	keep, newVal := (*filter)(decodeKey(key), decoded)
+	if !keep {
+		return false, nil
+	}
+	if newVal != nil {
+		if encoded, err := mgr.encodeValue(newVal); err == BLTErrOk && len(encoded) <= len(val) {
+			if mgr.expiration {
+				// rewritten value keeps expiration time
+				copy(encoded, val[:expirySize])
+			}
+			return true, encoded
+		}
+	}
+	return true, val
+}
@@ -0,0 +1,65 @@
+package blink_tree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestBufMgr_CompactionFilter(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	bltree := NewBLTree(mgr)
+	keyOf := func(i uint64) []byte {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		return bs
+	}
+
+	// make garbage on the leaf page
+	for i := uint64(0); i < 100; i++ {
+		bltree.InsertKey(keyOf(i*10), 0, []byte{1, 0, 0, 0, 0, 0}, true)
+	}
+	for i := uint64(0); i < 80; i++ {
+		bltree.DeleteKey(keyOf(i*10), 0)
+	}
+
+	// drop odd keys and rewrite value of even keys
+	filtered := 0
+	mgr.SetCompactionFilter(func(key []byte, val []byte) (bool, []byte) {
+		i := binary.BigEndian.Uint64(key)
+		if i%10 != 0 {
+			return true, nil
+		}
+		i /= 10
+		filtered++
+		if i%2 == 1 {
+			return false, nil
+		}
+		return true, []byte{9, 0, 0, 0, 0, 0}
+	})
+
+	// inserting keys makes the page compacted
+	for i := uint64(0); i < 100; i++ {
+		bltree.InsertKey(keyOf(i*10+5), 0, []byte{1, 0, 0, 0, 0, 0}, true)
+	}
+	if filtered == 0 {
+		t.Fatalf("compaction filter is not called")
+	}
+	mgr.SetCompactionFilter(nil)
+
+	for i := uint64(80); i < 100; i++ {
+		valLen, _, val := bltree.FindKey(keyOf(i*10), BtId)
+		if i%2 == 1 {
+			if valLen >= 0 {
+				t.Errorf("FindKey(%d) found dropped key", i)
+			}
+		} else if !bytes.Equal(val, []byte{9, 0, 0, 0, 0, 0}) {
+			t.Errorf("FindKey(%d) = %v, want %v", i, val, []byte{9, 0, 0, 0, 0, 0})
+		}
+	}
+	for i := uint64(0); i < 100; i++ {
+		if _, foundKey, _ := bltree.FindKey(keyOf(i*10+5), BtId); !bytes.Equal(foundKey, keyOf(i*10+5)) {
+			t.Errorf("FindKey() = %v, want %v", foundKey, keyOf(i*10+5))
+		}
+	}
+}
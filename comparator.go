@@ -0,0 +1,122 @@
+package blink_tree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/fnv"
+	"time"
+)
+
+// KeyCompare returns a negative number when key a is less than key b,
+// 0 when they are equal and a positive number when a is greater than b
+type KeyCompare func(a, b []byte) int
+
+// SetKeyComparator sets order of user keys instead of bytes.Compare.
+// name identifies the order and hash of name is persisted in page zero
+// (in Cnt of its header, because its data area holds page id mapping info),
+// so a tree must be opened with the comparator of the name which it was created with.
+// the comparator can be set only while the tree has no key unless the tree
+// was created with it. empty key is less than any other key regardless of cmp.
+// duplicate keys (InsertKey with uniq false) are rejected while cmp is set,
+// because cmp can't compare their sequence suffixes.
+// nil cmp restores bytes order. BLTErrCompare is returned when the comparator
+// doesn't match the tree. it must be set before any operation on the tree
+func (mgr *BufMgr) SetKeyComparator(name string, cmp KeyCompare) BLTErr {
+	hash := uint32(0)
+	if cmp != nil {
+		hash = comparatorHash(name)
+	}
+
+	if stored := mgr.pageZero.comparatorHash(); stored != hash {
+		if stored != 0 || !mgr.isEmpty() {
+			return BLTErrCompare
+		}
+	}
+	mgr.pageZero.setComparatorHash(hash)
+
+	if cmp == nil {
+		mgr.keyCompare = nil
+		return BLTErrOk
+	}
+	mgr.keyCompare = func(a, b []byte) int {
+		// infinite stopper key and empty key are out of the order of cmp
+		aStopper, bStopper := isStopperKey(a), isStopperKey(b)
+		switch {
+		case aStopper || bStopper:
+			return boolCmp(aStopper, bStopper)
+		case len(a) == 0 || len(b) == 0:
+			return len(a) - len(b)
+		}
+		return cmp(decodeKey(a), decodeKey(b))
+	}
+	return BLTErrOk
+}
+
+// compareKeys compares keys stored in pages in order of the tree
+func (mgr *BufMgr) compareKeys(a, b []byte) int {
+	if mgr.keyCompare == nil {
+		return bytes.Compare(a, b)
+	}
+	return mgr.keyCompare(a, b)
+}
+
+// compareUserKeys compares user keys in order of the tree
+func (mgr *BufMgr) compareUserKeys(a, b []byte) int {
+	if mgr.keyCompare == nil {
+		return bytes.Compare(a, b)
+	}
+	a, _ = encodeKey(a)
+	b, _ = encodeKey(b)
+	return mgr.keyCompare(a, b)
+}
+
+// isEmpty reports whether the tree has no live key
+func (mgr *BufMgr) isEmpty() bool {
+	var reads, writes uint
+	var set PageSet
+
+	slot, _ := mgr.pageFetch(&set, []byte{}, 0, LockRead, &reads, &writes, time.Time{})
+	if slot == 0 {
+		return false
+	}
+	// only the infinite stopper key is left
+	empty := set.page.Act == 1 && GetID(&set.page.Right) == 0
+	mgr.PageUnlock(LockRead, set.latch)
+	mgr.UnpinLatch(set.latch)
+	return empty
+}
+
+// comparatorHash returns hash of comparator name persisted in page zero.
+// 0 means bytes order
+func (z *PageZero) comparatorHash() uint32 {
+	return binary.LittleEndian.Uint32(z.alloc[:4])
+}
+
+func (z *PageZero) setComparatorHash(hash uint32) {
+	binary.LittleEndian.PutUint32(z.alloc[:4], hash)
+}
+
+// comparatorHash returns hash of comparator name which isn't 0
+func comparatorHash(name string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	if sum := h.Sum32(); sum != 0 {
+		return sum
+	}
+	return 1
+}
+
+// isStopperKey reports whether key is the infinite stopper key
+func isStopperKey(key []byte) bool {
+	return len(key) == 2 && key[0] == 0xff && key[1] == 0xff
+}
+
+func boolCmp(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case a:
+		return 1
+	}
+	return -1
+}
@@ -0,0 +1,86 @@
+package blink_tree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sync"
+	"testing"
+)
+
+// reverseCompare orders keys in descending bytes order
+func reverseCompare(a, b []byte) int {
+	return bytes.Compare(b, a)
+}
+
+func TestBufMgr_SetKeyComparator(t *testing.T) {
+	pbmPageMap := &sync.Map{}
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(pbmPageMap), nil)
+	if err := mgr.SetKeyComparator("reverse", reverseCompare); err != BLTErrOk {
+		t.Fatalf("SetKeyComparator() = %v, want %v", err, BLTErrOk)
+	}
+	bltree := NewBLTree(mgr)
+
+	num := uint64(3000)
+	key := func(i uint64) []byte {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		return bs
+	}
+	for i := uint64(0); i < num; i++ {
+		if err := bltree.InsertKey(key(i), 0, []byte{byte(i), 0, 0, 0, 0, 0}, true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+	if err := bltree.InsertKey(key(0), 0, make([]byte, BtId), false); err != BLTErrCompare {
+		t.Errorf("InsertKey() of duplicate key = %v, want %v", err, BLTErrCompare)
+	}
+
+	// keys are scanned in order of the comparator
+	n, keys, _ := bltree.RangeScan(key(2000), key(1000))
+	if n != 1001 || !bytes.Equal(keys[0], key(2000)) || !bytes.Equal(keys[n-1], key(1000)) {
+		t.Errorf("RangeScan() = %v keys from %v to %v, want %v keys from %v to %v", n, keys[0], keys[n-1], 1001, key(2000), key(1000))
+	}
+	for i := uint64(0); i < num; i += 7 {
+		if ret, _, val := bltree.FindKey(key(i), BtId); ret < 0 || val[0] != byte(i) {
+			t.Fatalf("FindKey(%v) = %v, %v, want %v", i, ret, val, byte(i))
+		}
+	}
+	if deleted, err := bltree.DeleteRange(key(1999), key(1000)); deleted != 1000 || err != BLTErrOk {
+		t.Errorf("DeleteRange() = %v, %v, want %v, %v", deleted, err, 1000, BLTErrOk)
+	}
+	if cnt, _ := bltree.Count(); cnt != int(num-1000) {
+		t.Errorf("Count() = %v, want %v", cnt, num-1000)
+	}
+
+	// the comparator can't be changed while the tree has keys
+	if err := mgr.SetKeyComparator("other", bytes.Compare); err != BLTErrCompare {
+		t.Errorf("SetKeyComparator() of other name = %v, want %v", err, BLTErrCompare)
+	}
+	if err := mgr.SetKeyComparator("", nil); err != BLTErrCompare {
+		t.Errorf("SetKeyComparator() of bytes order = %v, want %v", err, BLTErrCompare)
+	}
+
+	// name of the comparator is persisted
+	mgr.Close()
+	lastPageZeroId := mgr.GetMappedPPageIdOfPageZero()
+	mgr = NewBufMgr(12, 48, NewParentBufMgrDummy(pbmPageMap), &lastPageZeroId)
+	if err := mgr.SetKeyComparator("other", bytes.Compare); err != BLTErrCompare {
+		t.Errorf("SetKeyComparator() of other name after restart = %v, want %v", err, BLTErrCompare)
+	}
+	if err := mgr.SetKeyComparator("reverse", reverseCompare); err != BLTErrOk {
+		t.Fatalf("SetKeyComparator() after restart = %v, want %v", err, BLTErrOk)
+	}
+	bltree = NewBLTree(mgr)
+	if k, _, err := bltree.FindGE(key(1500)); err != BLTErrOk || !bytes.Equal(k, key(999)) {
+		t.Errorf("FindGE() after restart = %v, %v, want %v", k, err, key(999))
+	}
+
+	// a tree without comparator can't be opened with one
+	mgr = NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	if err := NewBLTree(mgr).InsertKey(key(1), 0, make([]byte, BtId), true); err != BLTErrOk {
+		t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+	}
+	if err := mgr.SetKeyComparator("reverse", reverseCompare); err != BLTErrCompare {
+		t.Errorf("SetKeyComparator() of non empty tree = %v, want %v", err, BLTErrCompare)
+	}
+}
@@ -0,0 +1,422 @@
+package blink_tree
+
+import "bytes"
+
+// Cursor is a position on leaf keys of BLTree which can be moved in both directions.
+// the cursor keeps a copy of the leaf page of the current key and holds no page latch
+// between method calls, so other goroutines can modify the tree meanwhile.
+// when the cursor leaves the copied page, the next position is searched again
+// from the root page with the current key, so keys inserted or deleted
+// by others after the copy are reflected from that point.
+// a Cursor must not be used by multiple goroutines at the same time
+type Cursor struct {
+	tree   *BLTree
+	page   *Page  // copy of leaf page of the current key
+	pageNo Uid    // page number of the copied page
+	slot   uint32 // slot of the current key in page
+	key    []byte // current key (encoded)
+	value  []byte // current value
+	valid  bool   // cursor is positioned on a key
+	closed bool
+	err    BLTErr // error which invalidated the cursor
+}
+
+// NewCursor returns a cursor which is not positioned. call Seek to position it
+func (tree *BLTree) NewCursor() *Cursor {
+	return &Cursor{tree: tree, page: NewPage(tree.mgr.pageDataSize)}
+}
+
+// Seek positions the cursor on the first key which is not less than key.
+// returns false when there is no such key
+func (c *Cursor) Seek(key []byte) bool {
+	if c.closed {
+		return false
+	}
+	c.tree.startOp()
+	c.err = BLTErrOk
+	c.valid = false
+
+	encoded, err := encodeKey(key)
+	if err != BLTErrOk {
+		c.fail(err)
+		return false
+	}
+	return c.seekAfter(encoded, true)
+}
+
+// FindGE returns the smallest key which is not less than key and its value.
+// foundKey is nil when there is no such key
+func (tree *BLTree) FindGE(key []byte) (foundKey []byte, value []byte, err BLTErr) {
+	defer tree.mgr.recordLatency(LatencyFind, tree.mgr.latencyStart())
+
+	c := tree.NewCursor()
+	defer c.Close()
+	if !c.Seek(key) {
+		return nil, nil, c.Err()
+	}
+	return c.Key(), c.Value(), BLTErrOk
+}
+
+// FindLE returns the largest key which is not greater than key and its value.
+// foundKey is nil when there is no such key
+func (tree *BLTree) FindLE(key []byte) (foundKey []byte, value []byte, err BLTErr) {
+	defer tree.mgr.recordLatency(LatencyFind, tree.mgr.latencyStart())
+
+	c := tree.NewCursor()
+	defer c.Close()
+	if c.Seek(key) {
+		if tree.mgr.compareUserKeys(c.Key(), key) != 0 && !c.Prev() {
+			return nil, nil, c.Err()
+		}
+		return c.Key(), c.Value(), BLTErrOk
+	}
+	if c.Err() != BLTErrOk {
+		return nil, nil, c.Err()
+	}
+
+	// all keys are less than key
+	encoded, _ := encodeKey(key)
+	if !c.seekBefore(encoded) {
+		return nil, nil, c.Err()
+	}
+	return c.Key(), c.Value(), BLTErrOk
+}
+
+// FindLongestPrefix returns the longest stored key which is a prefix of key
+// (key itself included) and its value. foundKey is nil when there is no such key.
+// each lookup with FindLE either finds the answer or shortens the key searched next
+// to the common prefix with the key found, because stored prefixes of key
+// can't be longer than the common prefix. prefixes are compared as bytes, so
+// the longest one may be missed when the tree has key comparator
+func (tree *BLTree) FindLongestPrefix(key []byte) (foundKey []byte, value []byte, err BLTErr) {
+	for search := key; ; {
+		foundKey, value, err = tree.FindLE(search)
+		if foundKey == nil || err != BLTErrOk {
+			return nil, nil, err
+		}
+		if bytes.HasPrefix(key, foundKey) {
+			return foundKey, value, BLTErrOk
+		}
+
+		common := 0
+		for common < len(search) && common < len(foundKey) && search[common] == foundKey[common] {
+			common++
+		}
+		// search can be a prefix of the key found only in order of key comparator
+		if common == len(search) {
+			common--
+		}
+		search = search[:common]
+	}
+}
+
+// Next moves the cursor to the next key. returns false when the cursor
+// reached the end or it's not positioned
+func (c *Cursor) Next() bool {
+	if !c.valid {
+		return false
+	}
+	c.tree.startOp()
+
+	for c.slot < c.page.Cnt {
+		c.slot++
+		if c.isStopper(c.slot) {
+			c.valid = false
+			return false
+		}
+		if c.live(c.slot) {
+			return c.setCurrent()
+		}
+	}
+	return c.seekAfter(c.key, false)
+}
+
+// Prev moves the cursor to the previous key. returns false when the cursor
+// reached the beginning or it's not positioned
+func (c *Cursor) Prev() bool {
+	if !c.valid {
+		return false
+	}
+	c.tree.startOp()
+
+	for c.slot > 1 {
+		c.slot--
+		if c.live(c.slot) {
+			return c.setCurrent()
+		}
+	}
+	return c.seekBefore(c.key)
+}
+
+// Key returns the current key. the returned slice is owned by the caller.
+// nil is returned when the cursor is not positioned
+func (c *Cursor) Key() []byte {
+	if !c.valid {
+		return nil
+	}
+	return decodeKey(c.key)
+}
+
+// Value returns value of the current key.
+// nil is returned when the cursor is not positioned
+func (c *Cursor) Value() []byte {
+	if !c.valid {
+		return nil
+	}
+	return c.value
+}
+
+// Valid reports whether the cursor is positioned on a key
+func (c *Cursor) Valid() bool {
+	return c.valid
+}
+
+// Err returns error which invalidated the cursor
+func (c *Cursor) Err() BLTErr {
+	return c.err
+}
+
+// Close releases the copied page. the cursor can't be used after Close
+func (c *Cursor) Close() {
+	c.closed = true
+	c.valid = false
+	c.page = nil
+	c.key = nil
+	c.value = nil
+}
+
+func (c *Cursor) fail(err BLTErr) bool {
+	c.tree.err = err
+	c.err = err
+	c.valid = false
+	return false
+}
+
+// live reports whether slot of the copied page holds a key
+func (c *Cursor) live(slot uint32) bool {
+	return !c.page.Dead(slot) && c.page.Typ(slot) != Librarian && !c.isStopper(slot) && !c.tree.mgr.expired(c.page, slot)
+}
+
+// isStopper reports whether slot is the infinite stopper of the rightmost page
+func (c *Cursor) isStopper(slot uint32) bool {
+	return slot == c.page.Cnt && GetID(&c.page.Right) == 0
+}
+
+// setCurrent reads key and value of the current slot
+func (c *Cursor) setCurrent() bool {
+	val, err := c.tree.mgr.decodeValue(*c.page.Value(c.slot))
+	if err != BLTErrOk {
+		return c.fail(err)
+	}
+	c.key = c.page.Key(c.slot)
+	c.value = val
+	c.valid = true
+	return true
+}
+
+// copyPage copies page of set to the cursor
+func (c *Cursor) copyPage(set *PageSet) {
+	MemCpyPage(c.page, set.page)
+	c.pageNo = set.latch.pageNo
+}
+
+// seekAfter positions the cursor on the first key greater than key
+// (or equal to key when inclusive) searching from the root page
+func (c *Cursor) seekAfter(key []byte, inclusive bool) bool {
+	tree := c.tree
+	var set PageSet
+
+	slot, err := tree.mgr.pageFetch(&set, key, 0, LockRead, &tree.reads, &tree.writes, tree.deadline)
+	if slot == 0 {
+		if err == BLTErrOk {
+			err = BLTErrStruct
+		}
+		return c.fail(err)
+	}
+
+	c.valid = false
+	for ; slot > 0; slot = tree.findNext(&set, slot) {
+		if set.page.Typ(slot) == Librarian || set.page.Dead(slot) || tree.mgr.expired(set.page, slot) {
+			continue
+		}
+		// infinite stopper of the rightmost page
+		if slot == set.page.Cnt && GetID(&set.page.Right) == 0 {
+			break
+		}
+		cmp := tree.mgr.compareKeys(set.page.Key(slot), key)
+		if cmp < 0 || (cmp == 0 && !inclusive) {
+			continue
+		}
+
+		c.copyPage(&set)
+		c.slot = slot
+		c.setCurrent()
+		break
+	}
+	tree.mgr.PageUnlock(LockRead, set.latch)
+	tree.mgr.UnpinLatch(set.latch)
+	if slot == 0 {
+		return c.fail(tree.err)
+	}
+	return c.valid
+}
+
+// seekBefore positions the cursor on the last key less than key
+// searching from the root page. pages are linked only to the right,
+// so left pages are found through their parent pages
+func (c *Cursor) seekBefore(key []byte) bool {
+	tree := c.tree
+	target := key
+
+	for retry := 0; ; retry++ {
+		var set PageSet
+		slot, err := tree.mgr.pageFetch(&set, key, 0, LockRead, &tree.reads, &tree.writes, tree.deadline)
+		if slot == 0 {
+			if err == BLTErrOk {
+				err = BLTErrStruct
+			}
+			return c.fail(err)
+		}
+		c.copyPage(&set)
+		tree.mgr.PageUnlock(LockRead, set.latch)
+		tree.mgr.UnpinLatch(set.latch)
+
+		// key in the page which is searched for its left page
+		bound := key
+		for err == BLTErrOk {
+			for slot := c.page.Cnt; slot > 0; slot-- {
+				if c.live(slot) && tree.mgr.compareKeys(c.page.Key(slot), target) < 0 {
+					c.slot = slot
+					return c.setCurrent()
+				}
+			}
+
+			var left Uid
+			if left, err = tree.leftPage(bound, 0, c.pageNo); err == BLTErrOk {
+				if left == 0 {
+					c.valid = false
+					return false
+				}
+				err = c.readPage(left)
+				bound = c.page.Key(c.page.Cnt)
+			}
+		}
+
+		// pages were split or deleted while they are read
+		if err != BLTErrStruct || retry >= maxPostRetries {
+			return c.fail(err)
+		}
+		tree.startOp()
+	}
+}
+
+// readPage copies page pageNo to the cursor
+func (c *Cursor) readPage(pageNo Uid) BLTErr {
+	tree := c.tree
+	latch, err := tree.mgr.pinLatch(pageNo, true, &tree.reads, &tree.writes, tree.deadline)
+	if latch == nil {
+		return err
+	}
+	if !tree.mgr.pageLockDeadline(LockRead, latch, tree.deadline) {
+		tree.mgr.UnpinLatch(latch)
+		return BLTErrTimeout
+	}
+	page := tree.mgr.GetRefOfPageAtPool(latch)
+	free := page.Free
+	MemCpyPage(c.page, page)
+	tree.mgr.PageUnlock(LockRead, latch)
+	tree.mgr.UnpinLatch(latch)
+	if free {
+		return BLTErrStruct
+	}
+	c.pageNo = pageNo
+	return BLTErrOk
+}
+
+// leftPage returns the left sibling of page pageNo of level lvl which contains key.
+// 0 is returned when pageNo is the leftmost page. BLTErrStruct is returned
+// when pageNo is not found on the right chain because the tree was modified
+func (tree *BLTree) leftPage(key []byte, lvl uint8, pageNo Uid) (Uid, BLTErr) {
+	rootLatch, err := tree.mgr.pinLatch(RootPage, true, &tree.reads, &tree.writes, tree.deadline)
+	if rootLatch == nil {
+		return 0, err
+	}
+	tree.mgr.PageLock(LockRead, rootLatch)
+	rootLvl := tree.mgr.GetRefOfPageAtPool(rootLatch).Lvl
+	tree.mgr.PageUnlock(LockRead, rootLatch)
+	tree.mgr.UnpinLatch(rootLatch)
+	if lvl >= rootLvl {
+		return 0, BLTErrOk
+	}
+
+	var set PageSet
+	slot, err := tree.mgr.pageFetch(&set, key, lvl+1, LockRead, &tree.reads, &tree.writes, tree.deadline)
+	if slot == 0 {
+		if err == BLTErrOk {
+			err = BLTErrStruct
+		}
+		return 0, err
+	}
+	parentNo := set.latch.pageNo
+	parentKey := set.page.Key(set.page.Cnt)
+
+	// the child which covers key is pageNo or its left page whose split is not posted yet
+	start := Uid(0)
+	for ; slot > 0; slot-- {
+		if set.page.Dead(slot) || set.page.Typ(slot) == Librarian {
+			continue
+		}
+		if child := GetIDFromValue(set.page.Value(slot)); child != pageNo {
+			start = child
+			break
+		}
+	}
+	tree.mgr.PageUnlock(LockRead, set.latch)
+	tree.mgr.UnpinLatch(set.latch)
+
+	// the left page is the rightmost child of left page of the parent
+	if start == 0 {
+		leftParent, err := tree.leftPage(parentKey, lvl+1, parentNo)
+		if leftParent == 0 {
+			return 0, err
+		}
+		latch, err := tree.mgr.pinLatch(leftParent, true, &tree.reads, &tree.writes, tree.deadline)
+		if latch == nil {
+			return 0, err
+		}
+		tree.mgr.PageLock(LockRead, latch)
+		page := tree.mgr.GetRefOfPageAtPool(latch)
+		for slot = page.Cnt; slot > 0 && start == 0; slot-- {
+			if !page.Dead(slot) && page.Typ(slot) != Librarian {
+				start = GetIDFromValue(page.Value(slot))
+			}
+		}
+		tree.mgr.PageUnlock(LockRead, latch)
+		tree.mgr.UnpinLatch(latch)
+		if start == 0 {
+			return 0, BLTErrStruct
+		}
+	}
+
+	// slide right until the page on the left of pageNo
+	for start > 0 {
+		latch, err := tree.mgr.pinLatch(start, true, &tree.reads, &tree.writes, tree.deadline)
+		if latch == nil {
+			return 0, err
+		}
+		tree.mgr.PageLock(LockRead, latch)
+		page := tree.mgr.GetRefOfPageAtPool(latch)
+		right := GetID(&page.Right)
+		free := page.Free
+		tree.mgr.PageUnlock(LockRead, latch)
+		tree.mgr.UnpinLatch(latch)
+		if free {
+			break
+		}
+		if right == pageNo {
+			return start, BLTErrOk
+		}
+		start = right
+	}
+	return 0, BLTErrStruct
+}
@@ -0,0 +1,350 @@
+package blink_tree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestCursor_SeekNextPrev(t *testing.T) {
+	mgr := NewBufMgr(12, 64, NewParentBufMgrDummy(nil), nil)
+	bltree := NewBLTree(mgr)
+
+	// enough keys to have two upper levels
+	num := uint64(60000)
+	key := func(i uint64) []byte {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		return bs
+	}
+	for i := uint64(0); i < num; i++ {
+		if err := bltree.InsertKey(key(i), 0, []byte{byte(i), 0, 0, 0, 0, 0}, true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+	for i := uint64(0); i < num; i += 3 {
+		if err := bltree.DeleteKey(key(i), 0); err != BLTErrOk {
+			t.Fatalf("DeleteKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+	wantNum, wantKeys, wantVals := bltree.RangeScan(nil, nil)
+
+	c := bltree.NewCursor()
+	defer c.Close()
+	if c.Valid() || c.Next() || c.Prev() {
+		t.Errorf("cursor is positioned before Seek()")
+	}
+
+	n := 0
+	for ok := c.Seek(nil); ok; ok = c.Next() {
+		if n < wantNum && (!bytes.Equal(c.Key(), wantKeys[n]) || !bytes.Equal(c.Value(), wantVals[n])) {
+			t.Fatalf("Next() [%d] = %v, %v, want %v, %v", n, c.Key(), c.Value(), wantKeys[n], wantVals[n])
+		}
+		n++
+	}
+	if n != wantNum || c.Err() != BLTErrOk {
+		t.Errorf("Next() moved %v times with %v, want %v times", n, c.Err(), wantNum)
+	}
+
+	if !c.Seek(wantKeys[wantNum-1]) {
+		t.Fatalf("Seek(last key) = false, want true")
+	}
+	n = wantNum - 1
+	for ok := true; ok; ok = c.Prev() {
+		if n < 0 || !bytes.Equal(c.Key(), wantKeys[n]) {
+			t.Fatalf("Prev() [%d] = %v, want %v", n, c.Key(), wantKeys[n])
+		}
+		n--
+	}
+	if n != -1 || c.Err() != BLTErrOk {
+		t.Errorf("Prev() stopped at %v with %v, want %v", n, c.Err(), -1)
+	}
+
+	// seek to deleted key and turn around
+	if !c.Seek(key(3000)) || !bytes.Equal(c.Key(), key(3001)) {
+		t.Fatalf("Seek(deleted key) = %v, want %v", c.Key(), key(3001))
+	}
+	if !c.Prev() || !bytes.Equal(c.Key(), key(2999)) {
+		t.Errorf("Prev() = %v, want %v", c.Key(), key(2999))
+	}
+	if !c.Next() || !bytes.Equal(c.Key(), key(3001)) {
+		t.Errorf("Next() = %v, want %v", c.Key(), key(3001))
+	}
+
+	if c.Seek(key(num)) {
+		t.Errorf("Seek() beyond the last key = true, want false")
+	}
+
+	c.Close()
+	if c.Seek(nil) {
+		t.Errorf("Seek() after Close() = true, want false")
+	}
+}
+
+func TestCursor_concurrentModification(t *testing.T) {
+	mgr := NewBufMgr(12, 64, NewParentBufMgrDummy(nil), nil)
+	bltree := NewBLTree(mgr)
+
+	num := uint64(20000)
+	key := func(i uint64) []byte {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		return bs
+	}
+	// even keys exist all the time. odd keys are inserted and deleted concurrently
+	for i := uint64(0); i < num; i += 2 {
+		if err := bltree.InsertKey(key(i), 0, make([]byte, BtId), true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		writer := NewBLTree(mgr)
+		for i := uint64(1); i < num; i += 2 {
+			writer.InsertKey(key(i), 0, make([]byte, BtId), true)
+		}
+		for i := uint64(1); i < num; i += 4 {
+			writer.DeleteKey(key(i), 0)
+		}
+	}()
+
+	c := NewBLTree(mgr).NewCursor()
+	var prev []byte
+	evens := uint64(0)
+	for ok := c.Seek(nil); ok; ok = c.Next() {
+		k := c.Key()
+		if prev != nil && bytes.Compare(prev, k) >= 0 {
+			t.Fatalf("Next() = %v after %v, want ascending keys", k, prev)
+		}
+		if binary.BigEndian.Uint64(k)%2 == 0 {
+			evens++
+		}
+		prev = k
+	}
+	if c.Err() != BLTErrOk || evens != num/2 {
+		t.Errorf("Next() visited %v stable keys with %v, want %v", evens, c.Err(), num/2)
+	}
+
+	evens = 0
+	prev = nil
+	for ok := c.Seek(key(num - 2)); ok; ok = c.Prev() {
+		k := c.Key()
+		if prev != nil && bytes.Compare(prev, k) <= 0 {
+			t.Fatalf("Prev() = %v after %v, want descending keys", k, prev)
+		}
+		if binary.BigEndian.Uint64(k)%2 == 0 {
+			evens++
+		}
+		prev = k
+	}
+	if c.Err() != BLTErrOk || evens != num/2 {
+		t.Errorf("Prev() visited %v stable keys with %v, want %v", evens, c.Err(), num/2)
+	}
+	wg.Wait()
+}
+
+func TestBLTree_FindGE_FindLE(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	bltree := NewBLTree(mgr)
+
+	key := func(i uint64) []byte {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		return bs
+	}
+
+	if k, _, err := bltree.FindGE(key(0)); k != nil || err != BLTErrOk {
+		t.Errorf("FindGE() of empty tree = %v, %v, want %v, %v", k, err, nil, BLTErrOk)
+	}
+	if k, _, err := bltree.FindLE(key(0)); k != nil || err != BLTErrOk {
+		t.Errorf("FindLE() of empty tree = %v, %v, want %v, %v", k, err, nil, BLTErrOk)
+	}
+
+	// even keys from 10 to 20008 over several leaf pages
+	for i := uint64(10); i < 20010; i += 2 {
+		if err := bltree.InsertKey(key(i), 0, []byte{byte(i), 0, 0, 0, 0, 0}, true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+
+	tests := []struct {
+		key    uint64
+		ge, le int64 // -1 means no key
+	}{
+		{0, 10, -1},
+		{10, 10, 10},
+		{11, 12, 10},
+		{9999, 10000, 9998},
+		{10000, 10000, 10000},
+		{20008, 20008, 20008},
+		{20009, -1, 20008},
+		{30000, -1, 20008},
+	}
+	for _, tt := range tests {
+		k, v, err := bltree.FindGE(key(tt.key))
+		if tt.ge < 0 {
+			if k != nil || err != BLTErrOk {
+				t.Errorf("FindGE(%v) = %v, %v, want %v, %v", tt.key, k, err, nil, BLTErrOk)
+			}
+		} else if !bytes.Equal(k, key(uint64(tt.ge))) || v[0] != byte(tt.ge) || err != BLTErrOk {
+			t.Errorf("FindGE(%v) = %v, %v, %v, want %v", tt.key, k, v, err, tt.ge)
+		}
+
+		k, v, err = bltree.FindLE(key(tt.key))
+		if tt.le < 0 {
+			if k != nil || err != BLTErrOk {
+				t.Errorf("FindLE(%v) = %v, %v, want %v, %v", tt.key, k, err, nil, BLTErrOk)
+			}
+		} else if !bytes.Equal(k, key(uint64(tt.le))) || v[0] != byte(tt.le) || err != BLTErrOk {
+			t.Errorf("FindLE(%v) = %v, %v, %v, want %v", tt.key, k, v, err, tt.le)
+		}
+	}
+}
+
+func TestBLTree_FindLongestPrefix(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	bltree := NewBLTree(mgr)
+
+	for i, key := range []string{"a", "ab", "abcd", "abd", "b", "bcd"} {
+		if err := bltree.InsertKey([]byte(key), 0, []byte{byte(i), 0, 0, 0, 0, 0}, true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+	// keys between the prefixes make FindLE land on keys which are not prefixes
+	for i := 0; i < 3000; i++ {
+		key := []byte(fmt.Sprintf("abc%05d", i))
+		if err := bltree.InsertKey(key, 0, []byte{0xff, 0, 0, 0, 0, 0}, true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+
+	tests := []struct {
+		key  string
+		want string // empty means no prefix is stored
+	}{
+		{"a", "a"},
+		{"abc", "ab"},
+		{"abcd", "abcd"},
+		{"abcde", "abcd"},
+		{"abcz", "ab"},
+		{"abdz", "abd"},
+		{"az", "a"},
+		{"bc", "b"},
+		{"bcde", "bcd"},
+		{"c", ""},
+		{"0", ""},
+	}
+	for _, tt := range tests {
+		k, v, err := bltree.FindLongestPrefix([]byte(tt.key))
+		if tt.want == "" {
+			if k != nil || err != BLTErrOk {
+				t.Errorf("FindLongestPrefix(%q) = %q, %v, want %v, %v", tt.key, k, err, nil, BLTErrOk)
+			}
+			continue
+		}
+		if string(k) != tt.want || v[0] == 0xff || err != BLTErrOk {
+			t.Errorf("FindLongestPrefix(%q) = %q, %v, %v, want %q", tt.key, k, v, err, tt.want)
+		}
+	}
+}
+
+func TestCursor_concurrentCleanAndSplit(t *testing.T) {
+	mgr := NewBufMgr(12, 64, NewParentBufMgrDummy(nil), nil)
+	bltree := NewBLTree(mgr)
+
+	key := func(i uint64) []byte {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		return bs
+	}
+	// stable keys fit in one leaf page. other keys between them are inserted and
+	// deleted repeatedly so that the leaf is cleaned of dead slots and split
+	stable := uint64(40)
+	for i := uint64(0); i < stable; i++ {
+		if err := bltree.InsertKey(key(i*100), 0, key(i), true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+
+	// the writer continues until both scans pass over the leaf many times
+	stop := make(chan struct{})
+	writerDone := make(chan struct{})
+	go func() {
+		defer close(writerDone)
+		writer := NewBLTree(mgr)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			for j := uint64(1); j < 50; j++ {
+				for i := uint64(0); i < stable; i++ {
+					if err := writer.InsertKey(key(i*100+j), 0, make([]byte, 16), true); err != BLTErrOk {
+						t.Errorf("InsertKey() = %v, want %v", err, BLTErrOk)
+						return
+					}
+				}
+			}
+			for j := uint64(1); j < 50; j++ {
+				for i := uint64(0); i < stable; i++ {
+					writer.DeleteKey(key(i*100+j), 0)
+				}
+			}
+		}
+	}()
+
+	wg := sync.WaitGroup{}
+	check := func(name string, scan func(visit func(k, v []byte)) BLTErr) {
+		defer wg.Done()
+		for pass := 0; pass < 50; pass++ {
+			var prev []byte
+			found := uint64(0)
+			err := scan(func(k, v []byte) {
+				if prev != nil && bytes.Compare(prev, k) >= 0 {
+					t.Errorf("%s visited %v after %v, want ascending keys", name, k, prev)
+				}
+				if i := binary.BigEndian.Uint64(k); i%100 == 0 {
+					if !bytes.Equal(v, key(i/100)) {
+						t.Errorf("%s visited %v with %v, want %v", name, k, v, key(i/100))
+					}
+					found++
+				}
+				prev = append(prev[:0], k...)
+			})
+			if err != BLTErrOk || found != stable {
+				t.Errorf("%s visited %v stable keys with %v, want %v", name, found, err, stable)
+				return
+			}
+		}
+	}
+	wg.Add(2)
+	go check("Cursor", func(visit func(k, v []byte)) BLTErr {
+		c := NewBLTree(mgr).NewCursor()
+		defer c.Close()
+		for ok := c.Seek(nil); ok; ok = c.Next() {
+			visit(c.Key(), c.Value())
+		}
+		return c.Err()
+	})
+	go check("BLTreeItr", func(visit func(k, v []byte)) BLTErr {
+		itr := NewBLTree(mgr).GetRangeItr(nil, nil)
+		defer itr.Close()
+		for ok, k, v := itr.Next(); ok; ok, k, v = itr.Next() {
+			visit(k, v)
+		}
+		return itr.Err()
+	})
+	wg.Wait()
+	close(stop)
+	<-writerDone
+
+	if report, err := ValidateTree(bltree); err != BLTErrOk || !report.Valid() || report.Keys != stable {
+		t.Errorf("ValidateTree() = %v, %v keys, %v, want valid and %v keys", report.Problems, report.Keys, err, stable)
+	}
+}
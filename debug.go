@@ -0,0 +1,71 @@
+package blink_tree
+
+// DebugSlot is a slot of leaf page reported by DebugScan
+type DebugSlot struct {
+	PageNo  Uid      // page number of leaf page
+	Slot    uint32   // slot index in the page
+	Typ     SlotType // Unique, Librarian, Duplicate or Delete
+	Dead    bool     // slot is marked dead and waits for cleanup
+	Fence   bool     // slot is fence key of the page
+	Stopper bool     // slot is infinite stopper key of the rightmost leaf page
+	Key     []byte   // user key. uniqueifier of Duplicate slot is kept
+	Value   []byte   // value as stored in page (not decoded by ValueCodec)
+}
+
+// DebugScan walks leaf pages from the page which contains lowerKey
+// (the leftmost page when lowerKey is nil) to the right and calls fn
+// for every slot including dead slots, librarian place holders and fence keys.
+// all slots of the first page are reported, even those below lowerKey.
+// the walk stops when fn returns false.
+// ATTENTION: this method is for diagnosis. like RangeScan, it is not atomic
+// with other tree operations
+func (tree *BLTree) DebugScan(lowerKey []byte, fn func(s DebugSlot) bool) BLTErr {
+	tree.startOp()
+
+	if lowerKey != nil {
+		var err BLTErr
+		if lowerKey, err = encodeKey(lowerKey); err != BLTErrOk {
+			tree.err = err
+			return err
+		}
+	}
+	if tree.startKey(lowerKey) == 0 {
+		return tree.err
+	}
+
+	for {
+		page := tree.cursor
+		right := GetID(&page.Right)
+		for slot := uint32(1); slot <= page.Cnt; slot++ {
+			key := page.Key(slot)
+			keyLen := len(key)
+			if page.Typ(slot) == Duplicate {
+				keyLen -= BtId
+			}
+			userKey := decodeKey(key[:keyLen])
+			s := DebugSlot{
+				PageNo:  tree.cursorPage,
+				Slot:    slot,
+				Typ:     page.Typ(slot),
+				Dead:    page.Dead(slot),
+				Fence:   slot == page.Cnt,
+				Stopper: slot == page.Cnt && right == 0,
+				Key:     make([]byte, len(userKey)+len(key)-keyLen),
+				Value:   make([]byte, len(*page.Value(slot))),
+			}
+			copy(s.Key, userKey)
+			copy(s.Key[len(userKey):], key[keyLen:])
+			copy(s.Value, *page.Value(slot))
+			if !fn(s) {
+				return BLTErrOk
+			}
+		}
+
+		if right == 0 {
+			return BLTErrOk
+		}
+		if !tree.cursorRight(right) {
+			return tree.err
+		}
+	}
+}
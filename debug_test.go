@@ -0,0 +1,63 @@
+package blink_tree
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBLTree_DebugScan(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	bltree := NewBLTree(mgr)
+
+	for i := 0; i < 10; i++ {
+		if err := bltree.InsertKey([]byte{byte(i)}, 0, []byte{byte(i), 0, 0, 0, 0, 0}, true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+	for _, i := range []byte{2, 5, 7} {
+		if err := bltree.DeleteKey([]byte{i}, 0); err != BLTErrOk {
+			t.Fatalf("DeleteKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+
+	var slots []DebugSlot
+	if err := bltree.DebugScan(nil, func(s DebugSlot) bool {
+		slots = append(slots, s)
+		return true
+	}); err != BLTErrOk {
+		t.Fatalf("DebugScan() = %v, want %v", err, BLTErrOk)
+	}
+
+	dead := make(map[byte]bool)
+	live := 0
+	for _, s := range slots {
+		if s.Dead && s.Typ == Unique {
+			dead[s.Key[0]] = true
+		} else if !s.Dead && !s.Fence {
+			live++
+		}
+	}
+	for _, i := range []byte{2, 5, 7} {
+		if !dead[i] {
+			t.Errorf("DebugScan() doesn't report dead slot of key %v", i)
+		}
+	}
+	if live != 7 {
+		t.Errorf("DebugScan() live slots = %v, want %v", live, 7)
+	}
+
+	last := slots[len(slots)-1]
+	if !last.Stopper || !last.Fence || !bytes.Equal(last.Key, []byte{0xff, 0xff}) {
+		t.Errorf("DebugScan() last slot = %+v, want stopper key", last)
+	}
+
+	// scan stops when fn returns false
+	cnt := 0
+	bltree.DebugScan(nil, func(s DebugSlot) bool {
+		cnt++
+		return cnt < 3
+	})
+	if cnt != 3 {
+		t.Errorf("DebugScan() called fn %v times, want %v", cnt, 3)
+	}
+}
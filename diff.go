@@ -0,0 +1,103 @@
+package blink_tree
+
+import "bytes"
+
+// DiffItr yields keys which differ between two trees in key order.
+// leaf chains of both trees are read lazily with BLTreeItr,
+// so memory usage doesn't depend on size of the trees
+type DiffItr struct {
+	itrA, itrB *BLTreeItr
+	okA, okB   bool // itrA and itrB have current entries
+	keyA, keyB []byte
+	valA, valB []byte
+}
+
+// NewDiffItr returns iterator of keys which differ between a and b.
+// see Diff for reported changes.
+// ATTENTION: like RangeScan, the iteration is not atomic with other tree operations.
+// a and b should be snapshots which are not modified during the iteration
+func NewDiffItr(a *BLTree, b *BLTree) *DiffItr {
+	d := &DiffItr{itrA: a.GetRangeItr(nil, nil), itrB: b.GetRangeItr(nil, nil)}
+	if a == b {
+		d.itrA.Close()
+		d.itrB.Close()
+	}
+	d.nextA()
+	d.nextB()
+	return d
+}
+
+func (d *DiffItr) nextA() {
+	d.okA, d.keyA, d.valA = d.itrA.Next()
+}
+
+func (d *DiffItr) nextB() {
+	d.okB, d.keyB, d.valB = d.itrB.Next()
+}
+
+// Next returns next key which differs. a key which exists only in b (inserted)
+// is returned with nil oldVal, a key which exists only in a (deleted) is returned
+// with nil newVal and a key whose value is changed is returned with both values.
+// ok is false when the iteration is finished or failed. use Err to distinguish them
+func (d *DiffItr) Next() (ok bool, key []byte, oldVal []byte, newVal []byte) {
+	for d.Err() == BLTErrOk && (d.okA || d.okB) {
+		cmp := 0
+		switch {
+		case !d.okB:
+			cmp = -1
+		case !d.okA:
+			cmp = 1
+		default:
+			cmp = d.itrA.tree.mgr.compareUserKeys(d.keyA, d.keyB)
+		}
+
+		switch {
+		case cmp < 0:
+			key, oldVal = d.keyA, d.valA
+			d.nextA()
+			return true, key, oldVal, nil
+		case cmp > 0:
+			key, newVal = d.keyB, d.valB
+			d.nextB()
+			return true, key, nil, newVal
+		default:
+			key, oldVal, newVal = d.keyA, d.valA, d.valB
+			d.nextA()
+			d.nextB()
+			if !bytes.Equal(oldVal, newVal) {
+				return true, key, oldVal, newVal
+			}
+		}
+	}
+	return false, nil, nil, nil
+}
+
+// Err returns error which stopped the iteration
+func (d *DiffItr) Err() BLTErr {
+	if err := d.itrA.Err(); err != BLTErrOk {
+		return err
+	}
+	return d.itrB.Err()
+}
+
+// Close finishes the iteration
+func (d *DiffItr) Close() {
+	d.itrA.Close()
+	d.itrB.Close()
+	d.okA, d.okB = false, false
+}
+
+// Diff walks leaf chains of a and b in lockstep and calls fn for each key
+// which differs between them.
+// a key which exists only in b (inserted) is reported with nil oldVal,
+// a key which exists only in a (deleted) is reported with nil newVal
+// and a key whose value is changed is reported with both values.
+// ATTENTION: like RangeScan, this method call is not atomic with other tree operations.
+// a and b should be snapshots which are not modified during the walk
+func Diff(a *BLTree, b *BLTree, fn func(key []byte, oldVal []byte, newVal []byte)) BLTErr {
+	d := NewDiffItr(a, b)
+	for ok, key, oldVal, newVal := d.Next(); ok; ok, key, oldVal, newVal = d.Next() {
+		fn(key, oldVal, newVal)
+	}
+	return d.Err()
+}
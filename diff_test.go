@@ -0,0 +1,108 @@
+package blink_tree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	newTree := func() *BLTree {
+		return NewBLTree(NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil))
+	}
+	keyOf := func(i uint64) []byte {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		return bs
+	}
+
+	a := newTree()
+	b := newTree()
+	// keys span several leaf pages
+	for i := uint64(0); i < 3000; i++ {
+		a.InsertKey(keyOf(i), 0, []byte{1, 0, 0, 0, 0, 0}, true)
+		b.InsertKey(keyOf(i), 0, []byte{1, 0, 0, 0, 0, 0}, true)
+	}
+	a.DeleteKey(keyOf(0), 0)                                    // inserted in b
+	b.DeleteKey(keyOf(1500), 0)                                 // deleted in b
+	b.InsertKey(keyOf(2000), 0, []byte{2, 0, 0, 0, 0, 0}, true) // changed in b
+	b.InsertKey(keyOf(5000), 0, []byte{3, 0, 0, 0, 0, 0}, true) // inserted in b
+
+	type change struct {
+		key    []byte
+		oldVal []byte
+		newVal []byte
+	}
+	want := []change{
+		{keyOf(0), nil, []byte{1, 0, 0, 0, 0, 0}},
+		{keyOf(1500), []byte{1, 0, 0, 0, 0, 0}, nil},
+		{keyOf(2000), []byte{1, 0, 0, 0, 0, 0}, []byte{2, 0, 0, 0, 0, 0}},
+		{keyOf(5000), nil, []byte{3, 0, 0, 0, 0, 0}},
+	}
+
+	got := make([]change, 0)
+	if err := Diff(a, b, func(key []byte, oldVal []byte, newVal []byte) {
+		got = append(got, change{key, oldVal, newVal})
+	}); err != BLTErrOk {
+		t.Fatalf("Diff() = %v, want %v", err, BLTErrOk)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Diff() reported %d changes, want %d: %v", len(got), len(want), got)
+	}
+	for i := range want {
+		if !bytes.Equal(got[i].key, want[i].key) || !bytes.Equal(got[i].oldVal, want[i].oldVal) ||
+			!bytes.Equal(got[i].newVal, want[i].newVal) || (got[i].oldVal == nil) != (want[i].oldVal == nil) ||
+			(got[i].newVal == nil) != (want[i].newVal == nil) {
+			t.Errorf("Diff() change[%d] = %v, want %v", i, got[i], want[i])
+		}
+	}
+
+	// no difference with itself
+	if err := Diff(a, a, func(key []byte, oldVal []byte, newVal []byte) {
+		t.Errorf("Diff() reported %v for same tree", key)
+	}); err != BLTErrOk {
+		t.Errorf("Diff() = %v, want %v", err, BLTErrOk)
+	}
+}
+
+func TestDiffItr(t *testing.T) {
+	newTree := func() *BLTree {
+		return NewBLTree(NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil))
+	}
+	keyOf := func(i uint64) []byte {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		return bs
+	}
+
+	a := newTree()
+	b := newTree()
+	for i := uint64(0); i < 3000; i++ {
+		a.InsertKey(keyOf(i), 0, []byte{1, 0, 0, 0, 0, 0}, true)
+		if i%100 != 0 {
+			b.InsertKey(keyOf(i), 0, []byte{1, 0, 0, 0, 0, 0}, true)
+		}
+	}
+
+	d := NewDiffItr(a, b)
+	n := uint64(0)
+	for ok, key, oldVal, newVal := d.Next(); ok; ok, key, oldVal, newVal = d.Next() {
+		if !bytes.Equal(key, keyOf(n*100)) || oldVal == nil || newVal != nil {
+			t.Errorf("Next() = %v, %v, %v, want deletion of %v", key, oldVal, newVal, keyOf(n*100))
+		}
+		n++
+	}
+	if n != 30 || d.Err() != BLTErrOk {
+		t.Errorf("Next() returned %v changes with %v, want %v", n, d.Err(), 30)
+	}
+
+	// trees swapped and closed in the middle
+	d = NewDiffItr(b, a)
+	if ok, key, oldVal, newVal := d.Next(); !ok || !bytes.Equal(key, keyOf(0)) || oldVal != nil || newVal == nil {
+		t.Errorf("Next() = %v, %v, %v, want insertion of %v", key, oldVal, newVal, keyOf(0))
+	}
+	d.Close()
+	if ok, _, _, _ := d.Next(); ok {
+		t.Errorf("Next() after Close() = true, want false")
+	}
+}
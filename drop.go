@@ -0,0 +1,36 @@
+package blink_tree
+
+// DropTree releases all pages of the tree to the parent buffer manager.
+// parent pages mapped to pages of the tree, including page zero and free pages,
+// are deallocated by DeallocatePPage and their page id mapping entries are removed.
+// pool pages are discarded without being written back. returns count of
+// deallocated parent pages.
+// ATTENTION: DropTree should be called while no other operation is in progress,
+// and mgr and trees on it must not be used after DropTree
+func (mgr *BufMgr) DropTree() int {
+	mgr.lock.SpinWriteLock()
+	defer mgr.lock.SpinReleaseWrite()
+
+	// pool pages are not written back after their parent pages are deallocated
+	var slot uint32
+	for slot = 1; slot <= mgr.latchDeployed; slot++ {
+		mgr.clearDirty(&mgr.latchs[slot])
+	}
+
+	// entries are collected first not to delete them while ranging over the map
+	pageNos := make([]Uid, 0, mgr.pageIdConvMap.Len())
+	ppageIds := make([]int32, 0, mgr.pageIdConvMap.Len())
+	mgr.pageIdConvMap.Range(func(pageNo Uid, ppageId int32) bool {
+		pageNos = append(pageNos, pageNo)
+		ppageIds = append(ppageIds, ppageId)
+		return true
+	})
+	for i, pageNo := range pageNos {
+		mgr.pbm.DeallocatePPage(ppageIds[i], true)
+		mgr.pageIdConvMap.Delete(pageNo)
+	}
+
+	mgr.prunedFree = nil
+	PutID(&mgr.pageZero.chain, 0)
+	return len(pageNos)
+}
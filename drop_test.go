@@ -0,0 +1,72 @@
+package blink_tree
+
+import (
+	"encoding/binary"
+	"sync"
+	"testing"
+)
+
+func TestBufMgr_DropTree(t *testing.T) {
+	pbmPageMap := &sync.Map{}
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(pbmPageMap), nil)
+	bltree := NewBLTree(mgr)
+	keyOf := func(i uint64) []byte {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		return bs
+	}
+
+	num := uint64(20000)
+	for i := uint64(0); i < num; i++ {
+		bltree.InsertKey(keyOf(i), 0, make([]byte, BtId), true)
+	}
+	// some pages are on the free chain
+	bltree.DeleteRange(keyOf(1000), keyOf(5000))
+
+	ppages := func() int {
+		cnt := 0
+		pbmPageMap.Range(func(_, _ any) bool {
+			cnt++
+			return true
+		})
+		return cnt
+	}
+	allocated := ppages()
+	mapped := mgr.GetPageIdConvMap().Len()
+
+	if got := mgr.DropTree(); int64(got) != mapped {
+		t.Errorf("DropTree() = %v, want %v", got, mapped)
+	}
+	if got := mgr.GetPageIdConvMap().Len(); got != 0 {
+		t.Errorf("page id mapping entries after DropTree() = %v, want 0", got)
+	}
+	if got := ppages(); got != allocated-int(mapped) {
+		t.Errorf("parent pages after DropTree() = %v, want %v", got, allocated-int(mapped))
+	}
+}
+
+func TestBufMgr_DropTree_reopened(t *testing.T) {
+	pbmPageMap := &sync.Map{}
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(pbmPageMap), nil)
+	bltree := NewBLTree(mgr)
+	keyOf := func(i uint64) []byte {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		return bs
+	}
+	for i := uint64(0); i < 5000; i++ {
+		bltree.InsertKey(keyOf(i), 0, make([]byte, BtId), true)
+	}
+	if err := mgr.Close(); err != BLTErrOk {
+		t.Fatalf("Close() = %v, want %v", err, BLTErrOk)
+	}
+
+	lastPageZeroId := mgr.GetMappedPPageIdOfPageZero()
+	mgr = NewBufMgr(12, 48, NewParentBufMgrDummy(pbmPageMap), &lastPageZeroId)
+	if got := mgr.DropTree(); got == 0 {
+		t.Errorf("DropTree() = %v, want deallocated pages", got)
+	}
+	if _, ok := pbmPageMap.Load(lastPageZeroId); ok {
+		t.Errorf("parent page of page zero is left after DropTree()")
+	}
+}
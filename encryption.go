@@ -0,0 +1,37 @@
+package blink_tree
+
+// PageEncryptor encrypts pages written to parent buffer manager for encryption at rest.
+// pages are kept decrypted in buffer pool
+type PageEncryptor interface {
+	// KeyId returns id of the key which pages are encrypted with on PageOut. it must not be 0
+	KeyId() uint32
+	// Encrypt appends src encrypted with key keyId to dst and returns it.
+	// encrypted data must be as long as src, so that it fits in the page.
+	// pageNo is unique to the page and can be used as tweak (e.g. AES-XTS)
+	Encrypt(dst []byte, src []byte, keyId uint32, pageNo Uid) []byte
+	// Decrypt appends src decrypted with key keyId to dst and returns it
+	Decrypt(dst []byte, src []byte, keyId uint32, pageNo Uid) ([]byte, error)
+}
+
+// SetPageEncryptor sets encryptor which is applied to data area of each page on PageOut
+// and on PageIn after PageCodec. header of page is written as plain with id of the key
+// except fence hints, which are encrypted with data area because they hold bytes of keys,
+// so pages encrypted with previous keys are read after the key is rotated and written
+// with the current key. page zero, which holds page id mapping, is never encrypted.
+// nil means pages are written as plain.
+//
+// encryptor is not persisted, so it must be set before any operation on the tree
+// and encryptor which has all keys of the tree must be set whenever the tree is opened.
+// encrypted pages can't be read without encryptor, so RescueBufMgr skips them.
+// BLTErrDecrypt is returned when a page can't be decrypted
+func (mgr *BufMgr) SetPageEncryptor(encryptor PageEncryptor) {
+	mgr.pageEncryptor = encryptor
+}
+
+// imageFilterOf returns filter applied to image of page pageNo
+func (mgr *BufMgr) imageFilterOf(pageNo Uid) imageFilter {
+	if pageNo == 0 {
+		return imageFilter{}
+	}
+	return imageFilter{codec: mgr.pageCodec, encryptor: mgr.pageEncryptor, pageNo: pageNo}
+}
@@ -0,0 +1,102 @@
+package blink_tree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// xorPageEncryptor is PageEncryptor which xors data with stream of key and page number
+type xorPageEncryptor struct {
+	keyId uint32
+}
+
+func (e xorPageEncryptor) KeyId() uint32 {
+	return e.keyId
+}
+
+func (e xorPageEncryptor) Encrypt(dst []byte, src []byte, keyId uint32, pageNo Uid) []byte {
+	for i, b := range src {
+		dst = append(dst, b^byte(uint32(i)*7+keyId*31+uint32(pageNo)*13+1))
+	}
+	return dst
+}
+
+func (e xorPageEncryptor) Decrypt(dst []byte, src []byte, keyId uint32, pageNo Uid) ([]byte, error) {
+	if keyId > e.keyId {
+		return nil, fmt.Errorf("unknown key %d", keyId)
+	}
+	return e.Encrypt(dst, src, keyId, pageNo), nil
+}
+
+func TestBufMgr_SetPageEncryptor(t *testing.T) {
+	pbmPageMap := &sync.Map{}
+	pbm := NewParentBufMgrDummy(pbmPageMap)
+	mgr := NewBufMgr(12, 48, pbm, nil)
+	mgr.SetPageEncryptor(xorPageEncryptor{keyId: 1})
+	bltree := NewBLTree(mgr)
+
+	keyOf := func(i int) []byte {
+		return []byte(fmt.Sprintf("secret-%06d", i))
+	}
+	insert := func(from int, to int) {
+		for i := from; i < to; i++ {
+			if err := bltree.InsertKey(keyOf(i), 0, make([]byte, BtId), true); err != BLTErrOk {
+				t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+			}
+		}
+	}
+	reopen := func(encryptor PageEncryptor) {
+		if err := mgr.Close(); err != BLTErrOk {
+			t.Fatalf("Close() = %v, want %v", err, BLTErrOk)
+		}
+		lastPageZeroId := mgr.GetMappedPPageIdOfPageZero()
+		mgr = NewBufMgr(12, 48, NewParentBufMgrDummy(pbmPageMap), &lastPageZeroId)
+		if encryptor != nil {
+			mgr.SetPageEncryptor(encryptor)
+		}
+		bltree = NewBLTree(mgr)
+	}
+	insert(0, 1000)
+	reopen(nil)
+
+	// keys don't appear in parent pages
+	mgr.pageIdConvMap.Range(func(pageNo Uid, ppageId int32) bool {
+		ppage := pbm.FetchPPage(ppageId)
+		image := ppage.DataAsSlice()
+		if keyId := binary.LittleEndian.Uint32(image[keyIdOffset:]); pageNo > 0 && keyId != 1 {
+			t.Errorf("page %v is encrypted with key %v, want %v", pageNo, keyId, 1)
+		}
+		if bytes.Contains(image, []byte("secret")) {
+			t.Errorf("page %v has plain key", pageNo)
+		}
+		pbm.UnpinPPage(ppageId, false)
+		return true
+	})
+	if _, _, _, err := bltree.FindKeyErr(keyOf(0), BtId); err != BLTErrDecrypt {
+		t.Errorf("FindKeyErr() without encryptor = %v, want %v", err, BLTErrDecrypt)
+	}
+
+	// pages encrypted with rotated key are still read
+	reopen(xorPageEncryptor{keyId: 2})
+	insert(1000, 2000)
+	reopen(xorPageEncryptor{keyId: 2})
+	for i := 0; i < 2000; i++ {
+		if _, foundKey, _ := bltree.FindKey(keyOf(i), BtId); !bytes.Equal(foundKey, keyOf(i)) {
+			t.Errorf("FindKey() = %s, want %s", foundKey, keyOf(i))
+		}
+	}
+
+	// encryption is applied after compression
+	mgr.SetPageCodec(flatePageCodec{})
+	insert(2000, 3000)
+	reopen(xorPageEncryptor{keyId: 2})
+	mgr.SetPageCodec(flatePageCodec{})
+	for i := 0; i < 3000; i++ {
+		if _, foundKey, _ := bltree.FindKey(keyOf(i), BtId); !bytes.Equal(foundKey, keyOf(i)) {
+			t.Errorf("FindKey() = %s, want %s", foundKey, keyOf(i))
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package blink_tree
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// expirySize is length of expiration time put before each stored value
+const expirySize = 8
+
+// SetExpiration enables expiration of keys. when it's enabled, each value is
+// stored with its expiration time and keys inserted by InsertWithTTL are treated
+// as deleted by reads after the time. expired keys are reclaimed when their
+// leaf page is compacted or by PurgeExpired.
+//
+// it changes format of stored values, so it must be set before any operation
+// on the tree and the same setting must be used whenever the tree is opened.
+// expiration time takes 8 bytes of MaxValueSize. Count, Select, Rank and
+// statistics include expired keys which are not reclaimed yet
+func (mgr *BufMgr) SetExpiration(enabled bool) {
+	mgr.expiration = enabled
+}
+
+// now returns current time of expiration
+func (mgr *BufMgr) now() time.Time {
+	if mgr.clock != nil {
+		return mgr.clock()
+	}
+	return time.Now()
+}
+
+// expiredValue reports whether stored value has expired
+func (mgr *BufMgr) expiredValue(stored []byte) bool {
+	if len(stored) < expirySize {
+		return false
+	}
+	at := int64(binary.BigEndian.Uint64(stored))
+	return at > 0 && at <= mgr.now().UnixNano()
+}
+
+// expired reports whether the key of slot of leaf page has expired
+func (mgr *BufMgr) expired(page *Page, slot uint32) bool {
+	if !mgr.expiration || page.Lvl > 0 {
+		return false
+	}
+	return mgr.expiredValue(page.valueBytes(slot))
+}
+
+// expiredEntries returns size in data area and count of expired keys of leaf page.
+// fence key is not counted because it's kept by cleanup
+func (mgr *BufMgr) expiredEntries(page *Page) (uint32, uint32) {
+	if !mgr.expiration {
+		return 0, 0
+	}
+	size, keys := uint32(0), uint32(0)
+	for slot := uint32(1); slot < page.Cnt; slot++ {
+		if !page.Dead(slot) && page.Typ(slot) != Librarian && mgr.expired(page, slot) {
+			size += page.entrySize(slot)
+			keys++
+		}
+	}
+	return size, keys
+}
+
+// InsertWithTTL inserts unique key with value which expires after ttl.
+// the key is treated as deleted after the expiration, until it's inserted again.
+// InsertKey and other writes set values without expiration.
+// BLTErrExpire is returned when expiration isn't enabled by SetExpiration
+func (tree *BLTree) InsertWithTTL(key []byte, value []byte, ttl time.Duration) BLTErr {
+	if !tree.mgr.expiration {
+		tree.err = BLTErrExpire
+		return tree.err
+	}
+	tree.expireAt = tree.mgr.now().Add(ttl).UnixNano()
+	err := tree.insertValue(key, value, true)
+	tree.expireAt = 0
+	return err
+}
+
+// PurgeExpired deletes expired keys from all leaf pages and returns count of them.
+// keys of each leaf page are deleted under one write lock of the page and
+// emptied pages are reclaimed like DeleteRange. purged keys are not reported to ChangeHook.
+// ATTENTION: like DeleteRange, the deletion is not atomic
+func (tree *BLTree) PurgeExpired() (int, BLTErr) {
+	if !tree.mgr.expiration {
+		return 0, BLTErrOk
+	}
+	tree.purging = true
+	defer func() { tree.purging = false }()
+	return tree.DeleteRange(nil, nil)
+}
@@ -0,0 +1,111 @@
+package blink_tree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func TestBLTree_InsertWithTTL(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	bltree := NewBLTree(mgr)
+
+	if err := bltree.InsertWithTTL([]byte{1}, []byte{1}, time.Second); err != BLTErrExpire {
+		t.Errorf("InsertWithTTL() without expiration = %v, want %v", err, BLTErrExpire)
+	}
+
+	now := time.Unix(1000, 0)
+	mgr.clock = func() time.Time { return now }
+	mgr.SetExpiration(true)
+
+	keyOf := func(i uint64) []byte {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		return bs
+	}
+	// even keys expire
+	for i := uint64(0); i < 10; i++ {
+		var err BLTErr
+		if i%2 == 0 {
+			err = bltree.InsertWithTTL(keyOf(i), []byte{byte(i)}, time.Minute)
+		} else {
+			err = bltree.InsertKey(keyOf(i), 0, []byte{byte(i)}, true)
+		}
+		if err != BLTErrOk {
+			t.Fatalf("insert of %v = %v, want %v", i, err, BLTErrOk)
+		}
+	}
+
+	if _, _, val := bltree.FindKey(keyOf(2), 8); !bytes.Equal(val, []byte{2}) {
+		t.Errorf("FindKey() before expiration = %v, want %v", val, []byte{2})
+	}
+
+	now = now.Add(time.Minute)
+	for i := uint64(0); i < 10; i++ {
+		want := i%2 == 1
+		if got := bltree.Exists(keyOf(i)); got != want {
+			t.Errorf("Exists(%v) = %v, want %v", i, got, want)
+		}
+		if ret, _, _ := bltree.FindKey(keyOf(i), 8); (ret >= 0) != want {
+			t.Errorf("FindKey(%v) = %v, want found %v", i, ret, want)
+		}
+	}
+	if num, keys, _ := bltree.RangeScan(nil, nil); num != 5 || !bytes.Equal(keys[0], keyOf(1)) {
+		t.Errorf("RangeScan() = %v, %v, want 5 keys from %v", num, keys, keyOf(1))
+	}
+
+	// expired key is inserted again without old value
+	if found, _, _ := bltree.Remove(keyOf(0)); found {
+		t.Errorf("Remove() of expired key found it")
+	}
+	if err := bltree.Update(keyOf(0), []byte{9}); err != BLTErrNotFound {
+		t.Errorf("Update() of expired key = %v, want %v", err, BLTErrNotFound)
+	}
+	if err := bltree.InsertKey(keyOf(0), 0, []byte{9}, true); err != BLTErrOk {
+		t.Errorf("InsertKey() = %v, want %v", err, BLTErrOk)
+	}
+	if _, _, val := bltree.FindKey(keyOf(0), 8); !bytes.Equal(val, []byte{9}) {
+		t.Errorf("FindKey() after insert = %v, want %v", val, []byte{9})
+	}
+
+	// keys 2, 4, 6 and 8 remain expired
+	if n, err := bltree.PurgeExpired(); n != 4 || err != BLTErrOk {
+		t.Errorf("PurgeExpired() = %v, %v, want 4, %v", n, err, BLTErrOk)
+	}
+	if n, _ := bltree.Count(); n != 6 {
+		t.Errorf("Count() after purge = %v, want 6", n)
+	}
+	if n, _ := bltree.PurgeExpired(); n != 0 {
+		t.Errorf("PurgeExpired() again = %v, want 0", n)
+	}
+}
+
+func TestBLTree_ExpiredKeysAreCompacted(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	now := time.Unix(1000, 0)
+	mgr.clock = func() time.Time { return now }
+	mgr.SetExpiration(true)
+	bltree := NewBLTree(mgr)
+
+	keyOf := func(i uint64) []byte {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		return bs
+	}
+	for i := uint64(0); i < 50; i++ {
+		bltree.InsertWithTTL(keyOf(i*10), []byte{1, 0, 0, 0, 0, 0}, time.Second)
+	}
+	now = now.Add(time.Second)
+
+	// inserting keys makes the page compacted
+	for i := uint64(0); i < 100; i++ {
+		bltree.InsertKey(keyOf(i*10+5), 0, []byte{1, 0, 0, 0, 0, 0}, true)
+	}
+	if n, _ := bltree.Count(); n != 100 {
+		t.Errorf("Count() = %v, want 100", n)
+	}
+	if n, _ := bltree.PurgeExpired(); n != 0 {
+		t.Errorf("PurgeExpired() after compaction = %v, want 0", n)
+	}
+}
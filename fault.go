@@ -0,0 +1,81 @@
+package blink_tree
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// FaultPoint is a call site of BufMgr where a fault can be injected
+type FaultPoint uint8
+
+const (
+	FaultPageOut FaultPoint = iota // before page is written to parent buffer manager
+	FaultPageIn                    // before page is read from parent buffer manager
+	FaultLatch                     // before page lock is acquired
+)
+
+type (
+	// Fault is injected at a call site. Err is returned from the call site
+	// after Delay passes. Err is ignored at FaultLatch because locking can't fail
+	Fault struct {
+		Err   BLTErr        // error to return (BLTErrOk means no error)
+		Delay time.Duration // artificial delay
+	}
+
+	// FaultInjector decides fault injected at point for page pageNo.
+	// it is called on goroutines of tree operations, so it must be safe
+	// for concurrent use
+	FaultInjector func(point FaultPoint, pageNo Uid) Fault
+)
+
+// SetFaultInjector sets injector of faults for chaos testing. nil removes it.
+// injected errors are surfaced like real failures of parent buffer manager,
+// so error handling of callers can be tested. not for production use
+func (mgr *BufMgr) SetFaultInjector(injector FaultInjector) {
+	if injector == nil {
+		mgr.faultInjector.Store(nil)
+		return
+	}
+	mgr.faultInjector.Store(&injector)
+}
+
+// injectFault applies fault of point for page pageNo if injector is set
+func (mgr *BufMgr) injectFault(point FaultPoint, pageNo Uid) BLTErr {
+	injector := mgr.faultInjector.Load()
+	if injector == nil {
+		return BLTErrOk
+	}
+	fault := (*injector)(point, pageNo)
+	if fault.Delay > 0 {
+		time.Sleep(fault.Delay)
+	}
+	return fault.Err
+}
+
+// EvictPage writes back page pageNo if it's dirty and drops it from the buffer pool,
+// so that next access fetches it from parent buffer manager.
+// BLTErrLock is returned when the page is pinned. evicting a page which is
+// not in the buffer pool does nothing
+func (mgr *BufMgr) EvictPage(pageNo Uid) BLTErr {
+	hashIdx := uint(pageNo) % mgr.latchHash
+	mgr.hashTable[hashIdx].latch.SpinWriteLock()
+	defer mgr.hashTable[hashIdx].latch.SpinReleaseWrite()
+
+	slot := mgr.lookupLatch(hashIdx, pageNo)
+	if slot == 0 || mgr.latchs[slot].invalid {
+		return BLTErrOk
+	}
+	latch := &mgr.latchs[slot]
+	if atomic.LoadUint32(&latch.pin)&^ClockBit > 0 {
+		return BLTErrLock
+	}
+
+	if err := mgr.pageOut(&mgr.pagePool[slot], pageNo, latch.dirty, latch.lsn, time.Time{}); err != BLTErrOk {
+		return err
+	}
+	mgr.clearDirty(latch)
+
+	// invalid entry is reloaded when it's pinned next time
+	latch.invalid = true
+	return BLTErrOk
+}
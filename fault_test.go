@@ -0,0 +1,116 @@
+package blink_tree
+
+import (
+	"bytes"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBufMgr_SetFaultInjector(t *testing.T) {
+	t.Run("page out failure", func(t *testing.T) {
+		mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+		mgr.SetFaultInjector(func(point FaultPoint, pageNo Uid) Fault {
+			if point == FaultPageOut {
+				return Fault{Err: BLTErrWrite}
+			}
+			return Fault{}
+		})
+
+		var reads, writes uint
+		var set PageSet
+		if err := mgr.NewPage(&set, NewPage(mgr.pageDataSize), &reads, &writes); err != BLTErrWrite {
+			t.Errorf("NewPage() = %v, want %v", err, BLTErrWrite)
+		}
+
+		mgr.SetFaultInjector(nil)
+		if err := mgr.NewPage(&set, NewPage(mgr.pageDataSize), &reads, &writes); err != BLTErrOk {
+			t.Errorf("NewPage() after SetFaultInjector(nil) = %v, want %v", err, BLTErrOk)
+		}
+	})
+
+	t.Run("page in failure", func(t *testing.T) {
+		mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+		bltree := NewBLTree(mgr)
+		key := []byte{1, 2, 3}
+		if err := bltree.InsertKey(key, 0, []byte{1, 0, 0, 0, 0, 0}, true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+		if err := mgr.EvictPage(RootPage); err != BLTErrOk {
+			t.Fatalf("EvictPage() = %v, want %v", err, BLTErrOk)
+		}
+
+		mgr.SetFaultInjector(func(point FaultPoint, pageNo Uid) Fault {
+			if point == FaultPageIn && pageNo == RootPage {
+				return Fault{Err: BLTErrRead}
+			}
+			return Fault{}
+		})
+		var reads, writes uint
+		if latch := mgr.PinLatch(RootPage, true, &reads, &writes); latch != nil {
+			t.Errorf("PinLatch() with page in failure = %v, want nil", latch)
+		}
+
+		// the page is fetched again after the failure
+		mgr.SetFaultInjector(nil)
+		if ret, _, val := bltree.FindKey(key, BtId); ret < 0 || !bytes.Equal(val, []byte{1, 0, 0, 0, 0, 0}) {
+			t.Errorf("FindKey() after page in failure = %v, %v, want value %v", ret, val, []byte{1, 0, 0, 0, 0, 0})
+		}
+	})
+
+	t.Run("latch delay", func(t *testing.T) {
+		mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+		bltree := NewBLTree(mgr)
+		delayed := int32(0)
+		mgr.SetFaultInjector(func(point FaultPoint, pageNo Uid) Fault {
+			if point == FaultLatch && pageNo == RootPage {
+				atomic.AddInt32(&delayed, 1)
+				return Fault{Delay: 10 * time.Millisecond}
+			}
+			return Fault{}
+		})
+
+		start := time.Now()
+		bltree.FindKey([]byte{1}, BtId)
+		if elapsed := time.Since(start); atomic.LoadInt32(&delayed) == 0 || elapsed < 10*time.Millisecond {
+			t.Errorf("FindKey() with latch delay took %v and delayed %v times, want delay", elapsed, delayed)
+		}
+	})
+}
+
+func TestBufMgr_EvictPage(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	bltree := NewBLTree(mgr)
+
+	for i := 0; i < 1000; i++ {
+		if err := bltree.InsertKey([]byte{byte(i >> 8), byte(i)}, 0, []byte{byte(i), 0, 0, 0, 0, 0}, true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+
+	// dirty page is written back before it's evicted
+	allocated, _ := mgr.PageCapacity()
+	for pageNo := Uid(1); pageNo < allocated; pageNo++ {
+		if err := mgr.EvictPage(pageNo); err != BLTErrOk {
+			t.Fatalf("EvictPage(%v) = %v, want %v", pageNo, err, BLTErrOk)
+		}
+		if mgr.IsResident(pageNo) {
+			t.Errorf("IsResident(%v) after EvictPage() = true, want false", pageNo)
+		}
+	}
+	if got := mgr.DirtyPages(); got != 0 {
+		t.Errorf("DirtyPages() after EvictPage() = %v, want %v", got, 0)
+	}
+	for i := 0; i < 1000; i++ {
+		if ret, _, val := bltree.FindKey([]byte{byte(i >> 8), byte(i)}, BtId); ret < 0 || val[0] != byte(i) {
+			t.Errorf("FindKey(%v) after EvictPage() = %v, %v, want value %v", i, ret, val, byte(i))
+		}
+	}
+
+	var reads, writes uint
+	latch := mgr.PinLatch(RootPage, true, &reads, &writes)
+	if err := mgr.EvictPage(RootPage); err != BLTErrLock {
+		t.Errorf("EvictPage() of pinned page = %v, want %v", err, BLTErrLock)
+	}
+	mgr.UnpinLatch(latch)
+}
@@ -0,0 +1,40 @@
+package blink_tree
+
+import "bytes"
+
+// FenceHintSize is count of leading bytes of fence keys held in page header
+const FenceHintSize = 8
+
+// fenceHint returns leading FenceHintSize bytes of key padded with zero.
+// hints keep bytes order of keys loosely: a <= b means fenceHint(a) <= fenceHint(b),
+// so key whose hint is out of LowFence and HighFence of a page isn't on the page
+func fenceHint(key []byte) (hint [FenceHintSize]uint8) {
+	copy(hint[:], key)
+	return hint
+}
+
+// setHighFence sets HighFence of page from its fence key
+func (p *Page) setHighFence() {
+	p.HighFence = fenceHint(p.Key(p.Cnt))
+}
+
+// mayHoldKey reports whether key stored in pages may belong to page by fence hints
+// of its header without reading its slots. it's always true when keys are ordered
+// by a comparator, because hints keep only bytes order
+func (mgr *BufMgr) mayHoldKey(page *Page, key []byte) bool {
+	if mgr.keyCompare != nil {
+		return true
+	}
+	hint := fenceHint(key)
+	return bytes.Compare(hint[:], page.LowFence[:]) >= 0 && bytes.Compare(hint[:], page.HighFence[:]) <= 0
+}
+
+// keyBelowPage reports whether key stored in pages is less than all keys of page
+// by LowFence of page without reading its slots
+func (mgr *BufMgr) keyBelowPage(page *Page, key []byte) bool {
+	if mgr.keyCompare != nil {
+		return false
+	}
+	hint := fenceHint(key)
+	return bytes.Compare(hint[:], page.LowFence[:]) < 0
+}
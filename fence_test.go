@@ -0,0 +1,70 @@
+package blink_tree
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+func TestBLTree_fenceHints(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	bltree := NewBLTree(mgr)
+
+	keyOf := func(i int) []byte {
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, uint64(i))
+		return key
+	}
+	num := 20000
+	for i := 0; i < num; i++ {
+		if err := bltree.InsertKey(keyOf(i), 0, make([]byte, BtId), true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+	// deletions merge pages and replace fence keys of upper levels
+	for i := 0; i < num; i++ {
+		if i%10 != 0 && i > num/4 && i < num*3/4 {
+			if err := bltree.DeleteKey(keyOf(i), 0); err != BLTErrOk {
+				t.Fatalf("DeleteKey() = %v, want %v", err, BLTErrOk)
+			}
+		}
+	}
+	report, err := ValidateTree(bltree)
+	if err != BLTErrOk || !report.Valid() {
+		t.Fatalf("ValidateTree() = %v, %v, want valid tree", report, err)
+	}
+
+	var set PageSet
+	if slot := mgr.PageFetch(&set, keyOf(num/2), 0, LockRead, &bltree.reads, &bltree.writes); slot == 0 {
+		t.Fatalf("PageFetch() failed")
+	}
+	page := set.page
+	if !mgr.mayHoldKey(page, keyOf(num/2)) {
+		t.Errorf("mayHoldKey() = %v, want %v", false, true)
+	}
+	if mgr.mayHoldKey(page, keyOf(0)) || mgr.mayHoldKey(page, keyOf(num)) {
+		t.Errorf("mayHoldKey() of keys of other pages = %v, want %v", true, false)
+	}
+	if !mgr.keyBelowPage(page, keyOf(0)) || mgr.keyBelowPage(page, keyOf(num/2)) {
+		t.Errorf("keyBelowPage() doesn't match LowFence %v", page.LowFence)
+	}
+
+	// broken hint is detected
+	page.LowFence[0] = 0xff
+	mgr.markDirty(set.latch)
+	mgr.PageUnlock(LockRead, set.latch)
+	mgr.UnpinLatch(set.latch)
+	report, err = ValidateTree(bltree)
+	broken := false
+	for _, problem := range report.Problems {
+		broken = broken || strings.Contains(problem.Msg, "LowFence")
+	}
+	if err != BLTErrOk || !broken {
+		t.Errorf("ValidateTree() = %v, %v, want broken LowFence", report, err)
+	}
+
+	// range scan doesn't go beyond the page of upper bound
+	if num, keys, _ := bltree.RangeScan(keyOf(10), keyOf(100)); num != 91 || len(keys) != 91 {
+		t.Errorf("RangeScan() = %v, want %v", num, 91)
+	}
+}
@@ -0,0 +1,71 @@
+package blink_tree
+
+import "time"
+
+// SetFixedWidth turns on fixed width mode of the tree, in which an entry whose key
+// is keyWidth bytes and value is valWidth bytes is stored without length bytes
+// of them, so more keys fit in a page. entries of upper levels are stored so when
+// their keys are keyWidth bytes, because their values are page numbers.
+// other keys and values, e.g. duplicate keys which have sequence suffix, keys
+// beginning with 0xff which are escaped and values of other lengths, are stored
+// with length bytes in the same page. lengths are of keys and values stored in page,
+// so valWidth includes expiration time when SetExpiration is enabled and
+// values are measured after they're encoded by ValueCodec.
+// keyWidth out of [1, MaxKey] or valWidth out of [0, MaxKey] is BLTErrOverflow.
+// the widths are persisted in page zero and pages of the tree, so the mode
+// is kept when the tree is opened. it can be set only while the tree has
+// no key and no page but the root page and the leaf page of a new tree,
+// and BLTErrNotEmpty is returned otherwise unless the tree has the same widths.
+// it must be set before any operation on the tree
+func (mgr *BufMgr) SetFixedWidth(keyWidth int, valWidth int) BLTErr {
+	if keyWidth < 1 || keyWidth > MaxKey || valWidth < 0 || valWidth > MaxKey {
+		return BLTErrOverflow
+	}
+	if k, v := mgr.pageZero.fixedWidth(); int(k) == keyWidth && int(v) == valWidth {
+		return BLTErrOk
+	} else if k != 0 || GetID(mgr.pageZero.AllocRight()) != MinLvl+1 || GetID(&mgr.pageZero.chain) != 0 || !mgr.isEmpty() {
+		return BLTErrNotEmpty
+	}
+	mgr.pageZero.setFixedWidth(uint8(keyWidth), uint8(valWidth))
+
+	// the root page and the leaf page have only the stopper key with length bytes
+	var reads, writes uint
+	for pageNo := RootPage; pageNo <= LeafPage; pageNo++ {
+		latch, err := mgr.pinLatch(pageNo, true, &reads, &writes, time.Time{})
+		if latch == nil {
+			return err
+		}
+		mgr.PageLock(LockWrite, latch)
+		mgr.setFixedWidth(mgr.GetRefOfPageAtPool(latch))
+		mgr.markDirty(latch)
+		mgr.PageUnlock(LockWrite, latch)
+		mgr.UnpinLatch(latch)
+	}
+	return BLTErrOk
+}
+
+// FixedWidth returns widths of keys and values set by SetFixedWidth.
+// keyWidth is 0 when fixed width mode isn't turned on
+func (mgr *BufMgr) FixedWidth() (keyWidth int, valWidth int) {
+	k, v := mgr.pageZero.fixedWidth()
+	return int(k), int(v)
+}
+
+// setFixedWidth sets widths of entries of page built from scratch to widths of the tree.
+// pages built from another page copy its widths instead, so that their entries
+// take the same size as they took in the page
+func (mgr *BufMgr) setFixedWidth(page *Page) {
+	page.KeyWidth, page.ValWidth = mgr.pageZero.fixedWidth()
+	if page.KeyWidth > 0 && page.Lvl > 0 {
+		page.ValWidth = BtId
+	}
+}
+
+// fixedWidth returns widths of keys and values persisted in header of page zero
+func (z *PageZero) fixedWidth() (uint8, uint8) {
+	return z.alloc[keyWidthOffset], z.alloc[keyWidthOffset+1]
+}
+
+func (z *PageZero) setFixedWidth(keyWidth uint8, valWidth uint8) {
+	z.alloc[keyWidthOffset], z.alloc[keyWidthOffset+1] = keyWidth, valWidth
+}
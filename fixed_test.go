@@ -0,0 +1,185 @@
+package blink_tree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sync"
+	"testing"
+)
+
+func TestBufMgr_SetFixedWidth(t *testing.T) {
+	pbmPageMap := &sync.Map{}
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(pbmPageMap), nil)
+	if err := mgr.SetFixedWidth(0, 8); err != BLTErrOverflow {
+		t.Errorf("SetFixedWidth() of key width 0 = %v, want %v", err, BLTErrOverflow)
+	}
+	if err := mgr.SetFixedWidth(8, 8); err != BLTErrOk {
+		t.Fatalf("SetFixedWidth() = %v, want %v", err, BLTErrOk)
+	}
+	bltree := NewBLTree(mgr)
+
+	num := uint64(5000)
+	key := func(i uint64) []byte {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		return bs
+	}
+	for i := uint64(0); i < num; i++ {
+		if err := bltree.InsertKey(key(i), 0, key(i+1), true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+
+	// keys and values of other lengths are stored with length bytes
+	others := map[string][]byte{
+		string(key(num)):                      {1, 2, 3},
+		string(key(num + 1)[:5]):              make([]byte, 8),
+		string(bytes.Repeat([]byte{0xff}, 8)): key(7),
+	}
+	for k, v := range others {
+		if err := bltree.InsertKey([]byte(k), 0, v, true); err != BLTErrOk {
+			t.Fatalf("InsertKey(%v) = %v, want %v", []byte(k), err, BLTErrOk)
+		}
+	}
+	// value of other length replaces fixed width entry and goes back to it
+	for _, value := range [][]byte{{9}, key(10), make([]byte, 20), key(11)} {
+		if err := bltree.InsertKey(key(10), 0, value, true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+	if err := bltree.DeleteKey(key(10), 0); err != BLTErrOk {
+		t.Fatalf("DeleteKey() = %v, want %v", err, BLTErrOk)
+	}
+	for i := uint64(1000); i < 2000; i++ {
+		if err := bltree.DeleteKey(key(i), 0); err != BLTErrOk {
+			t.Fatalf("DeleteKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+
+	check := func(when string) {
+		for i := uint64(0); i < num; i++ {
+			ret, _, val := bltree.FindKey(key(i), 8)
+			if deleted := i == 10 || (i >= 1000 && i < 2000); deleted != (ret < 0) {
+				t.Fatalf("FindKey(%v) %s = %v, want found %v", i, when, ret, !deleted)
+			} else if !deleted && !bytes.Equal(val, key(i+1)) {
+				t.Fatalf("FindKey(%v) %s = %v, want %v", i, when, val, key(i+1))
+			}
+		}
+		for k, v := range others {
+			if ret, _, val := bltree.FindKey([]byte(k), len(v)); ret < 0 || !bytes.Equal(val, v) {
+				t.Errorf("FindKey(%v) %s = %v, %v, want %v", []byte(k), when, ret, val, v)
+			}
+		}
+		report, err := ValidateTree(bltree)
+		if err != BLTErrOk || !report.Valid() || report.Keys != num-1001+uint64(len(others)) {
+			t.Errorf("ValidateTree() %s = %v, %v keys, %v, want valid and %v keys", when, report.Problems, report.Keys, err, num-1001+uint64(len(others)))
+		}
+	}
+	check("")
+
+	// the widths can't be changed while the tree has keys
+	if err := mgr.SetFixedWidth(8, 8); err != BLTErrOk {
+		t.Errorf("SetFixedWidth() of the same widths = %v, want %v", err, BLTErrOk)
+	}
+	if err := mgr.SetFixedWidth(8, 4); err != BLTErrNotEmpty {
+		t.Errorf("SetFixedWidth() of other widths = %v, want %v", err, BLTErrNotEmpty)
+	}
+
+	// the widths are persisted
+	mgr.Close()
+	lastPageZeroId := mgr.GetMappedPPageIdOfPageZero()
+	mgr = NewBufMgr(12, 48, NewParentBufMgrDummy(pbmPageMap), &lastPageZeroId)
+	if k, v := mgr.FixedWidth(); k != 8 || v != 8 {
+		t.Errorf("FixedWidth() after restart = %v, %v, want %v, %v", k, v, 8, 8)
+	}
+	bltree = NewBLTree(mgr)
+	check("after restart")
+
+	// a tree which has pages other than those of a new tree can't be set the widths
+	mgr = NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	bltree = NewBLTree(mgr)
+	for i := uint64(0); i < 1000; i++ {
+		bltree.InsertKey(key(i), 0, key(i), true)
+	}
+	for i := uint64(0); i < 1000; i++ {
+		bltree.DeleteKey(key(i), 0)
+	}
+	if err := mgr.SetFixedWidth(8, 8); err != BLTErrNotEmpty {
+		t.Errorf("SetFixedWidth() after splits = %v, want %v", err, BLTErrNotEmpty)
+	}
+}
+
+func TestBLTree_BulkLoad_fixedWidth(t *testing.T) {
+	num := 20000
+	pages := func(fixed bool) uint64 {
+		mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+		if fixed {
+			if err := mgr.SetFixedWidth(8, 8); err != BLTErrOk {
+				t.Fatalf("SetFixedWidth() = %v, want %v", err, BLTErrOk)
+			}
+		}
+		bltree := NewBLTree(mgr)
+		i := 0
+		iter := func() ([]byte, []byte, bool) {
+			if i >= num {
+				return nil, nil, false
+			}
+			bs := make([]byte, 8)
+			binary.BigEndian.PutUint64(bs, uint64(i))
+			i++
+			return bs, bs, true
+		}
+		if loaded, err := bltree.BulkLoad(iter, 1); loaded != num || err != BLTErrOk {
+			t.Fatalf("BulkLoad() = %v, %v, want %v, %v", loaded, err, num, BLTErrOk)
+		}
+		report, err := ValidateTree(bltree)
+		if err != BLTErrOk || !report.Valid() || report.Keys != uint64(num) {
+			t.Fatalf("ValidateTree() = %v, %v keys, %v, want valid and %v keys", report.Problems, report.Keys, err, num)
+		}
+		bs := make([]byte, 8)
+		for j := 0; j < num; j += 97 {
+			binary.BigEndian.PutUint64(bs, uint64(j))
+			if ret, _, val := bltree.FindKey(bs, 8); ret < 0 || !bytes.Equal(val, bs) {
+				t.Fatalf("FindKey(%v) = %v, %v, want %v", j, ret, val, bs)
+			}
+		}
+		return report.Pages
+	}
+
+	// entries without length bytes take fewer pages
+	if fixed, plain := pages(true), pages(false); fixed >= plain {
+		t.Errorf("pages of fixed width tree = %v, want less than %v", fixed, plain)
+	}
+}
+
+func TestBufMgr_SetFixedWidth_longKeys(t *testing.T) {
+	bits := uint8(18)
+	pbm := &parentBufMgrLarge{size: 1 << bits, pages: make(map[int32]*parentPageLarge)}
+	mgr := NewBufMgr(bits, HASH_TABLE_ENTRY_CHAIN_LEN*2, pbm, nil)
+	if err := mgr.SetFixedWidth(8, 8); err != BLTErrOk {
+		t.Fatalf("SetFixedWidth() = %v, want %v", err, BLTErrOk)
+	}
+	if got := mgr.MaxKeySize(); got != MaxLongKey {
+		t.Errorf("MaxKeySize() = %v, want %v", got, MaxLongKey)
+	}
+	bltree := NewBLTree(mgr)
+
+	// lengths of long keys share slot byte with the mark of fixed width entry
+	keys := [][]byte{bytes.Repeat([]byte{1}, 0x7f00), bytes.Repeat([]byte{2}, MaxLongKey), {3, 3, 3, 3, 3, 3, 3, 3}}
+	for i, key := range keys {
+		if err := bltree.InsertKey(key, 0, []byte{byte(i), 0, 0, 0, 0, 0, 0, 0}, true); err != BLTErrOk {
+			t.Fatalf("InsertKey() of %v bytes key = %v, want %v", len(key), err, BLTErrOk)
+		}
+	}
+	for i, key := range keys {
+		if ret, found, val := bltree.FindKey(key, 8); ret < 0 || !bytes.Equal(found, key) || val[0] != byte(i) {
+			t.Errorf("FindKey() of %v bytes key = %v, %v bytes, %v, want %v bytes", len(key), ret, len(found), val, len(key))
+		}
+	}
+	if report, err := ValidateTree(bltree); err != BLTErrOk || !report.Valid() || report.Keys != uint64(len(keys)) {
+		t.Errorf("ValidateTree() = %v, %v keys, %v, want valid and %v keys", report.Problems, report.Keys, err, len(keys))
+	}
+	if err := bltree.InsertKey(bytes.Repeat([]byte{4}, MaxLongKey+1), 0, make([]byte, 8), true); err != BLTErrOverflow {
+		t.Errorf("InsertKey() of %v bytes key = %v, want %v", MaxLongKey+1, err, BLTErrOverflow)
+	}
+}
@@ -0,0 +1,59 @@
+package blink_tree
+
+// SetSlotFlags sets application-defined flags of unique key. only bits of
+// SlotFlagsMask are kept. flags are kept while the key is moved by cleanup,
+// split or merge of pages, and they are cleared when value of the key is
+// written again or the key is deleted. BLTErrNotFound is returned when key
+// doesn't exist
+func (tree *BLTree) SetSlotFlags(key []byte, flags uint8) BLTErr {
+	var set PageSet
+
+	tree.startOp()
+
+	key, tree.err = encodeKey(key)
+	if tree.err != BLTErrOk {
+		return tree.err
+	}
+
+	slot, err := tree.mgr.pageFetch(&set, key, 0, LockWrite, &tree.reads, &tree.writes, tree.deadline)
+	if slot == 0 {
+		if err == BLTErrOk {
+			err = BLTErrStruct
+		}
+		tree.err = err
+		return err
+	}
+	tree.mgr.recordAccess(key, set.latch.pageNo)
+
+	// if librarian slot, advance to real slot
+	if set.page.Typ(slot) == Librarian {
+		slot++
+	}
+
+	found := tree.mgr.compareKeys(set.page.Key(slot), key) == 0 &&
+		!set.page.Dead(slot) && !tree.mgr.expired(set.page, slot)
+	if found {
+		set.page.setSlotFlags(slot, flags)
+		tree.markDirty(set.latch)
+	}
+
+	tree.mgr.PageUnlock(LockWrite, set.latch)
+	tree.mgr.UnpinLatch(set.latch)
+	tree.mgr.enforceDirtyQuota(&tree.reads, &tree.writes)
+
+	if !found {
+		tree.err = BLTErrNotFound
+		return tree.err
+	}
+	return BLTErrOk
+}
+
+// SlotFlags returns application-defined flags of unique key or first duplicate key
+// set by SetSlotFlags. found is false when key doesn't exist
+func (tree *BLTree) SlotFlags(key []byte) (flags uint8, found bool) {
+	tree.findKey(key, false, func(page *Page, slot uint32) {
+		flags = page.SlotFlags(slot)
+		found = true
+	})
+	return flags, found
+}
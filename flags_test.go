@@ -0,0 +1,66 @@
+package blink_tree
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestBLTree_SlotFlags(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	bltree := NewBLTree(mgr)
+
+	if err := bltree.SetSlotFlags([]byte{1}, 1); err != BLTErrNotFound {
+		t.Errorf("SetSlotFlags() of missing key = %v, want %v", err, BLTErrNotFound)
+	}
+
+	num := uint64(2000)
+	key := func(i uint64) []byte {
+		k := make([]byte, 8)
+		binary.BigEndian.PutUint64(k, i)
+		return k
+	}
+	for i := uint64(0); i < num; i += 2 {
+		if err := bltree.InsertKey(key(i), 0, key(i), true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+		if err := bltree.SetSlotFlags(key(i), uint8(i%SlotFlagsMask)+1); err != BLTErrOk {
+			t.Fatalf("SetSlotFlags() = %v, want %v", err, BLTErrOk)
+		}
+	}
+	// pages are split and cleaned by keys inserted between flagged keys
+	for i := uint64(1); i < num; i += 2 {
+		if err := bltree.InsertKey(key(i), 0, key(i), true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+
+	for i := uint64(0); i < num; i++ {
+		want := uint8(0)
+		if i%2 == 0 {
+			want = uint8(i%SlotFlagsMask) + 1
+		}
+		if flags, found := bltree.SlotFlags(key(i)); !found || flags != want {
+			t.Errorf("SlotFlags(%d) = %v, %v, want %v, true", i, flags, found, want)
+		}
+	}
+
+	// flags are cleared when value is written again or key is deleted
+	if err := bltree.InsertKey(key(0), 0, key(1), true); err != BLTErrOk {
+		t.Errorf("InsertKey() = %v, want %v", err, BLTErrOk)
+	}
+	if flags, _ := bltree.SlotFlags(key(0)); flags != 0 {
+		t.Errorf("SlotFlags() after update = %v, want 0", flags)
+	}
+	if err := bltree.DeleteKey(key(2), 0); err != BLTErrOk {
+		t.Errorf("DeleteKey() = %v, want %v", err, BLTErrOk)
+	}
+	if _, found := bltree.SlotFlags(key(2)); found {
+		t.Errorf("SlotFlags() of deleted key found")
+	}
+	if err := bltree.SetSlotFlags(key(2), 1); err != BLTErrNotFound {
+		t.Errorf("SetSlotFlags() of deleted key = %v, want %v", err, BLTErrNotFound)
+	}
+	if _, _, val := bltree.FindKey(key(4), 8); binary.BigEndian.Uint64(val) != 4 {
+		t.Errorf("FindKey() = %v, want 4", val)
+	}
+}
@@ -0,0 +1,63 @@
+package blink_tree
+
+import "sync/atomic"
+
+// GarbageStats is garbage of pages of a tree. garbage is the part of data area
+// of a page which is used by neither live keys nor key prefix, e.g. deleted keys.
+// it's reclaimed when the page is cleaned up or split
+type GarbageStats struct {
+	Pages        uint64 // count of walked pages
+	GarbagePages uint64 // count of pages which have garbage
+	Bytes        uint64 // garbage bytes of all pages
+	LeafBytes    uint64 // garbage bytes of leaf pages
+}
+
+// GarbageStats walks all pages of the tree level by level and sums up their garbage.
+// garbage recorded in each page header is verified against its slots and
+// BLTErrStruct is returned when they don't match.
+// ATTENTION: like RangeScan, this method call is not atomic with other tree operations
+func (tree *BLTree) GarbageStats() (GarbageStats, BLTErr) {
+	var stats GarbageStats
+
+	tree.startOp()
+
+	// leftmost page of the level
+	pageNo := RootPage
+	for pageNo > 0 {
+		var err BLTErr
+		pageNo, err = tree.walkLevel(pageNo, func(_ Uid, page *Page) (bool, BLTErr) {
+			if page.Garbage != page.countGarbage() {
+				return false, BLTErrStruct
+			}
+			stats.Pages++
+			if page.Garbage > 0 {
+				stats.GarbagePages++
+				stats.Bytes += uint64(page.Garbage)
+				if page.Lvl == 0 {
+					stats.LeafBytes += uint64(page.Garbage)
+				}
+			}
+			return true, BLTErrOk
+		})
+		if err != BLTErrOk {
+			return stats, err
+		}
+	}
+
+	return stats, BLTErrOk
+}
+
+// SetCleanupThreshold sets fraction of data area of a page which must be free
+// after the page is cleaned up. when a key doesn't fit in a page, its garbage is
+// reclaimed by the cleanup only if it leaves free out of the data area, and otherwise
+// the page is split. pages merged on underflow leave it free likewise.
+// larger free splits pages earlier and leaves more room for later inserts.
+// free is bounded to [0.1, 0.5], and it's 0.2 by default
+func (mgr *BufMgr) SetCleanupThreshold(free float64) {
+	if free < 0.1 {
+		free = 0.1
+	} else if free > 0.5 {
+		free = 0.5
+	}
+	atomic.StoreUint32(&mgr.cleanupBytes, uint32(float64(mgr.pageDataSize)*free))
+}
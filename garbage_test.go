@@ -0,0 +1,125 @@
+package blink_tree
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestBLTree_GarbageStats(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	bltree := NewBLTree(mgr)
+
+	keyOf := func(i int) []byte {
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, uint64(i))
+		return key
+	}
+
+	for i := 0; i < 100; i++ {
+		if err := bltree.InsertKey(keyOf(i), 0, make([]byte, BtId), true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+	if stats, err := bltree.GarbageStats(); err != BLTErrOk || stats.Bytes != 0 {
+		t.Errorf("GarbageStats() = %+v, %v, want no garbage", stats, err)
+	}
+
+	// each deleted entry leaves its key and value with length prefixes
+	for i := 0; i < 10; i++ {
+		if err := bltree.DeleteKey(keyOf(i*3), 0); err != BLTErrOk {
+			t.Fatalf("DeleteKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+	stats, err := bltree.GarbageStats()
+	if err != BLTErrOk {
+		t.Fatalf("GarbageStats() = %v, want %v", err, BLTErrOk)
+	}
+	if want := uint64(10 * (1 + 8 + 1 + BtId)); stats.Bytes != want || stats.LeafBytes != want || stats.GarbagePages != 1 {
+		t.Errorf("GarbageStats() = %+v, want %v bytes on a leaf page", stats, want)
+	}
+
+	// re-inserting a deleted key reuses its entry
+	if err := bltree.InsertKey(keyOf(0), 0, []byte{1, 0, 0, 0, 0, 0}, true); err != BLTErrOk {
+		t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+	}
+	if stats, _ := bltree.GarbageStats(); stats.Bytes != uint64(9*(1+8+1+BtId)) {
+		t.Errorf("GarbageStats().Bytes after re-insert = %v, want %v", stats.Bytes, 9*(1+8+1+BtId))
+	}
+
+	// garbage stays consistent through cleanups and splits
+	for i := 0; i < 20000; i++ {
+		if err := bltree.InsertKey(keyOf(i%3000), 0, make([]byte, BtId), true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+		if i%3 == 0 {
+			if err := bltree.DeleteKey(keyOf((i*7)%3000), 0); err != BLTErrOk {
+				t.Fatalf("DeleteKey() = %v, want %v", err, BLTErrOk)
+			}
+		}
+	}
+	if stats, err := bltree.GarbageStats(); err != BLTErrOk || stats.Pages < 2 {
+		t.Errorf("GarbageStats() = %+v, %v, want consistent garbage of multiple pages", stats, err)
+	}
+
+	// broken garbage accounting is detected
+	latch := mgr.PinLatch(RootPage, true, &bltree.reads, &bltree.writes)
+	mgr.GetRefOfPageAtPool(latch).Garbage++
+	mgr.UnpinLatch(latch)
+	if _, err := bltree.GarbageStats(); err != BLTErrStruct {
+		t.Errorf("GarbageStats() of broken page = %v, want %v", err, BLTErrStruct)
+	}
+}
+
+func TestBufMgr_SetCleanupThreshold(t *testing.T) {
+	keyOf := func(i int) []byte {
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, uint64(i))
+		return key
+	}
+
+	pagesOf := func(free float64) uint64 {
+		mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+		if free > 0 {
+			mgr.SetCleanupThreshold(free)
+		}
+		bltree := NewBLTree(mgr)
+
+		num := 5000
+		for i := 0; i < num; i++ {
+			if err := bltree.InsertKey(keyOf(i*7919%num), 0, make([]byte, BtId), true); err != BLTErrOk {
+				t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+			}
+			if i%4 == 0 {
+				if err := bltree.DeleteKey(keyOf(i*7919%num), 0); err != BLTErrOk {
+					t.Fatalf("DeleteKey() = %v, want %v", err, BLTErrOk)
+				}
+			}
+		}
+		for i := 0; i < num; i++ {
+			want := BtId
+			if i%4 == 0 {
+				want = -1
+			}
+			if ret, _, _ := bltree.FindKey(keyOf(i*7919%num), BtId); ret != want {
+				t.Errorf("FindKey(%v) = %v, want %v", i*7919%num, ret, want)
+			}
+		}
+		if _, err := bltree.GarbageStats(); err != BLTErrOk {
+			t.Errorf("GarbageStats() = %v, want %v", err, BLTErrOk)
+		}
+		report, err := ValidateTree(bltree)
+		if err != BLTErrOk || !report.Valid() {
+			t.Fatalf("ValidateTree() = %v, %v, want valid tree", report, err)
+		}
+		return report.Pages
+	}
+
+	// pages are split earlier to leave more free space
+	if def, half := pagesOf(0), pagesOf(0.5); half <= def {
+		t.Errorf("tree has %v pages with threshold 0.5, want more than %v pages by default", half, def)
+	}
+	// out of range threshold is bounded
+	if low, bounded := pagesOf(0.01), pagesOf(0.1); low != bounded {
+		t.Errorf("tree has %v pages with threshold 0.01, want %v pages", low, bounded)
+	}
+}
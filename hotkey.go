@@ -0,0 +1,207 @@
+package blink_tree
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	HotKeyDefaultCapacity   = 64 // default count of tracked keys and pages
+	HotKeyDefaultSampleRate = 16 // default sampling rate (1 of N accesses is recorded)
+)
+
+type (
+	// HotKey is a frequently accessed key reported by TopKeys
+	HotKey struct {
+		Key   []byte
+		Count uint64 // estimated access count (upper bound)
+		Err   uint64 // max overestimation of Count
+	}
+
+	// HotPage is a frequently accessed leaf page reported by TopPages
+	HotPage struct {
+		PageNo Uid
+		Count  uint64 // estimated access count (upper bound)
+		Err    uint64 // max overestimation of Count
+	}
+
+	// HotKeySketch tracks most frequently accessed keys and leaf pages
+	// with Space-Saving algorithm. only sampled accesses are recorded
+	// to keep overhead of point operations low.
+	HotKeySketch struct {
+		mu         sync.Mutex
+		sampleRate uint32
+		accessCnt  uint32
+		keys       *spaceSaving[string]
+		pages      *spaceSaving[Uid]
+	}
+
+	// spaceSaving is counters of Space-Saving algorithm
+	spaceSaving[K comparable] struct {
+		capacity int
+		counters map[K]*ssCounter
+	}
+
+	ssCounter struct {
+		count uint64
+		err   uint64
+	}
+)
+
+// NewHotKeySketch creates a sketch which tracks capacity keys and pages.
+// 1 of sampleRate accesses is recorded
+func NewHotKeySketch(capacity int, sampleRate uint32) *HotKeySketch {
+	if capacity <= 0 {
+		capacity = HotKeyDefaultCapacity
+	}
+	if sampleRate == 0 {
+		sampleRate = 1
+	}
+	return &HotKeySketch{
+		sampleRate: sampleRate,
+		keys:       newSpaceSaving[string](capacity),
+		pages:      newSpaceSaving[Uid](capacity),
+	}
+}
+
+// Record records an access to key on leaf page pageNo
+func (h *HotKeySketch) Record(key []byte, pageNo Uid) {
+	if h.sampleRate > 1 && atomic.AddUint32(&h.accessCnt, 1)%h.sampleRate != 0 {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.keys.add(string(key))
+	h.pages.add(pageNo)
+}
+
+// TopKeys returns at most n keys in descending order of access count.
+// counts are scaled by sampling rate
+func (h *HotKeySketch) TopKeys(n int) []HotKey {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	top := h.keys.top(n)
+	ret := make([]HotKey, len(top))
+	for i, k := range top {
+		c := h.keys.counters[k]
+		ret[i] = HotKey{Key: []byte(k), Count: c.count * uint64(h.sampleRate), Err: c.err * uint64(h.sampleRate)}
+	}
+	return ret
+}
+
+// TopPages returns at most n leaf pages in descending order of access count.
+// counts are scaled by sampling rate
+func (h *HotKeySketch) TopPages(n int) []HotPage {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	top := h.pages.top(n)
+	ret := make([]HotPage, len(top))
+	for i, pageNo := range top {
+		c := h.pages.counters[pageNo]
+		ret[i] = HotPage{PageNo: pageNo, Count: c.count * uint64(h.sampleRate), Err: c.err * uint64(h.sampleRate)}
+	}
+	return ret
+}
+
+// Reset clears all counters
+func (h *HotKeySketch) Reset() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.keys = newSpaceSaving[string](h.keys.capacity)
+	h.pages = newSpaceSaving[Uid](h.pages.capacity)
+}
+
+func newSpaceSaving[K comparable](capacity int) *spaceSaving[K] {
+	return &spaceSaving[K]{
+		capacity: capacity,
+		counters: make(map[K]*ssCounter, capacity),
+	}
+}
+
+// add increments counter of k. when all counters are used,
+// counter with minimum count is taken over by k
+func (s *spaceSaving[K]) add(k K) {
+	if c, ok := s.counters[k]; ok {
+		c.count++
+		return
+	}
+	if len(s.counters) < s.capacity {
+		s.counters[k] = &ssCounter{count: 1}
+		return
+	}
+
+	var minKey K
+	var minCounter *ssCounter
+	for key, c := range s.counters {
+		if minCounter == nil || c.count < minCounter.count {
+			minKey = key
+			minCounter = c
+		}
+	}
+	delete(s.counters, minKey)
+	s.counters[k] = &ssCounter{count: minCounter.count + 1, err: minCounter.count}
+}
+
+// top returns at most n keys in descending order of count
+func (s *spaceSaving[K]) top(n int) []K {
+	keys := make([]K, 0, len(s.counters))
+	for k := range s.counters {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return s.counters[keys[i]].count > s.counters[keys[j]].count
+	})
+	if n >= 0 && n < len(keys) {
+		keys = keys[:n]
+	}
+	return keys
+}
+
+// EnableHotKeyTracking starts tracking of frequently accessed keys and leaf pages
+// on point operations (FindKey, InsertKey and DeleteKey). tracked counts are
+// shared by all BLTree handles using this BufMgr.
+// capacity is count of tracked keys and 1 of sampleRate accesses is recorded
+func (mgr *BufMgr) EnableHotKeyTracking(capacity int, sampleRate uint32) {
+	mgr.hotKeys.Store(NewHotKeySketch(capacity, sampleRate))
+}
+
+// DisableHotKeyTracking stops tracking and discards tracked counts
+func (mgr *BufMgr) DisableHotKeyTracking() {
+	mgr.hotKeys.Store(nil)
+}
+
+// TopKeys returns at most n most frequently accessed keys.
+// returns nil when hot key tracking is disabled
+func (mgr *BufMgr) TopKeys(n int) []HotKey {
+	if h := mgr.hotKeys.Load(); h != nil {
+		// keys are recorded as stored in pages
+		keys := h.TopKeys(n)
+		for i := range keys {
+			keys[i].Key = decodeKey(keys[i].Key)
+		}
+		return keys
+	}
+	return nil
+}
+
+// TopPages returns at most n most frequently accessed leaf pages.
+// returns nil when hot key tracking is disabled
+func (mgr *BufMgr) TopPages(n int) []HotPage {
+	if h := mgr.hotKeys.Load(); h != nil {
+		return h.TopPages(n)
+	}
+	return nil
+}
+
+// recordAccess records an access to key on leaf page pageNo if tracking is enabled
+func (mgr *BufMgr) recordAccess(key []byte, pageNo Uid) {
+	if h := mgr.hotKeys.Load(); h != nil {
+		h.Record(key, pageNo)
+	}
+}
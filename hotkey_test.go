@@ -0,0 +1,68 @@
+package blink_tree
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestHotKeySketch_TopKeys(t *testing.T) {
+	h := NewHotKeySketch(4, 1)
+	// "hot" is accessed many times among many cold keys
+	for i := 0; i < 100; i++ {
+		h.Record([]byte("hot"), 1)
+		h.Record([]byte{byte(i)}, Uid(2+i%3))
+	}
+
+	top := h.TopKeys(1)
+	if len(top) != 1 || !bytes.Equal(top[0].Key, []byte("hot")) {
+		t.Fatalf("TopKeys(1) = %v, want key %q", top, "hot")
+	}
+	if top[0].Count-top[0].Err > 100 || top[0].Count < 100 {
+		t.Errorf("TopKeys(1) count = %v (err %v), want 100 in range", top[0].Count, top[0].Err)
+	}
+	if got := len(h.TopKeys(10)); got != 4 {
+		t.Errorf("len(TopKeys(10)) = %v, want %v", got, 4)
+	}
+
+	pages := h.TopPages(1)
+	if len(pages) != 1 || pages[0].PageNo != 1 {
+		t.Errorf("TopPages(1) = %v, want page %v", pages, 1)
+	}
+
+	h.Reset()
+	if got := h.TopKeys(10); len(got) != 0 {
+		t.Errorf("TopKeys() after Reset = %v, want empty", got)
+	}
+}
+
+func TestBufMgr_HotKeyTracking(t *testing.T) {
+	mgr := NewBufMgr(12, 20, NewParentBufMgrDummy(nil), nil)
+	bltree := NewBLTree(mgr)
+
+	if got := mgr.TopKeys(1); got != nil {
+		t.Errorf("TopKeys() when disabled = %v, want nil", got)
+	}
+
+	mgr.EnableHotKeyTracking(8, 1)
+	for i := 0; i < 20; i++ {
+		if err := bltree.InsertKey([]byte{byte(i)}, 0, make([]byte, BtId), true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+	for i := 0; i < 50; i++ {
+		bltree.FindKey([]byte{7}, BtId)
+	}
+
+	top := mgr.TopKeys(1)
+	if len(top) != 1 || !bytes.Equal(top[0].Key, []byte{7}) {
+		t.Errorf("TopKeys(1) = %v, want key %v", top, []byte{7})
+	}
+	if pages := mgr.TopPages(1); len(pages) != 1 || pages[0].Count < 50 {
+		t.Errorf("TopPages(1) = %v, want a page accessed at least 50 times", pages)
+	}
+
+	mgr.DisableHotKeyTracking()
+	if got := mgr.TopPages(1); got != nil {
+		t.Errorf("TopPages() after disable = %v, want nil", got)
+	}
+}
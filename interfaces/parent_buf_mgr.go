@@ -6,3 +6,12 @@ type ParentBufMgr interface {
 	NewPPage() ParentPage
 	DeallocatePPage(pageID int32, isNoWait bool) error
 }
+
+// ParentBufMgrWithLSN is optionally implemented by ParentBufMgr.
+// when it's implemented, BufMgr calls UnpinPPageWithLSN instead of UnpinPPage
+// on writing back a dirty page. lsn is the largest LSN which was attached
+// to changes of the page (0 if no LSN was attached), so that WAL can be
+// flushed up to lsn before the page is persisted.
+type ParentBufMgrWithLSN interface {
+	UnpinPPageWithLSN(pageID int32, isDirty bool, lsn uint64) error
+}
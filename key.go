@@ -0,0 +1,112 @@
+package blink_tree
+
+// user keys are stored with order-preserving escaping so that every stored key
+// sorts before the infinite stopper key {0xff, 0xff}.
+//
+// keys which don't start with 0xff are stored as is. in keys which start with 0xff,
+// bytes after the first byte are escaped as below so that the second byte of
+// stored key is never 0xff.
+//
+//	0x00-0xfd -> as is
+//	0xfe      -> 0xfe 0x00
+//	0xff      -> 0xfe 0x01
+const (
+	keyEscapeByte = 0xfe
+	keyEscapedFE  = 0x00
+	keyEscapedFF  = 0x01
+)
+
+// KeyValidator checks a user key and returns error to reject it
+type KeyValidator func(key []byte) error
+
+// SetKeyValidator sets validator which is called with each key passed to
+// InsertKey, DeleteKey and DeleteBatch before the tree is accessed.
+// rejected keys make these methods return BLTErrKey without any change.
+// keys for lookup (FindKey, RangeScan, ...) are not validated.
+// nil removes the validator. it must be set before any operation on the tree
+func (mgr *BufMgr) SetKeyValidator(validator KeyValidator) {
+	mgr.keyValidator = validator
+}
+
+// validateKey calls key validator if it is set
+func (mgr *BufMgr) validateKey(key []byte) BLTErr {
+	if mgr.keyValidator != nil && mgr.keyValidator(key) != nil {
+		return BLTErrKey
+	}
+	return BLTErrOk
+}
+
+// WriteValidator checks a write of leaf key before it's done and returns error
+// to veto it. op is ChangeInsert with value to be stored, or ChangeDelete with nil value
+type WriteValidator func(op ChangeOp, key []byte, value []byte) error
+
+// SetWriteValidator sets validator which is called with each key and value
+// written by InsertKey, InsertDuplicate, Merge, InsertBatch, BulkLoad and Write,
+// and each key deleted by DeleteKey, DeleteDuplicate, DeleteBatch and Write.
+// vetoed writes make these methods return BLTErrRejected. a vetoed key of
+// InsertBatch, DeleteBatch or Write rejects the whole batch, while BulkLoad stops
+// at the vetoed key like other errors. value computed by Merge is validated
+// while the leaf page is write locked, so validator must not call methods of BLTree.
+// keys deleted by DeleteRange, DeletePrefix and PurgeExpired are not validated.
+// nil removes the validator. it must be set before any operation on the tree
+func (mgr *BufMgr) SetWriteValidator(validator WriteValidator) {
+	mgr.writeValidator = validator
+}
+
+// validateWrite calls write validator if it is set
+func (mgr *BufMgr) validateWrite(op ChangeOp, key []byte, value []byte) BLTErr {
+	if mgr.writeValidator != nil && mgr.writeValidator(op, key, value) != nil {
+		return BLTErrRejected
+	}
+	return BLTErrOk
+}
+
+// encodeKey returns key which is stored in page for user key
+func encodeKey(key []byte) ([]byte, BLTErr) {
+	if len(key) == 0 || key[0] != 0xff {
+		if len(key) > MaxLongKey {
+			return nil, BLTErrOverflow
+		}
+		return key, BLTErrOk
+	}
+
+	encoded := make([]byte, 1, len(key)+4)
+	encoded[0] = 0xff
+	for _, b := range key[1:] {
+		switch b {
+		case 0xfe:
+			encoded = append(encoded, keyEscapeByte, keyEscapedFE)
+		case 0xff:
+			encoded = append(encoded, keyEscapeByte, keyEscapedFF)
+		default:
+			encoded = append(encoded, b)
+		}
+	}
+	if len(encoded) > MaxLongKey {
+		return nil, BLTErrOverflow
+	}
+	return encoded, BLTErrOk
+}
+
+// decodeKey returns user key of key stored in page
+func decodeKey(key []byte) []byte {
+	if len(key) == 0 || key[0] != 0xff {
+		return key
+	}
+
+	decoded := make([]byte, 1, len(key))
+	decoded[0] = 0xff
+	for i := 1; i < len(key); i++ {
+		if key[i] == keyEscapeByte && i+1 < len(key) {
+			i++
+			if key[i] == keyEscapedFF {
+				decoded = append(decoded, 0xff)
+			} else {
+				decoded = append(decoded, 0xfe)
+			}
+			continue
+		}
+		decoded = append(decoded, key[i])
+	}
+	return decoded
+}
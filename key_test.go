@@ -0,0 +1,291 @@
+package blink_tree
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+)
+
+func TestEncodeKey(t *testing.T) {
+	keys := [][]byte{
+		{},
+		{0x00},
+		{0x01, 0xff},
+		{0xfe},
+		{0xfe, 0xff, 0xff},
+		{0xff},
+		{0xff, 0x00},
+		{0xff, 0xfd},
+		{0xff, 0xfe},
+		{0xff, 0xfe, 0x00},
+		{0xff, 0xfe, 0x01},
+		{0xff, 0xfe, 0xff},
+		{0xff, 0xff},
+		{0xff, 0xff, 0x00},
+		{0xff, 0xff, 0xff},
+	}
+
+	var encoded [][]byte
+	for _, key := range keys {
+		enc, err := encodeKey(key)
+		if err != BLTErrOk {
+			t.Fatalf("encodeKey(%v) = %v, want %v", key, err, BLTErrOk)
+		}
+		if KeyCmp(enc, []byte{0xff, 0xff}) >= 0 {
+			t.Errorf("encodeKey(%v) = %v, want less than stopper key", key, enc)
+		}
+		if got := decodeKey(enc); !bytes.Equal(got, key) {
+			t.Errorf("decodeKey(encodeKey(%v)) = %v, want %v", key, got, key)
+		}
+		encoded = append(encoded, enc)
+	}
+
+	// keys are listed in ascending order and encoding must preserve it
+	if !sort.SliceIsSorted(encoded, func(i, j int) bool { return bytes.Compare(encoded[i], encoded[j]) < 0 }) {
+		t.Errorf("encodeKey() doesn't preserve order: %v", encoded)
+	}
+	for i := 1; i < len(encoded); i++ {
+		if bytes.Equal(encoded[i-1], encoded[i]) {
+			t.Errorf("encodeKey(%v) = encodeKey(%v) = %v", keys[i-1], keys[i], encoded[i])
+		}
+	}
+
+	if _, err := encodeKey(bytes.Repeat([]byte{0xff}, MaxLongKey/2+2)); err != BLTErrOverflow {
+		t.Errorf("encodeKey() of too long escaped key = %v, want %v", err, BLTErrOverflow)
+	}
+}
+
+func TestBLTree_reservedKeys(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	bltree := NewBLTree(mgr)
+
+	keys := [][]byte{
+		{0xff, 0xff, 0xff},
+		{0xff, 0xff},
+		{0xff, 0xfe},
+		{0xff},
+		{0x01},
+	}
+	for i, key := range keys {
+		if err := bltree.InsertKey(key, 0, []byte{byte(i), 0, 0, 0, 0, 0}, true); err != BLTErrOk {
+			t.Fatalf("InsertKey(%v) = %v, want %v", key, err, BLTErrOk)
+		}
+	}
+	// enough keys to split leaf pages
+	for i := 0; i < 2000; i++ {
+		key := []byte{0xff, 0xff, byte(i >> 8), byte(i)}
+		if err := bltree.InsertKey(key, 0, make([]byte, BtId), true); err != BLTErrOk {
+			t.Fatalf("InsertKey(%v) = %v, want %v", key, err, BLTErrOk)
+		}
+	}
+
+	for i, key := range keys {
+		ret, foundKey, foundValue := bltree.FindKey(key, BtId)
+		if ret != BtId || !bytes.Equal(foundKey, key) || foundValue[0] != byte(i) {
+			t.Errorf("FindKey(%v) = %v, %v, %v, want %v, %v, %v", key, ret, foundKey, foundValue, BtId, key, byte(i))
+		}
+	}
+
+	num, retKeys, _ := bltree.RangeScan([]byte{0xff}, []byte{0xff, 0xff, 0xff})
+	if num != 2004 {
+		t.Errorf("RangeScan() num = %v, want %v", num, 2004)
+	}
+	if !sort.SliceIsSorted(retKeys, func(i, j int) bool { return bytes.Compare(retKeys[i], retKeys[j]) < 0 }) {
+		t.Errorf("RangeScan() keys are not sorted")
+	}
+	if len(retKeys) > 0 && !bytes.Equal(retKeys[len(retKeys)-1], []byte{0xff, 0xff, 0xff}) {
+		t.Errorf("RangeScan() last key = %v, want %v", retKeys[len(retKeys)-1], []byte{0xff, 0xff, 0xff})
+	}
+
+	if err := bltree.DeleteKey([]byte{0xff, 0xff}, 0); err != BLTErrOk {
+		t.Errorf("DeleteKey() = %v, want %v", err, BLTErrOk)
+	}
+	if ret, _, _ := bltree.FindKey([]byte{0xff, 0xff}, BtId); ret != -1 {
+		t.Errorf("FindKey() after DeleteKey = %v, want %v", ret, -1)
+	}
+	if ret, _, _ := bltree.FindKey([]byte{0xff, 0xff, 0xff}, BtId); ret != BtId {
+		t.Errorf("FindKey() of neighbour key = %v, want %v", ret, BtId)
+	}
+}
+
+func TestBufMgr_SetKeyValidator(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	bltree := NewBLTree(mgr)
+
+	if err := bltree.InsertKey([]byte{1, 2, 3}, 0, make([]byte, BtId), true); err != BLTErrOk {
+		t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+	}
+
+	// only keys of 4 bytes are valid
+	mgr.SetKeyValidator(func(key []byte) error {
+		if len(key) != 4 {
+			return errors.New("invalid key length")
+		}
+		return nil
+	})
+
+	if err := bltree.InsertKey([]byte{1, 2}, 0, make([]byte, BtId), true); err != BLTErrKey {
+		t.Errorf("InsertKey() of invalid key = %v, want %v", err, BLTErrKey)
+	}
+	if ret, _, _ := bltree.FindKey([]byte{1, 2}, BtId); ret != -1 {
+		t.Errorf("FindKey() of rejected key = %v, want %v", ret, -1)
+	}
+	if err := bltree.InsertKey([]byte{1, 2, 3, 4}, 0, make([]byte, BtId), true); err != BLTErrOk {
+		t.Errorf("InsertKey() of valid key = %v, want %v", err, BLTErrOk)
+	}
+
+	if err := bltree.DeleteKey([]byte{1, 2, 3}, 0); err != BLTErrKey {
+		t.Errorf("DeleteKey() of invalid key = %v, want %v", err, BLTErrKey)
+	}
+	if ret, _, _ := bltree.FindKey([]byte{1, 2, 3}, BtId); ret != BtId {
+		t.Errorf("FindKey() after rejected DeleteKey() = %v, want %v", ret, BtId)
+	}
+	if _, err := bltree.DeleteBatch([][]byte{{1, 2, 3, 4}, {1, 2, 3}}); err != BLTErrKey {
+		t.Errorf("DeleteBatch() with invalid key = %v, want %v", err, BLTErrKey)
+	}
+	if ret, _, _ := bltree.FindKey([]byte{1, 2, 3, 4}, BtId); ret != BtId {
+		t.Errorf("FindKey() after rejected DeleteBatch() = %v, want %v", ret, BtId)
+	}
+
+	mgr.SetKeyValidator(nil)
+	if err := bltree.DeleteKey([]byte{1, 2, 3}, 0); err != BLTErrOk {
+		t.Errorf("DeleteKey() without validator = %v, want %v", err, BLTErrOk)
+	}
+}
+
+func TestBufMgr_SetWriteValidator(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	bltree := NewBLTree(mgr)
+
+	if err := bltree.InsertKey([]byte{0, 1}, 0, []byte{1}, true); err != BLTErrOk {
+		t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+	}
+
+	// values of keys beginning with 0 are up to 2 bytes and the keys can't be deleted
+	mgr.SetWriteValidator(func(op ChangeOp, key []byte, value []byte) error {
+		if key[0] != 0 {
+			return nil
+		}
+		if op == ChangeDelete {
+			return errors.New("undeletable key")
+		}
+		if len(value) > 2 {
+			return errors.New("too long value")
+		}
+		return nil
+	})
+
+	if err := bltree.InsertKey([]byte{0, 2}, 0, []byte{1, 2, 3}, true); err != BLTErrRejected {
+		t.Errorf("InsertKey() of vetoed value = %v, want %v", err, BLTErrRejected)
+	}
+	if err := bltree.InsertKey([]byte{0, 2}, 0, []byte{1, 2}, true); err != BLTErrOk {
+		t.Errorf("InsertKey() = %v, want %v", err, BLTErrOk)
+	}
+	if err := bltree.InsertKey([]byte{1}, 0, []byte{1, 2, 3}, true); err != BLTErrOk {
+		t.Errorf("InsertKey() of other key = %v, want %v", err, BLTErrOk)
+	}
+	if err := bltree.InsertBatch([][]byte{{2}, {0, 3}}, [][]byte{{1}, {1, 2, 3}}); err != BLTErrRejected {
+		t.Errorf("InsertBatch() with vetoed value = %v, want %v", err, BLTErrRejected)
+	}
+	if ret, _, _ := bltree.FindKey([]byte{2}, BtId); ret != -1 {
+		t.Errorf("FindKey() after rejected InsertBatch() = %v, want %v", ret, -1)
+	}
+	mgr.SetMergeOperator(func(_ []byte, old []byte, _ bool, operand []byte) []byte {
+		return append(append([]byte{}, old...), operand...)
+	})
+	if err := bltree.InsertMerge([]byte{0, 2}, []byte{3}); err != BLTErrRejected {
+		t.Errorf("InsertMerge() making vetoed value = %v, want %v", err, BLTErrRejected)
+	}
+
+	if err := bltree.DeleteKey([]byte{0, 1}, 0); err != BLTErrRejected {
+		t.Errorf("DeleteKey() of vetoed key = %v, want %v", err, BLTErrRejected)
+	}
+	var batch WriteBatch
+	batch.Delete([]byte{1})
+	batch.Delete([]byte{0, 2})
+	if err := bltree.Write(&batch); err != BLTErrRejected {
+		t.Errorf("Write() with vetoed delete = %v, want %v", err, BLTErrRejected)
+	}
+	for _, key := range [][]byte{{0, 1}, {0, 2}, {1}} {
+		if ret, _, _ := bltree.FindKey(key, BtId); ret < 0 {
+			t.Errorf("FindKey(%v) after rejected writes = %v, want found", key, ret)
+		}
+	}
+	if _, _, val := bltree.FindKey([]byte{0, 2}, BtId); !bytes.Equal(val, []byte{1, 2}) {
+		t.Errorf("FindKey() after rejected InsertMerge() = %v, want %v", val, []byte{1, 2})
+	}
+
+	mgr.SetWriteValidator(nil)
+	if err := bltree.DeleteKey([]byte{0, 1}, 0); err != BLTErrOk {
+		t.Errorf("DeleteKey() after validator is removed = %v, want %v", err, BLTErrOk)
+	}
+}
+
+func TestBLTree_longKeys(t *testing.T) {
+	pbmPageMap := &sync.Map{}
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(pbmPageMap), nil)
+	bltree := NewBLTree(mgr)
+	if max := mgr.MaxKeySize(); max <= MaxKey {
+		t.Fatalf("MaxKeySize() = %v, want more than %v", max, MaxKey)
+	}
+	if max := NewBufMgr(9, 48, NewParentBufMgrDummy(nil), nil).MaxKeySize(); max != MaxKey {
+		t.Errorf("MaxKeySize() of small pages = %v, want %v", max, MaxKey)
+	}
+
+	// keys share prefix longer than key prefix of upper level pages
+	prefix := bytes.Repeat([]byte{'p'}, 300)
+	keyOf := func(i int) []byte {
+		key := append(append([]byte{}, prefix...), byte(i>>8), byte(i))
+		return append(key, bytes.Repeat([]byte{'s'}, i%(mgr.MaxKeySize()-len(key)+1))...)
+	}
+	num := 2000
+	for i := 0; i < num; i++ {
+		if err := bltree.InsertKey(keyOf(i), 0, []byte{byte(i)}, true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+	for i := 0; i < num; i += 3 {
+		if err := bltree.DeleteKey(keyOf(i), 0); err != BLTErrOk {
+			t.Fatalf("DeleteKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+
+	check := func(when string) {
+		for i := 0; i < num; i++ {
+			ret, foundKey, val := bltree.FindKey(keyOf(i), 1)
+			if i%3 == 0 {
+				if ret >= 0 {
+					t.Fatalf("FindKey(%v) of deleted key %s = %v, want %v", i, when, ret, -1)
+				}
+				continue
+			}
+			if ret != 1 || val[0] != byte(i) || !bytes.Equal(foundKey, keyOf(i)) {
+				t.Fatalf("FindKey(%v) %s = %v, %v, want %v", i, when, ret, val, byte(i))
+			}
+		}
+		n, keys, _ := bltree.RangeScan(nil, nil)
+		if n != num-(num+2)/3 || !sort.SliceIsSorted(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) < 0 }) {
+			t.Errorf("RangeScan() %s = %v sorted keys, want %v", when, n, num-(num+2)/3)
+		}
+		if report, err := ValidateTree(bltree); err != BLTErrOk || !report.Valid() {
+			t.Errorf("ValidateTree() %s = %v, %v, want no problem", when, report.Problems, err)
+		}
+	}
+	check("")
+
+	// long keys are persisted
+	mgr.Close()
+	lastPageZeroId := mgr.GetMappedPPageIdOfPageZero()
+	mgr = NewBufMgr(12, 48, NewParentBufMgrDummy(pbmPageMap), &lastPageZeroId)
+	bltree = NewBLTree(mgr)
+	check("after restart")
+
+	if err := bltree.InsertKey(make([]byte, mgr.MaxKeySize()+1), 0, []byte{1}, true); err != BLTErrOverflow {
+		t.Errorf("InsertKey() of too long key = %v, want %v", err, BLTErrOverflow)
+	}
+	if err := bltree.InsertKey(make([]byte, mgr.MaxKeySize()), 0, []byte{1}, false); err != BLTErrOverflow {
+		t.Errorf("InsertKey() of too long duplicate key = %v, want %v", err, BLTErrOverflow)
+	}
+}
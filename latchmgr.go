@@ -4,6 +4,7 @@ import (
 	"runtime"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 /*
@@ -35,7 +36,7 @@ const (
 	LockRead   BLTLockMode = 4
 	LockWrite  BLTLockMode = 8
 	LockParent BLTLockMode = 16
-	//LockAtomic BLTLockMode = 32 // Note: not supported in this golang implementation
+	LockAtomic BLTLockMode = 32
 )
 
 const (
@@ -75,12 +76,15 @@ type (
 		access BLTRWLock // access intent / page delete
 		parent BLTRWLock // posting of fence key in parent
 		atomic BLTRWLock // atomic update in progress
-		split  uint      // right split page atomic insert
+		split  uint      // entry of right split page locked for holder of atomic lock
 		entry  uint      // entry slot in latch table
 		next   uint      // next entry in hash table chain
 		prev   uint      // prev entry in hash table chain
 		pin    uint32    // number of outstanding threads
 		dirty  bool      // page in cache is dirty
+		lsn    uint64    // largest LSN of changes since page was written back
+		// page contents are not loaded because page fault was given up
+		invalid bool
 
 		atomicID uint // thread id holding atomic lock
 	}
@@ -118,6 +122,47 @@ func (lock *BLTRWLock) ReadRelease() {
 	atomic.AddUint32(&lock.rout, RInc)
 }
 
+// WriteLockDeadline is WriteLock which gives up waiting at deadline.
+// ticket is taken only when no other writer waits, because a taken ticket
+// can't be returned. so writers with deadline are not served in phase-fair order.
+func (lock *BLTRWLock) WriteLockDeadline(deadline time.Time) bool {
+	var tix uint32
+	for {
+		tix = atomic.LoadUint32(&lock.ticket)
+		if tix == atomic.LoadUint32(&lock.serving) && atomic.CompareAndSwapUint32(&lock.ticket, tix, tix+1) {
+			break
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		runtime.Gosched()
+	}
+
+	// wait for readers which entered before us
+	w := Pres | (tix & PhID)
+	r := atomic.AddUint32(&lock.rin, w) - w
+	for r != atomic.LoadUint32(&lock.rout) {
+		runtime.Gosched()
+	}
+	return true
+}
+
+// ReadLockDeadline is ReadLock which gives up waiting at deadline.
+// reader count is incremented only when no writer is present,
+// so a reader which gave up is never counted by writers
+func (lock *BLTRWLock) ReadLockDeadline(deadline time.Time) bool {
+	for {
+		rin := atomic.LoadUint32(&lock.rin)
+		if rin&Mask == 0 && atomic.CompareAndSwapUint32(&lock.rin, rin, rin+RInc) {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		runtime.Gosched()
+	}
+}
+
 // SpinReadLock wait until write lock mode is clear and add 1 to the share count
 func (l *SpinLatch) SpinReadLock() {
 	var prev bool
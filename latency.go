@@ -0,0 +1,196 @@
+package blink_tree
+
+import (
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+// LatencyOp is kind of operation whose latency is recorded
+type LatencyOp int
+
+const (
+	LatencyInsert    LatencyOp = iota // InsertKey
+	LatencyDelete                     // DeleteKey
+	LatencyFind                       // FindKey
+	LatencyScanNext                   // moving scan to next page
+	LatencyPageFault                  // reading page from parent buffer pool
+	latencyOpNum
+)
+
+const (
+	// each power of 2 range of latency is divided to 1<<latencySubBits buckets
+	// so relative error of recorded latency is less than 1/(1<<latencySubBits)
+	latencySubBits   = 3
+	latencySubCnt    = 1 << latencySubBits
+	latencyBucketNum = (65 - latencySubBits) * latencySubCnt
+)
+
+type (
+	// opLatencies is latency histograms indexed by LatencyOp
+	opLatencies [latencyOpNum]latencyHistogram
+
+	// latencyHistogram is log-linear (HDR style) histogram of latencies in nanoseconds
+	latencyHistogram struct {
+		buckets [latencyBucketNum]uint64
+		count   uint64
+		sum     uint64
+		max     uint64
+	}
+
+	// LatencyBucket is a bucket of LatencySnapshot. latencies in [Lower, Upper) are counted
+	LatencyBucket struct {
+		Lower time.Duration
+		Upper time.Duration
+		Count uint64
+	}
+
+	// LatencySnapshot is copy of latency histogram of an operation
+	LatencySnapshot struct {
+		Count   uint64
+		Sum     time.Duration
+		Max     time.Duration
+		Buckets []LatencyBucket // not empty buckets in ascending order
+	}
+
+	// LatencyStats is latency histograms of all operations
+	LatencyStats struct {
+		Insert    LatencySnapshot
+		Delete    LatencySnapshot
+		Find      LatencySnapshot
+		ScanNext  LatencySnapshot
+		PageFault LatencySnapshot
+	}
+)
+
+// latencyBucketOf returns bucket index of latency v in nanoseconds
+func latencyBucketOf(v uint64) int {
+	if v < latencySubCnt {
+		return int(v)
+	}
+	e := bits.Len64(v) - 1
+	sub := (v >> (e - latencySubBits)) & (latencySubCnt - 1)
+	return (e-latencySubBits+1)*latencySubCnt + int(sub)
+}
+
+// latencyBucketRange returns range of latencies in nanoseconds counted in bucket idx
+func latencyBucketRange(idx int) (lower uint64, upper uint64) {
+	if idx < latencySubCnt {
+		return uint64(idx), uint64(idx) + 1
+	}
+	shift := idx/latencySubCnt - 1
+	lower = (latencySubCnt + uint64(idx%latencySubCnt)) << shift
+	return lower, lower + 1<<shift
+}
+
+func (h *latencyHistogram) record(d time.Duration) {
+	v := uint64(0)
+	if d > 0 {
+		v = uint64(d)
+	}
+	atomic.AddUint64(&h.buckets[latencyBucketOf(v)], 1)
+	atomic.AddUint64(&h.count, 1)
+	atomic.AddUint64(&h.sum, v)
+	for {
+		max := atomic.LoadUint64(&h.max)
+		if v <= max || atomic.CompareAndSwapUint64(&h.max, max, v) {
+			break
+		}
+	}
+}
+
+func (h *latencyHistogram) snapshot() LatencySnapshot {
+	s := LatencySnapshot{
+		Count: atomic.LoadUint64(&h.count),
+		Sum:   time.Duration(atomic.LoadUint64(&h.sum)),
+		Max:   time.Duration(atomic.LoadUint64(&h.max)),
+	}
+	for idx := range h.buckets {
+		if cnt := atomic.LoadUint64(&h.buckets[idx]); cnt > 0 {
+			lower, upper := latencyBucketRange(idx)
+			s.Buckets = append(s.Buckets, LatencyBucket{Lower: time.Duration(lower), Upper: time.Duration(upper), Count: cnt})
+		}
+	}
+	return s
+}
+
+// Mean returns average latency
+func (s *LatencySnapshot) Mean() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Sum / time.Duration(s.Count)
+}
+
+// Quantile returns upper bound of bucket which contains q quantile (0 <= q <= 1)
+func (s *LatencySnapshot) Quantile(q float64) time.Duration {
+	var total uint64
+	for _, b := range s.Buckets {
+		total += b.Count
+	}
+	if total == 0 {
+		return 0
+	}
+
+	rank := uint64(q * float64(total))
+	if rank >= total {
+		rank = total - 1
+	}
+	var cnt uint64
+	for _, b := range s.Buckets {
+		cnt += b.Count
+		if cnt > rank {
+			if b.Upper > s.Max {
+				return s.Max
+			}
+			return b.Upper
+		}
+	}
+	return s.Max
+}
+
+// EnableLatencyHistograms starts recording latency histograms of operations
+// of all BLTree handles using this BufMgr. recorded histograms are cleared
+func (mgr *BufMgr) EnableLatencyHistograms() {
+	mgr.latencyHists.Store(new(opLatencies))
+}
+
+// DisableLatencyHistograms stops recording latency histograms
+func (mgr *BufMgr) DisableLatencyHistograms() {
+	mgr.latencyHists.Store(nil)
+}
+
+// LatencyStats returns recorded latency histograms.
+// all histograms are empty when recording is disabled
+func (mgr *BufMgr) LatencyStats() LatencyStats {
+	hists := mgr.latencyHists.Load()
+	if hists == nil {
+		return LatencyStats{}
+	}
+	return LatencyStats{
+		Insert:    hists[LatencyInsert].snapshot(),
+		Delete:    hists[LatencyDelete].snapshot(),
+		Find:      hists[LatencyFind].snapshot(),
+		ScanNext:  hists[LatencyScanNext].snapshot(),
+		PageFault: hists[LatencyPageFault].snapshot(),
+	}
+}
+
+// latencyStart returns start time of an operation.
+// returns zero time when recording is disabled
+func (mgr *BufMgr) latencyStart() time.Time {
+	if mgr.latencyHists.Load() == nil {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+// recordLatency records latency of operation op which is started at start
+func (mgr *BufMgr) recordLatency(op LatencyOp, start time.Time) {
+	if start.IsZero() {
+		return
+	}
+	if hists := mgr.latencyHists.Load(); hists != nil {
+		hists[op].record(time.Since(start))
+	}
+}
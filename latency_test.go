@@ -0,0 +1,75 @@
+package blink_tree
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func TestLatencyBucket(t *testing.T) {
+	values := []uint64{0, 1, 7, 8, 9, 15, 16, 1000, 123456789, 1 << 40, 1<<63 + 12345}
+	for _, v := range values {
+		idx := latencyBucketOf(v)
+		if idx < 0 || idx >= latencyBucketNum {
+			t.Fatalf("latencyBucketOf(%d) = %d is out of range", v, idx)
+		}
+		lower, upper := latencyBucketRange(idx)
+		if v < lower || (upper > lower && v >= upper) {
+			t.Errorf("latencyBucketRange(%d) = [%d, %d), doesn't contain %d", idx, lower, upper, v)
+		}
+		if v >= latencySubCnt && float64(upper-lower)/float64(lower) > 1.0/latencySubCnt {
+			t.Errorf("latencyBucketRange(%d) = [%d, %d) is too wide", idx, lower, upper)
+		}
+	}
+}
+
+func TestLatencySnapshot_Quantile(t *testing.T) {
+	h := latencyHistogram{}
+	for i := 1; i <= 100; i++ {
+		h.record(time.Duration(i) * time.Microsecond)
+	}
+	s := h.snapshot()
+	if s.Count != 100 || s.Max != 100*time.Microsecond {
+		t.Fatalf("snapshot() = count %v, max %v, want 100, %v", s.Count, s.Max, 100*time.Microsecond)
+	}
+	if got := s.Mean(); got != 50500*time.Nanosecond {
+		t.Errorf("Mean() = %v, want %v", got, 50500*time.Nanosecond)
+	}
+	if got := s.Quantile(0.5); got < 50*time.Microsecond || got > 57*time.Microsecond {
+		t.Errorf("Quantile(0.5) = %v, want about 50us", got)
+	}
+	if got := s.Quantile(1); got != 100*time.Microsecond {
+		t.Errorf("Quantile(1) = %v, want %v", got, 100*time.Microsecond)
+	}
+}
+
+func TestBufMgr_LatencyStats(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	bltree := NewBLTree(mgr)
+
+	mgr.EnableLatencyHistograms()
+	num := 1000
+	for i := 0; i < num; i++ {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, uint64(i))
+		bltree.InsertKey(bs, 0, make([]byte, BtId), true)
+		bltree.FindKey(bs, BtId)
+	}
+	bltree.DeleteKey([]byte{0}, 0)
+	bltree.RangeScan(nil, nil)
+
+	stats := mgr.LatencyStats()
+	if stats.Insert.Count != uint64(num) || stats.Find.Count != uint64(num) || stats.Delete.Count != 1 {
+		t.Errorf("LatencyStats() counts = insert: %v, find: %v, delete: %v, want %v, %v, 1",
+			stats.Insert.Count, stats.Find.Count, stats.Delete.Count, num, num)
+	}
+	if stats.ScanNext.Count == 0 {
+		t.Errorf("LatencyStats() doesn't record scan")
+	}
+
+	mgr.DisableLatencyHistograms()
+	bltree.FindKey([]byte{0}, BtId)
+	if stats := mgr.LatencyStats(); stats.Find.Count != 0 {
+		t.Errorf("LatencyStats() after disable = %v, want empty", stats.Find.Count)
+	}
+}
@@ -0,0 +1,35 @@
+package blink_tree
+
+// MergeOperator combines operand given to InsertMerge with current value of key.
+// exists is false when key doesn't exist and old is nil then.
+// it returns new value of key
+type MergeOperator func(key []byte, old []byte, exists bool, operand []byte) []byte
+
+// SetMergeOperator sets operator applied by InsertMerge. nil removes the operator.
+//
+// operands aren't kept in leaf pages to be combined lazily by reads or compaction
+// like LSM trees. leaf page of key is write latched when an operand is inserted
+// and its current value is on the page, so each InsertMerge is read-modify-write
+// of the value and costs about the same as Merge. operator is called while the
+// leaf page is write latched, so it must not call methods of BLTree
+func (mgr *BufMgr) SetMergeOperator(op MergeOperator) {
+	if op == nil {
+		mgr.mergeOp.Store(nil)
+		return
+	}
+	mgr.mergeOp.Store(&op)
+}
+
+// InsertMerge combines operand with current value of key by the merge operator
+// and stores the result at once, which saves the caller a FindKey before the write
+// but not reading the value under the latch. BLTErrMerge is returned when no operator is set
+func (tree *BLTree) InsertMerge(key []byte, operand []byte) BLTErr {
+	op := tree.mgr.mergeOp.Load()
+	if op == nil {
+		tree.err = BLTErrMerge
+		return tree.err
+	}
+	return tree.Merge(key, func(old []byte, exists bool) []byte {
+		return (*op)(key, old, exists, operand)
+	})
+}
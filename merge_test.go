@@ -0,0 +1,61 @@
+package blink_tree
+
+import (
+	"encoding/binary"
+	"sync"
+	"testing"
+)
+
+func TestBLTree_InsertMerge(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	bltree := NewBLTree(mgr)
+
+	key := []byte{1, 2, 3}
+	if err := bltree.InsertMerge(key, []byte{1}); err != BLTErrMerge {
+		t.Errorf("InsertMerge() without operator = %v, want %v", err, BLTErrMerge)
+	}
+
+	// counters of 8 bytes added by operands
+	mgr.SetMergeOperator(func(_ []byte, old []byte, exists bool, operand []byte) []byte {
+		sum := make([]byte, 8)
+		n := binary.BigEndian.Uint64(operand)
+		if exists {
+			n += binary.BigEndian.Uint64(old)
+		}
+		binary.BigEndian.PutUint64(sum, n)
+		return sum
+	})
+
+	keys, workers, incs := 50, 4, 100
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tree := NewBLTree(mgr)
+			operand := make([]byte, 8)
+			for n := 0; n < incs; n++ {
+				for k := 0; k < keys; k++ {
+					binary.BigEndian.PutUint64(operand, uint64(k))
+					if err := tree.InsertMerge([]byte{byte(k)}, operand); err != BLTErrOk {
+						t.Errorf("InsertMerge() = %v, want %v", err, BLTErrOk)
+						return
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for k := 0; k < keys; k++ {
+		want := uint64(k * workers * incs)
+		if _, _, val := bltree.FindKey([]byte{byte(k)}, 8); binary.BigEndian.Uint64(val) != want {
+			t.Errorf("FindKey(%v) = %v, want %v", k, binary.BigEndian.Uint64(val), want)
+		}
+	}
+
+	mgr.SetMergeOperator(nil)
+	if err := bltree.InsertMerge(key, []byte{1}); err != BLTErrMerge {
+		t.Errorf("InsertMerge() after operator is removed = %v, want %v", err, BLTErrMerge)
+	}
+}
@@ -0,0 +1,147 @@
+package blink_tree
+
+import "encoding/binary"
+
+// OverflowHeaderSize is size of header of overflow page, which is at the head
+// of its data area and followed by payload
+//
+//	bytes 0-5: page number of next overflow page of the chain (0 means the last page)
+//	bytes 6-9: length of payload held by the page
+const OverflowHeaderSize = BtId + 4
+
+// overflowNext returns page number of next page of overflow chain
+func (p *Page) overflowNext() Uid {
+	return GetID((*[BtId]uint8)(p.Data[:BtId]))
+}
+
+// overflowPayload returns payload held by overflow page, or nil when its length is broken
+func (p *Page) overflowPayload() []byte {
+	size := binary.LittleEndian.Uint32(p.Data[BtId:OverflowHeaderSize])
+	if size > uint32(len(p.Data))-OverflowHeaderSize {
+		return nil
+	}
+	return p.Data[OverflowHeaderSize : OverflowHeaderSize+size]
+}
+
+// overflowPage returns overflow page which holds payload followed by next page
+func (mgr *BufMgr) overflowPage(next Uid, payload []byte) *Page {
+	page := NewPage(mgr.pageDataSize)
+	page.Bits = mgr.pageBits
+	page.Kind = PageKindOverflow
+	PutID((*[BtId]uint8)(page.Data[:BtId]), next)
+	binary.LittleEndian.PutUint32(page.Data[BtId:], uint32(len(payload)))
+	copy(page.Data[OverflowHeaderSize:], payload)
+	return page
+}
+
+// WriteOverflow writes payload to a chain of new overflow pages and returns page
+// number of the first page. overflow pages are not linked from the tree, so payload
+// which doesn't fit in leaf pages like large value is stored with the page number
+// as value of a key (see PutID), and the chain is read by ReadOverflow and released
+// by FreeOverflow. empty payload takes a page.
+// BLTErrCapacity is returned when the chain exceeds the page number limit
+func (tree *BLTree) WriteOverflow(payload []byte) (Uid, BLTErr) {
+	tree.startOp()
+	defer tree.mgr.enforceDirtyQuota(&tree.reads, &tree.writes)
+
+	per := int(tree.mgr.pageDataSize - OverflowHeaderSize)
+	cnt := (len(payload) + per - 1) / per
+	if cnt == 0 {
+		cnt = 1
+	}
+	if !tree.mgr.hasCapacity(Uid(cnt)) {
+		tree.err = BLTErrCapacity
+		return 0, tree.err
+	}
+
+	// pages are written from the tail of the chain so that each page
+	// is written with its next page
+	next := Uid(0)
+	for i := cnt - 1; i >= 0; i-- {
+		end := (i + 1) * per
+		if end > len(payload) {
+			end = len(payload)
+		}
+		var set PageSet
+		if err := tree.mgr.NewPage(&set, tree.mgr.overflowPage(next, payload[i*per:end]), &tree.reads, &tree.writes); err != BLTErrOk {
+			if next > 0 {
+				tree.freeOverflow(next)
+			}
+			tree.err = err
+			return 0, err
+		}
+		tree.markDirty(set.latch)
+		next = set.latch.pageNo
+		tree.mgr.UnpinLatch(set.latch)
+	}
+	return next, BLTErrOk
+}
+
+// ReadOverflow returns payload written by WriteOverflow to the chain whose first page is pageNo.
+// BLTErrStruct is returned when a page of the chain isn't overflow page
+func (tree *BLTree) ReadOverflow(pageNo Uid) ([]byte, BLTErr) {
+	tree.startOp()
+
+	var payload []byte
+	err := tree.walkOverflow(pageNo, LockRead, func(set *PageSet) {
+		payload = append(payload, set.page.overflowPayload()...)
+	})
+	if err != BLTErrOk {
+		return nil, err
+	}
+	if payload == nil {
+		payload = []byte{}
+	}
+	return payload, BLTErrOk
+}
+
+// FreeOverflow pushes pages of the chain whose first page is pageNo onto the free chain.
+// the chain must not be read after it's freed.
+// BLTErrStruct is returned when a page of the chain isn't overflow page,
+// and then pages before it are already freed
+func (tree *BLTree) FreeOverflow(pageNo Uid) BLTErr {
+	tree.startOp()
+	tree.startStructureMod()
+	defer tree.mgr.enforceDirtyQuota(&tree.reads, &tree.writes)
+
+	return tree.freeOverflow(pageNo)
+}
+
+func (tree *BLTree) freeOverflow(pageNo Uid) BLTErr {
+	return tree.walkOverflow(pageNo, LockWrite, func(set *PageSet) {
+		tree.mgr.PageLock(LockDelete, set.latch)
+		tree.mgr.PageFree(set)
+	})
+}
+
+// walkOverflow calls fn with each page of overflow chain locked in mode.
+// fn of LockWrite takes over the latch and releases it like PageFree
+func (tree *BLTree) walkOverflow(pageNo Uid, mode BLTLockMode, fn func(set *PageSet)) BLTErr {
+	for pageNo > 0 {
+		latch, err := tree.mgr.pinLatch(pageNo, true, &tree.reads, &tree.writes, tree.deadline)
+		if latch == nil {
+			tree.err = err
+			return err
+		}
+		if !tree.mgr.pageLockDeadline(mode, latch, tree.deadline) {
+			tree.mgr.UnpinLatch(latch)
+			tree.err = BLTErrTimeout
+			return tree.err
+		}
+		set := PageSet{page: tree.mgr.GetRefOfPageAtPool(latch), latch: latch}
+		if set.page.Kind != PageKindOverflow || set.page.Free || set.page.overflowPayload() == nil {
+			tree.mgr.PageUnlock(mode, latch)
+			tree.mgr.UnpinLatch(latch)
+			tree.err = BLTErrStruct
+			return tree.err
+		}
+
+		pageNo = set.page.overflowNext()
+		fn(&set)
+		if mode == LockRead {
+			tree.mgr.PageUnlock(mode, latch)
+			tree.mgr.UnpinLatch(latch)
+		}
+	}
+	return BLTErrOk
+}
@@ -0,0 +1,80 @@
+package blink_tree
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+)
+
+func TestBLTree_Overflow(t *testing.T) {
+	pbmPageMap := &sync.Map{}
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(pbmPageMap), nil)
+	bltree := NewBLTree(mgr)
+
+	payloads := [][]byte{{}, bytes.Repeat([]byte{1}, 100), make([]byte, 3*mgr.pageDataSize+5)}
+	for i := range payloads[2] {
+		payloads[2][i] = byte(i)
+	}
+
+	// page numbers of chains are stored as values of keys
+	for i, payload := range payloads {
+		pageNo, err := bltree.WriteOverflow(payload)
+		if err != BLTErrOk {
+			t.Fatalf("WriteOverflow() = %v, want %v", err, BLTErrOk)
+		}
+		var value [BtId]byte
+		PutID(&value, pageNo)
+		if err := bltree.InsertKey([]byte{byte(i)}, 0, value[:], true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+	chainOf := func(i int) Uid {
+		_, _, value := bltree.FindKey([]byte{byte(i)}, BtId)
+		return GetIDFromValue(&value)
+	}
+
+	// overflow pages are not linked from the tree
+	if report, err := ValidateTree(bltree); err != BLTErrOk || !report.Valid() || report.Pages != 2 {
+		t.Errorf("ValidateTree() = %v, %v, want valid tree of %v pages", report, err, 2)
+	}
+
+	// chains are read back after restart
+	mgr.Close()
+	lastPageZeroId := mgr.GetMappedPPageIdOfPageZero()
+	mgr = NewBufMgr(12, 48, NewParentBufMgrDummy(pbmPageMap), &lastPageZeroId)
+	bltree = NewBLTree(mgr)
+	for i, payload := range payloads {
+		if got, err := bltree.ReadOverflow(chainOf(i)); err != BLTErrOk || !bytes.Equal(got, payload) {
+			t.Errorf("ReadOverflow() = %v bytes, %v, want %v bytes", len(got), err, len(payload))
+		}
+	}
+	if _, err := bltree.ReadOverflow(RootPage); err != BLTErrStruct {
+		t.Errorf("ReadOverflow() of tree page = %v, want %v", err, BLTErrStruct)
+	}
+
+	// freed pages are reused by new chain
+	allocated, _ := mgr.PageCapacity()
+	if err := bltree.FreeOverflow(chainOf(2)); err != BLTErrOk {
+		t.Fatalf("FreeOverflow() = %v, want %v", err, BLTErrOk)
+	}
+	if _, err := bltree.ReadOverflow(chainOf(2)); err != BLTErrStruct {
+		t.Errorf("ReadOverflow() of freed chain = %v, want %v", err, BLTErrStruct)
+	}
+	payload := bytes.Repeat([]byte{2}, 2*int(mgr.pageDataSize))
+	pageNo, err := bltree.WriteOverflow(payload)
+	if err != BLTErrOk {
+		t.Fatalf("WriteOverflow() = %v, want %v", err, BLTErrOk)
+	}
+	if got, _ := mgr.PageCapacity(); got != allocated {
+		t.Errorf("PageCapacity() = %v, want %v", got, allocated)
+	}
+	if got, err := bltree.ReadOverflow(pageNo); err != BLTErrOk || !bytes.Equal(got, payload) {
+		t.Errorf("ReadOverflow() = %v bytes, %v, want %v bytes", len(got), err, len(payload))
+	}
+
+	// chain beyond page number limit isn't written
+	mgr.SetPageLimit(allocated)
+	if _, err := bltree.WriteOverflow(payload); err != BLTErrCapacity {
+		t.Errorf("WriteOverflow() = %v, want %v", err, BLTErrCapacity)
+	}
+}
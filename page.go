@@ -25,12 +25,36 @@ const (
 	Delete
 )
 
+// PageKind is kind of page. blink-tree pages of all levels are PageKindTree,
+// and leaf and upper pages are told apart by Lvl
+type PageKind uint8
+
+const (
+	PageKindTree     PageKind = iota
+	PageKindOverflow          // page of overflow chain which holds a part of payload (see WriteOverflow)
+)
+
 const (
 	MaxKey   = 255
 	KeyArray = MaxKey + 1 // 1 is key length
-
-	PageHeaderSize = 26 // size of page header in bytes
-	SlotSize       = 6  // size of slot in bytes
+	// MaxLongKey is the largest length of stored key. the length byte of key
+	// holds its lower 8 bits and the slot holds the rest (see storedKeyLen)
+	MaxLongKey = 0x7fff
+
+	PageHeaderSize = 67   // size of page header in bytes
+	SlotSize       = 6    // size of slot in bytes
+	SlotFlagsMask  = 0x7f // bits of slot which are usable as user flags
+	// MaxKeyOffset is the largest key offset in data area which slot can hold.
+	// the lower 16 bits of offset are held in slot bytes 0-1 and the rest in
+	// bits 2-7 of the type byte, so pages up to 1 << BtMaxBits bytes are addressed
+	MaxKeyOffset = 1<<22 - 1
+	slotTypMask  = 0x03 // bits of the type byte of slot which hold SlotType
+	// fixedEntryBit of slot byte 3 marks entry which is stored
+	// without length bytes (see SetFixedWidth)
+	fixedEntryBit = 0x01
+	// PageFormatVersion is version of layout of pages written to parent buffer manager.
+	// it's raised whenever the layout changes
+	PageFormatVersion = 6
 
 	EntrySizeForDebug = 66
 	KeySizeForDebug   = 12 // Integer //50
@@ -68,15 +92,29 @@ type (
 	// Note: this structure size must be a multiple of 8 bytes in order
 	// to place dups correctly
 	PageHeader struct {
-		Cnt     uint32      // count of keys in page
-		Act     uint32      // count of active keys
-		Min     uint32      // next key offset
-		Garbage uint32      // page garbage in bytes
-		Bits    uint8       // page size in bits
-		Free    bool        // page is on free chain
-		Lvl     uint8       // level of page
-		Kill    bool        // page is being deleted
-		Right   [BtId]uint8 // page number to right
+		Cnt       uint32               // count of keys in page
+		Act       uint32               // count of active keys
+		Min       uint32               // next key offset
+		Garbage   uint32               // page garbage in bytes
+		Bits      uint8                // page size in bits
+		Free      bool                 // page is on free chain
+		Lvl       uint8                // level of page
+		Kill      bool                 // page is being deleted
+		Right     [BtId]uint8          // page number to right
+		Version   uint8                // PageFormatVersion which the page is written in
+		Kind      PageKind             // kind of page
+		KeyWidth  uint8                // width of keys stored without length bytes (0 means none, see SetFixedWidth)
+		ValWidth  uint8                // width of values stored without length bytes
+		Prefixed  bool                 // tail of data area holds key prefix (see keyPrefix)
+		Encoded   uint32               // length of data area encoded by PageCodec in parent page (0 means raw)
+		KeyId     uint32               // id of key which data area is encrypted with in parent page (0 means plain)
+		LowFence  [FenceHintSize]uint8 // leading bytes of fence key of the left page (see fenceHint)
+		HighFence [FenceHintSize]uint8 // leading bytes of fence key of the page (see fenceHint)
+		LSN       uint64               // largest LSN attached to changes of the page by SetLSN
+		// Checksum is CRC32 of the page written to parent buffer manager,
+		// which is computed by PageOut and verified by PageIn.
+		// it must stay the last field
+		Checksum uint32
 	}
 	Page struct {
 		PageHeader
@@ -96,7 +134,7 @@ func NewPage(pageDataSize uint32) *Page {
 
 func (p *Page) slotBytes(i uint32) []byte {
 	off := SlotSize * (i - 1)
-	if off > 32767 {
+	if off > MaxKeyOffset {
 		panic(fmt.Sprintf("offset is too big : %d", off))
 	}
 	return p.Data[off : off+SlotSize]
@@ -107,28 +145,44 @@ func (p *Page) ClearSlot(slot uint32) {
 	copy(slotBytes, make([]byte, SlotSize))
 }
 
+// SetKeyOffset also clears bytes 2-3 of slot. upper bits of offset are
+// put in the type byte, so pages written with offsets shorter than
+// 16 bits are read as they are
 func (p *Page) SetKeyOffset(slot uint32, offset uint32) {
-	if offset > 32767 {
+	if offset > MaxKeyOffset {
 		panic("offset is too big")
 	}
 	slotBytes := p.slotBytes(slot)
-	binary.LittleEndian.PutUint32(slotBytes, offset)
+	binary.LittleEndian.PutUint32(slotBytes, offset&0xffff)
+	slotBytes[4] = slotBytes[4]&slotTypMask | byte(offset>>16)<<2
 }
 
 func (p *Page) KeyOffset(slot uint32) uint32 {
 	slotBytes := p.slotBytes(slot)
-	return binary.LittleEndian.Uint32(slotBytes)
+	return uint32(binary.LittleEndian.Uint16(slotBytes)) | uint32(slotBytes[4]>>2)<<16
+}
+
+// prefixLen returns count of leading key bytes which are omitted from
+// stored key of slot and shared with key prefix of the page
+func (p *Page) prefixLen(slot uint32) uint32 {
+	return uint32(p.slotBytes(slot)[2])
+}
+
+// setPrefixLen must be called after SetKeyOffset which clears it
+func (p *Page) setPrefixLen(slot uint32, n uint32) {
+	p.slotBytes(slot)[2] = uint8(n)
 }
 
 func (p *Page) SetTyp(slot uint32, typ SlotType) {
 	slotBytes := p.slotBytes(slot)
-	slotBytes[4] = byte(typ)
+	slotBytes[4] = slotBytes[4]&^slotTypMask | byte(typ)&slotTypMask
 }
 func (p *Page) Typ(slot uint32) SlotType {
 	slotBytes := p.slotBytes(slot)
-	return SlotType(slotBytes[4])
+	return SlotType(slotBytes[4] & slotTypMask)
 }
 
+// SetDead also clears user flags of slot
 func (p *Page) SetDead(slot uint32, b bool) {
 	slotBytes := p.slotBytes(slot)
 	if b {
@@ -140,48 +194,382 @@ func (p *Page) SetDead(slot uint32, b bool) {
 
 func (p *Page) Dead(slot uint32) bool {
 	slotBytes := p.slotBytes(slot)
-	return slotBytes[5] == 1
+	return slotBytes[5]&1 == 1
+}
+
+// SlotFlags returns application-defined flags of slot which are kept
+// in upper bits of the byte of dead flag
+func (p *Page) SlotFlags(slot uint32) uint8 {
+	return p.slotBytes(slot)[5] >> 1
+}
+
+// setSlotFlags must be called after SetDead which clears them
+func (p *Page) setSlotFlags(slot uint32, flags uint8) {
+	slotBytes := p.slotBytes(slot)
+	slotBytes[5] = slotBytes[5]&1 | (flags&SlotFlagsMask)<<1
 }
 
 func (p *Page) SetKey(bytes []byte, slot uint32) {
 	off := p.KeyOffset(slot)
-	keyLen := uint8(len(bytes))
-	copy(p.Data[off:], append([]byte{keyLen}, bytes...))
+	copy(p.Data[off:], append([]byte{byte(len(bytes))}, bytes...))
+	p.setPrefixLen(slot, 0)
+	p.setStoredKeyLen(slot, uint32(len(bytes)))
 }
 
-func (p *Page) Key(slot uint32) []byte {
+// storedKeyLen returns length of key bytes stored at key offset of slot.
+// the length byte in data area holds the lower 8 bits of it and the upper
+// bits are held in bits 1-7 of slot byte 3, which are zero for keys
+// shorter than 256 bytes, so pages written without long keys are read as they are.
+// key of fixed width entry has no length byte and is KeyWidth bytes with prefix
+func (p *Page) storedKeyLen(slot uint32) uint32 {
+	if p.fixedEntry(slot) {
+		return uint32(p.KeyWidth) - p.prefixLen(slot)
+	}
+	return uint32(p.Data[p.KeyOffset(slot)]) | uint32(p.slotBytes(slot)[3]>>1)<<8
+}
+
+// setStoredKeyLen records upper bits of length of stored key in slot.
+// it must be called after SetKeyOffset which clears them
+func (p *Page) setStoredKeyLen(slot uint32, n uint32) {
+	slotBytes := p.slotBytes(slot)
+	slotBytes[3] = slotBytes[3]&fixedEntryBit | byte(n>>8)<<1
+}
+
+// storedKey returns key bytes stored for slot, which lack key prefix
+// of the page, without copying them
+func (p *Page) storedKey(slot uint32) []byte {
 	off := p.KeyOffset(slot)
-	keyLen := uint32(p.Data[off])
-	res := make([]byte, keyLen)
-	copy(res, p.Data[off+1:off+1+keyLen])
+	if !p.fixedEntry(slot) {
+		off++
+	}
+	return p.Data[off : off+p.storedKeyLen(slot)]
+}
+
+// fixed width entries
+/*
+ *  When keys and values of a tree have known widths (see SetFixedWidth),
+ *  KeyWidth and ValWidth of header of a page are set to them, and keys
+ *  and values of these widths are stored without their length bytes,
+ *  so the value follows the key at the offset computed from KeyWidth.
+ *  The slot of such entry is marked by fixedEntryBit of slot byte 3,
+ *  whose other bits hold upper bits of key length of other entries.
+ *  Other entries like the stopper key, duplicate keys or escaped keys
+ *  keep their length bytes in the same page. Widths of a page are copied
+ *  to pages which are built from it, so entries are rebuilt in the same size.
+ */
+
+// fixedEntry reports whether key and value of slot are stored without length bytes
+func (p *Page) fixedEntry(slot uint32) bool {
+	return p.slotBytes(slot)[3]&fixedEntryBit != 0
+}
+
+// fitsFixedWidth reports whether key and value are stored without length bytes in page
+func (p *Page) fitsFixedWidth(key []byte, value []byte) bool {
+	return p.KeyWidth > 0 && len(key) == int(p.KeyWidth) && len(value) == int(p.ValWidth)
+}
+
+// putEntry writes key and value below offset end of data area and returns
+// key offset of them. leading pre bytes of key are omitted as key prefix
+func (p *Page) putEntry(end uint32, key []byte, pre uint32, value []byte) uint32 {
+	if p.fitsFixedWidth(key, value) {
+		end -= uint32(len(value))
+		copy(p.Data[end:], value)
+		end -= uint32(len(key)) - pre
+		copy(p.Data[end:], key[pre:])
+		return end
+	}
+	end -= uint32(len(value)) + 1
+	copy(p.Data[end:], append([]byte{byte(len(value))}, value...))
+	end -= uint32(len(key)) - pre + 1
+	copy(p.Data[end:], append([]byte{byte(uint32(len(key)) - pre)}, key[pre:]...))
+	return end
+}
+
+// setEntry points slot to key and value written at offset off by putEntry
+func (p *Page) setEntry(slot uint32, off uint32, key []byte, pre uint32, value []byte) {
+	p.SetKeyOffset(slot, off)
+	p.setPrefixLen(slot, pre)
+	if p.fitsFixedWidth(key, value) {
+		p.slotBytes(slot)[3] = fixedEntryBit
+	} else {
+		p.setStoredKeyLen(slot, uint32(len(key))-pre)
+	}
+}
+
+func (p *Page) Key(slot uint32) []byte {
+	stored := p.storedKey(slot)
+	pre := p.prefixLen(slot)
+	res := make([]byte, pre+uint32(len(stored)))
+	if pre > 0 {
+		copy(res, p.keyPrefix()[:pre])
+	}
+	copy(res[pre:], stored)
 	return res
 }
 
+// cmpKey compares key of slot with key like KeyCmp without copying key of slot.
+// keys are compared by cmp instead when it's not nil
+func (p *Page) cmpKey(slot uint32, key []byte, cmp KeyCompare) int {
+	if cmp != nil {
+		return cmp(p.Key(slot), key)
+	}
+	stored := p.storedKey(slot)
+	pre := int(p.prefixLen(slot))
+	if pre > 0 {
+		prefix := p.keyPrefix()[:pre]
+		n := pre
+		if len(key) < n {
+			n = len(key)
+		}
+		if c := bytes.Compare(prefix[:n], key[:n]); c != 0 {
+			return c
+		}
+		if len(key) < pre {
+			return 1
+		}
+		key = key[pre:]
+	}
+	return bytes.Compare(stored, key)
+}
+
+// hasKey reports whether key of slot equals key without copying key of slot.
+// suffix bytes at the end of key of slot are not compared (BtId for duplicate keys).
+// keys are compared by cmp instead when it's not nil
+func (p *Page) hasKey(slot uint32, key []byte, suffix int, cmp KeyCompare) bool {
+	if cmp != nil {
+		stored := p.Key(slot)
+		return len(stored) >= suffix && cmp(stored[:len(stored)-suffix], key) == 0
+	}
+	stored := p.storedKey(slot)
+	pre := int(p.prefixLen(slot))
+	if pre+len(stored)-suffix != len(key) {
+		return false
+	}
+	// suffix may be partly omitted as key prefix
+	n := pre
+	if len(key) < n {
+		n = len(key)
+	}
+	if n > 0 && !bytes.Equal(p.keyPrefix()[:n], key[:n]) {
+		return false
+	}
+	if pre >= len(key) {
+		return true
+	}
+	return bytes.Equal(stored[:len(key)-pre], key[pre:])
+}
+
+// key prefix
+/*
+ *  Keys of a page tend to share long prefixes, like separator keys of
+ *  upper level pages and sequential numbers or paths in leaf pages.
+ *  When a page is rebuilt, the common prefix of its keys
+ *  is stored once at the tail of the data area (prefix bytes followed
+ *  by the prefix length byte) and each slot records how many bytes
+ *  of the prefix are omitted from its stored key. Slots which record
+ *  zero hold the whole key, so pages written without key prefix
+ *  are read as they are. Prefixed of page header records that
+ *  the page holds key prefix. A key which doesn't share the whole
+ *  prefix is inserted after the page is rebuilt with shorter prefix,
+ *  so every key of the page shares it and halves of a split page
+ *  never grow.
+ */
+
+// hasKeyPrefix reports whether the tail of data area holds key prefix
+func (p *Page) hasKeyPrefix() bool {
+	return p.Prefixed
+}
+
+// setKeyPrefixFlag records whether the tail of data area holds key prefix
+// of page being rebuilt
+func (p *Page) setKeyPrefixFlag(has bool) {
+	p.Prefixed = has
+}
+
+// keyPrefix returns key prefix stored at the tail of data area
+func (p *Page) keyPrefix() []byte {
+	end := uint32(len(p.Data)) - 1
+	return p.Data[end-uint32(p.Data[end]) : end]
+}
+
+// putKeyPrefix stores key prefix at the tail of data area of page
+// being rebuilt and returns offset of data area below it
+func (p *Page) putKeyPrefix(prefix []byte) uint32 {
+	end := uint32(len(p.Data))
+	if len(prefix) == 0 {
+		return end
+	}
+	end--
+	p.Data[end] = uint8(len(prefix))
+	end -= uint32(len(prefix))
+	copy(p.Data[end:], prefix)
+	return end
+}
+
+// keyPrefixSize returns size of data area used by key prefix
+func keyPrefixSize(prefix []byte) uint32 {
+	if len(prefix) == 0 {
+		return 0
+	}
+	return uint32(len(prefix)) + 1
+}
+
+// commonKeyPrefix returns common prefix of keys of slots from first to last.
+// infinite stopper key of the rightmost page is excluded.
+// it's at most MaxKey bytes because its length is stored in a byte
+func (p *Page) commonKeyPrefix(first uint32, last uint32) []byte {
+	var prefix []byte
+	found := false
+	for slot := first; slot <= last; slot++ {
+		if p.Typ(slot) == Librarian || (slot == p.Cnt && GetID(&p.Right) == 0) {
+			continue
+		}
+		if !found {
+			prefix = p.Key(slot)
+			found = true
+			continue
+		}
+		prefix = prefix[:p.sharedKeyLen(slot, prefix)]
+	}
+	if len(prefix) > MaxKey {
+		prefix = prefix[:MaxKey]
+	}
+	return prefix
+}
+
+// sharedKeyLen returns length of common prefix of key of slot and b
+// without copying key of slot
+func (p *Page) sharedKeyLen(slot uint32, b []byte) uint32 {
+	pre := p.prefixLen(slot)
+	if pre > 0 {
+		if n := sharedPrefixLen(p.keyPrefix()[:pre], b); n < pre {
+			return n
+		}
+	}
+	return pre + sharedPrefixLen(p.storedKey(slot), b[pre:])
+}
+
+// cleanedKeyPrefix returns key prefix of page after cleanup for inserting key,
+// which is common prefix of keys of the page and key
+func (p *Page) cleanedKeyPrefix(key []byte) []byte {
+	prefix := p.commonKeyPrefix(1, p.Cnt)
+	return prefix[:sharedPrefixLen(key, prefix)]
+}
+
+// rebuiltEntrySize returns size of key and value of slot in data area
+// when the key is stored without prefix
+func (p *Page) rebuiltEntrySize(slot uint32, prefix []byte) uint32 {
+	return p.entrySize(slot) + p.prefixLen(slot) - p.sharedKeyLen(slot, prefix)
+}
+
+// sharedPrefixLen returns length of common prefix of a and b
+func sharedPrefixLen(a []byte, b []byte) uint32 {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return uint32(n)
+}
+
+// ValueOffset returns offset of value of slot in data area, which is
+// the offset of its length byte unless the entry has fixed width
 func (p *Page) ValueOffset(slot uint32) uint32 {
 	off := p.KeyOffset(slot)
-	if off > 32767 {
+	if off > MaxKeyOffset {
 		panic("offset is too big")
 	}
-	keyLen := p.Data[off]
-	return off + uint32(1+keyLen)
+	if p.fixedEntry(slot) {
+		return off + p.storedKeyLen(slot)
+	}
+	return off + 1 + p.storedKeyLen(slot)
 }
 
+// SetValue overwrites value of slot. value of fixed width entry must be ValWidth bytes
 func (p *Page) SetValue(bytes []byte, slot uint32) {
 	off := p.ValueOffset(slot)
+	if p.fixedEntry(slot) {
+		copy(p.Data[off:], bytes)
+		return
+	}
 	valLen := uint8(len(bytes))
 	copy(p.Data[off:], append([]byte{valLen}, bytes...))
 }
 
+// valueFits reports whether value can overwrite value of slot by SetValue
+func (p *Page) valueFits(slot uint32, value []byte) bool {
+	if p.fixedEntry(slot) {
+		return len(value) == int(p.ValWidth)
+	}
+	return len(value) <= len(p.valueBytes(slot))
+}
+
 func (p *Page) Value(slot uint32) *[]byte {
-	off := p.ValueOffset(slot)
-	valLen := uint32(p.Data[off])
-	res := make([]byte, valLen)
-	copy(res, p.Data[off+1:off+1+valLen])
+	val := p.valueBytes(slot)
+	res := make([]byte, len(val))
+	copy(res, val)
 	return &res
 }
 
+// valueBytes returns value of slot which refers to the page data without copying
+func (p *Page) valueBytes(slot uint32) []byte {
+	off := p.ValueOffset(slot)
+	if p.fixedEntry(slot) {
+		end := off + uint32(p.ValWidth)
+		return p.Data[off:end:end]
+	}
+	end := off + 1 + uint32(p.Data[off])
+	return p.Data[off+1 : end : end]
+}
+
+// entrySize returns size of key and value of slot in data area
+func (p *Page) entrySize(slot uint32) uint32 {
+	if p.fixedEntry(slot) {
+		return p.storedKeyLen(slot) + uint32(p.ValWidth)
+	}
+	off := p.KeyOffset(slot)
+	valOff := off + 1 + p.storedKeyLen(slot)
+	return valOff - off + 1 + uint32(p.Data[valOff])
+}
+
+// rebuiltSize returns size of data area and slots which live keys of slots
+// from 1 to cnt take when they are copied to a new page with librarian slots
+// and stored without prefix. size of prefix itself isn't included
+func (p *Page) rebuiltSize(cnt uint32, prefix []byte) uint32 {
+	size, keys := uint32(0), uint32(0)
+	for slot := uint32(1); slot <= cnt; slot++ {
+		if !p.Dead(slot) && p.Typ(slot) != Librarian {
+			size += p.rebuiltEntrySize(slot, prefix)
+			keys++
+		}
+	}
+	if keys > 0 {
+		size += (2*keys - 1) * SlotSize
+	}
+	return size
+}
+
+// countGarbage returns size of data area which is used by neither
+// live keys nor key prefix. Garbage of consistent page equals to it
+func (p *Page) countGarbage() uint32 {
+	garbage := uint32(len(p.Data)) - p.Min
+	if p.hasKeyPrefix() {
+		garbage -= keyPrefixSize(p.keyPrefix())
+	}
+	for slot := uint32(1); slot <= p.Cnt; slot++ {
+		if !p.Dead(slot) && p.Typ(slot) != Librarian {
+			garbage -= p.entrySize(slot)
+		}
+	}
+	return garbage
+}
+
 // FindSlot find slot in page for given key at a given level
 func (p *Page) FindSlot(key []byte) uint32 {
+	return p.findSlot(key, nil)
+}
+
+// findSlot is FindSlot which compares keys by cmp when it's not nil
+func (p *Page) findSlot(key []byte, cmp KeyCompare) uint32 {
 	higher := p.Cnt
 	low := uint32(1)
 	var slot uint32
@@ -198,7 +586,7 @@ func (p *Page) FindSlot(key []byte) uint32 {
 	diff := higher - low
 	for diff > 0 {
 		slot = low + diff>>1
-		if KeyCmp(p.Key(slot), key) < 0 {
+		if p.cmpKey(slot, key, cmp) < 0 {
 			low = slot + 1
 		} else {
 			higher = slot
@@ -344,3 +344,85 @@ func TestCopyPage(t *testing.T) {
 		t.Errorf("set2.page.Data = %v, want %v", set2.page.Data, []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0})
 	}
 }
+
+func TestPage_keyPrefix(t *testing.T) {
+	p := NewPage(64)
+	p.Lvl = 1
+	nxt := p.putKeyPrefix([]byte("abc"))
+	if nxt != 60 || !bytes.Equal(p.keyPrefix(), []byte("abc")) {
+		t.Fatalf("putKeyPrefix() = %v, %v, want %v, %v", nxt, p.keyPrefix(), 60, []byte("abc"))
+	}
+
+	// first slot omits whole prefix and second slot omits part of it
+	nxt -= 3
+	copy(p.Data[nxt:], []byte{2, 'x', 'y'})
+	p.SetKeyOffset(1, nxt)
+	p.setPrefixLen(1, 3)
+	nxt -= 4
+	copy(p.Data[nxt:], []byte{3, 'd', 'e', 'f'})
+	p.SetKeyOffset(2, nxt)
+	p.setPrefixLen(2, 2)
+	p.Cnt = 2
+	p.setKeyPrefixFlag(true)
+	PutID(&p.Right, 2)
+
+	if got := p.Key(1); !bytes.Equal(got, []byte("abcxy")) {
+		t.Errorf("Page.Key(1) = %s, want %s", got, "abcxy")
+	}
+	if got := p.Key(2); !bytes.Equal(got, []byte("abdef")) {
+		t.Errorf("Page.Key(2) = %s, want %s", got, "abdef")
+	}
+	if got := p.KeyOffset(1); got != 57 {
+		t.Errorf("Page.KeyOffset(1) = %v, want %v", got, 57)
+	}
+	if !p.hasKeyPrefix() {
+		t.Errorf("Page.hasKeyPrefix() = %v, want %v", false, true)
+	}
+	if got := p.commonKeyPrefix(1, 2); !bytes.Equal(got, []byte("ab")) {
+		t.Errorf("Page.commonKeyPrefix() = %s, want %s", got, "ab")
+	}
+	for _, key := range []string{"", "a", "abc", "abcx", "abcxy", "abcxyz", "abcz", "abd", "abdef", "abdefg", "b"} {
+		for slot := uint32(1); slot <= 2; slot++ {
+			if got, want := p.cmpKey(slot, []byte(key), nil), KeyCmp(p.Key(slot), []byte(key)); got != want {
+				t.Errorf("Page.cmpKey(%v, %s) = %v, want %v", slot, key, got, want)
+			}
+			if got, want := p.hasKey(slot, []byte(key), 0, nil), bytes.Equal(p.Key(slot), []byte(key)); got != want {
+				t.Errorf("Page.hasKey(%v, %s) = %v, want %v", slot, key, got, want)
+			}
+		}
+	}
+	if !p.hasKey(2, []byte("abd"), 2, nil) || p.hasKey(2, []byte("abde"), 2, nil) {
+		t.Errorf("Page.hasKey() with suffix doesn't ignore suffix of %s", p.Key(2))
+	}
+
+	// SetKey stores whole key
+	p.SetKey([]byte("zz"), 1)
+	if got := p.Key(1); !bytes.Equal(got, []byte("zz")) {
+		t.Errorf("Page.Key(1) after SetKey = %s, want %s", got, "zz")
+	}
+}
+
+func TestPage_KeyOffset_wide(t *testing.T) {
+	p := NewPage(2 * SlotSize)
+	for _, typ := range []SlotType{Unique, Librarian, Duplicate, Delete} {
+		for _, offset := range []uint32{0, 64, 0xffff, 0x10000, 0x2a5a5, MaxKeyOffset} {
+			p.SetTyp(2, typ)
+			p.SetKeyOffset(2, offset)
+			if got := p.KeyOffset(2); got != offset {
+				t.Errorf("Page.KeyOffset() = %v, want %v", got, offset)
+			}
+			if got := p.Typ(2); got != typ {
+				t.Errorf("Page.Typ() = %v, want %v", got, typ)
+			}
+			// type is set after offset too
+			p.SetTyp(2, Unique)
+			p.SetTyp(2, typ)
+			if got := p.KeyOffset(2); got != offset {
+				t.Errorf("Page.KeyOffset() after SetTyp() = %v, want %v", got, offset)
+			}
+		}
+	}
+	if got := p.KeyOffset(1); got != 0 {
+		t.Errorf("Page.KeyOffset(1) = %v, want %v", got, 0)
+	}
+}
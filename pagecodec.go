@@ -0,0 +1,24 @@
+package blink_tree
+
+// PageCodec compresses pages written to parent buffer manager (e.g. LZ4, zstd or snappy).
+// pages are kept decoded in buffer pool
+type PageCodec interface {
+	// Encode appends encoded src to dst and returns it
+	Encode(dst []byte, src []byte) []byte
+	// Decode appends decoded src to dst and returns it
+	Decode(dst []byte, src []byte) ([]byte, error)
+}
+
+// SetPageCodec sets codec which is applied to data area of each page on PageOut
+// and on PageIn. header of page is written as is, and data which isn't shortened
+// by codec is written as is, so only the used bytes of page image (see PageImageSize)
+// need to be kept by parent buffer manager. page zero is never encoded.
+// nil means pages are written as is.
+//
+// codec is not persisted, so it must be set before any operation on the tree
+// and the same codec must be set whenever the tree is opened.
+// encoded pages can't be read without codec, so RescueBufMgr skips them.
+// BLTErrCodec is returned when a page can't be decoded
+func (mgr *BufMgr) SetPageCodec(codec PageCodec) {
+	mgr.pageCodec = codec
+}
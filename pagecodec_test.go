@@ -0,0 +1,81 @@
+package blink_tree
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"io"
+	"sync"
+	"testing"
+)
+
+// flatePageCodec is PageCodec of DEFLATE
+type flatePageCodec struct{}
+
+func (flatePageCodec) Encode(dst []byte, src []byte) []byte {
+	buf := bytes.NewBuffer(dst)
+	w, _ := flate.NewWriter(buf, flate.BestSpeed)
+	w.Write(src)
+	w.Close()
+	return buf.Bytes()
+}
+
+func (flatePageCodec) Decode(dst []byte, src []byte) ([]byte, error) {
+	decoded, err := io.ReadAll(flate.NewReader(bytes.NewReader(src)))
+	if err != nil {
+		return nil, err
+	}
+	return append(dst, decoded...), nil
+}
+
+func TestBufMgr_SetPageCodec(t *testing.T) {
+	pbmPageMap := &sync.Map{}
+	pbm := NewParentBufMgrDummy(pbmPageMap)
+	mgr := NewBufMgr(12, 48, pbm, nil)
+	mgr.SetPageCodec(flatePageCodec{})
+	bltree := NewBLTree(mgr)
+
+	keyOf := func(i uint64) []byte {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		return bs
+	}
+	num := uint64(5000)
+	for i := uint64(0); i < num; i++ {
+		if err := bltree.InsertKey(keyOf(i), 0, make([]byte, BtId), true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+	if err := mgr.Close(); err != BLTErrOk {
+		t.Fatalf("Close() = %v, want %v", err, BLTErrOk)
+	}
+
+	// pages of sequential keys are stored compressed
+	used, size := 0, 0
+	mgr.pageIdConvMap.Range(func(pageNo Uid, ppageId int32) bool {
+		ppage := pbm.FetchPPage(ppageId)
+		used += PageImageSize(ppage.DataAsSlice())
+		size += int(mgr.pageSize)
+		pbm.UnpinPPage(ppageId, false)
+		return true
+	})
+	if used*3 > size {
+		t.Errorf("pages use %v bytes of %v bytes, want at most a third", used, size)
+	}
+
+	lastPageZeroId := mgr.GetMappedPPageIdOfPageZero()
+	mgr = NewBufMgr(12, 48, NewParentBufMgrDummy(pbmPageMap), &lastPageZeroId)
+	mgr.SetPageCodec(flatePageCodec{})
+	bltree = NewBLTree(mgr)
+	for i := uint64(0); i < num; i++ {
+		if _, foundKey, _ := bltree.FindKey(keyOf(i), BtId); !bytes.Equal(foundKey, keyOf(i)) {
+			t.Errorf("FindKey() = %v, want %v", foundKey, keyOf(i))
+		}
+	}
+
+	// encoded pages aren't read without codec
+	mgr = NewBufMgr(12, 48, NewParentBufMgrDummy(pbmPageMap), &lastPageZeroId)
+	if _, _, _, err := NewBLTree(mgr).FindKeyErr(keyOf(0), BtId); err != BLTErrCodec {
+		t.Errorf("FindKeyErr() without codec = %v, want %v", err, BLTErrCodec)
+	}
+}
@@ -0,0 +1,196 @@
+package blink_tree
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	PageIdMapShardNum  = 16      // number of shards of dense area (must be power of 2)
+	PageIdMapDenseMax  = 1 << 28 // Uids equal or larger than this are stored in sparse area
+	pageIdMapInitShard = 64      // initial entry count of each shard
+	pageIdMapEntrySize = 4       // size of a dense entry in bytes
+	pageIdMapSparseEst = 64      // estimated memory usage of a sparse entry in bytes
+)
+
+type (
+	// PageIdMap is page id conversion map: Uid -> parent page id
+	//
+	// Uids of blink-tree pages are allocated densely from AllocRight,
+	// so they are stored in growable slices sharded by Uid and looked up
+	// without locking. Uids which are too large to be stored densely
+	// (sparse mode) are stored in sync.Map.
+	PageIdMap struct {
+		shards      [PageIdMapShardNum]pageIdMapShard
+		sparse      sync.Map
+		denseCnt    int64 // count of entries in dense area
+		sparseCnt   int64 // count of entries in sparse area
+		isSparseAll bool  // store all entries in sparse area
+	}
+
+	pageIdMapShard struct {
+		mu sync.Mutex // serializes writers and growth of ids
+		// entries indexed by Uid / PageIdMapShardNum
+		// parent page id is stored with +1 offset. 0 means no entry
+		ids atomic.Pointer[[]uint32]
+	}
+
+	// PageIdMapStats is size and memory usage of PageIdMap
+	PageIdMapStats struct {
+		Entries       int64 // count of all entries
+		DenseEntries  int64 // count of entries stored in dense area
+		SparseEntries int64 // count of entries stored in sparse area
+		DenseCapacity int64 // count of allocated dense entry slots
+		MemoryBytes   int64 // estimated memory usage in bytes
+	}
+)
+
+// NewPageIdMap creates a new page id conversion map
+// when isSparse is true, all entries are stored in sync.Map
+func NewPageIdMap(isSparse bool) *PageIdMap {
+	return &PageIdMap{isSparseAll: isSparse}
+}
+
+func (m *PageIdMap) isDense(pageNo Uid) bool {
+	return !m.isSparseAll && pageNo < PageIdMapDenseMax
+}
+
+func (m *PageIdMap) shardOf(pageNo Uid) (*pageIdMapShard, uint64) {
+	return &m.shards[pageNo&(PageIdMapShardNum-1)], uint64(pageNo) / PageIdMapShardNum
+}
+
+// Load returns parent page id mapped to pageNo
+func (m *PageIdMap) Load(pageNo Uid) (int32, bool) {
+	if !m.isDense(pageNo) {
+		if val, ok := m.sparse.Load(pageNo); ok {
+			return val.(int32), true
+		}
+		return -1, false
+	}
+
+	shard, idx := m.shardOf(pageNo)
+	ids := shard.ids.Load()
+	if ids == nil || idx >= uint64(len(*ids)) {
+		return -1, false
+	}
+	val := atomic.LoadUint32(&(*ids)[idx])
+	if val == 0 {
+		return -1, false
+	}
+	return int32(val - 1), true
+}
+
+// Store sets parent page id mapped to pageNo
+func (m *PageIdMap) Store(pageNo Uid, ppageId int32) {
+	if !m.isDense(pageNo) {
+		if _, loaded := m.sparse.Swap(pageNo, ppageId); !loaded {
+			atomic.AddInt64(&m.sparseCnt, 1)
+		}
+		return
+	}
+
+	shard, idx := m.shardOf(pageNo)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	ids := shard.ids.Load()
+	if ids == nil || idx >= uint64(len(*ids)) {
+		ids = shard.grow(idx)
+	}
+	if atomic.SwapUint32(&(*ids)[idx], uint32(ppageId)+1) == 0 {
+		atomic.AddInt64(&m.denseCnt, 1)
+	}
+}
+
+// Delete removes the entry of pageNo
+func (m *PageIdMap) Delete(pageNo Uid) {
+	if !m.isDense(pageNo) {
+		if _, loaded := m.sparse.LoadAndDelete(pageNo); loaded {
+			atomic.AddInt64(&m.sparseCnt, -1)
+		}
+		return
+	}
+
+	shard, idx := m.shardOf(pageNo)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	ids := shard.ids.Load()
+	if ids == nil || idx >= uint64(len(*ids)) {
+		return
+	}
+	if atomic.SwapUint32(&(*ids)[idx], 0) != 0 {
+		atomic.AddInt64(&m.denseCnt, -1)
+	}
+}
+
+// Range calls f sequentially for each entry.
+// If f returns false, range stops the iteration.
+// entries which are stored or deleted concurrently may or may not be visited
+func (m *PageIdMap) Range(f func(pageNo Uid, ppageId int32) bool) {
+	for shardIdx := range m.shards {
+		ids := m.shards[shardIdx].ids.Load()
+		if ids == nil {
+			continue
+		}
+		for idx := range *ids {
+			val := atomic.LoadUint32(&(*ids)[idx])
+			if val == 0 {
+				continue
+			}
+			pageNo := Uid(uint64(idx)*PageIdMapShardNum + uint64(shardIdx))
+			if !f(pageNo, int32(val-1)) {
+				return
+			}
+		}
+	}
+
+	m.sparse.Range(func(key, value interface{}) bool {
+		return f(key.(Uid), value.(int32))
+	})
+}
+
+// Len returns count of entries
+func (m *PageIdMap) Len() int64 {
+	return atomic.LoadInt64(&m.denseCnt) + atomic.LoadInt64(&m.sparseCnt)
+}
+
+// Stats returns size and memory usage of the map
+func (m *PageIdMap) Stats() PageIdMapStats {
+	stats := PageIdMapStats{
+		DenseEntries:  atomic.LoadInt64(&m.denseCnt),
+		SparseEntries: atomic.LoadInt64(&m.sparseCnt),
+	}
+	for shardIdx := range m.shards {
+		if ids := m.shards[shardIdx].ids.Load(); ids != nil {
+			stats.DenseCapacity += int64(len(*ids))
+		}
+	}
+	stats.Entries = stats.DenseEntries + stats.SparseEntries
+	stats.MemoryBytes = stats.DenseCapacity*pageIdMapEntrySize + stats.SparseEntries*pageIdMapSparseEst
+
+	return stats
+}
+
+// grow extends entries of the shard to be able to store idx
+// call with shard mutex locked
+func (s *pageIdMapShard) grow(idx uint64) *[]uint32 {
+	newLen := uint64(pageIdMapInitShard)
+	old := s.ids.Load()
+	if old != nil {
+		newLen = uint64(len(*old))
+	}
+	for newLen <= idx {
+		newLen *= 2
+	}
+
+	newIds := make([]uint32, newLen)
+	if old != nil {
+		for i := range *old {
+			newIds[i] = atomic.LoadUint32(&(*old)[i])
+		}
+	}
+	s.ids.Store(&newIds)
+
+	return &newIds
+}
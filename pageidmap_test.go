@@ -0,0 +1,119 @@
+package blink_tree
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestPageIdMap_StoreLoadDelete(t *testing.T) {
+	tests := []struct {
+		name     string
+		isSparse bool
+		pageNos  []Uid
+	}{
+		{
+			name:     "dense mode",
+			isSparse: false,
+			pageNos:  []Uid{0, 1, 2, 15, 16, 17, 1000, 100000},
+		},
+		{
+			name:     "dense mode with too large uid",
+			isSparse: false,
+			pageNos:  []Uid{0, 1, PageIdMapDenseMax, PageIdMapDenseMax + 1},
+		},
+		{
+			name:     "sparse mode",
+			isSparse: true,
+			pageNos:  []Uid{0, 1, 2, 1000, PageIdMapDenseMax},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewPageIdMap(tt.isSparse)
+			for i, pageNo := range tt.pageNos {
+				m.Store(pageNo, int32(i))
+			}
+			// overwrite doesn't increase entry count
+			m.Store(tt.pageNos[0], 0)
+
+			if got := m.Len(); got != int64(len(tt.pageNos)) {
+				t.Errorf("Len() = %v, want %v", got, len(tt.pageNos))
+			}
+			for i, pageNo := range tt.pageNos {
+				if got, ok := m.Load(pageNo); !ok || got != int32(i) {
+					t.Errorf("Load(%d) = %v, %v, want %v, true", pageNo, got, ok, i)
+				}
+			}
+			if _, ok := m.Load(3); ok {
+				t.Errorf("Load(3) found not stored entry")
+			}
+
+			visited := 0
+			m.Range(func(pageNo Uid, ppageId int32) bool {
+				if tt.pageNos[ppageId] != pageNo {
+					t.Errorf("Range() visited %d -> %d unexpectedly", pageNo, ppageId)
+				}
+				visited++
+				return true
+			})
+			if visited != len(tt.pageNos) {
+				t.Errorf("Range() visited %d entries, want %d", visited, len(tt.pageNos))
+			}
+
+			for _, pageNo := range tt.pageNos {
+				m.Delete(pageNo)
+			}
+			if got := m.Len(); got != 0 {
+				t.Errorf("Len() after Delete = %v, want 0", got)
+			}
+			if _, ok := m.Load(tt.pageNos[0]); ok {
+				t.Errorf("Load() found deleted entry")
+			}
+		})
+	}
+}
+
+func TestPageIdMap_Stats(t *testing.T) {
+	m := NewPageIdMap(false)
+	for i := 0; i < 1000; i++ {
+		m.Store(Uid(i), int32(i))
+	}
+	m.Store(PageIdMapDenseMax, 1000)
+
+	stats := m.Stats()
+	if stats.Entries != 1001 || stats.DenseEntries != 1000 || stats.SparseEntries != 1 {
+		t.Errorf("Stats() = %+v, want 1001 entries (dense: 1000, sparse: 1)", stats)
+	}
+	if stats.DenseCapacity < 1000 {
+		t.Errorf("Stats().DenseCapacity = %v, want >= 1000", stats.DenseCapacity)
+	}
+	if stats.MemoryBytes < stats.DenseCapacity*pageIdMapEntrySize {
+		t.Errorf("Stats().MemoryBytes = %v is too small", stats.MemoryBytes)
+	}
+}
+
+func TestPageIdMap_Concurrent(t *testing.T) {
+	m := NewPageIdMap(false)
+	routineNum := 8
+	perRoutine := 10000
+
+	wg := sync.WaitGroup{}
+	wg.Add(routineNum)
+	for r := 0; r < routineNum; r++ {
+		go func(n int) {
+			defer wg.Done()
+			for i := 0; i < perRoutine; i++ {
+				pageNo := Uid(i*routineNum + n)
+				m.Store(pageNo, int32(pageNo))
+				if got, ok := m.Load(pageNo); !ok || got != int32(pageNo) {
+					t.Errorf("Load(%d) = %v, %v", pageNo, got, ok)
+				}
+			}
+		}(r)
+	}
+	wg.Wait()
+
+	if got := m.Len(); got != int64(routineNum*perRoutine) {
+		t.Errorf("Len() = %v, want %v", got, routineNum*perRoutine)
+	}
+}
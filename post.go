@@ -0,0 +1,106 @@
+package blink_tree
+
+// maxPostRetries bounds how many times a queued insert is retried
+// after it split its target page
+const maxPostRetries = 64
+
+type postKind uint8
+
+const (
+	postInsert  postKind = iota // insert key at a level
+	postDelete                  // delete key at a level
+	postRelease                 // release locks held while fence keys are posted
+)
+
+// parentPost is a pending operation of insert, delete or structure modification.
+//
+// splitKeys, fixFence and deletePage don't call InsertKey/DeleteKey of upper
+// level recursively. they queue fence key postings on the stack of current
+// operation followed by release of the parent locks they hold, and runPosts
+// executes the stack in LIFO order. so postings of a page are completed before its
+// parent locks are released, in the same order as the recursive calls.
+type parentPost struct {
+	kind    postKind
+	key     []byte // key to find target slot
+	ins     []byte // key to insert. uniqueifier is appended for Duplicate
+	lvl     uint8
+	value   []byte
+	typ     SlotType
+	release func(ok bool) // ok is false when a posting above it failed
+	retries int
+}
+
+// postStack is stack of pending operations of an insert or delete.
+// it is local to the operation because BLTree may be shared by goroutines
+type postStack []parentPost
+
+// pushInsert queues insert of fence key which points pageNo
+func (posts *postStack) pushInsert(key []byte, lvl uint8, pageNo Uid) {
+	var value [BtId]byte
+	PutID(&value, pageNo)
+	*posts = append(*posts, parentPost{kind: postInsert, key: key, ins: key, lvl: lvl, value: value[:], typ: Unique})
+}
+
+// pushDelete queues delete of fence key
+func (posts *postStack) pushDelete(key []byte, lvl uint8) {
+	*posts = append(*posts, parentPost{kind: postDelete, key: key, lvl: lvl})
+}
+
+// pushRelease queues release of locks
+func (posts *postStack) pushRelease(release func(ok bool)) {
+	*posts = append(*posts, parentPost{kind: postRelease, release: release})
+}
+
+// runPosts executes queued operations until the stack is empty.
+// an insert which split its page stays on the stack under the postings
+// of the split and is retried after them.
+// when an operation fails, locks of pending releases are released
+// and the rest of operations are discarded.
+func (tree *BLTree) runPosts(posts *postStack) BLTErr {
+	for len(*posts) > 0 {
+		top := len(*posts) - 1
+		post := (*posts)[top]
+
+		done := true
+		var err BLTErr
+		switch post.kind {
+		case postInsert:
+			done, err = tree.insertOnce(posts, post.key, post.ins, post.lvl, post.value, post.typ)
+		case postDelete:
+			err = tree.deleteOnce(posts, post.key, post.lvl)
+		case postRelease:
+			post.release(true)
+		}
+
+		if err != BLTErrOk {
+			*posts = append((*posts)[:top], (*posts)[top+1:]...)
+			posts.abort()
+			return err
+		}
+		if !done {
+			(*posts)[top].retries++
+			if (*posts)[top].retries > maxPostRetries {
+				// the page keeps being filled by others
+				tree.err = BLTErrStruct
+				posts.abort()
+				return tree.err
+			}
+			continue
+		}
+
+		// operations queued by the finished one are kept above it
+		*posts = append((*posts)[:top], (*posts)[top+1:]...)
+	}
+	return BLTErrOk
+}
+
+// abort discards queued operations
+// and releases locks held for them
+func (posts *postStack) abort() {
+	for i := len(*posts) - 1; i >= 0; i-- {
+		if (*posts)[i].kind == postRelease {
+			(*posts)[i].release(false)
+		}
+	}
+	*posts = (*posts)[:0]
+}
@@ -0,0 +1,95 @@
+package blink_tree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestBLTree_deepTreePosting(t *testing.T) {
+	mgr := NewBufMgr(12, 64, NewParentBufMgrDummy(nil), nil)
+	bltree := NewBLTree(mgr)
+
+	// long keys without common prefix make the tree deep with few keys
+	key := func(i int) []byte {
+		bs := bytes.Repeat([]byte{byte(i)}, 200)
+		binary.BigEndian.PutUint32(bs, uint32(i))
+		return bs
+	}
+
+	num := 3000
+	for i := 0; i < num; i++ {
+		if err := bltree.InsertKey(key(i), 0, make([]byte, BtId), true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+
+	var root PageSet
+	root.latch = mgr.PinLatch(RootPage, true, &bltree.reads, &bltree.writes)
+	root.page = mgr.GetRefOfPageAtPool(root.latch)
+	lvl := root.page.Lvl
+	mgr.UnpinLatch(root.latch)
+	if lvl < 3 {
+		t.Fatalf("root level = %v, want at least %v", lvl, 3)
+	}
+
+	// deleting a range of keys empties pages of every level
+	deleted := func(i int) bool { return i%2 == 0 || (i >= 1000 && i < 2000) }
+	for i := 0; i < num; i++ {
+		if !deleted(i) {
+			continue
+		}
+		if err := bltree.DeleteKey(key(i), 0); err != BLTErrOk {
+			t.Fatalf("DeleteKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+	for i := 0; i < num; i++ {
+		ret, _, _ := bltree.FindKey(key(i), BtId)
+		if deleted(i) && ret != -1 {
+			t.Errorf("FindKey() of deleted key = %v, want %v", ret, -1)
+		} else if !deleted(i) && ret != BtId {
+			t.Errorf("FindKey() = %v, want %v", ret, BtId)
+		}
+	}
+}
+
+func TestBLTree_abortPosts(t *testing.T) {
+	mgr := NewBufMgr(12, 20, NewParentBufMgrDummy(nil), nil)
+	allocated, _ := mgr.PageCapacity()
+	mgr.SetPageLimit(allocated + 4)
+	bltree := NewBLTree(mgr)
+
+	var failed []byte
+	for i := uint64(0); failed == nil; i++ {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		err := bltree.InsertKey(bs, 0, make([]byte, BtId), true)
+		if err == BLTErrCapacity {
+			failed = bs
+		} else if err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		} else if i > 100000 {
+			t.Fatalf("InsertKey() never returned %v", BLTErrCapacity)
+		}
+	}
+
+	// pending release is done with ok=false when an operation above it fails
+	released := 0
+	var posts postStack
+	posts.pushRelease(func(ok bool) {
+		if ok {
+			t.Errorf("release ok = %v, want %v", ok, false)
+		}
+		released++
+	})
+	posts = append(posts, parentPost{kind: postInsert, key: failed, ins: failed, value: make([]byte, BtId), typ: Unique})
+	if err := bltree.runPosts(&posts); err != BLTErrCapacity {
+		t.Errorf("runPosts() = %v, want %v", err, BLTErrCapacity)
+	}
+	if released != 1 {
+		t.Errorf("release is called %v times, want %v", released, 1)
+	}
+	if len(posts) != 0 {
+		t.Errorf("runPosts() left %v pending posts", len(posts))
+	}
+}
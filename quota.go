@@ -0,0 +1,129 @@
+package blink_tree
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// SetDirtyQuota sets max count of dirty pages kept in buffer pool.
+// when an InsertKey or DeleteKey call leaves more dirty pages than quota,
+// dirty pages are written back to parent buffer pool until half of quota remains.
+// 0 means no limit.
+//
+// resident pages of the tree are limited by nodeMax of NewBufMgr and SetResidentQuota
+func (mgr *BufMgr) SetDirtyQuota(pages uint) {
+	atomic.StoreUint32(&mgr.dirtyQuota, uint32(pages))
+}
+
+// DirtyPages returns count of dirty pages in buffer pool
+func (mgr *BufMgr) DirtyPages() uint {
+	if cnt := atomic.LoadInt64(&mgr.dirtyCnt); cnt > 0 {
+		return uint(cnt)
+	}
+	return 0
+}
+
+// markDirty marks page of latch dirty
+// call with page write locked or with page which is not shared yet
+func (mgr *BufMgr) markDirty(latch *Latchs) {
+	if !latch.dirty {
+		latch.dirty = true
+		atomic.AddInt64(&mgr.dirtyCnt, 1)
+	}
+}
+
+// clearDirty clears dirty bit of latch after page is written back
+func (mgr *BufMgr) clearDirty(latch *Latchs) {
+	if latch.dirty {
+		latch.dirty = false
+		latch.lsn = 0
+		atomic.AddInt64(&mgr.dirtyCnt, -1)
+	}
+}
+
+// markDirty marks page dirty and attaches LSN set by SetLSN to the page.
+// LSN in page header is raised again when the page is written back
+// because the header may be overwritten by copying page contents
+func (tree *BLTree) markDirty(latch *Latchs) {
+	tree.mgr.markDirty(latch)
+	if tree.lsn > latch.lsn {
+		latch.lsn = tree.lsn
+	}
+	if page := tree.mgr.GetRefOfPageAtPool(latch); tree.lsn > page.LSN {
+		page.LSN = tree.lsn
+	}
+}
+
+// enforceDirtyQuota writes back dirty pages if count of them exceeds quota
+// call without any page latched
+func (mgr *BufMgr) enforceDirtyQuota(reads *uint, writes *uint) {
+	quota := atomic.LoadUint32(&mgr.dirtyQuota)
+	if quota == 0 || mgr.DirtyPages() <= uint(quota) {
+		return
+	}
+
+	// dirty bit of latch is read only with the page pinned and locked
+	// because it's set under page write lock
+	target := uint(quota / 2)
+	for hashIdx := uint(0); hashIdx < mgr.latchHash && mgr.DirtyPages() > target; hashIdx++ {
+		for _, pageNo := range mgr.chainPages(hashIdx) {
+			latch := mgr.pinResident(pageNo)
+			if latch == nil {
+				continue
+			}
+
+			// page write lock is not held by others while read lock is held
+			mgr.PageLock(LockRead, latch)
+			if latch.dirty {
+				if mgr.pageOut(mgr.GetRefOfPageAtPool(latch), pageNo, true, latch.lsn, time.Time{}) == BLTErrOk {
+					mgr.clearDirty(latch)
+					*writes++
+				}
+			}
+			mgr.PageUnlock(LockRead, latch)
+			mgr.UnpinLatch(latch)
+		}
+	}
+}
+
+// chainPages returns page numbers on hash chain hashIdx
+func (mgr *BufMgr) chainPages(hashIdx uint) []Uid {
+	mgr.hashTable[hashIdx].latch.SpinReadLock()
+	defer mgr.hashTable[hashIdx].latch.SpinReleaseRead()
+
+	var pages []Uid
+	for slot := mgr.hashTable[hashIdx].slot; slot > 0; slot = mgr.latchs[slot].next {
+		pages = append(pages, mgr.latchs[slot].pageNo)
+	}
+	return pages
+}
+
+// SetResidentQuota sets max count of pages kept in buffer pool, which is
+// nodeMax of NewBufMgr at most. once quota pages are resident, a page fault
+// evicts one of them instead of taking another entry of buffer pool.
+// 0 means no limit other than nodeMax.
+//
+// BLTErrCapacity is returned when quota is less than HASH_TABLE_ENTRY_CHAIN_LEN,
+// which is too few for pages pinned by concurrent operations, or when more pages
+// than quota are already resident. so it's intended to be called after opening
+// the tree and before other operations
+func (mgr *BufMgr) SetResidentQuota(pages uint) BLTErr {
+	if pages != 0 && (pages < HASH_TABLE_ENTRY_CHAIN_LEN || mgr.ResidentPages() > pages) {
+		return BLTErrCapacity
+	}
+	atomic.StoreUint32(&mgr.residentQuota, uint32(pages))
+	return BLTErrOk
+}
+
+// ResidentPages returns count of pages in buffer pool
+func (mgr *BufMgr) ResidentPages() uint {
+	return min(uint(atomic.LoadUint32(&mgr.latchDeployed)), mgr.latchTotal-1)
+}
+
+// residentLimit returns latch entry number which isn't deployed under resident quota
+func (mgr *BufMgr) residentLimit() uint {
+	if quota := uint(atomic.LoadUint32(&mgr.residentQuota)); quota > 0 && quota < mgr.latchTotal-1 {
+		return quota + 1
+	}
+	return mgr.latchTotal
+}
@@ -0,0 +1,157 @@
+package blink_tree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/ryogrid/bltree-go-for-embedding/interfaces"
+	"sync"
+	"testing"
+)
+
+func TestBufMgr_DirtyQuota(t *testing.T) {
+	mgr := NewBufMgr(12, 64, NewParentBufMgrDummy(nil), nil)
+	mgr.SetDirtyQuota(4)
+	bltree := NewBLTree(mgr)
+
+	num := uint64(5000)
+	for i := uint64(0); i < num; i++ {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		if err := bltree.InsertKey(bs, 0, make([]byte, BtId), true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+		if got := mgr.DirtyPages(); got > 4 {
+			t.Fatalf("DirtyPages() = %v, want <= 4", got)
+		}
+	}
+	for i := uint64(0); i < num; i++ {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		if _, foundKey, _ := bltree.FindKey(bs, BtId); !bytes.Equal(foundKey, bs) {
+			t.Fatalf("FindKey() = %v, want %v", foundKey, bs)
+		}
+	}
+
+	if err := mgr.Close(); err != BLTErrOk {
+		t.Fatalf("Close() = %v, want %v", err, BLTErrOk)
+	}
+	if got := mgr.DirtyPages(); got != 0 {
+		t.Errorf("DirtyPages() after Close() = %v, want 0", got)
+	}
+}
+
+func TestBufMgr_ResidentQuota(t *testing.T) {
+	mgr := NewBufMgr(12, 256, NewParentBufMgrDummy(nil), nil)
+	if err := mgr.SetResidentQuota(HASH_TABLE_ENTRY_CHAIN_LEN - 1); err != BLTErrCapacity {
+		t.Errorf("SetResidentQuota() of too few pages = %v, want %v", err, BLTErrCapacity)
+	}
+	quota := uint(32)
+	if err := mgr.SetResidentQuota(quota); err != BLTErrOk {
+		t.Fatalf("SetResidentQuota() = %v, want %v", err, BLTErrOk)
+	}
+	mgr.SetDirtyQuota(8)
+	bltree := NewBLTree(mgr)
+
+	num := uint64(20000)
+	key := func(i uint64) []byte {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		return bs
+	}
+	for i := uint64(0); i < num; i++ {
+		if err := bltree.InsertKey(key(i), 0, key(i), true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+		if got := mgr.ResidentPages(); got > quota {
+			t.Fatalf("ResidentPages() = %v, want <= %v", got, quota)
+		}
+	}
+	for i := uint64(0); i < num; i++ {
+		if _, _, val := bltree.FindKey(key(i), 8); !bytes.Equal(val, key(i)) {
+			t.Fatalf("FindKey() = %v, want %v", val, key(i))
+		}
+	}
+	if report, err := ValidateTree(bltree); err != BLTErrOk || !report.Valid() || report.Keys != num {
+		t.Errorf("ValidateTree() = %v, %v keys, %v, want valid and %v keys", report.Problems, report.Keys, err, num)
+	}
+	if got := mgr.ResidentPages(); got != quota {
+		t.Errorf("ResidentPages() = %v, want %v", got, quota)
+	}
+
+	// quota can't be lower than count of resident pages
+	if err := mgr.SetResidentQuota(quota - 1); err != BLTErrCapacity {
+		t.Errorf("SetResidentQuota() under resident pages = %v, want %v", err, BLTErrCapacity)
+	}
+	if err := mgr.SetResidentQuota(0); err != BLTErrOk {
+		t.Errorf("SetResidentQuota() of no limit = %v, want %v", err, BLTErrOk)
+	}
+	for i := uint64(0); i < num; i++ {
+		bltree.FindKey(key(i), 8)
+	}
+	if got := mgr.ResidentPages(); got <= quota {
+		t.Errorf("ResidentPages() without quota = %v, want > %v", got, quota)
+	}
+}
+
+// parentBufMgrLSN is ParentBufMgr which records LSNs passed with dirty pages
+type parentBufMgrLSN struct {
+	interfaces.ParentBufMgr
+	mu   sync.Mutex
+	lsns map[int32]uint64
+}
+
+func (p *parentBufMgrLSN) UnpinPPageWithLSN(pageID int32, isDirty bool, lsn uint64) error {
+	p.mu.Lock()
+	if lsn > p.lsns[pageID] {
+		p.lsns[pageID] = lsn
+	}
+	p.mu.Unlock()
+	return p.UnpinPPage(pageID, isDirty)
+}
+
+func TestBLTree_SetLSN(t *testing.T) {
+	pbm := &parentBufMgrLSN{ParentBufMgr: NewParentBufMgrDummy(nil), lsns: make(map[int32]uint64)}
+	mgr := NewBufMgr(12, 64, pbm, nil)
+	mgr.SetDirtyQuota(4)
+	bltree := NewBLTree(mgr)
+
+	num := 2000
+	for i := 0; i < num; i++ {
+		bltree.SetLSN(uint64(i + 1))
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, uint64(i))
+		if err := bltree.InsertKey(bs, 0, make([]byte, BtId), true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+	if err := mgr.Close(); err != BLTErrOk {
+		t.Fatalf("Close() = %v, want %v", err, BLTErrOk)
+	}
+
+	// every written page carries LSN of its last change, and the page
+	// of the last inserted key carries the last LSN
+	var maxLSN uint64
+	for pageID, lsn := range pbm.lsns {
+		if lsn > uint64(num) {
+			t.Errorf("LSN of page %v = %v, want at most %v", pageID, lsn, num)
+		}
+		if lsn > maxLSN {
+			maxLSN = lsn
+		}
+	}
+	if len(pbm.lsns) < 2 {
+		t.Errorf("pages written with LSN = %v, want at least %v", len(pbm.lsns), 2)
+	}
+	if maxLSN != uint64(num) {
+		t.Errorf("largest LSN = %v, want %v", maxLSN, num)
+	}
+
+	// page images carry the LSN in their header
+	for pageID, lsn := range pbm.lsns {
+		ppage := pbm.FetchPPage(pageID)
+		if got := PageImageLSN(ppage.DataAsSlice()); got != lsn {
+			t.Errorf("PageImageLSN() of page %v = %v, want %v", pageID, got, lsn)
+		}
+		pbm.UnpinPPage(pageID, false)
+	}
+}
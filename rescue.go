@@ -0,0 +1,442 @@
+package blink_tree
+
+import (
+	"github.com/ryogrid/bltree-go-for-embedding/interfaces"
+	"sort"
+)
+
+type (
+	// RescueReport is result of RescueBufMgr
+	RescueReport struct {
+		Scanned   int     // count of scanned candidate parent pages
+		Recovered int     // count of blink-tree pages mapped to page numbers
+		FreePages int     // count of pages put on free chain
+		Ambiguous int     // count of pages whose fence key is shared with another page at same level
+		Orphans   []int32 // parent page ids which look like blink-tree pages but are not in the tree
+	}
+
+	// rescuePage is a blink-tree page found on scanning parent pages
+	rescuePage struct {
+		ppageId  int32
+		page     Page
+		fence    []byte
+		pageNo   Uid
+		assigned bool
+	}
+)
+
+// RescueBufMgr opens a tree whose page zero is lost (lastPageZeroId is unknown)
+// by scanning candidate parent pages.
+//
+// pages which look like blink-tree pages are linked from root page
+// through child pointers of upper levels and right pointers, and the page id
+// mapping is rebuilt from them. unreachable free pages are put on the free chain
+// and other unreachable pages are reported as orphans without modification.
+// overflow pages which are not free are also reported as orphans because
+// their page numbers are held only by values of keys.
+// page zero is created newly, so call Close and record
+// GetMappedPPageIdOfPageZero to open the tree with NewBufMgr afterward.
+func RescueBufMgr(bits uint8, nodeMax uint, pbm interfaces.ParentBufMgr, candidates []int32) (*BufMgr, *RescueReport, BLTErr) {
+	mgr := newBufMgr(bits, nodeMax, pbm)
+	report := &RescueReport{}
+
+	// scan candidate parent pages
+	levels := make(map[uint8][]*rescuePage)
+	var frees []*rescuePage
+	var topLvl uint8
+	for _, ppageId := range candidates {
+		ppage := mgr.pbm.FetchPPage(ppageId)
+		if ppage == nil {
+			continue
+		}
+		report.Scanned++
+
+		rp := &rescuePage{ppageId: ppageId}
+		// page which doesn't match its checksum isn't trusted.
+		// encoded or encrypted page isn't read because codec and encryptor can't be set yet
+		err := readPageImage(ppage.DataAsSlice(), &rp.page, mgr.pageDataSize, imageFilter{})
+		mgr.pbm.UnpinPPage(ppageId, false)
+
+		if err != BLTErrOk {
+			continue
+		}
+		// overflow pages are linked only from values of keys
+		if rp.page.Kind == PageKindOverflow {
+			if rp.page.Free {
+				frees = append(frees, rp)
+			} else {
+				report.Orphans = append(report.Orphans, ppageId)
+			}
+			continue
+		}
+		if !mgr.isRescuablePage(&rp.page) {
+			continue
+		}
+
+		switch {
+		case rp.page.Free:
+			frees = append(frees, rp)
+		case rp.page.Kill:
+			// content was moved to left page
+			report.Orphans = append(report.Orphans, ppageId)
+		default:
+			rp.fence = rp.page.Key(rp.page.Cnt)
+			levels[rp.page.Lvl] = append(levels[rp.page.Lvl], rp)
+			if rp.page.Lvl > topLvl {
+				topLvl = rp.page.Lvl
+			}
+		}
+	}
+
+	// pages of each level are ordered by fence key as right pointer chain
+	byFence := make(map[uint8]map[string]*rescuePage)
+	for lvl, pages := range levels {
+		sort.SliceStable(pages, func(i, j int) bool {
+			return KeyCmp(pages[i].fence, pages[j].fence) < 0
+		})
+		byFence[lvl] = make(map[string]*rescuePage)
+		for _, rp := range pages {
+			if _, ok := byFence[lvl][string(rp.fence)]; ok {
+				report.Ambiguous++
+				continue
+			}
+			byFence[lvl][string(rp.fence)] = rp
+		}
+	}
+
+	// root page is the rightmost page of top level
+	pages := levels[topLvl]
+	if len(pages) == 0 {
+		mgr.err = BLTErrStruct
+		return nil, report, mgr.err
+	}
+	root := pages[len(pages)-1]
+	if GetID(&root.page.Right) != 0 || KeyCmp(root.fence, []byte{0xff, 0xff}) != 0 {
+		mgr.err = BLTErrStruct
+		return nil, report, mgr.err
+	}
+
+	assignedPages := make(map[Uid]*rescuePage)
+	assign := func(rp *rescuePage, pageNo Uid) {
+		if rp.assigned || pageNo == 0 {
+			return
+		}
+		if _, ok := assignedPages[pageNo]; ok {
+			return
+		}
+		rp.pageNo = pageNo
+		rp.assigned = true
+		assignedPages[pageNo] = rp
+	}
+	assign(root, RootPage)
+
+	for lvl := int(topLvl); lvl >= 0; lvl-- {
+		pages = levels[uint8(lvl)]
+
+		// follow child pointers of upper level
+		if lvl < int(topLvl) {
+			for _, parent := range levels[uint8(lvl+1)] {
+				if !parent.assigned {
+					continue
+				}
+				for slot := uint32(1); slot <= parent.page.Cnt; slot++ {
+					if parent.page.Dead(slot) || parent.page.Typ(slot) == Librarian {
+						continue
+					}
+					if child, ok := byFence[uint8(lvl)][string(parent.page.Key(slot))]; ok {
+						assign(child, GetIDFromValue(parent.page.Value(slot)))
+					}
+				}
+			}
+		}
+
+		// follow right pointers which are not posted to upper level yet
+		for i := 0; i < len(pages)-1; i++ {
+			if pages[i].assigned {
+				assign(pages[i+1], GetID(&pages[i].page.Right))
+			}
+		}
+	}
+
+	// rebuild page id mapping
+	maxPageNo := Uid(MinLvl)
+	for pageNo, rp := range assignedPages {
+		mgr.pageIdConvMap.Store(pageNo, rp.ppageId)
+		if pageNo > maxPageNo {
+			maxPageNo = pageNo
+		}
+		report.Recovered++
+	}
+	for lvl := range levels {
+		for _, rp := range levels[lvl] {
+			if !rp.assigned {
+				report.Orphans = append(report.Orphans, rp.ppageId)
+			}
+		}
+	}
+
+	// rebuild free chain with unreachable free pages.
+	// they are numbered with page numbers which are not used in the tree
+	var chain [BtId]uint8
+	pageNo := Uid(1)
+	for _, rp := range frees {
+		for ; pageNo < maxPageNo; pageNo++ {
+			if _, ok := assignedPages[pageNo]; !ok {
+				break
+			}
+		}
+		if pageNo >= maxPageNo {
+			report.Orphans = append(report.Orphans, rp.ppageId)
+			continue
+		}
+
+		rp.page.Right = chain
+		mgr.pageIdConvMap.Store(pageNo, rp.ppageId)
+		if err := mgr.PageOut(&rp.page, pageNo, true); err != BLTErrOk {
+			return nil, report, err
+		}
+		PutID(&chain, pageNo)
+		report.FreePages++
+		pageNo++
+	}
+	mgr.pageZero.chain = chain
+
+	if err := mgr.initPageZero(maxPageNo + 1); err != BLTErrOk {
+		return nil, report, err
+	}
+
+	return mgr, report, BLTErrOk
+}
+
+// isRescuablePage reports whether page has consistent blink-tree page header and slots
+func (mgr *BufMgr) isRescuablePage(page *Page) bool {
+	if page.Bits != mgr.pageBits || page.Kind != PageKindTree || page.Lvl > 8*BtId {
+		return false
+	}
+	if page.Cnt == 0 || page.Act > page.Cnt || page.Garbage > mgr.pageDataSize {
+		return false
+	}
+	if page.Min > mgr.pageDataSize || page.Cnt*SlotSize > page.Min {
+		return false
+	}
+
+	for slot := uint32(1); slot <= page.Cnt; slot++ {
+		if page.Typ(slot) > Delete {
+			return false
+		}
+		off := page.KeyOffset(slot)
+		if off < page.Min || off >= mgr.pageDataSize {
+			return false
+		}
+		// omitted key prefix must be stored at the tail of data area
+		if pre := page.prefixLen(slot); pre > 0 {
+			if pre > uint32(page.Data[mgr.pageDataSize-1]) || pre >= mgr.pageDataSize-page.Min {
+				return false
+			}
+		}
+		// key and value with length prefixes must be in page
+		if page.fixedEntry(slot) {
+			if page.KeyWidth == 0 || page.prefixLen(slot) > uint32(page.KeyWidth) || off+page.entrySize(slot) > mgr.pageDataSize {
+				return false
+			}
+			continue
+		}
+		valOff := off + 1 + page.storedKeyLen(slot)
+		if valOff >= mgr.pageDataSize || valOff+1+uint32(page.Data[valOff]) > mgr.pageDataSize {
+			return false
+		}
+	}
+
+	return true
+}
+
+// completeHalfSplits runs RepairHalfSplits once before the first operation
+// on the tree opened by OpenBufMgr. the flag is cleared before the repair,
+// so operations of the repair itself don't wait for it. failure of the repair
+// is kept in err of mgr, and the half splits are left to be reported by ValidateTree
+func (mgr *BufMgr) completeHalfSplits() {
+	if !mgr.halfSplits.Load() {
+		return
+	}
+	mgr.halfSplitsLock.Lock()
+	defer mgr.halfSplitsLock.Unlock()
+	if !mgr.halfSplits.Swap(false) {
+		return
+	}
+	if _, err := NewBLTree(mgr).RepairHalfSplits(); err != BLTErrOk {
+		mgr.err = err
+	}
+}
+
+// fencePosting is a fence key of a page which should be posted to the upper level
+type fencePosting struct {
+	fence  []byte
+	pageNo Uid
+	lvl    uint8
+}
+
+// RepairHalfSplits completes splits whose fence keys were not posted to the upper
+// level, e.g. because of crash between writing split pages and posting the fences.
+// such a page is reachable only through right link of its left sibling.
+//
+// pages of each level are walked through right links from the leftmost page and
+// compared with the keys of the upper level. a missing or stale key for a page
+// is posted as splitKeys does. returns count of posted fence keys.
+// it's called before the first operation on a tree opened by OpenBufMgr
+// (see completeHalfSplits), so it needs to be called again only when that failed,
+// e.g. because a page can't be read until it's rebuilt by RepairPage.
+func (tree *BLTree) RepairHalfSplits() (int, BLTErr) {
+	repaired := 0
+
+	tree.startOp()
+
+	// live keys of the upper level and their child pages
+	var uppers map[string]Uid
+	pageNo := RootPage
+	for pageNo > 0 {
+		var missing []fencePosting
+		lowers := make(map[string]Uid)
+
+		lower, err := tree.walkLevel(pageNo, func(pageNo Uid, page *Page) (bool, BLTErr) {
+			if uppers != nil {
+				fence := page.Key(page.Cnt)
+				if child, ok := uppers[string(fence)]; !ok || child != pageNo {
+					missing = append(missing, fencePosting{fence: fence, pageNo: pageNo, lvl: page.Lvl})
+				}
+			}
+			if page.Lvl > 0 {
+				for slot := uint32(1); slot <= page.Cnt; slot++ {
+					if !page.Dead(slot) && page.Typ(slot) != Librarian {
+						lowers[string(page.Key(slot))] = GetIDFromValue(page.Value(slot))
+					}
+				}
+			}
+			return true, BLTErrOk
+		})
+		if err != BLTErrOk {
+			return repaired, err
+		}
+
+		// postings are ordered from left to right, so fence of left sibling
+		// is inserted before the key of right sibling is switched to it
+		for _, posting := range missing {
+			var value [BtId]byte
+			PutID(&value, posting.pageNo)
+			if err := tree.InsertKey(posting.fence, posting.lvl+1, value[:], true); err != BLTErrOk {
+				return repaired, err
+			}
+			repaired++
+		}
+
+		uppers = lowers
+		pageNo = lower
+	}
+
+	return repaired, BLTErrOk
+}
+
+// RepairPage rebuilds leaf page pageNo which can't be read, e.g. because it doesn't
+// match its checksum, as an empty page so that the rest of the tree remains usable.
+// keys of the page are lost, and they can be inserted again afterward.
+//
+// the page isn't read. its key range is reconstructed from the level above leaves:
+// the key pointing to the page is its fence key, the key pointing to the preceding
+// page, whose right link must be pageNo, is its low fence, and the page pointed by
+// the following key becomes its right link. so half splits must be completed
+// beforehand, which is done before the first operation of an opened tree. BLTErrStruct is returned when pageNo isn't pointed
+// by the level above leaves, e.g. pageNo is the root page or an upper page.
+// it is intended to be called after opening the tree with NewBufMgr
+// and before other operations.
+func (tree *BLTree) RepairPage(pageNo Uid) BLTErr {
+	tree.startOp()
+
+	upper, err := tree.leftmostPage(1)
+	if err != BLTErrOk {
+		return err
+	}
+
+	// live keys of the level above leaves and their child pages in key order
+	var keys [][]byte
+	var children []Uid
+	if _, err := tree.walkLevel(upper, func(_ Uid, page *Page) (bool, BLTErr) {
+		for slot := uint32(1); slot <= page.Cnt; slot++ {
+			if !page.Dead(slot) && page.Typ(slot) != Librarian {
+				keys = append(keys, page.Key(slot))
+				children = append(children, GetIDFromValue(page.Value(slot)))
+			}
+		}
+		return true, BLTErrOk
+	}); err != BLTErrOk {
+		return err
+	}
+
+	idx := -1
+	for i, child := range children {
+		if child == pageNo {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		tree.err = BLTErrStruct
+		return tree.err
+	}
+
+	var right Uid
+	if idx+1 < len(children) {
+		right = children[idx+1]
+	}
+	var page *Page
+	if right == 0 {
+		page = tree.mgr.stopperPage(0, []byte{})
+	} else {
+		page = NewPage(tree.mgr.pageDataSize)
+		page.Bits = tree.mgr.pageBits
+		tree.mgr.setFixedWidth(page)
+		l := &bulkLevel{frame: page, nxt: tree.mgr.pageDataSize}
+		l.put(keys[idx], []byte{})
+		page.SetDead(page.Cnt, true)
+		page.Act = 0
+		page.Garbage = page.countGarbage()
+		page.setHighFence()
+		PutID(&page.Right, right)
+	}
+
+	if idx > 0 {
+		if !tree.linksTo(children[idx-1], pageNo) {
+			return tree.err
+		}
+		page.LowFence = fenceHint(keys[idx-1])
+	}
+
+	latch, err := tree.mgr.pinLatch(pageNo, false, &tree.reads, &tree.writes, tree.deadline)
+	if latch == nil {
+		tree.err = err
+		return err
+	}
+	tree.mgr.PageLock(LockWrite, latch)
+	// the page isn't loaded, so its data area isn't allocated
+	pooled := tree.mgr.GetRefOfPageAtPool(latch)
+	pooled.Data = make([]byte, tree.mgr.pageDataSize)
+	MemCpyPage(pooled, page)
+	tree.markDirty(latch)
+	tree.mgr.PageUnlock(LockWrite, latch)
+	tree.mgr.UnpinLatch(latch)
+	return BLTErrOk
+}
+
+// linksTo reports whether right link of page pageNo is right.
+// tree.err is set to BLTErrStruct when it isn't
+func (tree *BLTree) linksTo(pageNo Uid, right Uid) bool {
+	linked := false
+	if _, err := tree.walkLevel(pageNo, func(_ Uid, page *Page) (bool, BLTErr) {
+		linked = GetID(&page.Right) == right
+		return false, BLTErrOk
+	}); err != BLTErrOk {
+		return false
+	}
+	if !linked {
+		tree.err = BLTErrStruct
+	}
+	return linked
+}
@@ -0,0 +1,225 @@
+package blink_tree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sync"
+	"testing"
+)
+
+func TestRescueBufMgr(t *testing.T) {
+	pbmPageMap := &sync.Map{}
+
+	pbm := NewParentBufMgrDummy(pbmPageMap)
+	mgr := NewBufMgr(12, 48, pbm, nil)
+	bltree := NewBLTree(mgr)
+
+	// long keys make tree of 3 levels
+	keyOf := func(i uint64) []byte {
+		bs := make([]byte, 200)
+		binary.BigEndian.PutUint64(bs, i)
+		return bs
+	}
+
+	firstNum := uint64(2000)
+	for i := uint64(0); i < firstNum; i++ {
+		if err := bltree.InsertKey(keyOf(i), 0, make([]byte, BtId), true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+	if err := mgr.Close(); err != BLTErrOk {
+		t.Fatalf("Close() = %v, want %v", err, BLTErrOk)
+	}
+
+	// lose page zero
+	pbmPageMap.Delete(mgr.GetMappedPPageIdOfPageZero())
+	candidates := make([]int32, 0)
+	pbmPageMap.Range(func(key, value interface{}) bool {
+		candidates = append(candidates, key.(int32))
+		return true
+	})
+
+	pbm = NewParentBufMgrDummy(pbmPageMap)
+	mgr, report, err := RescueBufMgr(12, 48, pbm, candidates)
+	if err != BLTErrOk {
+		t.Fatalf("RescueBufMgr() = %v, want %v", err, BLTErrOk)
+	}
+	if report.Scanned != len(candidates) || report.Recovered == 0 || len(report.Orphans) != 0 {
+		t.Errorf("RescueBufMgr() report = %+v, want all pages recovered", report)
+	}
+
+	bltree = NewBLTree(mgr)
+	secondNum := uint64(2500)
+	for i := firstNum; i < secondNum; i++ {
+		if err := bltree.InsertKey(keyOf(i), 0, make([]byte, BtId), true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+	if err := mgr.Close(); err != BLTErrOk {
+		t.Fatalf("Close() = %v, want %v", err, BLTErrOk)
+	}
+
+	// rescued tree can be opened normally
+	lastPageZeroId := mgr.GetMappedPPageIdOfPageZero()
+	pbm = NewParentBufMgrDummy(pbmPageMap)
+	mgr = NewBufMgr(12, 48, pbm, &lastPageZeroId)
+	bltree = NewBLTree(mgr)
+	for i := uint64(0); i < secondNum; i++ {
+		if _, foundKey, _ := bltree.FindKey(keyOf(i), BtId); !bytes.Equal(foundKey, keyOf(i)) {
+			t.Fatalf("FindKey() = %v, want %v", foundKey, keyOf(i))
+		}
+	}
+}
+
+func TestRescueBufMgr_noTree(t *testing.T) {
+	pbm := NewParentBufMgrDummy(nil)
+	// page which is not blink-tree page
+	ppage := pbm.NewPPage()
+	pbm.UnpinPPage(ppage.GetPPageId(), false)
+
+	if _, _, err := RescueBufMgr(12, 48, pbm, []int32{ppage.GetPPageId()}); err != BLTErrStruct {
+		t.Errorf("RescueBufMgr() = %v, want %v", err, BLTErrStruct)
+	}
+}
+
+func TestBLTree_RepairHalfSplits(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	bltree := NewBLTree(mgr)
+
+	keyOf := func(i uint64) []byte {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		return bs
+	}
+
+	num := uint64(3000)
+	for i := uint64(0); i < num; i++ {
+		if err := bltree.InsertKey(keyOf(i*2), 0, make([]byte, BtId), true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+	if repaired, err := bltree.RepairHalfSplits(); err != BLTErrOk || repaired != 0 {
+		t.Fatalf("RepairHalfSplits() of consistent tree = %v, %v, want %v, %v", repaired, err, 0, BLTErrOk)
+	}
+
+	// split leaf pages without posting their fence keys
+	for _, i := range []uint64{100, 1500} {
+		var set PageSet
+		if slot := mgr.PageFetch(&set, keyOf(i*2), 0, LockWrite, &bltree.reads, &bltree.writes); slot == 0 {
+			t.Fatalf("PageFetch() = %v", slot)
+		}
+		entry := bltree.splitPage(&set, false)
+		if entry == 0 {
+			t.Fatalf("splitPage() = %v", entry)
+		}
+		mgr.PageUnlock(LockWrite, set.latch)
+		mgr.UnpinLatch(set.latch)
+		mgr.UnpinLatch(&mgr.latchs[entry])
+	}
+
+	// keys are reachable through right links
+	for i := uint64(0); i < num; i++ {
+		if ret, _, _ := bltree.FindKey(keyOf(i*2), BtId); ret != BtId {
+			t.Fatalf("FindKey() before repair = %v, want %v", ret, BtId)
+		}
+	}
+
+	// fences of both left and right pages of each split are posted
+	if repaired, err := bltree.RepairHalfSplits(); err != BLTErrOk || repaired != 4 {
+		t.Errorf("RepairHalfSplits() = %v, %v, want %v, %v", repaired, err, 4, BLTErrOk)
+	}
+	if repaired, err := bltree.RepairHalfSplits(); err != BLTErrOk || repaired != 0 {
+		t.Errorf("RepairHalfSplits() after repair = %v, %v, want %v, %v", repaired, err, 0, BLTErrOk)
+	}
+
+	// repaired tree works as usual
+	for i := uint64(0); i < num; i++ {
+		if err := bltree.InsertKey(keyOf(i*2+1), 0, make([]byte, BtId), true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+	for i := uint64(0); i < num*2; i++ {
+		if ret, _, _ := bltree.FindKey(keyOf(i), BtId); ret != BtId {
+			t.Fatalf("FindKey() after repair = %v, want %v", ret, BtId)
+		}
+	}
+	if repaired, err := bltree.RepairHalfSplits(); err != BLTErrOk || repaired != 0 {
+		t.Errorf("RepairHalfSplits() = %v, %v, want %v, %v", repaired, err, 0, BLTErrOk)
+	}
+}
+
+func TestBLTree_RepairPage(t *testing.T) {
+	pbmPageMap := &sync.Map{}
+	pbm := NewParentBufMgrDummy(pbmPageMap)
+	mgr := NewBufMgr(12, 48, pbm, nil)
+	bltree := NewBLTree(mgr)
+
+	keyOf := func(i uint64) []byte {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		return bs
+	}
+	num := uint64(5000)
+	for i := uint64(0); i < num; i++ {
+		if err := bltree.InsertKey(keyOf(i), 0, make([]byte, BtId), true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+	leafOf := func(key []byte) Uid {
+		var set PageSet
+		if slot := mgr.PageFetch(&set, key, 0, LockRead, &bltree.reads, &bltree.writes); slot == 0 {
+			t.Fatalf("PageFetch() = %v", slot)
+		}
+		defer mgr.UnpinLatch(set.latch)
+		defer mgr.PageUnlock(LockRead, set.latch)
+		return set.latch.pageNo
+	}
+	// a middle leaf page and the rightmost leaf page
+	broken := []Uid{leafOf(keyOf(num / 2)), leafOf(keyOf(num - 1))}
+	if err := mgr.Close(); err != BLTErrOk {
+		t.Fatalf("Close() = %v, want %v", err, BLTErrOk)
+	}
+	for _, pageNo := range broken {
+		ppageId, _ := mgr.pageIdConvMap.Load(pageNo)
+		ppage := pbm.FetchPPage(ppageId)
+		ppage.DataAsSlice()[PageHeaderSize+100] ^= 1
+		pbm.UnpinPPage(ppageId, true)
+	}
+
+	lastPageZeroId := mgr.GetMappedPPageIdOfPageZero()
+	mgr = NewBufMgr(12, 48, NewParentBufMgrDummy(pbmPageMap), &lastPageZeroId)
+	bltree = NewBLTree(mgr)
+	if _, err := ValidateTree(bltree); err != BLTErrCorrupt {
+		t.Fatalf("ValidateTree() of corrupted tree = %v, want %v", err, BLTErrCorrupt)
+	}
+	if err := bltree.RepairPage(RootPage); err != BLTErrStruct {
+		t.Errorf("RepairPage() of root page = %v, want %v", err, BLTErrStruct)
+	}
+	for _, pageNo := range broken {
+		if err := bltree.RepairPage(pageNo); err != BLTErrOk {
+			t.Fatalf("RepairPage() = %v, want %v", err, BLTErrOk)
+		}
+	}
+
+	// keys of repaired pages are lost and the rest of the tree is kept
+	report, err := ValidateTree(bltree)
+	if err != BLTErrOk || !report.Valid() {
+		t.Fatalf("ValidateTree() after repair = %v, %v, want valid tree", report, err)
+	}
+	if found, _, _, err := bltree.FindKeyErr(keyOf(num/2), BtId); found || err != BLTErrOk {
+		t.Errorf("FindKeyErr() of repaired page = %v, %v, want %v, %v", found, err, false, BLTErrOk)
+	}
+	if found, _, _, err := bltree.FindKeyErr(keyOf(0), BtId); !found || err != BLTErrOk {
+		t.Errorf("FindKeyErr() = %v, %v, want %v, %v", found, err, true, BLTErrOk)
+	}
+
+	// lost keys are inserted again
+	for i := uint64(0); i < num; i++ {
+		if err := bltree.InsertKey(keyOf(i), 0, make([]byte, BtId), true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+	if report, err := ValidateTree(bltree); err != BLTErrOk || !report.Valid() || report.Keys != num {
+		t.Errorf("ValidateTree() = %v, %v, want valid tree of %v keys", report, err, num)
+	}
+}
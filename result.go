@@ -0,0 +1,235 @@
+package blink_tree
+
+// ResultMode selects ownership of keys and values passed to callbacks
+// of FindKeyFunc and ScanFunc
+type ResultMode uint8
+
+const (
+	// Copy passes keys and values owned by the caller. they can be retained
+	// and modified after the callback returns
+	Copy ResultMode = iota
+	// Borrow passes values which refer to the buffer pool page without copying.
+	// they are read-only and valid only until the callback returns, because
+	// the page is pinned and read locked only while the callback runs.
+	// values decoded by ValueCodec are not borrowed from the page
+	Borrow
+)
+
+// resultValue returns value of slot to be passed to a callback in mode
+func (tree *BLTree) resultValue(page *Page, slot uint32, mode ResultMode) ([]byte, BLTErr) {
+	val := page.valueBytes(slot)
+	if tree.mgr.valueCodec != nil {
+		return tree.mgr.decodeValue(val)
+	}
+	if tree.mgr.expiration {
+		if len(val) < expirySize {
+			return nil, BLTErrCodec
+		}
+		val = val[expirySize:]
+	}
+	if mode == Copy {
+		owned := make([]byte, len(val))
+		copy(owned, val)
+		return owned, BLTErrOk
+	}
+	// valueBytes limits capacity, so append of caller doesn't overwrite the page
+	return val, BLTErrOk
+}
+
+// FindKeyFunc finds unique key or first duplicate key and calls fn with its value
+// while the leaf page is pinned and read locked. fn must not call methods
+// which modify the tree. returns false when key is not found
+func (tree *BLTree) FindKeyFunc(key []byte, mode ResultMode, fn func(value []byte)) bool {
+	found := false
+
+	defer tree.mgr.recordLatency(LatencyFind, tree.mgr.latencyStart())
+
+	tree.findKey(key, false, func(page *Page, slot uint32) {
+		val, err := tree.resultValue(page, slot, mode)
+		if err != BLTErrOk {
+			tree.err = err
+			return
+		}
+		found = true
+		fn(val)
+	})
+
+	return found
+}
+
+// FindValue finds unique key or first duplicate key and returns its whole value.
+// unlike FindKey, the value is not truncated. found is false when key is not found
+func (tree *BLTree) FindValue(key []byte) (value []byte, found bool) {
+	found = tree.FindKeyFunc(key, Copy, func(v []byte) {
+		value = v
+	})
+	return value, found
+}
+
+// FindAll returns values of all keys which are equal to key, that is unique key
+// and duplicate keys whose sequence suffix is stripped, in order of stored keys.
+// empty slice is returned when key is not found
+func (tree *BLTree) FindAll(key []byte) ([][]byte, BLTErr) {
+	values := make([][]byte, 0)
+	if err := tree.FindAllFunc(key, Copy, func(value []byte) bool {
+		values = append(values, value)
+		return true
+	}); err != BLTErrOk {
+		return nil, err
+	}
+	return values, BLTErrOk
+}
+
+// FindAllFunc calls fn with values of all keys which are equal to key like FindAll
+// while their leaf pages are pinned and read locked. iteration stops when fn returns false.
+// fn must not call methods which modify the tree
+func (tree *BLTree) FindAllFunc(key []byte, mode ResultMode, fn func(value []byte) bool) BLTErr {
+	defer tree.mgr.recordLatency(LatencyFind, tree.mgr.latencyStart())
+
+	tree.startOp()
+
+	key, err := encodeKey(key)
+	if err != BLTErrOk {
+		tree.err = err
+		return err
+	}
+
+	return tree.findEntries(key, func(page *Page, slot uint32) bool {
+		val, err := tree.resultValue(page, slot, mode)
+		if err != BLTErrOk {
+			tree.err = err
+			return false
+		}
+		return fn(val)
+	})
+}
+
+// findEntries calls fn with live slots of all keys which are equal to encoded key
+// in order while their leaf pages are read locked. fn returns false to stop
+func (tree *BLTree) findEntries(key []byte, fn func(page *Page, slot uint32) bool) BLTErr {
+	var set PageSet
+
+	slot, err := tree.mgr.pageFetch(&set, key, 0, LockRead, &tree.reads, &tree.writes, tree.deadline)
+	if slot == 0 {
+		tree.err = err
+		return err
+	}
+
+	for ; slot > 0; slot = tree.findNext(&set, slot) {
+		if set.page.Typ(slot) == Librarian || set.page.Dead(slot) || tree.mgr.expired(set.page, slot) {
+			continue
+		}
+		// infinite stopper of the rightmost page
+		if slot == set.page.Cnt && GetID(&set.page.Right) == 0 {
+			break
+		}
+
+		suffix := 0
+		if set.page.Typ(slot) == Duplicate {
+			suffix = BtId
+		}
+		// duplicate keys follow the unique key in order
+		if !set.page.hasKey(slot, key, suffix, tree.mgr.keyCompare) || !fn(set.page, slot) {
+			break
+		}
+	}
+
+	tree.mgr.PageUnlock(LockRead, set.latch)
+	tree.mgr.UnpinLatch(set.latch)
+	return tree.err
+}
+
+// FindKeyInto finds unique key or first duplicate key and copies its value into dst
+// without allocating slices for found key and value. returns length of the whole value,
+// which is greater than len(dst) when dst is too short and only len(dst) bytes are copied,
+// or (-1) if not found. err is not BLTErrOk when the search failed
+func (tree *BLTree) FindKeyInto(key []byte, dst []byte) (n int, err BLTErr) {
+	n = -1
+
+	defer tree.mgr.recordLatency(LatencyFind, tree.mgr.latencyStart())
+
+	tree.findKey(key, false, func(page *Page, slot uint32) {
+		val, err := tree.resultValue(page, slot, Borrow)
+		if err != BLTErrOk {
+			tree.err = err
+			return
+		}
+		copy(dst, val)
+		n = len(val)
+	})
+
+	return n, tree.err
+}
+
+// ScanFunc calls fn with each key and value between lowerKey and upperKey (both inclusive)
+// in ascending order until fn returns false. nil argument for lowerKey means
+// no lower bound and nil argument for upperKey means no upper bound.
+// keys are always owned by the caller. values are passed according to mode.
+// each leaf page is read locked while fn is called with its keys,
+// so fn must not call methods which modify the tree.
+// ATTENTION: like RangeScan, this method call is not atomic with other tree operations
+func (tree *BLTree) ScanFunc(lowerKey []byte, upperKey []byte, mode ResultMode, fn func(key []byte, value []byte) bool) BLTErr {
+	var set PageSet
+
+	tree.startOp()
+
+	// bounds are compared with keys stored in pages
+	var err BLTErr
+	if lowerKey != nil {
+		if lowerKey, err = encodeKey(lowerKey); err != BLTErrOk {
+			tree.err = err
+			return err
+		}
+	}
+	if upperKey != nil {
+		if upperKey, err = encodeKey(upperKey); err != BLTErrOk {
+			tree.err = err
+			return err
+		}
+	}
+
+	slot, err := tree.mgr.pageFetch(&set, lowerKey, 0, LockRead, &tree.reads, &tree.writes, tree.deadline)
+	if slot == 0 {
+		tree.err = err
+		return err
+	}
+
+	tree.err = BLTErrOk
+	for ; slot > 0; slot = tree.findNext(&set, slot) {
+		// skip librarian slot place holder and deleted keys
+		if set.page.Typ(slot) == Librarian || set.page.Dead(slot) || tree.mgr.expired(set.page, slot) {
+			continue
+		}
+		// infinite stopper of the rightmost page
+		if slot == set.page.Cnt && GetID(&set.page.Right) == 0 {
+			break
+		}
+
+		key := set.page.Key(slot)
+		if upperKey != nil && tree.mgr.compareKeys(key, upperKey) > 0 {
+			break
+		}
+		if lowerKey != nil && tree.mgr.compareKeys(key, lowerKey) < 0 {
+			continue
+		}
+
+		val, err := tree.resultValue(set.page, slot, mode)
+		if err != BLTErrOk {
+			tree.err = err
+			break
+		}
+		if !fn(decodeKey(key), val) {
+			break
+		}
+	}
+
+	tree.mgr.PageUnlock(LockRead, set.latch)
+	tree.mgr.UnpinLatch(set.latch)
+	return tree.err
+}
+
+// Scan is ScanFunc in Borrow mode. values passed to fn are valid
+// only until fn returns, and no result slice is built
+func (tree *BLTree) Scan(lowerKey []byte, upperKey []byte, fn func(key []byte, value []byte) bool) BLTErr {
+	return tree.ScanFunc(lowerKey, upperKey, Borrow, fn)
+}
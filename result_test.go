@@ -0,0 +1,255 @@
+package blink_tree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"testing"
+)
+
+func TestBLTree_FindKeyFunc(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	bltree := NewBLTree(mgr)
+
+	key := []byte{1, 2, 3}
+	if err := bltree.InsertKey(key, 0, []byte{1, 0, 0, 0, 0, 0}, true); err != BLTErrOk {
+		t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+	}
+
+	for _, mode := range []ResultMode{Copy, Borrow} {
+		var got []byte
+		if !bltree.FindKeyFunc(key, mode, func(value []byte) {
+			if mode == Borrow && cap(value) != len(value) {
+				t.Errorf("FindKeyFunc() borrowed value can be appended in place")
+			}
+			got = append([]byte(nil), value...)
+		}) {
+			t.Fatalf("FindKeyFunc(%v) = false, want true", mode)
+		}
+		if !bytes.Equal(got, []byte{1, 0, 0, 0, 0, 0}) {
+			t.Errorf("FindKeyFunc(%v) value = %v, want %v", mode, got, []byte{1, 0, 0, 0, 0, 0})
+		}
+	}
+
+	// copied value is kept after the key is updated
+	var copied []byte
+	bltree.FindKeyFunc(key, Copy, func(value []byte) { copied = value })
+	if err := bltree.InsertKey(key, 0, []byte{2, 0, 0, 0, 0, 0}, true); err != BLTErrOk {
+		t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+	}
+	if copied[0] != 1 {
+		t.Errorf("FindKeyFunc(Copy) value after update = %v, want %v", copied[0], 1)
+	}
+
+	if bltree.FindKeyFunc([]byte{9}, Borrow, func([]byte) { t.Errorf("fn is called for missing key") }) {
+		t.Errorf("FindKeyFunc() of missing key = true, want false")
+	}
+}
+
+func TestBLTree_ScanFunc(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	bltree := NewBLTree(mgr)
+
+	num := uint64(3000)
+	key := func(i uint64) []byte {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		return bs
+	}
+	for i := uint64(0); i < num; i++ {
+		if err := bltree.InsertKey(key(i), 0, []byte{byte(i), 0, 0, 0, 0, 0}, true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+	for i := uint64(0); i < num; i += 3 {
+		if err := bltree.DeleteKey(key(i), 0); err != BLTErrOk {
+			t.Fatalf("DeleteKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+
+	tests := []struct {
+		name         string
+		lower, upper []byte
+	}{
+		{"all", nil, nil},
+		{"bounded", key(100), key(2500)},
+		{"deleted bounds", key(300), key(2700)},
+		{"open upper", key(1000), nil},
+	}
+	for _, tt := range tests {
+		for _, mode := range []ResultMode{Copy, Borrow} {
+			wantNum, wantKeys, wantVals := bltree.RangeScan(tt.lower, tt.upper)
+			n := 0
+			err := bltree.ScanFunc(tt.lower, tt.upper, mode, func(k []byte, v []byte) bool {
+				if n < wantNum && (!bytes.Equal(k, wantKeys[n]) || !bytes.Equal(v, wantVals[n])) {
+					t.Errorf("%v: ScanFunc(%v) [%d] = %v, %v, want %v, %v", tt.name, mode, n, k, v, wantKeys[n], wantVals[n])
+				}
+				n++
+				return true
+			})
+			if err != BLTErrOk {
+				t.Errorf("%v: ScanFunc() = %v, want %v", tt.name, err, BLTErrOk)
+			}
+			if n != wantNum {
+				t.Errorf("%v: ScanFunc(%v) called fn %v times, want %v", tt.name, mode, n, wantNum)
+			}
+		}
+	}
+
+	// scan stops when fn returns false
+	n := 0
+	bltree.ScanFunc(nil, nil, Borrow, func([]byte, []byte) bool {
+		n++
+		return n < 10
+	})
+	if n != 10 {
+		t.Errorf("ScanFunc() called fn %v times, want %v", n, 10)
+	}
+}
+
+func TestBLTree_Scan(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	bltree := NewBLTree(mgr)
+
+	for i := uint64(0); i < 1000; i++ {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		if err := bltree.InsertKey(bs, 0, []byte{byte(i), 0, 0, 0, 0, 0}, true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+
+	wantNum, wantKeys, wantVals := bltree.RangeScan(nil, nil)
+	n := 0
+	if err := bltree.Scan(nil, nil, func(k []byte, v []byte) bool {
+		if n < wantNum && (!bytes.Equal(k, wantKeys[n]) || !bytes.Equal(v, wantVals[n])) {
+			t.Errorf("Scan() [%d] = %v, %v, want %v, %v", n, k, v, wantKeys[n], wantVals[n])
+		}
+		n++
+		return true
+	}); err != BLTErrOk {
+		t.Errorf("Scan() = %v, want %v", err, BLTErrOk)
+	}
+	if n != wantNum {
+		t.Errorf("Scan() called fn %v times, want %v", n, wantNum)
+	}
+}
+
+func TestBLTree_FindValue(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	bltree := NewBLTree(mgr)
+
+	key := []byte{1, 2, 3}
+	if err := bltree.InsertKey(key, 0, []byte{1, 2, 3, 4, 5, 6}, true); err != BLTErrOk {
+		t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+	}
+
+	// FindKey truncates the value to valMax
+	if ret, _, _ := bltree.FindKey(key, 2); ret != 2 {
+		t.Fatalf("FindKey() = %v, want %v", ret, 2)
+	}
+	value, found := bltree.FindValue(key)
+	if !found || !bytes.Equal(value, []byte{1, 2, 3, 4, 5, 6}) {
+		t.Errorf("FindValue() = %v, %v, want %v, %v", value, found, []byte{1, 2, 3, 4, 5, 6}, true)
+	}
+	if value, found := bltree.FindValue([]byte{9}); found || value != nil {
+		t.Errorf("FindValue() of missing key = %v, %v, want %v, %v", value, found, nil, false)
+	}
+}
+
+func TestBLTree_FindKeyInto(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	bltree := NewBLTree(mgr)
+
+	key := []byte{1, 2, 3}
+	if err := bltree.InsertKey(key, 0, []byte{1, 2, 3, 4, 5, 6}, true); err != BLTErrOk {
+		t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+	}
+
+	dst := make([]byte, 8)
+	n, err := bltree.FindKeyInto(key, dst)
+	if n != 6 || err != BLTErrOk || !bytes.Equal(dst[:n], []byte{1, 2, 3, 4, 5, 6}) {
+		t.Errorf("FindKeyInto() = %v, %v, %v, want %v, %v, %v", n, err, dst[:6], 6, BLTErrOk, []byte{1, 2, 3, 4, 5, 6})
+	}
+
+	// short dst receives the head of the value and n reports the whole length
+	short := make([]byte, 2)
+	if n, err := bltree.FindKeyInto(key, short); n != 6 || err != BLTErrOk || !bytes.Equal(short, []byte{1, 2}) {
+		t.Errorf("FindKeyInto() with short dst = %v, %v, %v, want %v, %v, %v", n, err, short, 6, BLTErrOk, []byte{1, 2})
+	}
+
+	if n, err := bltree.FindKeyInto([]byte{9}, dst); n != -1 || err != BLTErrOk {
+		t.Errorf("FindKeyInto() of missing key = %v, %v, want %v, %v", n, err, -1, BLTErrOk)
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		bltree.FindKeyInto(key, dst)
+	})
+	if allocs > 1 {
+		t.Errorf("FindKeyInto() allocs = %v, want <= %v", allocs, 1)
+	}
+}
+
+func TestBLTree_FindAll(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	bltree := NewBLTree(mgr)
+
+	// duplicate keys over several leaf pages with neighbouring keys
+	dup := []byte("dup")
+	num := 1000
+	for i := 0; i < num; i++ {
+		if err := bltree.InsertKey([]byte(fmt.Sprintf("du%04d", i)), 0, make([]byte, BtId), true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+		if err := bltree.InsertKey(dup, 0, []byte{byte(i >> 8), byte(i), 0, 0, 0, 0}, false); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+		if err := bltree.InsertKey([]byte(fmt.Sprintf("dup%04d", i)), 0, make([]byte, BtId), true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+
+	values, err := bltree.FindAll(dup)
+	if err != BLTErrOk || len(values) != num {
+		t.Fatalf("FindAll() = %v values, %v, want %v, %v", len(values), err, num, BLTErrOk)
+	}
+	// sequence suffix keeps duplicate keys in order of insertion
+	for i, v := range values {
+		if !bytes.Equal(v[:2], []byte{byte(i >> 8), byte(i)}) {
+			t.Fatalf("FindAll() [%d] = %v, want %v", i, v, []byte{byte(i >> 8), byte(i)})
+		}
+	}
+
+	if values, err := bltree.FindAll([]byte("du0001")); err != BLTErrOk || len(values) != 1 {
+		t.Errorf("FindAll() of unique key = %v, %v, want 1 value", values, err)
+	}
+	if values, err := bltree.FindAll([]byte("du")); err != BLTErrOk || len(values) != 0 {
+		t.Errorf("FindAll() of missing key = %v, %v, want empty", values, err)
+	}
+}
+
+func TestBLTree_FindAllFunc(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	bltree := NewBLTree(mgr)
+
+	dup := []byte("dup")
+	num := 1000
+	for i := 0; i < num; i++ {
+		if err := bltree.InsertDuplicate(dup, []byte{byte(i >> 8), byte(i)}); err != BLTErrOk {
+			t.Fatalf("InsertDuplicate() = %v, want %v", err, BLTErrOk)
+		}
+	}
+
+	// iteration stops when fn returns false
+	seen := 0
+	err := bltree.FindAllFunc(dup, Borrow, func(value []byte) bool {
+		if !bytes.Equal(value, []byte{byte(seen >> 8), byte(seen)}) {
+			t.Fatalf("FindAllFunc() [%d] = %v, want %v", seen, value, []byte{byte(seen >> 8), byte(seen)})
+		}
+		seen++
+		return seen < num/2
+	})
+	if err != BLTErrOk || seen != num/2 {
+		t.Errorf("FindAllFunc() = %v, called %v times, want %v, %v", err, seen, BLTErrOk, num/2)
+	}
+}
@@ -0,0 +1,221 @@
+package blink_tree
+
+import "sync"
+
+// Snapshot is a read-only view of the tree frozen when it's taken.
+// pages are shared with the live tree until they are modified. a page is
+// copied into the snapshot when it's write locked for the first time after
+// the snapshot is taken, so memory of a snapshot grows only with pages which
+// are modified while it's open. pages of the live tree are read locked only
+// while they are copied, so backups and long scans on a snapshot don't block
+// writers for long. like BLTree, a snapshot must not be used by goroutines
+// concurrently, and it must be closed to stop the copying
+type Snapshot struct {
+	tree     *BLTree // handle on the live tree which pages are read through
+	allocTop Uid     // pages numbered from this are allocated after the snapshot is taken
+
+	mu    sync.Mutex
+	pages map[Uid]*Page // copies of pages taken before they are modified (nil means closed)
+}
+
+// Snapshot takes a snapshot of the tree. a page which is write locked when the
+// snapshot is taken is seen with modifications made under the lock.
+// like the live tree, pages which are split later are reached by their right links
+func (mgr *BufMgr) Snapshot() *Snapshot {
+	s := &Snapshot{tree: NewBLTree(mgr), pages: make(map[Uid]*Page)}
+
+	mgr.lock.SpinWriteLock()
+	s.allocTop = GetID(mgr.pageZero.AllocRight())
+	var snaps []*Snapshot
+	if cur := mgr.snapshots.Load(); cur != nil {
+		snaps = append(snaps, *cur...)
+	}
+	snaps = append(snaps, s)
+	mgr.snapshots.Store(&snaps)
+	mgr.lock.SpinReleaseWrite()
+
+	return s
+}
+
+// Close releases copied pages of the snapshot. methods of a closed snapshot
+// return BLTErrClosed. Close can be called more than once
+func (s *Snapshot) Close() {
+	mgr := s.tree.mgr
+
+	mgr.lock.SpinWriteLock()
+	if cur := mgr.snapshots.Load(); cur != nil {
+		var snaps []*Snapshot
+		for _, snap := range *cur {
+			if snap != s {
+				snaps = append(snaps, snap)
+			}
+		}
+		if len(snaps) == 0 {
+			mgr.snapshots.Store(nil)
+		} else {
+			mgr.snapshots.Store(&snaps)
+		}
+	}
+	mgr.lock.SpinReleaseWrite()
+
+	s.mu.Lock()
+	s.pages = nil
+	s.mu.Unlock()
+}
+
+// preservePage copies page of write locked latch into open snapshots
+// which don't have it yet. it's called whenever a page is write locked
+func (mgr *BufMgr) preservePage(latch *Latchs) {
+	snaps := mgr.snapshots.Load()
+	if snaps == nil {
+		return
+	}
+	for _, s := range *snaps {
+		s.preserve(latch.pageNo, mgr.GetRefOfPageAtPool(latch))
+	}
+}
+
+// preserve keeps copy of page pageNo as it's before it's modified
+func (s *Snapshot) preserve(pageNo Uid, page *Page) {
+	// pages allocated later are not reachable from the snapshot
+	if pageNo >= s.allocTop {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pages == nil {
+		return
+	}
+	if _, ok := s.pages[pageNo]; !ok {
+		frozen := NewPage(s.tree.mgr.pageDataSize)
+		MemCpyPage(frozen, page)
+		s.pages[pageNo] = frozen
+	}
+}
+
+// frozenPage copies page pageNo of the snapshot into page when it's copied
+// from the live tree. ok is false when the page isn't copied
+func (s *Snapshot) frozenPage(pageNo Uid, page *Page) (ok bool, err BLTErr) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pages == nil {
+		return false, BLTErrClosed
+	}
+	frozen, ok := s.pages[pageNo]
+	if ok {
+		MemCpyPage(page, frozen)
+	}
+	return ok, BLTErrOk
+}
+
+// readPage copies page pageNo of the snapshot into page
+func (s *Snapshot) readPage(pageNo Uid, page *Page) BLTErr {
+	if ok, err := s.frozenPage(pageNo, page); ok || err != BLTErrOk {
+		return err
+	}
+
+	tree := s.tree
+	latch, err := tree.mgr.pinLatch(pageNo, true, &tree.reads, &tree.writes, tree.deadline)
+	if latch == nil {
+		return err
+	}
+	if !tree.mgr.pageLockDeadline(LockRead, latch, tree.deadline) {
+		tree.mgr.UnpinLatch(latch)
+		return BLTErrTimeout
+	}
+	// the page may be copied before the read lock is obtained
+	ok, err := s.frozenPage(pageNo, page)
+	if !ok && err == BLTErrOk {
+		MemCpyPage(page, tree.mgr.GetRefOfPageAtPool(latch))
+	}
+	tree.mgr.PageUnlock(LockRead, latch)
+	tree.mgr.UnpinLatch(latch)
+	return err
+}
+
+// fetchLeaf copies leaf page of the snapshot which contains key into page
+// and returns slot of key like pageFetch
+func (s *Snapshot) fetchLeaf(key []byte, page *Page) (uint32, BLTErr) {
+	pageNo := RootPage
+	for pageNo > 0 {
+		if err := s.readPage(pageNo, page); err != BLTErrOk {
+			return 0, err
+		}
+		if page.Free {
+			return 0, BLTErrStruct
+		}
+
+		if !page.Kill {
+			if slot := page.findSlot(key, s.tree.mgr.keyCompare); slot > 0 {
+				if page.Lvl == 0 {
+					return slot, BLTErrOk
+				}
+				for page.Dead(slot) && slot < page.Cnt {
+					slot++
+				}
+				if !page.Dead(slot) {
+					pageNo = GetIDFromValue(page.Value(slot))
+					continue
+				}
+			}
+		}
+		// slide right into next page
+		pageNo = GetID(&page.Right)
+	}
+	return 0, BLTErrStruct
+}
+
+// Find returns value of unique key or first duplicate key in the snapshot.
+// found is false when key doesn't exist
+func (s *Snapshot) Find(key []byte) (value []byte, found bool, err BLTErr) {
+	s.tree.startOp()
+
+	key, err = encodeKey(key)
+	if err != BLTErrOk {
+		return nil, false, err
+	}
+	page := NewPage(s.tree.mgr.pageDataSize)
+	slot, err := s.fetchLeaf(key, page)
+	if slot == 0 {
+		return nil, false, err
+	}
+
+	for ; slot <= page.Cnt; slot++ {
+		// skip librarian slot place holder
+		if page.Typ(slot) == Librarian {
+			continue
+		}
+		// not there if we reach the stopper key
+		if slot == page.Cnt && GetID(&page.Right) == 0 {
+			break
+		}
+		if page.Dead(slot) || s.tree.mgr.expired(page, slot) {
+			continue
+		}
+
+		suffix := 0
+		if page.Typ(slot) == Duplicate {
+			suffix = BtId
+		}
+		if !page.hasKey(slot, key, suffix, s.tree.mgr.keyCompare) {
+			break
+		}
+		val, err := s.tree.mgr.decodeValue(*page.Value(slot))
+		if err != BLTErrOk {
+			return nil, false, err
+		}
+		return append([]byte{}, val...), true, BLTErrOk
+	}
+	return nil, false, BLTErrOk
+}
+
+// GetRangeItr returns iterator of keys and values of the snapshot between
+// lowerKey and upperKey like GetRangeItr of BLTree
+func (s *Snapshot) GetRangeItr(lowerKey []byte, upperKey []byte) *BLTreeItr {
+	return s.GetRangeItrOpts(lowerKey, upperKey, ScanOptions{})
+}
+
+// GetRangeItrOpts is GetRangeItr which selects keys by opts
+func (s *Snapshot) GetRangeItrOpts(lowerKey []byte, upperKey []byte, opts ScanOptions) *BLTreeItr {
+	return s.tree.newRangeItr(s, lowerKey, upperKey, opts)
+}
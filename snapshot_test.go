@@ -0,0 +1,86 @@
+package blink_tree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestBufMgr_Snapshot(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	bltree := NewBLTree(mgr)
+
+	key := func(i uint64) []byte {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		return bs
+	}
+	num := uint64(2000)
+	for i := uint64(0); i < num; i++ {
+		if err := bltree.InsertKey(key(i), 0, key(i), true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+
+	snap := mgr.Snapshot()
+
+	// pages are updated, deleted, split and evicted after the snapshot is taken
+	for i := uint64(0); i < num; i++ {
+		if i%2 == 0 {
+			if err := bltree.DeleteKey(key(i), 0); err != BLTErrOk {
+				t.Fatalf("DeleteKey() = %v, want %v", err, BLTErrOk)
+			}
+		} else if err := bltree.InsertKey(key(i), 0, key(i+1), true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+	for i := num; i < num*4; i++ {
+		if err := bltree.InsertKey(key(i), 0, key(i), true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+
+	for i := uint64(0); i < num*2; i += 7 {
+		val, found, err := snap.Find(key(i))
+		if err != BLTErrOk || found != (i < num) || (found && !bytes.Equal(val, key(i))) {
+			t.Errorf("Snapshot.Find(%v) = %v, %v, %v, want the value before the snapshot", i, val, found, err)
+		}
+	}
+	if found, _, _, _ := bltree.FindKeyErr(key(0), 8); found {
+		t.Errorf("FindKeyErr() of deleted key found")
+	}
+
+	itr := snap.GetRangeItr(nil, nil)
+	cnt := uint64(0)
+	for ok, k, v := itr.Next(); ok; ok, k, v = itr.Next() {
+		if !bytes.Equal(k, key(cnt)) || !bytes.Equal(v, key(cnt)) {
+			t.Fatalf("Snapshot iterator = %v, %v, want %v", k, v, key(cnt))
+		}
+		cnt++
+	}
+	if itr.Err() != BLTErrOk || cnt != num {
+		t.Errorf("Snapshot iterator returned %v keys with %v, want %v keys", cnt, itr.Err(), num)
+	}
+
+	// another snapshot sees the live tree
+	snap2 := mgr.Snapshot()
+	if val, found, _ := snap2.Find(key(1)); !found || !bytes.Equal(val, key(2)) {
+		t.Errorf("Snapshot.Find() of second snapshot = %v, %v, want %v", val, found, key(2))
+	}
+
+	snap.Close()
+	snap.Close()
+	if _, _, err := snap.Find(key(1)); err != BLTErrClosed {
+		t.Errorf("Snapshot.Find() after Close = %v, want %v", err, BLTErrClosed)
+	}
+	if err := bltree.InsertKey(key(1), 0, key(3), true); err != BLTErrOk {
+		t.Errorf("InsertKey() = %v, want %v", err, BLTErrOk)
+	}
+	if val, _, _ := snap2.Find(key(1)); !bytes.Equal(val, key(2)) {
+		t.Errorf("Snapshot.Find() after the other snapshot is closed = %v, want %v", val, key(2))
+	}
+	snap2.Close()
+	if mgr.snapshots.Load() != nil {
+		t.Errorf("snapshots remain after Close")
+	}
+}
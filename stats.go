@@ -0,0 +1,62 @@
+package blink_tree
+
+import "sync/atomic"
+
+// TreeStats is shape and space usage of a tree
+type TreeStats struct {
+	Height     uint8    // count of levels. 2 for a new tree
+	Pages      []uint64 // count of pages of each level. index 0 is leaf level
+	Keys       uint64   // count of live keys
+	FillFactor float64  // average ratio of used bytes to data area size of pages
+	Garbage    uint64   // garbage bytes of all pages (see GarbageStats)
+	Splits     uint64   // count of page splits since the buffer manager was created
+	RootSplits uint64   // count of root splits since the buffer manager was created
+}
+
+// Stats walks all pages of the tree level by level and reports its shape and space usage.
+// used bytes of a page are its slots and key and value bytes except garbage.
+// split counts are kept in memory only and include root splits.
+// ATTENTION: like RangeScan, this method call is not atomic with other tree operations
+func (tree *BLTree) Stats() (TreeStats, BLTErr) {
+	var stats TreeStats
+	var used uint64
+
+	tree.startOp()
+
+	// leftmost page of the level
+	pageNo := RootPage
+	for pageNo > 0 {
+		var err BLTErr
+		pageNo, err = tree.walkLevel(pageNo, func(_ Uid, page *Page) (bool, BLTErr) {
+			if stats.Pages == nil {
+				stats.Height = page.Lvl + 1
+				stats.Pages = make([]uint64, stats.Height)
+			}
+			stats.Pages[page.Lvl]++
+			stats.Garbage += uint64(page.Garbage)
+			used += uint64(page.Cnt*SlotSize+tree.mgr.pageDataSize-page.Min) - uint64(page.Garbage)
+			if page.Lvl == 0 {
+				stats.Keys += uint64(page.Act)
+				// stopper key of the rightmost leaf page
+				if GetID(&page.Right) == 0 {
+					stats.Keys--
+				}
+			}
+			return true, BLTErrOk
+		})
+		if err != BLTErrOk {
+			return stats, err
+		}
+	}
+
+	pages := uint64(0)
+	for _, n := range stats.Pages {
+		pages += n
+	}
+	if pages > 0 {
+		stats.FillFactor = float64(used) / float64(pages*uint64(tree.mgr.pageDataSize))
+	}
+	stats.Splits = atomic.LoadUint64(&tree.mgr.splits)
+	stats.RootSplits = atomic.LoadUint64(&tree.mgr.rootSplits)
+	return stats, BLTErrOk
+}
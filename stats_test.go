@@ -0,0 +1,55 @@
+package blink_tree
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestBLTree_Stats(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	bltree := NewBLTree(mgr)
+
+	// a new tree has a root page over an empty leaf page
+	stats, err := bltree.Stats()
+	if err != BLTErrOk || stats.Height != 2 || len(stats.Pages) != 2 || stats.Pages[0] != 1 || stats.Keys != 0 || stats.Splits != 0 {
+		t.Errorf("Stats() of empty tree = %+v, %v, want a root page over an empty leaf page", stats, err)
+	}
+
+	num := 30000
+	for i := 0; i < num; i++ {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, uint64(i))
+		if err := bltree.InsertKey(bs, 0, make([]byte, BtId), true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+
+	stats, err = bltree.Stats()
+	if err != BLTErrOk {
+		t.Fatalf("Stats() = %v, want %v", err, BLTErrOk)
+	}
+	if stats.Keys != uint64(num) {
+		t.Errorf("Stats() Keys = %v, want %v", stats.Keys, num)
+	}
+	if stats.Height < 3 || len(stats.Pages) != int(stats.Height) || stats.Pages[stats.Height-1] != 1 {
+		t.Errorf("Stats() Height = %v, Pages = %v, want a single root page over leaf pages", stats.Height, stats.Pages)
+	}
+	if stats.RootSplits != uint64(stats.Height-2) {
+		t.Errorf("Stats() RootSplits = %v, want %v", stats.RootSplits, stats.Height-2)
+	}
+
+	// every page but the first one of each level is made by a split
+	pages := uint64(0)
+	for _, n := range stats.Pages {
+		pages += n
+	}
+	if want := pages - uint64(stats.Height); stats.Splits != want {
+		t.Errorf("Stats() Splits = %v, want %v", stats.Splits, want)
+	}
+	if stats.FillFactor <= 0.3 || stats.FillFactor > 1 {
+		t.Errorf("Stats() FillFactor = %v, want between %v and %v", stats.FillFactor, 0.3, 1)
+	}
+	if stats.Garbage != 0 {
+		t.Errorf("Stats() Garbage = %v, want %v", stats.Garbage, 0)
+	}
+}
@@ -0,0 +1,88 @@
+package blink_tree
+
+// Truncate deletes all keys by resetting the tree to a root page over an empty
+// leaf page like a new tree. the leftmost leaf page is reused as the empty leaf page,
+// other pages are pushed onto the free chain, and then parent pages of pages
+// on the free chain are deallocated by PruneFreePages.
+// deleted keys are not reported to ChangeHook. returns count of freed pages.
+// ATTENTION: like BulkLoad, this method call is not atomic with other tree operations,
+// so it should be called while no other operation is in progress
+func (tree *BLTree) Truncate() (int, BLTErr) {
+	tree.startOp()
+	tree.startStructureMod()
+
+	// pages below the root page level by level
+	var leaf Uid
+	var pages []Uid
+	for pageNo := RootPage; pageNo > 0; {
+		var err BLTErr
+		pageNo, err = tree.walkLevel(pageNo, func(no Uid, page *Page) (bool, BLTErr) {
+			if page.Lvl == 0 && leaf == 0 {
+				leaf = no
+			} else if no != RootPage {
+				pages = append(pages, no)
+			}
+			return true, BLTErrOk
+		})
+		if err != BLTErrOk {
+			return 0, err
+		}
+	}
+	if leaf == 0 || leaf == RootPage {
+		tree.err = BLTErrStruct
+		return 0, tree.err
+	}
+
+	// new lookups don't reach the pages after the root page is reset
+	var child [BtId]byte
+	PutID(&child, leaf)
+	if err := tree.resetPage(RootPage, tree.mgr.stopperPage(1, child[:])); err != BLTErrOk {
+		return 0, err
+	}
+	if err := tree.resetPage(leaf, tree.mgr.stopperPage(0, []byte{})); err != BLTErrOk {
+		return 0, err
+	}
+
+	for i, pageNo := range pages {
+		latch, err := tree.mgr.pinLatch(pageNo, true, &tree.reads, &tree.writes, tree.deadline)
+		if latch == nil {
+			tree.err = err
+			return i, err
+		}
+		tree.mgr.PageLock(LockDelete, latch)
+		tree.mgr.PageLock(LockWrite, latch)
+		tree.mgr.PageFree(&PageSet{page: tree.mgr.GetRefOfPageAtPool(latch), latch: latch})
+	}
+	tree.mgr.PruneFreePages()
+
+	tree.cursorPage = 0
+	tree.mgr.enforceDirtyQuota(&tree.reads, &tree.writes)
+	return len(pages), BLTErrOk
+}
+
+// stopperPage returns a page of level lvl which has only the stopper key with value
+func (mgr *BufMgr) stopperPage(lvl uint8, value []byte) *Page {
+	page := NewPage(mgr.pageDataSize)
+	page.Bits = mgr.pageBits
+	page.Lvl = lvl
+	mgr.setFixedWidth(page)
+	l := &bulkLevel{frame: page, nxt: mgr.pageDataSize}
+	l.put([]byte{0xff, 0xff}, value)
+	page.setHighFence()
+	return page
+}
+
+// resetPage overwrites page pageNo with contents
+func (tree *BLTree) resetPage(pageNo Uid, contents *Page) BLTErr {
+	latch, err := tree.mgr.pinLatch(pageNo, true, &tree.reads, &tree.writes, tree.deadline)
+	if latch == nil {
+		tree.err = err
+		return err
+	}
+	tree.mgr.PageLock(LockWrite, latch)
+	MemCpyPage(tree.mgr.GetRefOfPageAtPool(latch), contents)
+	tree.markDirty(latch)
+	tree.mgr.PageUnlock(LockWrite, latch)
+	tree.mgr.UnpinLatch(latch)
+	return BLTErrOk
+}
@@ -0,0 +1,80 @@
+package blink_tree
+
+import (
+	"encoding/binary"
+	"sync"
+	"testing"
+)
+
+func TestBLTree_Truncate(t *testing.T) {
+	pbmPageMap := &sync.Map{}
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(pbmPageMap), nil)
+	bltree := NewBLTree(mgr)
+	keyOf := func(i uint64) []byte {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		return bs
+	}
+
+	num := uint64(20000)
+	for i := uint64(0); i < num; i++ {
+		if err := bltree.InsertKey(keyOf(i), 0, make([]byte, BtId), true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+	stats, _ := bltree.Stats()
+	pages := 0
+	for _, n := range stats.Pages {
+		pages += int(n)
+	}
+	mapped := mgr.GetPageIdConvMap().Len()
+
+	freed, err := bltree.Truncate()
+	if err != BLTErrOk {
+		t.Fatalf("Truncate() = %v, want %v", err, BLTErrOk)
+	}
+	// root page and a leaf page are left
+	if freed != pages-2 {
+		t.Errorf("Truncate() = %v, want %v", freed, pages-2)
+	}
+	if got := mgr.GetPageIdConvMap().Len(); got != mapped-int64(freed) {
+		t.Errorf("page id mapping entries after Truncate() = %v, want %v", got, mapped-int64(freed))
+	}
+	if n, _ := bltree.Count(); n != 0 {
+		t.Errorf("Count() = %v, want 0", n)
+	}
+	if bltree.Exists(keyOf(1)) {
+		t.Errorf("Exists() after Truncate() = true, want false")
+	}
+	if report, err := ValidateTree(bltree); err != BLTErrOk || !report.Valid() {
+		t.Fatalf("ValidateTree() = %v, %v, want no problem", report.Problems, err)
+	}
+
+	// the tree can be bulk loaded as a new tree and freed pages are reused
+	allocated, _ := mgr.PageCapacity()
+	i := uint64(0)
+	loaded, err := bltree.BulkLoad(func() ([]byte, []byte, bool) {
+		i++
+		return keyOf(i), make([]byte, BtId), i <= num/2
+	}, 1)
+	if loaded != int(num/2) || err != BLTErrOk {
+		t.Fatalf("BulkLoad() = %v, %v, want %v, %v", loaded, err, num/2, BLTErrOk)
+	}
+	if got, _ := mgr.PageCapacity(); got != allocated {
+		t.Errorf("PageCapacity() after BulkLoad() = %v, want %v", got, allocated)
+	}
+
+	// truncated tree is restored
+	if err := mgr.Close(); err != BLTErrOk {
+		t.Fatalf("Close() = %v, want %v", err, BLTErrOk)
+	}
+	lastPageZeroId := mgr.GetMappedPPageIdOfPageZero()
+	mgr = NewBufMgr(12, 48, NewParentBufMgrDummy(pbmPageMap), &lastPageZeroId)
+	bltree = NewBLTree(mgr)
+	if n, _ := bltree.Count(); n != int(num/2) {
+		t.Errorf("Count() after reopen = %v, want %v", n, num/2)
+	}
+	if report, err := ValidateTree(bltree); err != BLTErrOk || !report.Valid() {
+		t.Fatalf("ValidateTree() after reopen = %v, %v, want no problem", report.Problems, err)
+	}
+}
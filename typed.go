@@ -0,0 +1,86 @@
+package blink_tree
+
+// Codec converts values of type T to bytes stored in the tree and back.
+// Encode of keys must keep order of T in bytes order (or in order of key comparator).
+// Decode must not retain the passed slice
+type Codec[T any] struct {
+	Encode func(v T) []byte
+	Decode func(b []byte) (T, error)
+}
+
+// BLTreeOf is a typed view of BLTree whose keys are of type K and values are of type V.
+// encoded value must not be longer than MaxValueSize bytes of the buffer manager.
+// like BLTree, a BLTreeOf must not be used by multiple goroutines at the same time
+type BLTreeOf[K any, V any] struct {
+	tree *BLTree
+	key  Codec[K]
+	val  Codec[V]
+}
+
+// NewBLTreeOf returns a typed view of tree which converts keys and values by the codecs
+func NewBLTreeOf[K any, V any](tree *BLTree, key Codec[K], val Codec[V]) *BLTreeOf[K, V] {
+	return &BLTreeOf[K, V]{tree: tree, key: key, val: val}
+}
+
+// Tree returns the underlying tree
+func (t *BLTreeOf[K, V]) Tree() *BLTree {
+	return t.tree
+}
+
+// Insert inserts key with value or updates value of key
+func (t *BLTreeOf[K, V]) Insert(key K, value V) BLTErr {
+	return t.tree.insertValue(t.key.Encode(key), t.val.Encode(value), true)
+}
+
+// Find returns value of key. found is false when key is not found
+func (t *BLTreeOf[K, V]) Find(key K) (value V, found bool, err BLTErr) {
+	var decodeErr error
+	found = t.tree.FindKeyFunc(t.key.Encode(key), Borrow, func(v []byte) {
+		value, decodeErr = t.val.Decode(v)
+	})
+	if t.tree.err != BLTErrOk {
+		return value, false, t.tree.err
+	}
+	if decodeErr != nil {
+		t.tree.err = BLTErrCodec
+		return value, false, t.tree.err
+	}
+	return value, found, BLTErrOk
+}
+
+// Delete deletes key. deleting a key which doesn't exist is not an error
+func (t *BLTreeOf[K, V]) Delete(key K) BLTErr {
+	return t.tree.DeleteKey(t.key.Encode(key), 0)
+}
+
+// Scan calls fn with each key and value between lowerKey and upperKey (both inclusive)
+// in ascending order until fn returns false. nil argument for lowerKey means no lower bound
+// and nil argument for upperKey means no upper bound.
+// like ScanFunc, fn must not call methods which modify the tree
+func (t *BLTreeOf[K, V]) Scan(lowerKey *K, upperKey *K, fn func(key K, value V) bool) BLTErr {
+	var lower, upper []byte
+	if lowerKey != nil {
+		lower = t.key.Encode(*lowerKey)
+	}
+	if upperKey != nil {
+		upper = t.key.Encode(*upperKey)
+	}
+
+	var decodeErr error
+	err := t.tree.ScanFunc(lower, upper, Borrow, func(k []byte, v []byte) bool {
+		var key K
+		var value V
+		if key, decodeErr = t.key.Decode(k); decodeErr != nil {
+			return false
+		}
+		if value, decodeErr = t.val.Decode(v); decodeErr != nil {
+			return false
+		}
+		return fn(key, value)
+	})
+	if err == BLTErrOk && decodeErr != nil {
+		t.tree.err = BLTErrCodec
+		err = t.tree.err
+	}
+	return err
+}
@@ -0,0 +1,69 @@
+package blink_tree
+
+import (
+	"encoding/binary"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestBLTreeOf(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+
+	uint64Codec := Codec[uint64]{
+		Encode: func(v uint64) []byte { return binary.BigEndian.AppendUint64(nil, v) },
+		Decode: func(b []byte) (uint64, error) {
+			if len(b) != 8 {
+				return 0, errors.New("bad length")
+			}
+			return binary.BigEndian.Uint64(b), nil
+		},
+	}
+	stringCodec := Codec[string]{
+		Encode: func(v string) []byte { return []byte(v) },
+		Decode: func(b []byte) (string, error) { return string(b), nil },
+	}
+	tree := NewBLTreeOf(NewBLTree(mgr), uint64Codec, stringCodec)
+
+	num := uint64(2000)
+	for i := uint64(0); i < num; i++ {
+		if err := tree.Insert(i, strings.Repeat("v", int(i%20))); err != BLTErrOk {
+			t.Fatalf("Insert() = %v, want %v", err, BLTErrOk)
+		}
+	}
+
+	if v, found, err := tree.Find(37); !found || err != BLTErrOk || v != strings.Repeat("v", 17) {
+		t.Errorf("Find() = %q, %v, %v, want %q, %v, %v", v, found, err, strings.Repeat("v", 17), true, BLTErrOk)
+	}
+	if err := tree.Delete(37); err != BLTErrOk {
+		t.Errorf("Delete() = %v, want %v", err, BLTErrOk)
+	}
+	if _, found, err := tree.Find(37); found || err != BLTErrOk {
+		t.Errorf("Find() of deleted key = %v, %v, want %v, %v", found, err, false, BLTErrOk)
+	}
+
+	lower, upper := uint64(30), uint64(40)
+	var keys []uint64
+	err := tree.Scan(&lower, &upper, func(k uint64, v string) bool {
+		if v != strings.Repeat("v", int(k%20)) {
+			t.Errorf("Scan() value of %v = %q", k, v)
+		}
+		keys = append(keys, k)
+		return true
+	})
+	if err != BLTErrOk || len(keys) != 10 || keys[0] != 30 || keys[9] != 40 {
+		t.Errorf("Scan() = %v, %v, want 10 keys from 30 to 40 without 37", keys, err)
+	}
+
+	if err := tree.Insert(1, strings.Repeat("v", MaxKey+1)); err != BLTErrOverflow {
+		t.Errorf("Insert() of too long value = %v, want %v", err, BLTErrOverflow)
+	}
+
+	// key stored by other writers can't be decoded
+	if err := tree.Tree().InsertKey([]byte{1}, 0, make([]byte, BtId), true); err != BLTErrOk {
+		t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+	}
+	if err := tree.Scan(nil, nil, func(uint64, string) bool { return true }); err != BLTErrCodec {
+		t.Errorf("Scan() of undecodable key = %v, want %v", err, BLTErrCodec)
+	}
+}
@@ -0,0 +1,86 @@
+package blink_tree
+
+import "sync/atomic"
+
+// SetUnderflowFill sets fill factor of leaf pages under which a page is merged
+// into its right page. when live keys of a leaf page take less than fill of its
+// data area after a deletion, the keys are merged with keys of the right page
+// if they fit in a page leaving as much free space as cleanup does
+// (see SetCleanupThreshold), and the right page is freed.
+// the rightmost leaf page has no right page and isn't merged.
+// 0 disables the merge, and only emptied pages are deleted
+func (mgr *BufMgr) SetUnderflowFill(fill float64) {
+	if fill < 0 {
+		fill = 0
+	} else if fill > 1 {
+		fill = 1
+	}
+	atomic.StoreUint32(&mgr.underflowBytes, uint32(float64(mgr.pageDataSize)*fill))
+}
+
+// underflow reports whether live keys of leaf page take less than
+// the underflow threshold and the page can be merged into the right page
+func (mgr *BufMgr) underflow(page *Page) bool {
+	limit := atomic.LoadUint32(&mgr.underflowBytes)
+	if limit == 0 || page.Lvl > 0 || GetID(&page.Right) == 0 {
+		return false
+	}
+	var prefix []byte
+	if page.hasKeyPrefix() {
+		prefix = page.keyPrefix()
+	}
+	return page.rebuiltSize(page.Cnt, prefix)+keyPrefixSize(prefix) < limit
+}
+
+// mergeUnderflow merges write locked leaf page of set into its right page
+// when the page underflows. returns false when the page isn't merged,
+// and then the page is still locked
+func (tree *BLTree) mergeUnderflow(posts *postStack, set *PageSet) (bool, BLTErr) {
+	if !tree.mgr.underflow(set.page) {
+		return false, BLTErrOk
+	}
+	return tree.pullRight(posts, set, LockNone, true)
+}
+
+// mergedPage returns a page which has live keys of left page followed by keys
+// of right page, or nil when they don't fit in a page leaving as much free space as cleanup does.
+// header of the page is copied from right page, and keys are stored without
+// their common prefix
+func (mgr *BufMgr) mergedPage(left *Page, right *Page) *Page {
+	prefix := left.commonKeyPrefix(1, left.Cnt)
+	prefix = prefix[:sharedPrefixLen(prefix, right.commonKeyPrefix(1, right.Cnt))]
+	size := left.rebuiltSize(left.Cnt, prefix) + right.rebuiltSize(right.Cnt, prefix) + 2*SlotSize + keyPrefixSize(prefix)
+	if right.Dead(right.Cnt) {
+		// dead fence key is kept
+		size += right.rebuiltEntrySize(right.Cnt, prefix) + 2*SlotSize
+	}
+	if size > mgr.pageDataSize-atomic.LoadUint32(&mgr.cleanupBytes) {
+		return nil
+	}
+
+	frame := NewPage(mgr.pageDataSize)
+	frame.PageHeader = right.PageHeader
+	frame.Cnt, frame.Act, frame.Garbage = 0, 0, 0
+	l := &bulkLevel{frame: frame, nxt: frame.putKeyPrefix(prefix), prefix: prefix}
+	for _, page := range []*Page{left, right} {
+		for slot := uint32(1); slot <= page.Cnt; slot++ {
+			if page.Typ(slot) == Librarian {
+				continue
+			}
+			fence := page == right && slot == page.Cnt
+			if page.Dead(slot) && !fence {
+				continue
+			}
+			l.put(page.Key(slot), page.valueBytes(slot))
+			frame.SetTyp(frame.Cnt, page.Typ(slot))
+			if page.Dead(slot) {
+				frame.SetDead(frame.Cnt, true)
+				frame.Act--
+				frame.Garbage += frame.entrySize(frame.Cnt)
+			}
+			frame.setSlotFlags(frame.Cnt, page.SlotFlags(slot))
+		}
+	}
+	frame.setKeyPrefixFlag(len(prefix) > 0)
+	return frame
+}
@@ -0,0 +1,132 @@
+package blink_tree
+
+import (
+	"encoding/binary"
+	"sync"
+	"testing"
+)
+
+func TestBLTree_mergeUnderflow(t *testing.T) {
+	keyOf := func(i uint64) []byte {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		return bs
+	}
+	num := uint64(10000)
+
+	leaves := make([]uint32, 0)
+	for _, fill := range []float64{0, 0.3} {
+		mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+		mgr.SetUnderflowFill(fill)
+		bltree := NewBLTree(mgr)
+
+		for i := uint64(0); i < num; i++ {
+			bltree.InsertKey(keyOf(i), 0, make([]byte, BtId), true)
+		}
+		// keys which are not multiples of 10 are deleted. pages are merged into
+		// right pages which are already underfull by deleting from the tail
+		for i := num - 1; i > 0; i-- {
+			if i%10 != 0 {
+				if err := bltree.DeleteKey(keyOf(i), 0); err != BLTErrOk {
+					t.Fatalf("DeleteKey() = %v, want %v", err, BLTErrOk)
+				}
+			}
+		}
+
+		if report, err := ValidateTree(bltree); err != BLTErrOk || !report.Valid() {
+			t.Fatalf("ValidateTree() = %v, %v, want no problem", report.Problems, err)
+		}
+		for i := uint64(0); i < num; i++ {
+			if got := bltree.Exists(keyOf(i)); got != (i%10 == 0) {
+				t.Fatalf("Exists(%v) = %v, want %v", i, got, i%10 == 0)
+			}
+		}
+		stats, _ := bltree.Stats()
+		leaves = append(leaves, uint32(stats.Pages[0]))
+	}
+
+	if leaves[1]*3 > leaves[0] {
+		t.Errorf("Stats() leaf pages with merge = %v, want at most a third of %v", leaves[1], leaves[0])
+	}
+}
+
+func TestBLTree_mergeUnderflow_deleteRange(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	mgr.SetUnderflowFill(0.3)
+	bltree := NewBLTree(mgr)
+	keyOf := func(i uint64) []byte {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		return bs
+	}
+
+	num := uint64(10000)
+	for i := uint64(0); i < num; i++ {
+		bltree.InsertKey(keyOf(i), 0, make([]byte, BtId), true)
+	}
+	// ranges of 90 keys out of every 100 keys are deleted
+	for i := uint64(0); i < num; i += 100 {
+		if n, err := bltree.DeleteRange(keyOf(i+10), keyOf(i+99)); n != 90 || err != BLTErrOk {
+			t.Fatalf("DeleteRange() = %v, %v, want 90, %v", n, err, BLTErrOk)
+		}
+	}
+
+	if report, err := ValidateTree(bltree); err != BLTErrOk || !report.Valid() {
+		t.Fatalf("ValidateTree() = %v, %v, want no problem", report.Problems, err)
+	}
+	if n, _ := bltree.Count(); n != int(num/10) {
+		t.Errorf("Count() = %v, want %v", n, num/10)
+	}
+	if stats, _ := bltree.Stats(); stats.FillFactor < 0.3 {
+		t.Errorf("Stats() FillFactor = %v, want at least 0.3", stats.FillFactor)
+	}
+}
+
+func TestBLTree_mergeUnderflowConcurrently(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	mgr.SetUnderflowFill(0.3)
+	bltree := NewBLTree(mgr)
+	keyOf := func(i uint64) []byte {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		return bs
+	}
+
+	num, workers := uint64(20000), uint64(4)
+	for i := uint64(0); i < num; i++ {
+		bltree.InsertKey(keyOf(i), 0, make([]byte, BtId), true)
+	}
+
+	// each worker deletes its keys except multiples of 10 while reading kept keys
+	var wg sync.WaitGroup
+	for w := uint64(0); w < workers; w++ {
+		wg.Add(1)
+		go func(w uint64) {
+			defer wg.Done()
+			tree := NewBLTree(mgr)
+			for i := w; i < num; i += workers {
+				if i%10 == 0 {
+					if !tree.Exists(keyOf(i)) {
+						t.Errorf("Exists(%v) = false, want true", i)
+						return
+					}
+					continue
+				}
+				if err := tree.DeleteKey(keyOf(i), 0); err != BLTErrOk {
+					t.Errorf("DeleteKey() = %v, want %v", err, BLTErrOk)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if report, err := ValidateTree(bltree); err != BLTErrOk || !report.Valid() {
+		t.Fatalf("ValidateTree() = %v, %v, want no problem", report.Problems, err)
+	}
+	for i := uint64(0); i < num; i++ {
+		if got := bltree.Exists(keyOf(i)); got != (i%10 == 0) {
+			t.Fatalf("Exists(%v) = %v, want %v", i, got, i%10 == 0)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package blink_tree
+
+// VacuumStats is the result of Vacuum
+type VacuumStats struct {
+	Pages     uint64 // count of walked leaf pages
+	Compacted uint64 // count of compacted leaf pages
+	Freed     uint64 // count of leaf pages which are freed because they are emptied or merged
+	Bytes     uint64 // bytes of data area reclaimed by the compaction
+}
+
+// Vacuum compacts leaf pages between lowerKey and upperKey whose garbage, including
+// expired keys, is at least a quarter of the data area, and frees the pages
+// which are emptied. compacted pages are merged into their right pages when
+// they underflow the fill set by SetUnderflowFill. nil argument for lowerKey
+// means no lower bound and nil argument for upperKey means no upper bound.
+// each leaf page is write locked only while it's vacuumed, and entries dropped
+// by compaction filter are dropped too.
+// ATTENTION: like DeleteRange, this method call is not atomic with other tree operations
+func (tree *BLTree) Vacuum(lowerKey []byte, upperKey []byte) (VacuumStats, BLTErr) {
+	var stats VacuumStats
+	var err BLTErr
+	start := []byte{}
+	if lowerKey != nil {
+		if start, err = encodeKey(lowerKey); err != BLTErrOk {
+			tree.err = err
+			return stats, err
+		}
+	}
+	if upperKey != nil {
+		if upperKey, err = encodeKey(upperKey); err != BLTErrOk {
+			tree.err = err
+			return stats, err
+		}
+	}
+
+	tree.startOp()
+	defer tree.mgr.enforceDirtyQuota(&tree.reads, &tree.writes)
+
+	for start != nil {
+		if start, err = tree.vacuumLeaf(start, upperKey, &stats); err != BLTErrOk {
+			return stats, err
+		}
+	}
+	return stats, BLTErrOk
+}
+
+// vacuumLeaf vacuums the leaf page of start. returns the smallest key which
+// can be in the right page, or nil when the range is finished
+func (tree *BLTree) vacuumLeaf(start []byte, upperKey []byte, stats *VacuumStats) ([]byte, BLTErr) {
+	var set PageSet
+
+	slot, err := tree.mgr.pageFetch(&set, start, 0, LockWrite, &tree.reads, &tree.writes, tree.deadline)
+	if slot == 0 {
+		if err == BLTErrOk {
+			err = BLTErrStruct
+		}
+		tree.err = err
+		return nil, err
+	}
+	page := set.page
+	pageNo := set.latch.pageNo
+	stats.Pages++
+
+	// keys of the right page are greater than the fence key
+	var next []byte
+	right := GetID(&page.Right)
+	if fence := page.Key(page.Cnt); right > 0 && (upperKey == nil || tree.mgr.compareKeys(fence, upperKey) < 0) {
+		next = append(fence, 0)
+	} else {
+		right = 0
+	}
+
+	size, keys := tree.mgr.expiredEntries(page)
+	expiredFence := tree.mgr.expired(page, page.Cnt)
+	if expiredFence {
+		keys++
+	}
+	if page.Garbage+size < tree.mgr.pageDataSize/4 && keys < page.Act {
+		tree.mgr.PageUnlock(LockWrite, set.latch)
+		tree.mgr.UnpinLatch(set.latch)
+	} else {
+		// expired fence key is kept as dead one like deleted fence key
+		if expiredFence {
+			page.SetDead(page.Cnt, true)
+			page.Garbage += page.entrySize(page.Cnt)
+			page.Act--
+		}
+		min := page.Min
+		tree.compactPage(&set, page.commonKeyPrefix(1, page.Cnt), 0)
+		stats.Compacted++
+		stats.Bytes += uint64(page.Min - min)
+
+		pulled, err := tree.finishLeafDelete(&set)
+		if err != BLTErrOk {
+			return next, err
+		}
+		if pulled {
+			// contents of the right page are pulled into the page
+			stats.Freed++
+			if right > 0 {
+				right = pageNo
+			}
+		}
+	}
+
+	// appended byte doesn't make a greater key in order of key comparator
+	if right > 0 && tree.mgr.keyCompare != nil {
+		next, err = tree.firstKeyOf(right)
+	}
+	return next, err
+}
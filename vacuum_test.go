@@ -0,0 +1,112 @@
+package blink_tree
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func TestBLTree_Vacuum(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	bltree := NewBLTree(mgr)
+	keyOf := func(i uint64) []byte {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		return bs
+	}
+
+	num := uint64(3000)
+	for i := uint64(0); i < num; i++ {
+		bltree.InsertKey(keyOf(i), 0, make([]byte, BtId), true)
+	}
+	// keys which are not multiples of 10 are deleted
+	for i := uint64(0); i < num; i++ {
+		if i%10 != 0 {
+			bltree.DeleteKey(keyOf(i), 0)
+		}
+	}
+	before, _ := bltree.GarbageStats()
+
+	// lower half of the keys
+	stats, err := bltree.Vacuum(nil, keyOf(num/2))
+	if err != BLTErrOk {
+		t.Fatalf("Vacuum() = %v, want %v", err, BLTErrOk)
+	}
+	if stats.Compacted == 0 || stats.Bytes == 0 || stats.Compacted > stats.Pages {
+		t.Errorf("Vacuum() = %+v, want compacted pages", stats)
+	}
+	half, _ := bltree.GarbageStats()
+	if half.LeafBytes >= before.LeafBytes || half.LeafBytes == 0 {
+		t.Errorf("GarbageStats() after Vacuum of lower half = %v, want between 0 and %v", half.LeafBytes, before.LeafBytes)
+	}
+
+	if _, err = bltree.Vacuum(nil, nil); err != BLTErrOk {
+		t.Fatalf("Vacuum() = %v, want %v", err, BLTErrOk)
+	}
+	if after, _ := bltree.GarbageStats(); after.LeafBytes >= half.LeafBytes {
+		t.Errorf("GarbageStats() after Vacuum = %v, want less than %v", after.LeafBytes, half.LeafBytes)
+	}
+	// nothing is left to vacuum
+	if stats, _ = bltree.Vacuum(nil, nil); stats.Compacted != 0 {
+		t.Errorf("Vacuum() again = %+v, want no compacted page", stats)
+	}
+
+	if report, err := ValidateTree(bltree); err != BLTErrOk || !report.Valid() {
+		t.Fatalf("ValidateTree() = %v, %v, want no problem", report.Problems, err)
+	}
+	for i := uint64(0); i < num; i++ {
+		if got := bltree.Exists(keyOf(i)); got != (i%10 == 0) {
+			t.Errorf("Exists(%v) = %v, want %v", i, got, i%10 == 0)
+		}
+	}
+}
+
+func TestBLTree_Vacuum_freesEmptiedPages(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	now := time.Unix(1000, 0)
+	mgr.clock = func() time.Time { return now }
+	mgr.SetExpiration(true)
+	bltree := NewBLTree(mgr)
+	keyOf := func(i uint64) []byte {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		return bs
+	}
+
+	// keys in the middle expire
+	num := uint64(3000)
+	for i := uint64(0); i < num; i++ {
+		if i >= 1000 && i < 2000 {
+			bltree.InsertWithTTL(keyOf(i), make([]byte, BtId), time.Second)
+		} else {
+			bltree.InsertKey(keyOf(i), 0, make([]byte, BtId), true)
+		}
+	}
+	now = now.Add(time.Second)
+	pages, _ := bltree.Stats()
+
+	stats, err := bltree.Vacuum(nil, nil)
+	if err != BLTErrOk {
+		t.Fatalf("Vacuum() = %v, want %v", err, BLTErrOk)
+	}
+	if stats.Freed == 0 {
+		t.Errorf("Vacuum() = %+v, want freed pages", stats)
+	}
+	after, _ := bltree.Stats()
+	if after.Pages[0] >= pages.Pages[0] {
+		t.Errorf("Stats() leaf pages after Vacuum = %v, want less than %v", after.Pages[0], pages.Pages[0])
+	}
+	// expired keys of pages with little garbage are left
+	if n, _ := bltree.Count(); n >= int(num) || n < 2000 {
+		t.Errorf("Count() after Vacuum = %v, want between 2000 and %v", n, num)
+	}
+	for i := uint64(0); i < num; i++ {
+		want := i < 1000 || i >= 2000
+		if got := bltree.Exists(keyOf(i)); got != want {
+			t.Errorf("Exists(%v) = %v, want %v", i, got, want)
+		}
+	}
+	if report, err := ValidateTree(bltree); err != BLTErrOk || !report.Valid() {
+		t.Fatalf("ValidateTree() = %v, %v, want no problem", report.Problems, err)
+	}
+}
@@ -0,0 +1,192 @@
+package blink_tree
+
+import (
+	"bytes"
+	"fmt"
+)
+
+type (
+	// ValidateReport is result of ValidateTree
+	ValidateReport struct {
+		Pages    uint64        // count of walked pages
+		Keys     uint64        // count of live keys of leaf pages
+		Problems []TreeProblem // inconsistencies found in the tree
+		Repaired int           // count of fence keys posted by RepairTree before the validation
+	}
+
+	// TreeProblem is an inconsistency of a page found by ValidateTree
+	TreeProblem struct {
+		PageNo Uid
+		Lvl    uint8
+		Slot   uint32 // 0 when the problem isn't about a slot
+		Msg    string
+	}
+)
+
+// Valid reports whether no problem was found
+func (r *ValidateReport) Valid() bool {
+	return len(r.Problems) == 0
+}
+
+func (p TreeProblem) String() string {
+	if p.Slot > 0 {
+		return fmt.Sprintf("page %d (level %d) slot %d: %s", p.PageNo, p.Lvl, p.Slot, p.Msg)
+	}
+	return fmt.Sprintf("page %d (level %d): %s", p.PageNo, p.Lvl, p.Msg)
+}
+
+// ValidateTree walks all pages of tree level by level through right links and verifies
+//   - header of each page (Kind, Act, Garbage, Min, fence hints and Free / Kill flags)
+//   - keys are ascending within each page and across pages of each level
+//   - right links of each level end at the page of the infinite stopper key without cycles
+//   - fence key of each page equals the key pointing to it in the upper level
+//     and each key of the upper level points to a page of the level
+//
+// found inconsistencies are returned in the report instead of panicking.
+// BLTErr is returned only when pages can't be read.
+// ATTENTION: like RangeScan, this method call is not atomic with other tree operations,
+// so it should be called while no other operation is in progress
+func ValidateTree(tree *BLTree) (*ValidateReport, BLTErr) {
+	report := &ValidateReport{}
+	mgr := tree.mgr
+
+	tree.startOp()
+
+	// live keys of the upper level by their child pages
+	var uppers map[Uid][]byte
+	pageNo := RootPage
+	for expect := -1; pageNo > 0; expect-- {
+		lowers := make(map[Uid][]byte)
+		visited := make(map[Uid]bool)
+		var leftFence []byte
+		var rightmost bool
+
+		lower, err := tree.walkLevel(pageNo, func(pageNo Uid, page *Page) (bool, BLTErr) {
+			report.Pages++
+			problem := func(slot uint32, format string, args ...interface{}) {
+				report.Problems = append(report.Problems, TreeProblem{PageNo: pageNo, Lvl: page.Lvl, Slot: slot, Msg: fmt.Sprintf(format, args...)})
+			}
+
+			if visited[pageNo] {
+				problem(0, "right link cycle")
+				return false, BLTErrOk
+			}
+			visited[pageNo] = true
+			if expect < 0 {
+				// root page decides height of the tree
+				expect = int(page.Lvl)
+			}
+			if int(page.Lvl) != expect {
+				problem(0, "level is %d, want %d", page.Lvl, expect)
+			}
+			if page.Free || page.Kill {
+				problem(0, "freed or deleted page is linked")
+			}
+			if page.Kind != PageKindTree {
+				problem(0, "page of kind %d is linked", page.Kind)
+				return false, BLTErrOk
+			}
+			if page.Cnt == 0 || page.Cnt*SlotSize > page.Min || page.Min > mgr.pageDataSize {
+				problem(0, "Cnt %d and Min %d are out of data area", page.Cnt, page.Min)
+				return true, BLTErrOk
+			}
+
+			live := uint32(0)
+			prev := leftFence
+			for slot := uint32(1); slot <= page.Cnt; slot++ {
+				if page.Typ(slot) == Librarian {
+					if !page.Dead(slot) {
+						problem(slot, "librarian slot is live")
+					}
+					continue
+				}
+				key := page.Key(slot)
+				// value which outgrew its slot is inserted before the dead slot of the key
+				ordered := true
+				if prev != nil {
+					if c := mgr.compareKeys(prev, key); c > 0 || (c == 0 && !page.Dead(slot)) {
+						problem(slot, "key %v is not greater than previous key %v", key, prev)
+						ordered = false
+					}
+				}
+				// key out of order is reported once
+				if ordered && !mgr.mayHoldKey(page, key) {
+					problem(slot, "key %v is out of fence hints", key)
+				}
+				prev = key
+				if page.Dead(slot) {
+					continue
+				}
+				live++
+				if page.Lvl > 0 {
+					lowers[GetIDFromValue(page.Value(slot))] = key
+				}
+			}
+			if live != page.Act {
+				problem(0, "Act is %d, want %d", page.Act, live)
+			}
+			if garbage := page.countGarbage(); garbage != page.Garbage {
+				problem(0, "Garbage is %d, want %d", page.Garbage, garbage)
+			}
+
+			fence := page.Key(page.Cnt)
+			rightmost = GetID(&page.Right) == 0
+			if rightmost != isStopperKey(fence) {
+				problem(page.Cnt, "fence key %v doesn't match right link %d", fence, GetID(&page.Right))
+			}
+			if uppers != nil {
+				if key, ok := uppers[pageNo]; !ok {
+					problem(0, "no key of upper level points to the page")
+				} else if !bytes.Equal(key, fence) {
+					problem(0, "fence key %v differs from key %v of upper level", fence, key)
+				}
+			}
+			if page.HighFence != fenceHint(fence) {
+				problem(0, "HighFence %v doesn't match fence key %v", page.HighFence, fence)
+			}
+			if page.LowFence != fenceHint(leftFence) {
+				problem(0, "LowFence %v doesn't match fence key %v of left page", page.LowFence, leftFence)
+			}
+			leftFence = fence
+			if page.Lvl == 0 {
+				report.Keys += uint64(page.Act)
+				if rightmost && page.Act > 0 {
+					report.Keys--
+				}
+			}
+			return true, BLTErrOk
+		})
+		if err != BLTErrOk {
+			return report, err
+		}
+
+		for child, key := range uppers {
+			if !visited[child] {
+				report.Problems = append(report.Problems, TreeProblem{PageNo: child, Lvl: uint8(expect), Msg: fmt.Sprintf("page pointed by key %v of upper level is not linked", key)})
+			}
+		}
+
+		uppers = lowers
+		pageNo = lower
+		if expect == 0 {
+			break
+		}
+	}
+
+	return report, BLTErrOk
+}
+
+// RepairTree completes half splits with RepairHalfSplits and then validates
+// the tree with ValidateTree. half splits of an opened tree are completed before
+// its first operation, so this is needed only when that failed (see RepairHalfSplits).
+// a page reachable only through right link of its left sibling is reported
+// by ValidateTree as "no key of upper level points to the page" otherwise
+func RepairTree(tree *BLTree) (*ValidateReport, BLTErr) {
+	repaired, err := tree.RepairHalfSplits()
+	if err != BLTErrOk {
+		return &ValidateReport{Repaired: repaired}, err
+	}
+	report, err := ValidateTree(tree)
+	report.Repaired = repaired
+	return report, err
+}
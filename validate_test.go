@@ -0,0 +1,125 @@
+package blink_tree
+
+import (
+	"encoding/binary"
+	"sync"
+	"testing"
+)
+
+func TestValidateTree(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	bltree := NewBLTree(mgr)
+
+	num := uint64(30000)
+	for i := uint64(0); i < num; i++ {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		if err := bltree.InsertKey(bs, 0, make([]byte, BtId), true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+	// deleting whole leaf pages removes keys of upper level
+	for i := uint64(1000); i < 5000; i++ {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		if err := bltree.DeleteKey(bs, 0); err != BLTErrOk {
+			t.Fatalf("DeleteKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+
+	report, err := ValidateTree(bltree)
+	if err != BLTErrOk || !report.Valid() {
+		t.Fatalf("ValidateTree() = %v, %v, want no problem", report.Problems, err)
+	}
+	if report.Keys != num-4000 {
+		t.Errorf("ValidateTree() Keys = %v, want %v", report.Keys, num-4000)
+	}
+	if stats, _ := bltree.Stats(); report.Pages != stats.Pages[0]+stats.Pages[1]+stats.Pages[2] {
+		t.Errorf("ValidateTree() Pages = %v, want %v", report.Pages, stats.Pages)
+	}
+
+	// break header and key order of the leftmost leaf page
+	pageNo, _ := bltree.leftmostPage(0)
+	latch, _ := mgr.pinLatch(pageNo, true, &bltree.reads, &bltree.writes, bltree.deadline)
+	page := mgr.GetRefOfPageAtPool(latch)
+	page.Act++
+	off1, off2 := page.KeyOffset(1), page.KeyOffset(3)
+	pre1, pre2 := page.prefixLen(1), page.prefixLen(3)
+	page.SetKeyOffset(1, off2)
+	page.setPrefixLen(1, pre2)
+	page.SetKeyOffset(3, off1)
+	page.setPrefixLen(3, pre1)
+	mgr.UnpinLatch(latch)
+
+	report, err = ValidateTree(bltree)
+	if err != BLTErrOk || len(report.Problems) != 2 {
+		t.Fatalf("ValidateTree() of broken tree = %v, %v, want %v problems", report.Problems, err, 2)
+	}
+	if p := report.Problems[0]; p.PageNo != pageNo || p.Slot != 3 {
+		t.Errorf("ValidateTree() problem = %v, want key order of slot %v of page %v", p, 3, pageNo)
+	}
+	if p := report.Problems[1]; p.PageNo != pageNo || p.Slot != 0 {
+		t.Errorf("ValidateTree() problem = %v, want Act of page %v", p, pageNo)
+	}
+}
+
+func TestRepairTree(t *testing.T) {
+	pbmPageMap := &sync.Map{}
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(pbmPageMap), nil)
+	bltree := NewBLTree(mgr)
+
+	keyOf := func(i uint64) []byte {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		return bs
+	}
+	num := uint64(3000)
+	for i := uint64(0); i < num; i++ {
+		if err := bltree.InsertKey(keyOf(i), 0, make([]byte, BtId), true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+
+	// split a leaf page without posting its fence keys like a crash in the middle
+	halfSplit := func(key []byte) {
+		var set PageSet
+		if slot := mgr.PageFetch(&set, key, 0, LockWrite, &bltree.reads, &bltree.writes); slot == 0 {
+			t.Fatalf("PageFetch() = %v", slot)
+		}
+		entry := bltree.splitPage(&set, false)
+		if entry == 0 {
+			t.Fatalf("splitPage() = %v", entry)
+		}
+		mgr.PageUnlock(LockWrite, set.latch)
+		mgr.UnpinLatch(set.latch)
+		mgr.UnpinLatch(&mgr.latchs[entry])
+	}
+	halfSplit(keyOf(num / 2))
+	if report, err := ValidateTree(bltree); err != BLTErrOk || report.Valid() {
+		t.Fatalf("ValidateTree() of half split = %v, %v, want problems", report, err)
+	}
+
+	// fences of both pages of the split are posted before the validation
+	report, err := RepairTree(bltree)
+	if err != BLTErrOk || !report.Valid() || report.Repaired != 2 || report.Keys != num {
+		t.Errorf("RepairTree() = %v, %v, want valid tree of %v keys with %v repairs", report, err, num, 2)
+	}
+
+	// half split of a closed tree is completed when it's opened again
+	halfSplit(keyOf(num / 4))
+	if err := mgr.Close(); err != BLTErrOk {
+		t.Fatalf("Close() = %v, want %v", err, BLTErrOk)
+	}
+	lastPageZeroId := mgr.GetMappedPPageIdOfPageZero()
+	mgr = NewBufMgr(12, 48, NewParentBufMgrDummy(pbmPageMap), &lastPageZeroId)
+	bltree = NewBLTree(mgr)
+	report, err = ValidateTree(bltree)
+	if err != BLTErrOk || !report.Valid() || report.Keys != num {
+		t.Errorf("ValidateTree() after reopen = %v, %v, want valid tree of %v keys", report, err, num)
+	}
+	for i := uint64(0); i < num; i += 11 {
+		if ret, _, _ := bltree.FindKey(keyOf(i), BtId); ret < 0 {
+			t.Fatalf("FindKey(%v) after reopen = %v, want found", i, ret)
+		}
+	}
+}
@@ -0,0 +1,411 @@
+package blink_tree
+
+// PageView is a page reported by WalkLevel.
+// keys of the page are greater than LowerKey and not greater than UpperKey
+type PageView struct {
+	PageNo   Uid
+	Lvl      uint8
+	Keys     uint32 // count of live keys. stopper key of the rightmost leaf page is not counted
+	Right    Uid    // right sibling page. 0 for the rightmost page
+	LowerKey []byte // fence key of left sibling page. nil for the leftmost page
+	UpperKey []byte // fence key of the page. nil for the rightmost page
+}
+
+// WalkLevel calls fn with view of each page of level lvl (0 is leaf level)
+// from the leftmost page to the rightmost page. the walk stops when fn returns false.
+// BLTErrStruct is returned when lvl is higher than the root page.
+// bounds of leaf pages can be used to partition work along page boundaries.
+// ATTENTION: like RangeScan, this method call is not atomic with other tree operations.
+// pages may be split or deleted while the walk proceeds
+func (tree *BLTree) WalkLevel(lvl uint8, fn func(v PageView) bool) BLTErr {
+	tree.startOp()
+
+	pageNo, err := tree.leftmostPage(lvl)
+	if err != BLTErrOk {
+		return err
+	}
+
+	var lowerKey []byte
+	_, err = tree.walkLevel(pageNo, func(pageNo Uid, page *Page) (bool, BLTErr) {
+		v := PageView{
+			PageNo:   pageNo,
+			Lvl:      page.Lvl,
+			Keys:     page.Act,
+			Right:    GetID(&page.Right),
+			LowerKey: lowerKey,
+		}
+		if v.Right > 0 {
+			v.UpperKey = decodeKey(page.Key(page.Cnt))
+		} else if v.Lvl == 0 {
+			v.Keys--
+		}
+		lowerKey = v.UpperKey
+		return fn(v), BLTErrOk
+	})
+	return err
+}
+
+// leftmostPage returns the leftmost page of level lvl
+func (tree *BLTree) leftmostPage(lvl uint8) (Uid, BLTErr) {
+	latch, err := tree.mgr.pinLatch(RootPage, true, &tree.reads, &tree.writes, tree.deadline)
+	if latch == nil {
+		tree.err = err
+		return 0, err
+	}
+	tree.mgr.PageLock(LockRead, latch)
+	rootLvl := tree.mgr.GetRefOfPageAtPool(latch).Lvl
+	tree.mgr.PageUnlock(LockRead, latch)
+	tree.mgr.UnpinLatch(latch)
+	if lvl > rootLvl {
+		tree.err = BLTErrStruct
+		return 0, tree.err
+	}
+
+	// the smallest key leads to the leftmost page
+	var set PageSet
+	slot, err := tree.mgr.pageFetch(&set, []byte{}, lvl, LockRead, &tree.reads, &tree.writes, tree.deadline)
+	if slot == 0 {
+		if err == BLTErrOk {
+			err = BLTErrStruct
+		}
+		tree.err = err
+		return 0, err
+	}
+	pageNo := set.latch.pageNo
+	tree.mgr.PageUnlock(LockRead, set.latch)
+	tree.mgr.UnpinLatch(set.latch)
+	return pageNo, BLTErrOk
+}
+
+// Count returns count of live keys by walking leaf pages.
+// ATTENTION: like RangeScan, this method call is not atomic with other tree operations
+func (tree *BLTree) Count() (int, BLTErr) {
+	cnt := 0
+	err := tree.WalkLevel(0, func(v PageView) bool {
+		cnt += int(v.Keys)
+		return true
+	})
+	return cnt, err
+}
+
+// EstimateCount returns approximate count of live keys reading one page per level.
+// the estimate is count of live keys of the root page multiplied by count of
+// live keys of a page sampled on each lower level, which is the child of the middle
+// key of the page sampled on the upper level. it's exact for a single leaf tree.
+// use Count for the exact count, which reads all leaf pages.
+// ATTENTION: like RangeScan, this method call is not atomic with other tree operations
+func (tree *BLTree) EstimateCount() (int, BLTErr) {
+	tree.startOp()
+
+	est := 1
+	for pageNo := RootPage; pageNo > 0; {
+		var keys uint32
+		var child Uid
+		_, err := tree.walkLevel(pageNo, func(_ Uid, page *Page) (bool, BLTErr) {
+			keys = page.Act
+			if page.Lvl == 0 {
+				// stopper key of the rightmost leaf page
+				if GetID(&page.Right) == 0 {
+					keys--
+				}
+				return false, BLTErrOk
+			}
+			live := uint32(0)
+			for slot := uint32(1); slot <= page.Cnt; slot++ {
+				if page.Dead(slot) || page.Typ(slot) == Librarian {
+					continue
+				}
+				if live == keys/2 {
+					child = GetIDFromValue(page.Value(slot))
+					break
+				}
+				live++
+			}
+			return false, BLTErrOk
+		})
+		if err != BLTErrOk {
+			return 0, err
+		}
+		est *= int(keys)
+		pageNo = child
+	}
+	return est, BLTErrOk
+}
+
+// Select returns the n-th smallest live key (0 is the smallest) and its value.
+// key is nil when the tree has n or fewer keys.
+//
+// this is a linear fallback, not an order statistics tree: upper level pages don't
+// keep count of keys of their subtrees, so leaf pages are walked from the leftmost
+// page until the n-th key is reached, which costs O(n / keys per leaf page) page
+// reads. only Act of the leaf pages which are skipped is read.
+// ATTENTION: like RangeScan, this method call is not atomic with other tree operations
+func (tree *BLTree) Select(n uint64) (key []byte, value []byte, err BLTErr) {
+	tree.startOp()
+
+	pageNo, err := tree.leftmostPage(0)
+	if err != BLTErrOk {
+		return nil, nil, err
+	}
+
+	_, err = tree.walkLevel(pageNo, func(_ Uid, page *Page) (bool, BLTErr) {
+		live := uint64(page.Act)
+		// stopper key of the rightmost leaf page
+		if GetID(&page.Right) == 0 {
+			live--
+		}
+		if n >= live {
+			n -= live
+			return true, BLTErrOk
+		}
+		for slot := uint32(1); slot <= page.Cnt; slot++ {
+			if page.Dead(slot) || page.Typ(slot) == Librarian {
+				continue
+			}
+			if n > 0 {
+				n--
+				continue
+			}
+			ptr := page.Key(slot)
+			if page.Typ(slot) == Duplicate {
+				ptr = ptr[:len(ptr)-BtId]
+			}
+			val, err := tree.mgr.decodeValue(*page.Value(slot))
+			if err != BLTErrOk {
+				return false, err
+			}
+			key, value = decodeKey(ptr), val
+			break
+		}
+		return false, BLTErrOk
+	})
+	if err != BLTErrOk {
+		return nil, nil, err
+	}
+	return key, value, BLTErrOk
+}
+
+// Rank returns count of live keys which are less than key,
+// which is position of key in sort order when key exists.
+// like Select, this is a linear fallback: leaf pages are walked from the leftmost
+// page up to the page of key, and keys of the leaf pages below key are not compared.
+// ATTENTION: like RangeScan, this method call is not atomic with other tree operations
+func (tree *BLTree) Rank(key []byte) (uint64, BLTErr) {
+	tree.startOp()
+
+	// key is compared with keys stored in pages
+	key, err := encodeKey(key)
+	if err != BLTErrOk {
+		tree.err = err
+		return 0, err
+	}
+
+	pageNo, err := tree.leftmostPage(0)
+	if err != BLTErrOk {
+		return 0, err
+	}
+
+	rank := uint64(0)
+	_, err = tree.walkLevel(pageNo, func(_ Uid, page *Page) (bool, BLTErr) {
+		// fence key is the largest key of the page
+		if GetID(&page.Right) > 0 && page.cmpKey(page.Cnt, key, tree.mgr.keyCompare) < 0 {
+			rank += uint64(page.Act)
+			return true, BLTErrOk
+		}
+		for slot := uint32(1); slot <= page.Cnt; slot++ {
+			if page.Dead(slot) || page.Typ(slot) == Librarian {
+				continue
+			}
+			// infinite stopper of the rightmost page
+			if slot == page.Cnt && GetID(&page.Right) == 0 {
+				break
+			}
+			if page.cmpKey(slot, key, tree.mgr.keyCompare) >= 0 {
+				break
+			}
+			rank++
+		}
+		return false, BLTErrOk
+	})
+	return rank, err
+}
+
+// CountRange returns count of live keys between lowerKey and upperKey (both inclusive)
+// without copying keys and values. nil argument for lowerKey means no lower bound
+// and nil argument for upperKey means no upper bound.
+// ATTENTION: like RangeScan, this method call is not atomic with other tree operations
+func (tree *BLTree) CountRange(lowerKey []byte, upperKey []byte) (int, BLTErr) {
+	var set PageSet
+
+	tree.startOp()
+
+	// bounds are compared with keys stored in pages
+	var err BLTErr
+	if lowerKey != nil {
+		if lowerKey, err = encodeKey(lowerKey); err != BLTErrOk {
+			tree.err = err
+			return 0, err
+		}
+	}
+	if upperKey != nil {
+		if upperKey, err = encodeKey(upperKey); err != BLTErrOk {
+			tree.err = err
+			return 0, err
+		}
+	}
+
+	slot, err := tree.mgr.pageFetch(&set, lowerKey, 0, LockRead, &tree.reads, &tree.writes, tree.deadline)
+	if slot == 0 {
+		tree.err = err
+		return 0, err
+	}
+
+	cnt := 0
+	for ; slot > 0; slot = tree.findNext(&set, slot) {
+		if set.page.Typ(slot) == Librarian || set.page.Dead(slot) || tree.mgr.expired(set.page, slot) {
+			continue
+		}
+		// infinite stopper of the rightmost page
+		if slot == set.page.Cnt && GetID(&set.page.Right) == 0 {
+			break
+		}
+		if upperKey != nil && set.page.cmpKey(slot, upperKey, tree.mgr.keyCompare) > 0 {
+			break
+		}
+		if lowerKey != nil && set.page.cmpKey(slot, lowerKey, tree.mgr.keyCompare) < 0 {
+			continue
+		}
+		cnt++
+	}
+
+	tree.mgr.PageUnlock(LockRead, set.latch)
+	tree.mgr.UnpinLatch(set.latch)
+	return cnt, tree.err
+}
+
+// SampledScan calls fn with every n-th live key between lowerKey and upperKey
+// (both inclusive) in ascending order until fn returns false. n is the rounded
+// reciprocal of rate, so rate 0.01 passes one of 100 keys. rate >= 1 passes all keys
+// and rate <= 0 passes none. keys which are not passed are not copied.
+// nil argument for lowerKey means no lower bound and nil argument for upperKey
+// means no upper bound. fn must not call methods which modify the tree.
+// ATTENTION: like RangeScan, this method call is not atomic with other tree operations
+func (tree *BLTree) SampledScan(lowerKey []byte, upperKey []byte, rate float64, fn func(key []byte) bool) BLTErr {
+	var set PageSet
+
+	tree.startOp()
+	if rate <= 0 {
+		return BLTErrOk
+	}
+	every := 1
+	if rate < 1 {
+		every = int(1/rate + 0.5)
+	}
+
+	// bounds are compared with keys stored in pages
+	var err BLTErr
+	if lowerKey != nil {
+		if lowerKey, err = encodeKey(lowerKey); err != BLTErrOk {
+			tree.err = err
+			return err
+		}
+	}
+	if upperKey != nil {
+		if upperKey, err = encodeKey(upperKey); err != BLTErrOk {
+			tree.err = err
+			return err
+		}
+	}
+
+	slot, err := tree.mgr.pageFetch(&set, lowerKey, 0, LockRead, &tree.reads, &tree.writes, tree.deadline)
+	if slot == 0 {
+		tree.err = err
+		return err
+	}
+
+	// the first key of the range is passed
+	skipped := every - 1
+	for ; slot > 0; slot = tree.findNext(&set, slot) {
+		if set.page.Typ(slot) == Librarian || set.page.Dead(slot) || tree.mgr.expired(set.page, slot) {
+			continue
+		}
+		// infinite stopper of the rightmost page
+		if slot == set.page.Cnt && GetID(&set.page.Right) == 0 {
+			break
+		}
+		if upperKey != nil && set.page.cmpKey(slot, upperKey, tree.mgr.keyCompare) > 0 {
+			break
+		}
+		if lowerKey != nil && set.page.cmpKey(slot, lowerKey, tree.mgr.keyCompare) < 0 {
+			continue
+		}
+		if skipped++; skipped < every {
+			continue
+		}
+		skipped = 0
+		if !fn(decodeKey(set.page.Key(slot))) {
+			break
+		}
+	}
+
+	tree.mgr.PageUnlock(LockRead, set.latch)
+	tree.mgr.UnpinLatch(set.latch)
+	return tree.err
+}
+
+// PartitionKeys returns at most n-1 ascending keys which split keys of the tree
+// into n ranges holding approximately equal count of leaf pages:
+// (nil, keys[0]], (keys[0], keys[1]], ..., (keys[len(keys)-1], nil).
+// the keys are separator keys of the highest upper level which has enough of them,
+// so only upper level pages are read. fewer keys are returned when the tree
+// doesn't have enough leaf pages. use ScanOptions.ExcludeLower to scan a range.
+// ATTENTION: like RangeScan, this method call is not atomic with other tree operations
+func (tree *BLTree) PartitionKeys(n int) ([][]byte, BLTErr) {
+	tree.startOp()
+
+	var keys [][]byte
+	for pageNo := RootPage; pageNo > 0 && n > 1 && len(keys) < n-1; {
+		var lvl uint8
+		var level [][]byte
+		lower, err := tree.walkLevel(pageNo, func(_ Uid, page *Page) (bool, BLTErr) {
+			lvl = page.Lvl
+			if lvl == 0 {
+				return false, BLTErrOk
+			}
+			for slot := uint32(1); slot <= page.Cnt; slot++ {
+				if page.Dead(slot) || page.Typ(slot) == Librarian {
+					continue
+				}
+				// infinite stopper of the rightmost page
+				if slot == page.Cnt && GetID(&page.Right) == 0 {
+					break
+				}
+				level = append(level, page.Key(slot))
+			}
+			return true, BLTErrOk
+		})
+		if err != BLTErrOk {
+			return nil, err
+		}
+		if lvl == 0 {
+			break
+		}
+		keys = level
+		if lvl == 1 {
+			break
+		}
+		pageNo = lower
+	}
+
+	// each separator key is the upper bound of a child page
+	children := len(keys) + 1
+	parts := make([][]byte, 0, n)
+	for i := 1; i < n && i < children; i++ {
+		if children <= n {
+			parts = append(parts, decodeKey(keys[i-1]))
+		} else {
+			parts = append(parts, decodeKey(keys[i*children/n-1]))
+		}
+	}
+	return parts, BLTErrOk
+}
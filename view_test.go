@@ -0,0 +1,365 @@
+package blink_tree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestBLTree_WalkLevel(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	bltree := NewBLTree(mgr)
+
+	num := uint64(3000)
+	for i := uint64(0); i < num; i++ {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		if err := bltree.InsertKey(bs, 0, make([]byte, BtId), true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+
+	var leaves []PageView
+	if err := bltree.WalkLevel(0, func(v PageView) bool {
+		leaves = append(leaves, v)
+		return true
+	}); err != BLTErrOk {
+		t.Fatalf("WalkLevel() = %v, want %v", err, BLTErrOk)
+	}
+	if len(leaves) < 2 {
+		t.Fatalf("WalkLevel() reported %v leaf pages, want at least %v", len(leaves), 2)
+	}
+	if leaves[0].LowerKey != nil || leaves[len(leaves)-1].UpperKey != nil || leaves[len(leaves)-1].Right != 0 {
+		t.Errorf("WalkLevel() bounds of edge pages = %+v, %+v, want open", leaves[0], leaves[len(leaves)-1])
+	}
+
+	// bounds of pages partition keys of the tree
+	keys := uint32(0)
+	for i, v := range leaves {
+		if v.Lvl != 0 {
+			t.Errorf("WalkLevel() Lvl = %v, want %v", v.Lvl, 0)
+		}
+		if i > 0 && (!bytes.Equal(v.LowerKey, leaves[i-1].UpperKey) || leaves[i-1].Right != v.PageNo) {
+			t.Errorf("WalkLevel() page %v doesn't continue page %v", v, leaves[i-1])
+		}
+		num, retKeys, _ := bltree.RangeScan(v.LowerKey, v.UpperKey)
+		if len(retKeys) > 0 && bytes.Equal(retKeys[0], v.LowerKey) {
+			num--
+		}
+		if uint32(num) != v.Keys {
+			t.Errorf("RangeScan() in bounds of page %v = %v, want %v", v.PageNo, num, v.Keys)
+		}
+		keys += v.Keys
+	}
+	if keys != uint32(num) {
+		t.Errorf("WalkLevel() live keys = %v, want %v", keys, num)
+	}
+
+	var uppers []PageView
+	bltree.WalkLevel(1, func(v PageView) bool {
+		uppers = append(uppers, v)
+		return true
+	})
+	if len(uppers) == 0 || uppers[0].Lvl != 1 {
+		t.Errorf("WalkLevel(1) = %v, want pages of level 1", uppers)
+	}
+
+	cnt := 0
+	bltree.WalkLevel(0, func(v PageView) bool {
+		cnt++
+		return false
+	})
+	if cnt != 1 {
+		t.Errorf("WalkLevel() called fn %v times, want %v", cnt, 1)
+	}
+
+	if err := bltree.WalkLevel(100, func(v PageView) bool { return true }); err != BLTErrStruct {
+		t.Errorf("WalkLevel() above root = %v, want %v", err, BLTErrStruct)
+	}
+}
+
+func TestBLTree_Count(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	bltree := NewBLTree(mgr)
+
+	if cnt, err := bltree.Count(); cnt != 0 || err != BLTErrOk {
+		t.Errorf("Count() of empty tree = %v, %v, want %v, %v", cnt, err, 0, BLTErrOk)
+	}
+
+	num := uint64(3000)
+	for i := uint64(0); i < num; i++ {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		if err := bltree.InsertKey(bs, 0, make([]byte, BtId), true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+	for i := uint64(0); i < num; i += 4 {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		if err := bltree.DeleteKey(bs, 0); err != BLTErrOk {
+			t.Fatalf("DeleteKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+
+	if cnt, err := bltree.Count(); cnt != int(num-num/4) || err != BLTErrOk {
+		t.Errorf("Count() = %v, %v, want %v, %v", cnt, err, num-num/4, BLTErrOk)
+	}
+}
+
+func TestBLTree_EstimateCount(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	bltree := NewBLTree(mgr)
+
+	if cnt, err := bltree.EstimateCount(); cnt != 0 || err != BLTErrOk {
+		t.Errorf("EstimateCount() of empty tree = %v, %v, want %v, %v", cnt, err, 0, BLTErrOk)
+	}
+
+	for i := uint64(0); i < 10; i++ {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		if err := bltree.InsertKey(bs, 0, make([]byte, BtId), true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+	// single leaf tree is counted exactly
+	if cnt, err := bltree.EstimateCount(); cnt != 10 || err != BLTErrOk {
+		t.Errorf("EstimateCount() of single leaf tree = %v, %v, want %v, %v", cnt, err, 10, BLTErrOk)
+	}
+
+	num := uint64(30000)
+	for i := uint64(10); i < num; i++ {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		if err := bltree.InsertKey(bs, 0, make([]byte, BtId), true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+
+	cnt, err := bltree.EstimateCount()
+	if err != BLTErrOk || cnt < int(num/2) || cnt > int(num*2) {
+		t.Errorf("EstimateCount() = %v, %v, want about %v, %v", cnt, err, num, BLTErrOk)
+	}
+}
+
+func TestBLTree_Select(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	bltree := NewBLTree(mgr)
+
+	if key, _, err := bltree.Select(0); key != nil || err != BLTErrOk {
+		t.Errorf("Select() of empty tree = %v, %v, want %v, %v", key, err, nil, BLTErrOk)
+	}
+
+	num := uint64(3000)
+	for i := uint64(0); i < num; i++ {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		if err := bltree.InsertKey(bs, 0, []byte{byte(i), 0, 0, 0, 0, 0}, true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+	for i := uint64(0); i < num; i += 4 {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		if err := bltree.DeleteKey(bs, 0); err != BLTErrOk {
+			t.Fatalf("DeleteKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+
+	_, keys, values := bltree.RangeScan(nil, nil)
+	for _, n := range []int{0, 1, 500, 1000, len(keys) - 1} {
+		key, value, err := bltree.Select(uint64(n))
+		if !bytes.Equal(key, keys[n]) || !bytes.Equal(value, values[n]) || err != BLTErrOk {
+			t.Errorf("Select(%v) = %v, %v, %v, want %v, %v, %v", n, key, value, err, keys[n], values[n], BLTErrOk)
+		}
+	}
+	if key, _, err := bltree.Select(uint64(len(keys))); key != nil || err != BLTErrOk {
+		t.Errorf("Select(%v) = %v, %v, want %v, %v", len(keys), key, err, nil, BLTErrOk)
+	}
+}
+
+func TestBLTree_Rank(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	bltree := NewBLTree(mgr)
+
+	key := func(i uint64) []byte {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		return bs
+	}
+	if rank, err := bltree.Rank(key(0)); rank != 0 || err != BLTErrOk {
+		t.Errorf("Rank() of empty tree = %v, %v, want %v, %v", rank, err, 0, BLTErrOk)
+	}
+
+	// even keys from 0 to 5998
+	num := uint64(3000)
+	for i := uint64(0); i < num; i++ {
+		if err := bltree.InsertKey(key(i*2), 0, make([]byte, BtId), true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+
+	tests := []struct {
+		key  uint64
+		rank uint64
+	}{
+		{0, 0},
+		{1, 1},
+		{2, 1},
+		{1001, 501},
+		{4000, 2000},
+		{5998, 2999},
+		{5999, 3000},
+		{10000, 3000},
+	}
+	for _, tt := range tests {
+		if rank, err := bltree.Rank(key(tt.key)); rank != tt.rank || err != BLTErrOk {
+			t.Errorf("Rank(%v) = %v, %v, want %v, %v", tt.key, rank, err, tt.rank, BLTErrOk)
+		}
+	}
+
+	// Rank is the inverse of Select for existing keys
+	for _, n := range []uint64{0, 1234, num - 1} {
+		k, _, _ := bltree.Select(n)
+		if rank, _ := bltree.Rank(k); rank != n {
+			t.Errorf("Rank(Select(%v)) = %v, want %v", n, rank, n)
+		}
+	}
+}
+
+func TestBLTree_CountRange(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	bltree := NewBLTree(mgr)
+
+	num := uint64(3000)
+	key := func(i uint64) []byte {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		return bs
+	}
+	for i := uint64(0); i < num; i++ {
+		if err := bltree.InsertKey(key(i), 0, make([]byte, BtId), true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+	for i := uint64(0); i < num; i += 3 {
+		if err := bltree.DeleteKey(key(i), 0); err != BLTErrOk {
+			t.Fatalf("DeleteKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+
+	tests := []struct {
+		name         string
+		lower, upper []byte
+	}{
+		{"all", nil, nil},
+		{"bounded", key(100), key(2500)},
+		{"deleted bounds", key(300), key(2700)},
+		{"open lower", nil, key(1000)},
+		{"open upper", key(1000), nil},
+		{"empty", key(num), nil},
+	}
+	for _, tt := range tests {
+		want, _, _ := bltree.RangeScan(tt.lower, tt.upper)
+		if got, err := bltree.CountRange(tt.lower, tt.upper); got != want || err != BLTErrOk {
+			t.Errorf("%v: CountRange() = %v, %v, want %v, %v", tt.name, got, err, want, BLTErrOk)
+		}
+	}
+}
+
+func TestBLTree_SampledScan(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	bltree := NewBLTree(mgr)
+
+	num := uint64(3000)
+	key := func(i uint64) []byte {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		return bs
+	}
+	for i := uint64(0); i < num; i++ {
+		if err := bltree.InsertKey(key(i), 0, make([]byte, BtId), true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+
+	tests := []struct {
+		name         string
+		lower, upper []byte
+		rate         float64
+		want         []uint64
+	}{
+		{"every 1000th", nil, nil, 0.001, []uint64{0, 1000, 2000}},
+		{"bounded", key(10), key(400), 0.01, []uint64{10, 110, 210, 310}},
+		{"rounded rate", key(10), key(30), 0.3, []uint64{10, 13, 16, 19, 22, 25, 28}},
+		{"all", key(10), key(12), 1, []uint64{10, 11, 12}},
+		{"none", nil, nil, 0, nil},
+	}
+	for _, tt := range tests {
+		var got []uint64
+		if err := bltree.SampledScan(tt.lower, tt.upper, tt.rate, func(k []byte) bool {
+			got = append(got, binary.BigEndian.Uint64(k))
+			return true
+		}); err != BLTErrOk {
+			t.Errorf("%v: SampledScan() = %v, want %v", tt.name, err, BLTErrOk)
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%v: SampledScan() = %v, want %v", tt.name, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%v: SampledScan() = %v, want %v", tt.name, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestBLTree_PartitionKeys(t *testing.T) {
+	mgr := NewBufMgr(12, 64, NewParentBufMgrDummy(nil), nil)
+	bltree := NewBLTree(mgr)
+
+	if parts, err := bltree.PartitionKeys(4); err != BLTErrOk || len(parts) != 0 {
+		t.Errorf("PartitionKeys() of single leaf tree = %v, %v, want none", parts, err)
+	}
+
+	num := uint64(60000)
+	for i := uint64(0); i < num; i++ {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		if err := bltree.InsertKey(bs, 0, make([]byte, BtId), true); err != BLTErrOk {
+			t.Fatalf("InsertKey() = %v, want %v", err, BLTErrOk)
+		}
+	}
+
+	for _, n := range []int{1, 2, 8, 100} {
+		parts, err := bltree.PartitionKeys(n)
+		if err != BLTErrOk || len(parts) != n-1 {
+			t.Errorf("PartitionKeys(%v) = %v keys, %v, want %v keys", n, len(parts), err, n-1)
+			continue
+		}
+
+		// ranges are disjoint, cover all keys and are roughly equal
+		total := 0
+		var lower []byte
+		for i := 0; i <= len(parts); i++ {
+			var upper []byte
+			if i < len(parts) {
+				upper = parts[i]
+				if lower != nil && bytes.Compare(lower, upper) >= 0 {
+					t.Errorf("PartitionKeys(%v) keys are not ascending: %v, %v", n, lower, upper)
+				}
+			}
+			cnt, _, _ := bltree.RangeScanOpts(lower, upper, ScanOptions{ExcludeLower: true, KeysOnly: true})
+			if want := int(num) / n; cnt < want/2 || cnt > want*2 {
+				t.Errorf("PartitionKeys(%v) range %v has %v keys, want about %v", n, i, cnt, want)
+			}
+			total += cnt
+			lower = upper
+		}
+		if total != int(num) {
+			t.Errorf("PartitionKeys(%v) ranges have %v keys, want %v", n, total, num)
+		}
+	}
+}
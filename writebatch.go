@@ -0,0 +1,309 @@
+package blink_tree
+
+import "sort"
+
+// WriteBatch is a set of inserts and deletes which is applied atomically by Write.
+// zero value is an empty batch
+type WriteBatch struct {
+	ops []writeOp
+}
+
+// writeOp is an insert or delete of WriteBatch
+type writeOp struct {
+	key    []byte // user key
+	value  []byte // user value (nil for delete)
+	del    bool
+	enc    []byte // key as stored in page
+	stored []byte // value as stored in page
+}
+
+// writeLeaf is a leaf page write locked by Write and changes of the batch on it
+type writeLeaf struct {
+	set    PageSet
+	ops    []int // indexes of sorted changes which belong to the page
+	backup *Page // contents of the page before the changes
+}
+
+// Put queues insert of key with value like InsertKey with uniq true.
+// key and value are copied
+func (b *WriteBatch) Put(key []byte, value []byte) {
+	b.ops = append(b.ops, writeOp{key: append([]byte{}, key...), value: append([]byte{}, value...)})
+}
+
+// Delete queues delete of key. key is copied
+func (b *WriteBatch) Delete(key []byte) {
+	b.ops = append(b.ops, writeOp{key: append([]byte{}, key...), del: true})
+}
+
+// Len returns count of queued changes
+func (b *WriteBatch) Len() int {
+	return len(b.ops)
+}
+
+// Reset empties the batch for reuse
+func (b *WriteBatch) Reset() {
+	b.ops = b.ops[:0]
+}
+
+// Write applies changes of batch atomically. leaf pages of the keys are write
+// locked in key order and all of them are modified before any of them is released,
+// so readers see either none or all of the changes. when a key is changed twice,
+// the last change is applied. when a leaf page doesn't have room for its inserts,
+// the changes are undone, the page is split and the batch is retried.
+// when a key or value is rejected, nothing is changed. BLTErrOverflow is
+// returned and nothing is changed when inserts of the batch which go
+// between two adjacent keys of the tree don't fit in a page, and BLTErrCapacity
+// is returned when keys of the batch belong to more leaf pages than
+// a quarter of buffer pool pages.
+// pages emptied by deletes are reclaimed after the pages are released, and
+// changes are reported to ChangeHook after that
+func (tree *BLTree) Write(batch *WriteBatch) BLTErr {
+	ops := make([]writeOp, 0, len(batch.ops))
+	for _, op := range batch.ops {
+		var err BLTErr
+		if op.del {
+			if err = tree.mgr.validateKey(op.key); err == BLTErrOk {
+				err = tree.mgr.validateWrite(ChangeDelete, op.key, nil)
+			}
+			if err == BLTErrOk {
+				op.enc, err = encodeKey(op.key)
+			}
+			if err != BLTErrOk {
+				tree.err = err
+				return err
+			}
+		} else {
+			if op.enc, err = tree.insertingKey(op.key, true); err != BLTErrOk {
+				return err
+			}
+			if op.stored, err = tree.storedValue(op.key, op.value); err != BLTErrOk {
+				return err
+			}
+		}
+		ops = append(ops, op)
+	}
+	sort.SliceStable(ops, func(a, b int) bool {
+		return tree.mgr.compareKeys(ops[a].enc, ops[b].enc) < 0
+	})
+	// the last change of a key is kept
+	uniq := ops[:0]
+	for i := range ops {
+		if i+1 < len(ops) && tree.mgr.compareKeys(ops[i].enc, ops[i+1].enc) == 0 {
+			continue
+		}
+		uniq = append(uniq, ops[i])
+	}
+	ops = uniq
+	if len(ops) == 0 {
+		return BLTErrOk
+	}
+
+	tree.startOp()
+	defer tree.mgr.enforceDirtyQuota(&tree.reads, &tree.writes)
+
+	// each retry splits a page which doesn't have room for inserts
+	for retries := 0; ; retries++ {
+		if retries > maxPostRetries+len(ops) {
+			tree.err = BLTErrStruct
+			return tree.err
+		}
+		leaves, err := tree.lockLeaves(ops)
+		if err != BLTErrOk {
+			return err
+		}
+		changes, full := tree.applyLeaves(leaves, ops)
+		for _, leaf := range leaves {
+			tree.mgr.PageUnlock(LockWrite, leaf.set.latch)
+			tree.mgr.UnpinLatch(leaf.set.latch)
+		}
+		if full >= 0 {
+			if err = tree.splitLeaf(ops[full].enc); err != BLTErrOk {
+				return err
+			}
+			continue
+		}
+
+		for _, leaf := range leaves {
+			if err = tree.reclaimLeaf(leaf, ops); err != BLTErrOk {
+				return err
+			}
+		}
+		for _, c := range changes {
+			if c.seq == 0 {
+				continue
+			}
+			if op := ops[c.idx]; op.del {
+				tree.mgr.notifyChange(c.seq, ChangeDelete, op.key, tree.mgr.changedValue(c.val), nil)
+			} else {
+				tree.mgr.notifyChange(c.seq, ChangeInsert, op.key, tree.mgr.changedValue(c.val), op.value)
+			}
+		}
+		return BLTErrOk
+	}
+}
+
+// lockLeaves write locks leaf pages of sorted changes from left to right.
+// a page is locked while the page on its left is locked, so it's found through
+// the right link without descending from the root page again
+func (tree *BLTree) lockLeaves(ops []writeOp) ([]*writeLeaf, BLTErr) {
+	var leaves []*writeLeaf
+	release := func() {
+		for _, leaf := range leaves {
+			tree.mgr.PageUnlock(LockWrite, leaf.set.latch)
+			tree.mgr.UnpinLatch(leaf.set.latch)
+		}
+	}
+
+	leaf := &writeLeaf{}
+	slot, err := tree.mgr.pageFetch(&leaf.set, ops[0].enc, 0, LockWrite, &tree.reads, &tree.writes, tree.deadline)
+	if slot == 0 {
+		if err == BLTErrOk {
+			err = BLTErrStruct
+		}
+		tree.err = err
+		return nil, err
+	}
+	leaves = append(leaves, leaf)
+
+	for i := range ops {
+		// key is beyond fence key of the page
+		for leaf.set.page.findSlot(ops[i].enc, tree.mgr.keyCompare) == 0 {
+			// locked pages must leave most of buffer pool to other operations
+			if len(leaf.ops) > 0 && uint(len(leaves)) >= tree.mgr.latchTotal/4 {
+				release()
+				tree.err = BLTErrCapacity
+				return nil, tree.err
+			}
+			pageNo := GetID(&leaf.set.page.Right)
+			if pageNo == 0 {
+				release()
+				tree.err = BLTErrStruct
+				return nil, tree.err
+			}
+			latch, err := tree.mgr.pinLatch(pageNo, true, &tree.reads, &tree.writes, tree.deadline)
+			if latch == nil {
+				release()
+				tree.err = err
+				return nil, err
+			}
+			tree.mgr.PageLock(LockWrite, latch)
+
+			// page without changes is released
+			if len(leaf.ops) == 0 {
+				tree.mgr.PageUnlock(LockWrite, leaf.set.latch)
+				tree.mgr.UnpinLatch(leaf.set.latch)
+				leaves = leaves[:len(leaves)-1]
+			}
+			leaf = &writeLeaf{set: PageSet{page: tree.mgr.GetRefOfPageAtPool(latch), latch: latch}}
+			leaves = append(leaves, leaf)
+			if leaf.set.page.Kill {
+				release()
+				tree.err = BLTErrStruct
+				return nil, tree.err
+			}
+		}
+		leaf.ops = append(leaf.ops, i)
+	}
+	return leaves, BLTErrOk
+}
+
+// applyLeaves applies changes on write locked leaf pages. when a page doesn't
+// have room for an insert, changes of all pages are undone and index of the insert
+// is returned as full. full is -1 when all changes are applied
+func (tree *BLTree) applyLeaves(leaves []*writeLeaf, ops []writeOp) (changes []batchChange, full int) {
+	for n, leaf := range leaves {
+		if !ValidatePage(leaf.set.page) {
+			panic("Write: page is broken.")
+		}
+		leaf.backup = NewPage(tree.mgr.pageDataSize)
+		MemCpyPage(leaf.backup, leaf.set.page)
+
+		for _, i := range leaf.ops {
+			op := ops[i]
+			slot := leaf.set.page.findSlot(op.enc, tree.mgr.keyCompare)
+			tree.mgr.recordAccess(op.enc, leaf.set.latch.pageNo)
+			if op.del {
+				if found, seq, val := tree.deleteSlot(&leaf.set, slot, op.enc); found {
+					tree.markDirty(leaf.set.latch)
+					changes = append(changes, batchChange{idx: i, seq: seq, val: val})
+				}
+				continue
+			}
+			seq, ok := tree.putSlot(&leaf.set, slot, op.enc, op.stored)
+			if !ok {
+				for _, done := range leaves[:n+1] {
+					MemCpyPage(done.set.page, done.backup)
+				}
+				return nil, i
+			}
+			changes = append(changes, batchChange{idx: i, seq: seq, val: tree.changeVal})
+		}
+	}
+	return changes, -1
+}
+
+// splitLeaf splits leaf page of key which doesn't have room for inserts of a batch.
+// returns BLTErrOverflow when the page has no key to split
+func (tree *BLTree) splitLeaf(key []byte) BLTErr {
+	var set PageSet
+	slot, err := tree.mgr.pageFetch(&set, key, 0, LockWrite, &tree.reads, &tree.writes, tree.deadline)
+	if slot == 0 {
+		if err == BLTErrOk {
+			err = BLTErrStruct
+		}
+		tree.err = err
+		return err
+	}
+	// inserts between two adjacent keys don't fit in a page
+	if set.page.Act < 2 {
+		tree.mgr.PageUnlock(LockWrite, set.latch)
+		tree.mgr.UnpinLatch(set.latch)
+		tree.err = BLTErrOverflow
+		return tree.err
+	}
+	// split of root page needs two new pages
+	if !tree.mgr.hasCapacity(2) {
+		tree.mgr.PageUnlock(LockWrite, set.latch)
+		tree.mgr.UnpinLatch(set.latch)
+		tree.err = BLTErrCapacity
+		return tree.err
+	}
+
+	// keys are appended when they are before stopper key of the rightmost page
+	tail := slot == set.page.Cnt && GetID(&set.page.Right) == 0
+	entry := tree.splitPage(&set, tail)
+	if entry == 0 {
+		tree.mgr.PageUnlock(LockWrite, set.latch)
+		tree.mgr.UnpinLatch(set.latch)
+		return tree.err
+	}
+	var posts postStack
+	if err := tree.splitKeys(&posts, &set, &tree.mgr.latchs[entry]); err != BLTErrOk {
+		return err
+	}
+	return tree.runPosts(&posts)
+}
+
+// reclaimLeaf collapses dead slots of leaf page whose keys are deleted by a batch
+// and deletes or merges the page like DeleteBatch
+func (tree *BLTree) reclaimLeaf(leaf *writeLeaf, ops []writeOp) BLTErr {
+	deleted := false
+	for _, i := range leaf.ops {
+		deleted = deleted || ops[i].del
+	}
+	if !deleted {
+		return BLTErrOk
+	}
+
+	var set PageSet
+	slot, err := tree.mgr.pageFetch(&set, ops[leaf.ops[0]].enc, 0, LockWrite, &tree.reads, &tree.writes, tree.deadline)
+	if slot == 0 {
+		if err == BLTErrOk {
+			err = BLTErrStruct
+		}
+		tree.err = err
+		return err
+	}
+	_, err = tree.finishLeafDelete(&set)
+	return err
+}
@@ -0,0 +1,172 @@
+package blink_tree
+
+import (
+	"encoding/binary"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestBLTree_Write(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	bltree := NewBLTree(mgr)
+	keyOf := func(i uint64) []byte {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		return bs
+	}
+
+	// inserts between adjacent keys must fit in a page
+	num := uint64(300)
+	var batch WriteBatch
+	for i := uint64(0); i < num; i++ {
+		batch.Put(keyOf(i*2), keyOf(i))
+	}
+	if err := bltree.Write(&batch); err != BLTErrOverflow {
+		t.Errorf("Write() to empty tree = %v, want %v", err, BLTErrOverflow)
+	}
+	if n, _ := bltree.Count(); n != 0 {
+		t.Errorf("Count() after Write() = %v, want 0", n)
+	}
+	for i := uint64(0); i < num; i++ {
+		bltree.InsertKey(keyOf(i*2), 0, keyOf(i), true)
+	}
+
+	// keys between existing keys are inserted with splits
+	batch.Reset()
+	for i := uint64(0); i < num; i++ {
+		batch.Put(keyOf(i*2+1), keyOf(i))
+	}
+	hooked := 0
+	mgr.SetChangeHook(func(ev ChangeEvent) { hooked++ })
+	if err := bltree.Write(&batch); err != BLTErrOk {
+		t.Fatalf("Write() = %v, want %v", err, BLTErrOk)
+	}
+	if hooked != int(num) {
+		t.Errorf("hook calls of Write() = %v, want %v", hooked, num)
+	}
+	mgr.SetChangeHook(nil)
+	num *= 2
+	if n, _ := bltree.Count(); n != int(num) {
+		t.Errorf("Count() = %v, want %v", n, num)
+	}
+
+	// deletes, updates and the last change of a key
+	batch.Reset()
+	for i := uint64(0); i < num; i++ {
+		switch i % 3 {
+		case 0:
+			batch.Delete(keyOf(i))
+		case 1:
+			batch.Put(keyOf(i), []byte{1, 2, 3, 4, 5, 6, 7, 8, 9})
+		case 2:
+			batch.Delete(keyOf(i))
+			batch.Put(keyOf(i), []byte{2})
+		}
+	}
+	batch.Delete(keyOf(num))
+	if batch.Len() != int(num+num/3+1) {
+		t.Errorf("Len() = %v, want %v", batch.Len(), num+num/3+1)
+	}
+	if err := bltree.Write(&batch); err != BLTErrOk {
+		t.Fatalf("Write() = %v, want %v", err, BLTErrOk)
+	}
+	for i := uint64(0); i < num; i++ {
+		ret, _, val := bltree.FindKey(keyOf(i), 9)
+		switch {
+		case i%3 == 0 && ret != -1:
+			t.Fatalf("FindKey(%v) = %v, want %v", i, ret, -1)
+		case i%3 == 1 && (ret != 9 || val[8] != 9):
+			t.Fatalf("FindKey(%v) = %v, %v, want updated value", i, ret, val)
+		case i%3 == 2 && (ret != 1 || val[0] != 2):
+			t.Fatalf("FindKey(%v) = %v, %v, want the last value", i, ret, val)
+		}
+	}
+
+	// rejected key changes nothing
+	batch.Reset()
+	batch.Put(keyOf(1), []byte{0})
+	batch.Put(make([]byte, MaxLongKey+1), []byte{0})
+	if err := bltree.Write(&batch); err != BLTErrOverflow {
+		t.Errorf("Write() with too long key = %v, want %v", err, BLTErrOverflow)
+	}
+	if _, _, val := bltree.FindKey(keyOf(1), 9); val[0] != 1 {
+		t.Errorf("FindKey() after rejected Write() = %v, want unchanged", val)
+	}
+
+	if report, err := ValidateTree(bltree); err != BLTErrOk || !report.Valid() {
+		t.Fatalf("ValidateTree() = %v, %v, want no problem", report.Problems, err)
+	}
+
+	// keys of too many leaf pages
+	for i := uint64(0); i < 5000; i++ {
+		bltree.InsertKey(keyOf(i), 0, keyOf(i), true)
+	}
+	batch.Reset()
+	for i := uint64(0); i < 5000; i += 100 {
+		batch.Delete(keyOf(i))
+	}
+	if err := bltree.Write(&batch); err != BLTErrCapacity {
+		t.Errorf("Write() to many pages = %v, want %v", err, BLTErrCapacity)
+	}
+	if n, _ := bltree.Count(); n != 5000 {
+		t.Errorf("Count() after Write() to many pages = %v, want 5000", n)
+	}
+}
+
+func TestBLTree_WriteConcurrently(t *testing.T) {
+	mgr := NewBufMgr(12, 48, NewParentBufMgrDummy(nil), nil)
+	bltree := NewBLTree(mgr)
+	keyOf := func(i uint64) []byte {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		return bs
+	}
+
+	// first and last keys are on different pages
+	num := uint64(5000)
+	for i := uint64(0); i < num; i++ {
+		bltree.InsertKey(keyOf(i), 0, keyOf(0), true)
+	}
+
+	// both keys are set to the same version by each batch, so a reader which
+	// reads the first key and then the last key never sees older last key
+	versions := uint64(300)
+	var done int32
+	var wg sync.WaitGroup
+	for r := 0; r < 3; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tree := NewBLTree(mgr)
+			for atomic.LoadInt32(&done) == 0 {
+				_, _, first := tree.FindKey(keyOf(0), 8)
+				_, _, last := tree.FindKey(keyOf(num-1), 8)
+				if binary.BigEndian.Uint64(last) < binary.BigEndian.Uint64(first) {
+					t.Errorf("FindKey() = %v after %v, want the same or newer version", last, first)
+					return
+				}
+			}
+		}()
+	}
+
+	tree := NewBLTree(mgr)
+	for v := uint64(1); v <= versions; v++ {
+		var batch WriteBatch
+		batch.Put(keyOf(0), keyOf(v))
+		batch.Put(keyOf(num/2), keyOf(v))
+		batch.Put(keyOf(num-1), keyOf(v))
+		// inserted keys split pages while the batch is retried
+		batch.Put(append(keyOf(v*10), 1), keyOf(v))
+		if err := tree.Write(&batch); err != BLTErrOk {
+			t.Errorf("Write() = %v, want %v", err, BLTErrOk)
+			break
+		}
+	}
+	atomic.StoreInt32(&done, 1)
+	wg.Wait()
+
+	if report, err := ValidateTree(bltree); err != BLTErrOk || !report.Valid() {
+		t.Fatalf("ValidateTree() = %v, %v, want no problem", report.Problems, err)
+	}
+}